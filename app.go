@@ -2,8 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"github.com/dgraph-io/badger"
+	"github.com/prometheus/client_golang/prometheus"
 	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto"
+	"github.com/tendermint/tendermint/libs/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"time"
+	"unicode/utf8"
 )
 
 // Tendermint core, handles network (peer communication) and consensus between peers
@@ -11,19 +21,402 @@ import (
 // The goal is to make sure the current state of the state machine is the same across
 // all correct nodes
 
-const VALID_TX uint32 = 0
+// Transaction response codes
+// if the code value is a non-zero value then the transaction
+// is considered invalid by tendermint core
+const (
+	VALID_TX       uint32 = 0
+	INVALID_FORMAT uint32 = 1
+	DUPLICATE_TX   uint32 = 2
+	RESERVED_KEY   uint32 = 3
+	TX_EXPIRED     uint32 = 4
+	INVALID_VALUE  uint32 = 5
+	IMMUTABLE_KEY  uint32 = 6
+	MISSING_KEY    uint32 = 7
+	INVALID_KEY    uint32 = 8
+	KEY_LIMIT      uint32 = 9
+	TX_TOO_LARGE   uint32 = 10
+	READ_ONLY      uint32 = 11
+	// CONDITION_FAILED is a conditional write whose condition
+	// doesn't hold, see setif.go
+	CONDITION_FAILED uint32 = 12
+	LIST_TOO_LONG    uint32 = 13
+	OP_DISABLED      uint32 = 14
+	// INCOMPATIBLE_VALUE is an op that can't be applied to the key's
+	// current value, unlike INVALID_VALUE it depends on the state
+	INCOMPATIBLE_VALUE uint32 = 15
+	// DELETE_TOO_LARGE is a delete that would remove more keys than a
+	// single transaction is allowed to, see delprefix.go
+	DELETE_TOO_LARGE uint32 = 16
+	KEY_TOO_DEEP     uint32 = 17
+	DISK_FULL        uint32 = 18
+	// OUT_OF_RANGE is an increment whose result would be outside its
+	// bounds, see incr.go
+	OUT_OF_RANGE uint32 = 19
+	// BLOCK_FULL is a write in a block that's written its budget, see
+	// blockbudget.go
+	BLOCK_FULL uint32 = 20
+	// BATCH_TOO_LARGE is a batch with more lines than a transaction is
+	// allowed, see WithMaxBatchOps
+	BATCH_TOO_LARGE uint32 = 21
+	// KEY_EXISTS is a write that only creates keys, to a key that's
+	// already there
+	KEY_EXISTS uint32 = 22
+	// OUT_OF_GAS is a transaction that costs more than the gas schedule's
+	// limit, see gas.go
+	OUT_OF_GAS uint32 = 23
+	// VERSION_CONFLICT is a versioned write to a key that's at another
+	// version, see versions.go
+	VERSION_CONFLICT uint32 = 24
+	// NOT_EXPIRED is a reap of a key that hasn't expired, see expiry.go
+	NOT_EXPIRED uint32 = 25
+	// FROZEN is a transaction in a block inside a freeze window, see
+	// freeze.go
+	FROZEN uint32 = 26
+	// NO_OP_WRITE is a write that sets a key to the value it already has,
+	// see noop.go
+	NO_OP_WRITE uint32 = 27
+	// ALREADY_INITIALIZED is an init of a key that's been initialized
+	// before, even if it's since been removed, see init.go
+	ALREADY_INITIALIZED uint32 = 28
+	// TEMPLATE_INVALID is a template write whose value, once filled in,
+	// isn't valid for its type, unlike INVALID_VALUE it depends on the
+	// block, see template.go
+	TEMPLATE_INVALID uint32 = 29
+)
+
+// Query response codes, these don't affect consensus
+// they just tell the client why a query failed
+const (
+	QUERY_INVALID     uint32 = 1
+	QUERY_NOT_ALLOWED uint32 = 2
+	QUERY_FAILED      uint32 = 3
+	QUERY_TOO_LARGE   uint32 = 4
+	QUERY_PRUNED      uint32 = 5
+	// QUERY_BUSY is a query turned away because too many queries are
+	// already running, it's safe to retry
+	QUERY_BUSY uint32 = 6
+	// QUERY_UNFLUSHED is a query for heights that are committed but kept
+	// in memory by commit batching, it's safe to retry once they're
+	// flushed, see flush.go
+	QUERY_UNFLUSHED uint32 = 7
+)
 
 type KVStoreApplication struct {
-	db           *badger.DB
-	currentBatch *badger.Txn
+	store        Store
+	currentBatch Txn
+	// db is the store's badger db, nil with any other Store, see store.go
+	db *badger.DB
+
+	// committed is the state as of the last Commit, pending is the
+	// state being built by the current block, changes are the writes
+	// made by the current block in the order they were delivered
+	committed state
+	pending   state
+	changes   []change
+	// poisoned is set when a transaction in the current block was
+	// rejected and atomic blocks are enabled
+	poisoned bool
+
+	txLog        io.Writer
+	txLogPending [][]byte
+
+	shadow        ShadowWriter
+	shadowPending []shadowBlock
+
+	subscriptions  *subscriptions
+	publishPending []Change
+
+	// blockOpen is true between BeginBlock and Commit
+	blockOpen bool
+	// blockTime is the header time of the current block
+	blockTime time.Time
+	// unflushed are the changes, from every block since the last flush,
+	// that are in currentBatch but not yet in the db
+	unflushed       []change
+	unflushedBlocks int
+	lastFlush       time.Time
+
+	watchdog   *time.Timer
+	maintainer *maintainer
+
+	// malformedTxs counts the malformed transactions delivered since startup
+	malformedTxs int64
+
+	// formatVersion is the store's format version, 0 until it's written
+	formatVersion int
+
+	logger            log.Logger
+	adminQueries      bool
+	keyNormalization  KeyNormalization
+	trimValues        bool
+	maxListLength     int64
+	maxPrefixDelete   int
+	disabledOps       map[string]bool
+	proofCache        *proofCache
+	metricsRegistry   *prometheus.Registry
+	metrics           *appMetrics
+	atomicBlocks      bool
+	cache             *readCache
+	flushBlocks       int
+	flushInterval     time.Duration
+	watchdogTimeout   time.Duration
+	watchdogHalt      bool
+	merkleAppHash     bool
+	appendOnly        bool
+	utf8Keys          bool
+	maxKeyDepth       int
+	keyDepthSeparator []byte
+	txIndex           bool
+	keyLimits         []keyLimit
+	changeIndex       bool
+	invalidTxPolicy   InvalidTxPolicy
+	maxTxSize         int
+	batchDuplicates   BatchDuplicatePolicy
+	unknownOps        UnknownOpPolicy
+	appVersion        uint64
+	changeIndexRetain int64
+	txIndexRetention  TxIndexRetention
+	markDuplicates    bool
+	valueCRC          bool
+	modIndex          bool
+	timeIndex         bool
+	timeIndexRetain   time.Duration
+	replica           bool
+	// compactionThreshold is the number of deletes in a block that
+	// schedules a flatten, 0 disables compaction hints
+	compactionThreshold int
+	invariants          []namedInvariant
+	haltOnInvariant     bool
+	blockPredicate      BlockPredicate
+	// querySlots bounds the number of queries running at once, nil is
+	// no limit, see Query
+	querySlots chan struct{}
+	// checkSlots bounds the number of CheckTx calls running at once, nil
+	// is no limit, see CheckTx
+	checkSlots chan struct{}
+	// watchSlots bounds the number of watches waiting at once, nil is no
+	// limit, see watch.go
+	watchSlots chan struct{}
+	// verifyEvery is how often a flushed write is read back, 0 never,
+	// verifyCount counts the writes that could have been, see verify.go
+	verifyEvery int
+	verifyHalt  bool
+	verifyCount int64
+	// skipDeliverDuplicates skips checkSet in DeliverTx
+	skipDeliverDuplicates bool
+	// diskGuard is nil unless WithDiskGuard is set
+	diskGuard *diskGuard
+	// idempotencyRetain is the number of blocks an idempotency key is
+	// kept for, 0 is forever, see idempotency.go
+	idempotencyKeys   bool
+	idempotencyRetain int64
+	// rankingPrefix is the namespace of the ranking, see ranking.go
+	ranking       bool
+	rankingPrefix []byte
+	// shutdownSnapshot is the file Close writes a backup to, see snapshot.go
+	shutdownSnapshot string
+	// deadLetters is nil unless WithDeadLetterLog is set
+	deadLetters *deadLetters
+	// events is nil unless WithEventSink is set, see eventsink.go
+	events *eventSink
+	// maxMetricPrefixes is the cap on namespaces with their own label in
+	// the per prefix metrics, 0 disables them, see prefixmetrics.go
+	maxMetricPrefixes int
+	// changeCompactAfter and changeCompactSpan are the compaction
+	// threshold and range, see changecompact.go
+	changeCompactAfter int64
+	changeCompactSpan  int64
+	// localPrefixes are left out of the app hash, see localkeys.go
+	localPrefixes [][]byte
+	// blockBudget is the bytes a block can write, 0 is no limit,
+	// blockBytes the bytes the open block has written, see blockbudget.go
+	blockBudget int64
+	blockBytes  int64
+	// bloom is the last bloom filter queried, see bloom.go
+	bloom *bloomCache
+	// signingKey signs query responses, nil is unsigned, see signing.go
+	signingKey crypto.PrivKey
+	// maxBatchOps is the most lines a batch can have, 0 is no limit
+	maxBatchOps int
+	// internalQuery enables the "internal" query, see internal.go
+	internalQuery bool
+	// forceGenesis seeds the genesis even if the store has state, see
+	// genesis.go
+	forceGenesis bool
+	// offlineRewrites lets ReplaceAll, MigratePrefix and RestoreBackup run
+	// once the chain has started, see replace.go
+	offlineRewrites bool
+	// heightCheck is what happens to a block at an unexpected height,
+	// see heightcheck.go
+	heightCheck HeightCheckPolicy
+	// gas prices transactions, nil is 1 gas each, see gas.go
+	gas *GasSchedule
+	// diag is nil unless WithDiagnostics is set, see diagnostics.go
+	diag *diagnostics
+	// gatewayETags makes the gateway send ETags for /key, cached for
+	// gatewayMaxAge, see gatewaycache.go
+	gatewayETags  bool
+	gatewayMaxAge time.Duration
+	// queryBudget is how long an expensive scan can run, 0 is no limit,
+	// see querybudget.go
+	queryBudget time.Duration
+	// genesisDir is where relative genesis files are, empty if the
+	// genesis can't refer to a file, see genesisfile.go
+	genesisDir string
+	// keyVersions is set if every key's version is recorded, see versions.go
+	keyVersions bool
+	// syncOnFlush syncs the db after every flush, see flush.go
+	syncOnFlush bool
+	// phase is where the app is in the ABCI block sequence, see
+	// blockphase.go
+	phase blockPhase
+	// seenTxs is nil unless WithCheckTxDedupWindow is set, see seentxs.go
+	seenTxs *seenTxs
+	// bucketWidth is the width of a time bucket, 0 disables them, see
+	// timebucket.go
+	bucketWidth time.Duration
+	// receiptsRoot commits the block's receipts in the app hash, receipts
+	// are the leaves of the open block, see receipts.go
+	receiptsRoot bool
+	receipts     [][]byte
+	// maxResponseSize is the limit on the size of a query response, 0 is
+	// no limit, see responsesize.go
+	maxResponseSize int
+	// freezeWindows are the spans of block time no transaction is applied
+	// in, see freeze.go
+	freezeWindows []FreezeWindow
+	// readStats is nil unless WithReadStats is set, see readstats.go
+	readStats *readStats
+	// slowOpThreshold is how long a Commit, DeliverTx or Query runs before
+	// it's logged, 0 logs none, see slowlog.go
+	slowOpThreshold time.Duration
+	// startupCheck is how thoroughly the state is checked on startup, see
+	// startupcheck.go
+	startupCheck StartupCheck
+	// rejectNoOps rejects writes that don't change anything, see noop.go
+	rejectNoOps bool
+	// tracer traces the write path, a no-op one unless tracing is set,
+	// blockCtx and blockSpan are the current block's span, see tracing.go
+	tracing   bool
+	tracer    trace.Tracer
+	blockCtx  context.Context
+	blockSpan trace.Span
 }
 
 var _ abcitypes.Application = (*KVStoreApplication)(nil)
 
-func NewKVStoreApplication(db *badger.DB) *KVStoreApplication {
-	return &KVStoreApplication{
-		db: db,
+// NewKVStoreApplication returns the application with its data in db
+func NewKVStoreApplication(db *badger.DB, opts ...Option) *KVStoreApplication {
+	return newApplication(NewBadgerStore(db), db, opts)
+}
+
+// NewKVStoreApplicationWithStore returns the application with its data in
+// store, see store.go
+func NewKVStoreApplicationWithStore(store Store, opts ...Option) *KVStoreApplication {
+	if s, ok := store.(badgerStore); ok {
+		return newApplication(store, s.db, opts)
+	}
+	return newApplication(store, nil, opts)
+}
+
+func newApplication(store Store, db *badger.DB, opts []Option) *KVStoreApplication {
+	app := &KVStoreApplication{
+		store:           store,
+		db:              db,
+		logger:          log.NewNopLogger(),
+		flushBlocks:     1,
+		lastFlush:       time.Now(),
+		subscriptions:   &subscriptions{buffer: defaultSubscriptionBuffer},
+		querySlots:      make(chan struct{}, defaultMaxConcurrentQueries),
+		watchSlots:      make(chan struct{}, defaultMaxWatchers),
+		maxPrefixDelete: defaultMaxPrefixDelete,
+		maxBatchOps:     defaultMaxBatchOps,
+		bloom:           &bloomCache{},
+		tracer:          trace.NewNoopTracerProvider().Tracer(tracerName),
+	}
+	for _, opt := range opts {
+		opt(app)
+	}
+	if app.db == nil && (app.maintainer != nil || app.compactionThreshold > 0 || app.shutdownSnapshot != "" || app.syncOnFlush) {
+		panic("kvstore: maintenance, compaction hints, shutdown snapshots and sync on flush need a badger store")
+	}
+	// a discarded block would take every unflushed block down with it, the
+	// current batch holds them all
+	if app.atomicBlocks && app.flushBlocks > 1 {
+		panic("kvstore: atomic blocks can't be combined with commit batching")
+	}
+	if app.blockPredicate != nil && app.flushBlocks > 1 {
+		panic("kvstore: a block predicate can't be combined with commit batching")
+	}
+	if app.maxKeyDepth > 0 && len(app.keyDepthSeparator) == 0 {
+		panic("kvstore: the key depth separator can't be empty")
+	}
+	if app.ranking && isInternalKey(app.rankingPrefix) {
+		panic("kvstore: the ranking prefix is reserved")
+	}
+	for _, p := range app.localPrefixes {
+		if len(p) == 0 || isInternalKey(p) {
+			panic(fmt.Sprintf("kvstore: %q can't be a local prefix", p))
+		}
+	}
+	for name := range app.disabledOps {
+		if txOps[name] == nil {
+			panic(fmt.Sprintf("kvstore: can't disable unknown op %q", name))
+		}
+	}
+
+	version, err := loadFormatVersion(store)
+	if err == nil {
+		err = checkFormatVersion(version)
+	}
+	if err != nil {
+		halt("NewKVStoreApplication", err)
+	}
+	app.formatVersion = version
+
+	s, err := loadState(store)
+	if err == nil {
+		s.PrefixKeys, err = app.countPrefixKeys(s.PrefixKeys)
+	}
+	if err == nil && app.txIndexRetention.Receipts > 0 {
+		s.TxReceipts, err = countTxReceipts(store)
+	}
+	if err != nil {
+		halt("NewKVStoreApplication", err)
+	}
+	if err := app.checkStartup(s); err != nil {
+		halt("NewKVStoreApplication", err)
+	}
+	app.committed = s
+	if app.metricsRegistry != nil {
+		app.metrics = newAppMetrics()
+		app.metrics.setState(s)
+		if app.maxMetricPrefixes > 0 {
+			app.metrics.prefixes = newPrefixMetrics(app.maxMetricPrefixes)
+		}
+		app.metricsRegistry.MustRegister(app.metrics)
+	}
+	if s.Height > 0 && s.AppVersion != app.appVersion {
+		app.logger.Info("app version changed", "height", s.Height, "from", s.AppVersion, "to", app.appVersion)
+	}
+
+	// compaction hints are run by the maintainer, one without any
+	// other maintenance enabled if it wasn't configured
+	// a replica's db is read only, so there is nothing to maintain
+	if app.replica {
+		app.maintainer = nil
+	}
+	if app.compactionThreshold > 0 && app.maintainer == nil && !app.replica {
+		app.maintainer = newMaintainer(app, MaintenanceConfig{})
+	}
+	if app.maintainer != nil {
+		go app.maintainer.run()
+	}
+	if app.events != nil {
+		app.events.start(app)
 	}
+	return app
 }
 
 // When a peer gets a transaction from another peer, it has to confirm with
@@ -33,8 +426,33 @@ func NewKVStoreApplication(db *badger.DB) *KVStoreApplication {
 // CheckTx weakly validates the transaction
 // i.e. validates the transaction without applying it to the state machine
 func (app *KVStoreApplication) CheckTx(req abcitypes.RequestCheckTx) abcitypes.ResponseCheckTx {
-	code := app.isValid(req.Tx)
-	return abcitypes.ResponseCheckTx{Code: code, GasWanted: 1}
+	recheck := req.Type == abcitypes.CheckTxType_Recheck
+	if !recheck && app.seenTxs.seen(req.Tx) {
+		return abcitypes.ResponseCheckTx{Code: errSeenTx.code, Log: errSeenTx.log, GasWanted: 1}
+	}
+	// unlike a query, a transaction over the limit waits for a slot, a
+	// busy node rejecting it would be no different from it being invalid
+	if app.checkSlots != nil {
+		app.checkSlots <- struct{}{}
+		defer func() { <-app.checkSlots }()
+	}
+	t, err := app.isValid(req.Tx)
+	if r, ok := asRejection(err); ok {
+		if app.deadLetters != nil {
+			app.deadLetters.add(req.Tx, r)
+		}
+		if recheck {
+			app.seenTxs.forget(req.Tx)
+		}
+		return abcitypes.ResponseCheckTx{Code: r.code, Log: r.log, Info: r.info, GasWanted: 1}
+	}
+	if err != nil {
+		halt("CheckTx", err)
+	}
+	if !recheck {
+		app.seenTxs.add(req.Tx)
+	}
+	return abcitypes.ResponseCheckTx{Code: VALID_TX, GasWanted: app.gasWanted(req.Tx, t)}
 }
 
 // isValid validates that a transaction meets a set of constraints
@@ -42,50 +460,210 @@ func (app *KVStoreApplication) CheckTx(req abcitypes.RequestCheckTx) abcitypes.R
 // the transaction must follow the format 'key=value'
 // and that the exact key=value pair must not already exist
 // as nothing new is being added to the database
-func (app *KVStoreApplication) isValid(tx []byte) (code uint32) {
+// op transactions are checked by their op instead, see ops.go
+// a *rejection is returned if the transaction is invalid, any other
+// error means the db failed and the transaction couldn't be checked
+// the parsed transaction is returned so it doesn't need parsing again
+//
+// it's safe to call from many goroutines at once, it only reads the store
+// through read-only badger transactions, which see a snapshot and never
+// block the writer, and the rest of what it touches, the read cache, the
+// disk guard and the dead letter log, has its own lock, it isn't safe to
+// call at the same time as Commit, which moves the committed state on,
+// tendermint never does, it holds the mempool lock over every Commit
+func (app *KVStoreApplication) isValid(tx []byte) (t transaction, err error) {
+	if app.replica {
+		return t, errReplicaTx
+	}
+	t, err = app.validateTx(tx)
+	if err != nil {
+		return
+	}
+	// a transaction whose idempotency key was used is delivered as a
+	// no-op, there's no point in it taking up space in a block
+	if t.idempotencyKey != "" {
+		err = app.store.View(func(txn Txn) error {
+			return checkIdempotency(txn, t)
+		})
+		if a, ok := err.(*alreadyApplied); ok {
+			return t, a.rejection()
+		}
+		if err != nil {
+			return t, err
+		}
+	}
+	// the disk guard is local to this node, so it's only checked here
+	if t.writesData() && app.diskFull() {
+		return t, errDiskFull
+	}
+	if t.op != nil {
+		err = app.store.View(func(txn Txn) error {
+			if err := t.op.check(app, txn, t); err != nil {
+				return err
+			}
+			return app.checkNoOp(txn, t)
+		})
+		return t, err
+	}
+	if t.batch != nil {
+		err = app.store.View(func(txn Txn) error {
+			if err := app.checkBatch(txn, app.committed, t); err != nil {
+				return err
+			}
+			return app.checkNoOp(txn, t)
+		})
+		return t, err
+	}
+	err = app.store.View(func(txn Txn) error {
+		return app.checkSet(txn, app.committed, t)
+	})
+	return t, err
+}
 
-	// if the code value is a non-zero value then the transaction
-	// is considered invalid by tendermint core
-	// I technically don't need to put this here as the default value
-	// for uint32 is 0, but this feels much clearer
-	code = VALID_TX
+// validateTx runs the checks that don't depend on the state of the store
+func (app *KVStoreApplication) validateTx(tx []byte) (t transaction, err error) {
+	// the size is checked first, so an oversized transaction costs
+	// nothing more than its length to reject
+	if app.maxTxSize > 0 && len(tx) > app.maxTxSize {
+		return t, reject(TX_TOO_LARGE, fmt.Sprintf("transaction is %d bytes, over the limit of %d", len(tx), app.maxTxSize))
+	}
 
 	// check transaction format is of type 'key=value'
-	parts := bytes.Split(tx, []byte("="))
-	if len(parts) != 2 {
-		return 1 // Invalidates the transaction
+	t, err = app.parse(tx)
+	if err != nil {
+		return
+	}
+	if err := app.checkFrozen(); err != nil {
+		return t, err
+	}
+	if t.op != nil && !app.opEnabled(t.op) {
+		return t, reject(OP_DISABLED, fmt.Sprintf("the %s op is disabled on this network", t.op.name))
+	}
+	if err := app.checkGas(tx, t); err != nil {
+		return t, err
+	}
+	// without the index a retry would be applied again, which is what
+	// the client was trying to avoid
+	if t.idempotencyKey != "" && !app.idempotencyKeys {
+		return t, reject(INVALID_FORMAT, "idempotency keys are disabled on this network")
 	}
 
-	key, value := parts[0], parts[1]
+	for _, key := range t.keys() {
+		if err := app.checkKey(key); err != nil {
+			return t, err
+		}
+	}
+	if err := app.interpolateWrites(&t); err != nil {
+		return t, err
+	}
 
-	// check if the sane key=value pair already exist
-	err := app.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(key)
-		// The only permitted error is that the key was not found
-		// if we get any other error, return that so we can panic
-		if err != nil && err != badger.ErrKeyNotFound {
-			return err
+	for _, w := range t.writes() {
+		if err := w.contentType.validate(w.value); err != nil {
+			if r, ok := asRejection(err); ok && w.template {
+				return t, reject(TEMPLATE_INVALID, r.log)
+			}
+			return t, err
+		}
+		if w.contentType == typeList || w.contentType == typeSet {
+			list, _ := decodeList(w.value)
+			if err := app.checkListLength(int64(len(list))); err != nil {
+				return t, err
+			}
 		}
-		// We enter this branch if the key was found, now we need
-		// to verify that the value is not the same
-		if err == nil {
-			return item.Value(func(val []byte) error {
-				if bytes.Equal(val, value) {
-					code = 2 // Invalidates the transaction
-				}
-				return nil
-			})
-		}
-		// Db checks done, no database error encountered
-		return nil
-	})
 
-	if err != nil {
-		// err can only not be nil if something went wrong with the db
-		panic(err)
+		// a transaction that sat in the mempool past its deadline is stale
+		if w.validUntil != 0 && app.txHeight() > w.validUntil {
+			return t, reject(TX_EXPIRED, fmt.Sprintf("transaction was only valid until height %d", w.validUntil))
+		}
+		if w.ttl != 0 && w.deleteAt != 0 {
+			return t, reject(INVALID_FORMAT, "a write can't have both a ttl and delete_at")
+		}
+		// the key would be removed before it was written, at the end of
+		// the block it's written in is the earliest
+		if w.deleteAt != 0 && app.txHeight() > w.deleteAt {
+			return t, reject(TX_EXPIRED, fmt.Sprintf("delete_at height %d has already passed", w.deleteAt))
+		}
+	}
+	return t, nil
+}
+
+// checkKey checks a normalized user key can be written at all
+func (app *KVStoreApplication) checkKey(key []byte) error {
+	// badger can't store an empty key, it can also be left by
+	// normalization, e.g. ' =value' with NormalizeTrimSpace
+	if len(key) == 0 {
+		return reject(INVALID_FORMAT, "keys can't be empty")
+	}
+	// the application's own bookkeeping can't be overwritten
+	if isInternalKey(key) {
+		return reject(RESERVED_KEY, "keys starting with a 0x00 byte are reserved")
+	}
+	if app.utf8Keys && !utf8.Valid(key) {
+		return reject(INVALID_KEY, "keys must be valid UTF-8")
+	}
+	if app.maxKeyDepth > 0 && bytes.Count(key, app.keyDepthSeparator)+1 > app.maxKeyDepth {
+		return reject(KEY_TOO_DEEP, fmt.Sprintf("keys can't have more than %d %q separated segments", app.maxKeyDepth, app.keyDepthSeparator))
+	}
+	return nil
+}
+
+// checkSet checks a 'key=value' transaction against the state visible to
+// txn, whose counters are s, in CheckTx that's the committed state, in
+// DeliverTx it's the current batch, which has the writes earlier in the
+// block and, with commit batching, the blocks that aren't flushed yet
+func (app *KVStoreApplication) checkSet(txn Txn, s state, t transaction) error {
+	key, value := t.key, t.value
+
+	// check if the same key=value pair already exists
+	var exists, duplicate bool
+	item, err := txn.Get(key)
+	// The only permitted error is that the key was not found
+	// if we get any other error, return that so the caller can halt
+	if err != nil && err != ErrKeyNotFound {
+		return err
+	}
+	// We enter this branch if the key was found, now we need
+	// to verify that the value is not the same
+	if err == nil {
+		exists = true
+		err = item.Value(func(val []byte) error {
+			val, err := decodeValue(item.Key(), item.UserMeta(), val)
+			duplicate = bytes.Equal(val, value)
+			return err
+		})
+		if err != nil {
+			// err can only not be nil if something went wrong with the db
+			return err
+		}
+	}
+	// writing the same pair with a ttl refreshes its expiry
+	if duplicate && !t.expires() {
+		return app.duplicateRejection()
+	}
+	if (exists || t.expires()) && app.appendOnly {
+		return errOverwriteForbidden
+	}
+	if !exists {
+		return app.checkKeyLimits(key, s)
 	}
 
-	return code
+	return nil
+}
+
+// INFO_ALREADY_PRESENT is the Info attached to duplicate rejections
+// when WithDuplicateInfo is enabled
+const INFO_ALREADY_PRESENT = "already-present"
+
+// duplicateRejection is the rejection for a key=value pair that already exists
+// the code is always DUPLICATE_TX, only the log and info change so clients
+// can tell that the write they wanted is already there and stop retrying
+func (app *KVStoreApplication) duplicateRejection() *rejection {
+	if !app.markDuplicates {
+		return reject(DUPLICATE_TX, "key=value pair already exists")
+	}
+	r := reject(DUPLICATE_TX, "key=value pair is already present in the store, it is safe to drop this transaction")
+	r.info = INFO_ALREADY_PRESENT
+	return r
 }
 
 // Once tendermint core has reached consensus on a block it needs to be
@@ -97,47 +675,287 @@ func (app *KVStoreApplication) isValid(tx []byte) (code uint32) {
 // CommitBlock -> Applies the transactions in the block to the state machine in order
 
 // BeginBlock opens a new write batch on badger db
+// with commit batching the batch from the previous block might still be open
+// in which case this block's writes are added to it
 func (app *KVStoreApplication) BeginBlock(req abcitypes.RequestBeginBlock) abcitypes.ResponseBeginBlock {
-	app.currentBatch = app.db.NewTransaction(true)
+	app.diag.enter("BeginBlock")
+	defer app.diag.leave(app)
+	app.refuseOnReplica("BeginBlock")
+	app.advancePhase("BeginBlock", phaseIdle, phaseDelivering)
+	app.checkBlockHeight(req.Header.Height)
+	app.startBlockSpan(req.Header.Height)
+	if app.currentBatch == nil {
+		app.currentBatch = app.store.NewBatch()
+	}
+	app.blockOpen = true
+	app.pending = app.committed.clone()
+	app.pending.Height = req.Header.Height
+	app.pending.AppVersion = app.appVersion
+	app.blockTime = req.Header.Time
+	app.changes = nil
+	app.blockBytes = 0
+	app.receipts = nil
+	app.poisoned = false
+	app.txLogPending = nil
+	app.startWatchdog(app.pending.Height)
+	app.noteActivity()
 	return abcitypes.ResponseBeginBlock{}
 }
 
 // DeliverTx validates the transaction again but also
 // applies the transaction to the state machine
 // returns a code to indicate if the transaction is valid
-// I am not sure what the tendermint core will do if the application says
-// that a transaction is not valid as a response to DeliverTx
-func (app *KVStoreApplication) DeliverTx(req abcitypes.RequestDeliverTx) abcitypes.ResponseDeliverTx {
-	code := app.isValid(req.Tx)
-	if code != 0 {
-		return abcitypes.ResponseDeliverTx{Code: code}
+// tendermint keeps a rejected transaction in the block, the code only marks
+// it as failed in the block results, it has no effect on the state
+// a malformed transaction is handled according to the InvalidTxPolicy
+// db failures don't produce a code, they halt the node (see errors.go)
+func (app *KVStoreApplication) DeliverTx(req abcitypes.RequestDeliverTx) (res abcitypes.ResponseDeliverTx) {
+	app.diag.enter("DeliverTx")
+	defer app.diag.leave(app)
+	defer app.logSlow("DeliverTx", time.Now(), "height", app.pending.Height, "tx_size", len(req.Tx))
+	app.refuseOnReplica("DeliverTx")
+	app.advancePhase("DeliverTx", phaseDelivering, phaseDelivering)
+	app.seenTxs.forget(req.Tx)
+	span := app.startSpan("kvstore.DeliverTx", attribute.Int64("height", app.pending.Height), attribute.Int("tx_size", len(req.Tx)))
+	defer func() { endTxSpan(span, res.Code) }()
+	changed := len(app.changes)
+	t, err := app.deliverTx(req.Tx)
+	traceTx(span, t)
+	// the transaction that used the idempotency key first has the
+	// receipt, this one has the same hash if it's a plain retry
+	if a, ok := err.(*alreadyApplied); ok {
+		app.metrics.txDelivered(VALID_TX)
+		app.addReceipt(req.Tx, VALID_TX)
+		return abcitypes.ResponseDeliverTx{Code: VALID_TX, Log: a.Error(), Info: INFO_ALREADY_PRESENT}
+	}
+	if r, ok := asRejection(err); ok {
+		if r.malformed() {
+			app.malformedTxs++
+			app.logger.Error("malformed transaction in block", "height", app.pending.Height, "code", r.code, "log", r.log)
+			if app.invalidTxPolicy == InvalidTxHalt {
+				halt("DeliverTx", fmt.Errorf("block at height %d contains a malformed transaction: %s", app.pending.Height, r.log))
+			}
+		}
+		if app.atomicBlocks {
+			app.poisoned = true
+		}
+		if err := app.indexTx(req.Tx, t, r.code, r.log, nil); err != nil {
+			halt("DeliverTx", err)
+		}
+		app.metrics.txDelivered(r.code)
+		app.addReceipt(req.Tx, r.code)
+		return abcitypes.ResponseDeliverTx{Code: r.code, Log: r.log, Info: r.info}
+	}
+	if err != nil {
+		halt("DeliverTx", err)
+	}
+	if err := app.indexTx(req.Tx, t, VALID_TX, "", app.changes[changed:]); err != nil {
+		halt("DeliverTx", err)
+	}
+	app.logTx(req.Tx)
+	for _, c := range app.changes[changed:] {
+		app.metrics.keyWritten(c.key, len(c.value))
+	}
+	app.metrics.txDelivered(VALID_TX)
+	app.addReceipt(req.Tx, VALID_TX)
+	res = abcitypes.ResponseDeliverTx{Code: VALID_TX}
+	if app.gas != nil {
+		res.GasWanted = app.gas.txGas(req.Tx, t)
+		res.GasUsed = res.GasWanted
 	}
+	return res
+}
 
-	parts := bytes.Split(req.Tx, []byte("="))
-	key, value := parts[0], parts[1]
+// deliverTx validates and applies a single transaction to the current batch
+// the transaction is returned even if it's rejected, as far as it was parsed
+// an *alreadyApplied is returned if its idempotency key was already used
+func (app *KVStoreApplication) deliverTx(tx []byte) (transaction, error) {
+	t, err := app.validateTx(tx)
+	if err != nil {
+		return t, err
+	}
+	// the current batch has the keys used earlier in the block
+	if err := checkIdempotency(app.currentBatch, t); err != nil {
+		return t, err
+	}
+	if t.writesData() && app.blockBudgetSpent() {
+		return t, app.errBlockFull()
+	}
+	changed := len(app.changes)
+	if err := app.applyTx(t); err != nil {
+		return t, err
+	}
+	app.countBlockBytes(app.changes[changed:])
+	return t, app.recordIdempotency(t)
+}
+
+// applyTx checks a validated transaction against the current batch and
+// applies it
+func (app *KVStoreApplication) applyTx(t transaction) error {
+	// ops are checked against the current batch so they see the
+	// writes made earlier in the block
+	if t.op != nil {
+		if err := t.op.check(app, app.currentBatch, t); err != nil {
+			return err
+		}
+		if err := app.checkNoOp(app.currentBatch, t); err != nil {
+			return err
+		}
+		return t.op.apply(app, t)
+	}
+	if t.batch != nil {
+		if err := app.checkBatch(app.currentBatch, app.pending, t); err != nil {
+			return err
+		}
+		if err := app.checkNoOp(app.currentBatch, t); err != nil {
+			return err
+		}
+		for _, w := range t.batch {
+			if err := app.write(w); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if app.skipDeliverDuplicates {
+		// set still enforces append only mode and the key limits
+		// against the current batch, only a new expiry isn't covered
+		if t.expires() && app.appendOnly {
+			return errOverwriteForbidden
+		}
+	} else if err := app.checkSet(app.currentBatch, app.pending, t); err != nil {
+		return err
+	}
 
 	// Add the key value pair to the current batch
 	// NOTE: There is a possibility that the current batch
 	// might get too big, how would this be handled
 	// since we can't commit yet???
-	err := app.currentBatch.Set(key, value)
-	if err != nil {
-		panic(err)
-	}
+	return app.write(t)
+}
 
-	return abcitypes.ResponseDeliverTx{Code: VALID_TX}
+// write applies a single 'key=value' write to the current batch
+func (app *KVStoreApplication) write(t transaction) error {
+	if err := app.set(t.key, t.value, t.contentType); err != nil {
+		return err
+	}
+	switch {
+	case t.ttl != 0:
+		return app.setExpiry(t.key, app.pending.Height+t.ttl)
+	case t.deleteAt != 0:
+		return app.setExpiry(t.key, t.deleteAt)
+	}
+	return nil
 }
 
-// EndBlock doesn't really do anything for this application
+// EndBlock settles the block's writes once every transaction is delivered
+// a block that has to be discarded is discarded here, then the keys that
+// expire at this height are removed, after the transactions, so they can
+// still read them, each one is reported as an "expire" event, see expiry.go
 func (app *KVStoreApplication) EndBlock(req abcitypes.RequestEndBlock) abcitypes.ResponseEndBlock {
-	return abcitypes.ResponseEndBlock{}
+	app.diag.enter("EndBlock")
+	defer app.diag.leave(app)
+	app.refuseOnReplica("EndBlock")
+	app.advancePhase("EndBlock", phaseDelivering, phaseEnded)
+	if app.poisoned {
+		app.discardBlock()
+	}
+	if err := app.checkBlockPredicate(); err != nil {
+		app.logger.Error("block predicate failed, discarding block", "height", app.pending.Height, "err", err)
+		app.discardBlock()
+	}
+
+	expired, err := app.expireKeys()
+	if err != nil {
+		halt("EndBlock", err)
+	}
+	return abcitypes.ResponseEndBlock{Events: expiryEvents(expired)}
 }
 
 // Commit persistence all the transactions for the current batch i.e current block
+// along with the new state, so a restart picks up exactly where the block ended
+// the returned Data is the app hash, which tendermint core includes in the
+// next block header so nodes can check they all ended up with the same state
 func (app *KVStoreApplication) Commit() abcitypes.ResponseCommit {
-	app.currentBatch.Commit()
-	// Not sure what the Data is supposed to return
-	return abcitypes.ResponseCommit{Data: []byte{}}
+	app.diag.enter("Commit")
+	defer app.diag.leave(app)
+	defer app.logSlow("Commit", time.Now(), "height", app.pending.Height, "writes", len(app.changes))
+	app.refuseOnReplica("Commit")
+	app.advancePhase("Commit", phaseEnded, phaseIdle)
+	span := app.startSpan("kvstore.Commit", attribute.Int64("height", app.pending.Height), attribute.Int("writes", len(app.changes)))
+	if err := app.checkInvariants(); err != nil {
+		app.logger.Error("INVARIANT VIOLATION", "height", app.pending.Height, "err", err)
+		if app.haltOnInvariant {
+			halt("Commit", err)
+		}
+	}
+
+	if err := app.computeAppHash(); err != nil {
+		halt("Commit", err)
+	}
+	if err := app.pruneTxIndex(); err != nil {
+		halt("Commit", err)
+	}
+	if err := app.pruneTimeIndex(); err != nil {
+		halt("Commit", err)
+	}
+	if err := app.pruneIdempotency(); err != nil {
+		halt("Commit", err)
+	}
+	if err := app.indexChanges(); err != nil {
+		halt("Commit", err)
+	}
+	if err := app.pending.save(app.currentBatch); err != nil {
+		halt("Commit", err)
+	}
+	if err := app.saveFormatVersion(app.currentBatch); err != nil {
+		halt("Commit", err)
+	}
+	// the transaction log is written first, see wal.go
+	if err := app.flushTxLog(); err != nil {
+		halt("Commit", err)
+	}
+	app.committed = app.pending
+	app.metrics.committed(app.committed)
+	app.queueShadow()
+	app.queuePublish()
+	app.queueEvents()
+	app.hintCompaction()
+	app.unflushed = append(app.unflushed, app.changes...)
+	app.unflushedBlocks++
+	// The block is done, anything that needs to wait for
+	// block processing to finish can run from here
+	app.blockOpen = false
+	app.changes = nil
+	app.stopWatchdog()
+
+	// unless commit batching is enabled this always flushes
+	flushed := app.shouldFlush()
+	if flushed {
+		if err := app.flush(); err != nil {
+			halt("Commit", err)
+		}
+	}
+	span.SetAttributes(attribute.Bool("flushed", flushed))
+	span.End()
+	app.endBlockSpan()
+	app.noteActivity()
+	return abcitypes.ResponseCommit{Data: app.committed.AppHash}
+}
+
+// discardBlock throws away every write made by the current block
+// the block still happened though, so the height moves forward
+// with the state otherwise unchanged
+func (app *KVStoreApplication) discardBlock() {
+	app.logger.Info("discarding block", "height", app.pending.Height, "writes", len(app.changes))
+	app.currentBatch.Discard()
+	app.currentBatch = app.store.NewBatch()
+
+	height := app.pending.Height
+	app.pending = app.committed.clone()
+	app.pending.Height = height
+	app.changes = nil
+	app.txLogPending = nil
 }
 
 // There are some nodes that won't run the application layer
@@ -145,44 +963,152 @@ func (app *KVStoreApplication) Commit() abcitypes.ResponseCommit {
 // A light client might still want to query information about
 // the application state machine, the query interface is used for this
 
-// Query checks if a key exists in the db
+// Query answers a query, see query for the paths
+// a query that would take the number of queries in flight over the limit
+// is rejected with QUERY_BUSY straight away rather than queued, in flight
+// reads are what hold badger's resources, not the queries waiting on them
+func (app *KVStoreApplication) Query(req abcitypes.RequestQuery) abcitypes.ResponseQuery {
+	defer app.logSlow("Query", time.Now(), "path", req.Path, "data_size", len(req.Data))
+	if app.querySlots != nil {
+		select {
+		case app.querySlots <- struct{}{}:
+			defer func() { <-app.querySlots }()
+		default:
+			return abcitypes.ResponseQuery{Code: QUERY_BUSY, Log: "too many queries in flight, try again later"}
+		}
+	}
+	res := app.limitResponse(req)
+	if app.signingKey != nil {
+		app.signQuery(req, &res)
+	}
+	return res
+}
+
+// query routes the request based on its path
+// every path reads as of the last flushed Commit but "pending", see readmode.go
+// any path that isn't a known query path is treated as a key lookup
+// as that was the only query this application used to support
+func (app *KVStoreApplication) query(req abcitypes.RequestQuery) abcitypes.ResponseQuery {
+	switch req.Path {
+	case "flatten":
+		return app.queryFlatten(req)
+	case "compactchanges":
+		return app.queryCompactChanges(req)
+	case "stats":
+		return app.queryStats(req)
+	case "empty":
+		return app.queryEmpty(req)
+	case "complete":
+		return app.queryComplete(req)
+	case "prefixes":
+		return app.queryPrefixes(req)
+	case "prefix":
+		return app.queryPrefix(req)
+	case "checksum":
+		return app.queryChecksum(req)
+	case "statehash":
+		return app.queryStateHash(req)
+	case "compare":
+		return app.queryCompare(req)
+	case "get":
+		return app.queryGet(req)
+	case "mget":
+		return app.queryMultiGet(req)
+	case "getdefault":
+		return app.queryGetDefault(req)
+	case "rangeproof":
+		return app.queryRangeProof(req)
+	case "keyproof":
+		return app.queryKeyProof(req)
+	case "bloom":
+		return app.queryBloom(req)
+	case "diff":
+		return app.queryDiff(req)
+	case "history":
+		return app.queryHistory(req)
+	case "extremes":
+		return app.queryExtremes(req)
+	case "modified":
+		return app.queryModified(req)
+	case "since":
+		return app.querySince(req)
+	case "topn":
+		return app.queryTopN(req)
+	case "config":
+		return app.queryConfig(req)
+	case "scan":
+		return app.queryScan(req)
+	case "search":
+		return app.querySearch(req)
+	case "sizehist":
+		return app.querySizeHist(req)
+	case "sum":
+		return app.querySum(req)
+	case "receiptproof":
+		return app.queryReceiptProof(req)
+	case "buckets":
+		return app.queryBuckets(req)
+	case "bucket":
+		return app.queryBucket(req)
+	case "verify":
+		return app.queryVerify(req)
+	case "meta":
+		return app.queryMeta(req)
+	case "sismember":
+		return app.queryIsMember(req)
+	case "readstats":
+		return app.queryReadStats(req)
+	case "expiring":
+		return app.queryExpiring(req)
+	case "tx":
+		return app.queryTx(req)
+	case "format":
+		return app.queryFormat(req)
+	case "txformat":
+		return app.queryTxFormat(req)
+	case "rejected":
+		return app.queryRejected(req)
+	case "pending":
+		return app.queryPending(req)
+	case "internal":
+		return app.queryInternal(req)
+	default:
+		return app.queryKey(req)
+	}
+}
+
+// queryKey checks if a key exists in the db
 // returns the existence status and the value if it does exist
-func (app *KVStoreApplication) Query(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+func (app *KVStoreApplication) queryKey(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
 	// Attach the key to the response
 	res.Key = req.Data
-	err := app.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(req.Data)
-		if err != nil && err != badger.ErrKeyNotFound {
-			return err
-		}
-		// If the key is not found attach the not found status
-		if err == badger.ErrKeyNotFound {
-			res.Log = "does not exist"
-		} else {
-			// Attach the value associated with the key
-			return item.Value(func(val []byte) error {
-				res.Log = "exists"
-				res.Value = val
-				return nil
-			})
-		}
-		return nil
-	})
-	// db error, panic
+	value, exists, err := app.get(app.normalizeKey(req.Data))
+	// db error, or a corrupted value
 	if err != nil {
-		panic(err)
+		app.queryReadFailed(&res, err)
+		return
+	}
+	// If the key is not found attach the not found status
+	if !exists {
+		res.Log = "does not exist"
+		return
 	}
+	// Attach the value associated with the key
+	res.Log = "exists"
+	res.Value = value
 	return
 }
 
 // Satisfy the abci.Application interface
 
-func (KVStoreApplication) Info(req abcitypes.RequestInfo) abcitypes.ResponseInfo {
-	return abcitypes.ResponseInfo{}
-}
-
-func (KVStoreApplication) InitChain(req abcitypes.RequestInitChain) abcitypes.ResponseInitChain {
-	return abcitypes.ResponseInitChain{}
+// Info tells tendermint core where the application is at
+// on startup tendermint core replays any blocks after LastBlockHeight
+func (app *KVStoreApplication) Info(req abcitypes.RequestInfo) abcitypes.ResponseInfo {
+	return abcitypes.ResponseInfo{
+		AppVersion:       app.appVersion,
+		LastBlockHeight:  app.committed.Height,
+		LastBlockAppHash: app.committed.AppHash,
+	}
 }
 
 func (KVStoreApplication) ListSnapshots(abcitypes.RequestListSnapshots) abcitypes.ResponseListSnapshots {
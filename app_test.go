@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// newTestApp returns an app on an empty in memory store
+func newTestApp(t *testing.T, opts ...Option) *KVStoreApplication {
+	t.Helper()
+	return NewKVStoreApplicationWithStore(NewMemStore(), opts...)
+}
+
+// testDB opens a badger db in a temporary directory, for the tests of what
+// only a badger store has
+func testDB(t *testing.T) *badger.DB {
+	t.Helper()
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// deliverBlock runs a block of txs at height and returns their results
+func deliverBlock(app *KVStoreApplication, height int64, txs ...string) []abcitypes.ResponseDeliverTx {
+	return deliverBlockAt(app, height, time.Time{}, txs...)
+}
+
+// deliverBlockAt runs a block of txs at height with the given block time
+func deliverBlockAt(app *KVStoreApplication, height int64, at time.Time, txs ...string) []abcitypes.ResponseDeliverTx {
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: height, Time: at}})
+	var res []abcitypes.ResponseDeliverTx
+	for _, tx := range txs {
+		res = append(res, app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte(tx)}))
+	}
+	app.EndBlock(abcitypes.RequestEndBlock{Height: height})
+	app.Commit()
+	return res
+}
+
+func TestDeliverChecksUnflushedBlocks(t *testing.T) {
+	var hashes [][]byte
+	for _, opts := range [][]Option{nil, {WithCommitBatching(10, 0)}} {
+		app := newTestApp(t, append(opts, WithKeyLimit(2))...)
+		deliverBlock(app, 1, "a=1")
+		res := deliverBlock(app, 2, "a=1", "b=1", "c=1")
+		if res[0].Code != DUPLICATE_TX {
+			t.Errorf("redelivering a=1 got code %d, want DUPLICATE_TX", res[0].Code)
+		}
+		if res[1].Code != VALID_TX || res[2].Code != KEY_LIMIT {
+			t.Errorf("the key limit got codes %d and %d", res[1].Code, res[2].Code)
+		}
+		hashes = append(hashes, app.committed.AppHash)
+	}
+	if !bytes.Equal(hashes[0], hashes[1]) {
+		t.Error("the app hash depends on commit batching")
+	}
+}
+
+func TestDeliverChecksEarlierTxsInBlock(t *testing.T) {
+	app := newTestApp(t, WithAppendOnly(true))
+	res := deliverBlock(app, 1, "a=1", "a=2")
+	if res[0].Code != VALID_TX || res[1].Code != IMMUTABLE_KEY {
+		t.Errorf("got codes %d and %d", res[0].Code, res[1].Code)
+	}
+}
+
+func TestAtomicBlocks(t *testing.T) {
+	app := newTestApp(t, WithAtomicBlocks(true))
+	deliverBlock(app, 1, "a=1")
+	res := deliverBlock(app, 2, "b=2", "bad")
+	if res[0].Code != VALID_TX || res[1].Code != INVALID_FORMAT {
+		t.Fatalf("got codes %d and %d", res[0].Code, res[1].Code)
+	}
+	if res := app.Query(abcitypes.RequestQuery{Data: []byte("b")}); res.Value != nil {
+		t.Errorf("the good tx of the discarded block persisted: %q", res.Value)
+	}
+	if res := app.Query(abcitypes.RequestQuery{Data: []byte("a")}); string(res.Value) != "1" {
+		t.Errorf("the earlier block got lost: %q", res.Value)
+	}
+	if app.committed.Height != 2 || app.committed.KeyCount != 1 {
+		t.Errorf("got height %d and %d keys", app.committed.Height, app.committed.KeyCount)
+	}
+
+	restarted := NewKVStoreApplicationWithStore(app.store)
+	if res := restarted.Query(abcitypes.RequestQuery{Data: []byte("b")}); res.Value != nil {
+		t.Errorf("the discarded block was written: %q", res.Value)
+	}
+	if !bytes.Equal(restarted.committed.AppHash, app.committed.AppHash) {
+		t.Error("the app hash changed on restart")
+	}
+}
+
+func TestAtomicBlocksOff(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "a=1", "bad")
+	if res := app.Query(abcitypes.RequestQuery{Data: []byte("a")}); string(res.Value) != "1" {
+		t.Errorf("got %q, a rejected tx shouldn't take the block down by default", res.Value)
+	}
+}
+
+func TestAppendOnly(t *testing.T) {
+	app := newTestApp(t, WithAppendOnly(true))
+	res := deliverBlock(app, 1, "a=1", "b=2", "n=1;type=int")
+	for i, r := range res {
+		if r.Code != VALID_TX {
+			t.Fatalf("first write %d got code %d: %s", i, r.Code, r.Log)
+		}
+	}
+
+	// every way of changing or removing an existing key
+	changes := []string{"a=2", "delprefix:a", "swap:a:b", "incr:n:1", "getset:a:1:3", "c=1;ttl=5"}
+	for _, tx := range changes {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}); r.Code != IMMUTABLE_KEY {
+			t.Errorf("CheckTx %q got code %d, want IMMUTABLE_KEY", tx, r.Code)
+		}
+	}
+	res = deliverBlock(app, 2, append(changes, "c=3", "cp:a:d")...)
+	for i, tx := range changes {
+		if res[i].Code != IMMUTABLE_KEY {
+			t.Errorf("DeliverTx %q got code %d, want IMMUTABLE_KEY", tx, res[i].Code)
+		}
+	}
+	if res[len(changes)].Code != VALID_TX || res[len(changes)+1].Code != VALID_TX {
+		t.Errorf("new keys got codes %d and %d", res[len(changes)].Code, res[len(changes)+1].Code)
+	}
+
+	for key, want := range map[string]string{"a": "1", "b": "2", "n": "1", "c": "3", "d": "1"} {
+		if value, _, _ := app.get([]byte(key)); string(value) != want {
+			t.Errorf("%s is %q, want %q", key, value, want)
+		}
+	}
+}
+
+func TestAppVersion(t *testing.T) {
+	store := NewMemStore()
+	app := NewKVStoreApplicationWithStore(store, WithAppVersion(3))
+	if v := app.Info(abcitypes.RequestInfo{}).AppVersion; v != 3 {
+		t.Fatalf("Info reported app version %d, want 3", v)
+	}
+	deliverBlock(app, 1, "a=1")
+
+	// the upgraded app reports its own version, the block keeps the old one
+	app = NewKVStoreApplicationWithStore(store, WithAppVersion(4))
+	if v := app.Info(abcitypes.RequestInfo{}).AppVersion; v != 4 {
+		t.Fatalf("Info reported app version %d after the upgrade, want 4", v)
+	}
+	if app.committed.AppVersion != 3 {
+		t.Fatalf("block 1 was recorded with app version %d, want 3", app.committed.AppVersion)
+	}
+	deliverBlock(app, 2, "b=2")
+	if app.committed.AppVersion != 4 {
+		t.Fatalf("block 2 was recorded with app version %d, want 4", app.committed.AppVersion)
+	}
+}
+
+func TestMaxConcurrentQueries(t *testing.T) {
+	app := newTestApp(t, WithMaxConcurrentQueries(2))
+	deliverBlock(app, 1, "a=1")
+
+	// two queries in flight take every slot
+	app.querySlots <- struct{}{}
+	app.querySlots <- struct{}{}
+	if res := app.Query(abcitypes.RequestQuery{Data: []byte("a")}); res.Code != QUERY_BUSY {
+		t.Fatalf("a query over the limit got code %d", res.Code)
+	}
+
+	// one finishes, the next query gets its slot and gives it back
+	<-app.querySlots
+	if res := app.Query(abcitypes.RequestQuery{Data: []byte("a")}); res.Code != 0 || string(res.Value) != "1" {
+		t.Fatalf("a query under the limit got code %d: %s", res.Code, res.Log)
+	}
+	if n := len(app.querySlots); n != 1 {
+		t.Fatalf("%d slots are taken after the query, want 1", n)
+	}
+	<-app.querySlots
+
+	app = newTestApp(t, WithMaxConcurrentQueries(0))
+	if app.querySlots != nil {
+		t.Error("a limit of 0 still limits the queries")
+	}
+}
+
+func TestSkipDeliverDuplicateCheck(t *testing.T) {
+	skip := newTestApp(t, WithSkipDeliverDuplicateCheck(true))
+	check := newTestApp(t)
+	blocks := [][]string{
+		{"a=1", "b=2;type=int", "c=x;type=int", "incr:b:1"},
+		{"a=2", "d=1;ttl=2", "swap:a:b"},
+		{},
+		{"e=1", "delprefix:d"},
+	}
+	// blocks without duplicates get the same results and app hash
+	for i, txs := range blocks {
+		height := int64(i + 1)
+		got, want := deliverBlock(skip, height, txs...), deliverBlock(check, height, txs...)
+		for j := range txs {
+			if got[j].Code != want[j].Code {
+				t.Errorf("%s at height %d got code %d, want %d", txs[j], height, got[j].Code, want[j].Code)
+			}
+		}
+		if !bytes.Equal(skip.committed.AppHash, check.committed.AppHash) {
+			t.Fatalf("the app hashes differ at height %d", height)
+		}
+	}
+
+	// CheckTx still turns a duplicate away
+	if r := skip.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("e=1")}); r.Code != DUPLICATE_TX {
+		t.Errorf("CheckTx of a duplicate got code %d", r.Code)
+	}
+	// one that gets into a block anyway is written again
+	if r := deliverBlock(skip, 5, "e=1")[0]; r.Code != VALID_TX {
+		t.Errorf("DeliverTx of a duplicate got code %d", r.Code)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// BackupSince writes a backup of the user entries, and RestoreBackup loads
+// one, unlike a shutdown snapshot (see snapshot.go) it's a backup of the data
+// rather than of the db, so a backup since an earlier one only has the keys
+// written or removed after it, found with the change index, and is applied
+// on top of it
+//
+// A backup is JSON lines, a header and then one line per key, in key order
+//
+//	{"version": 1, "full": true, "from": 0, "to": 10}
+//	{"key": "YQ==", "value": "MQ==", "type": "int"}
+//	{"key": "Yg==", "deleted": true}
+//
+// keys and values are base64, type is left out for bytes, a full backup has
+// every key, and no deletes, an incremental one has every key changed in
+// (from, to], as of to, only the entries are backed up, not expiries or the
+// app's own bookkeeping, a restored key doesn't expire and is indexed as
+// written when it's restored
+//
+// The height the store's data is as of after a restore is recorded, an
+// incremental backup can only be restored on top of a store as of a height
+// between its from and to, i.e. after the full backup, and the incremental
+// ones in between, otherwise it's ErrBackupGap
+// an incremental backup needs the change index to cover every height since
+// from, and nothing to have been written outside of it since, like a
+// ReplaceAll (see replace.go) or a restore, otherwise BackupSince falls back
+// to a full one
+// a restore is a single badger transaction, like ReplaceAll, and has to fit
+// within badger's transaction size limit
+
+const backupVersion = 1
+
+// ErrBackupGap is returned by RestoreBackup for an incremental backup that
+// doesn't start at or before the height the store was last restored to
+var ErrBackupGap = errors.New("the store isn't as of a height the incremental backup covers, restore the backups before it first")
+
+var (
+	restoredKey  = internalKey("restored")
+	unindexedKey = internalKey("unindexed")
+)
+
+type backupHeader struct {
+	Version int   `json:"version"`
+	Full    bool  `json:"full,omitempty"`
+	From    int64 `json:"from"`
+	To      int64 `json:"to"`
+}
+
+type backupEntry struct {
+	Key     []byte `json:"key"`
+	Value   []byte `json:"value,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// changedSince returns the keys the change index has as changed in
+// (from, to], false if it doesn't cover every height in between
+func changedSince(txn Txn, from, to int64) ([]string, bool, error) {
+	changed := map[string]bool{}
+	for height := from + 1; height <= to; height++ {
+		changes, err := readChanges(txn, height)
+		if err == ErrKeyNotFound {
+			var s changeSummary
+			var end int64
+			s, end, err = readChangeSummary(txn, height)
+			// like a diff, only the first height can be inside a
+			// summary, its changes before from are as of the summary's
+			// end, so they're still right as of to
+			if err == ErrKeyNotFound || s.From >= height || (s.From < height-1 && height != from+1) {
+				return nil, false, nil
+			}
+			if err == nil {
+				changes, height = s.Changes, end
+			}
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		for _, c := range changes {
+			changed[string(c.Key)] = true
+		}
+	}
+	keys := make([]string, 0, len(changed))
+	for key := range changed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, true, nil
+}
+
+// BackupSince writes a backup of the keys changed since height to w, as of
+// the last flushed block, returns true if it had to write a full backup
+// instead, because the change index doesn't go back to height, height 0 is
+// always a full backup, see backup.go
+func (app *KVStoreApplication) BackupSince(height int64, w io.Writer) (full bool, err error) {
+	err = app.store.View(func(txn Txn) error {
+		s, err := readState(txn)
+		if err != nil {
+			return err
+		}
+		if height > s.Height {
+			return fmt.Errorf("can't back up since height %d, the store is at %d", height, s.Height)
+		}
+		unindexed, ok, err := readHeight(txn, unindexedKey)
+		if err != nil {
+			return err
+		}
+		var keys []string
+		covered := false
+		if app.changeIndex && height > 0 && (!ok || unindexed < height) {
+			if keys, covered, err = changedSince(txn, height, s.Height); err != nil {
+				return err
+			}
+		}
+		full = !covered
+
+		enc := json.NewEncoder(w)
+		hdr := backupHeader{Version: backupVersion, Full: full, To: s.Height}
+		if !full {
+			hdr.From = height
+		}
+		if err := enc.Encode(hdr); err != nil {
+			return err
+		}
+		if full {
+			return backupAll(txn, enc)
+		}
+		for _, key := range keys {
+			value, ct, exists, err := lookup(txn, []byte(key))
+			if err != nil {
+				return err
+			}
+			if err := enc.Encode(newBackupEntry([]byte(key), value, ct, exists)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func newBackupEntry(key, value []byte, ct contentType, exists bool) backupEntry {
+	if !exists {
+		return backupEntry{Key: key, Deleted: true}
+	}
+	e := backupEntry{Key: key, Value: value}
+	if ct != typeBytes {
+		e.Type = ct.String()
+	}
+	return e
+}
+
+// backupAll writes every user entry visible to txn
+func backupAll(txn Txn, enc *json.Encoder) error {
+	it := txn.NewIterator(DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek(prefixEnd(internalPrefix)); it.Valid(); it.Next() {
+		item := it.Item()
+		value, err := itemValue(item)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(newBackupEntry(item.Key(), value, itemType(item), true)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readHeight reads a height stored under key, false if there isn't one
+func readHeight(txn Txn, key []byte) (int64, bool, error) {
+	item, err := txn.Get(key)
+	if err == ErrKeyNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	var height int64
+	err = item.Value(func(val []byte) error {
+		height = int64(binary.BigEndian.Uint64(val))
+		return nil
+	})
+	return height, true, err
+}
+
+func (app *KVStoreApplication) writeHeight(key []byte, height int64) error {
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], uint64(height))
+	return app.currentBatch.Set(key, h[:])
+}
+
+// markUnindexed records that the current batch changes keys outside of the
+// change index, at the committed height, so no incremental backup since then
+// can be taken
+func (app *KVStoreApplication) markUnindexed() error {
+	if len(app.changes) == 0 {
+		return nil
+	}
+	return app.writeHeight(unindexedKey, app.pending.Height)
+}
+
+// RestoreBackup loads a backup written by BackupSince, a full backup
+// replaces every user key like ReplaceAll, an incremental one is applied on
+// top of the store, and can only be run between blocks, see backup.go
+// like ReplaceAll it's for a chain that hasn't started, see replace.go
+func (app *KVStoreApplication) RestoreBackup(r io.Reader) error {
+	if app.replica {
+		return ErrReplica
+	}
+	if app.inBlock() {
+		return ErrBlockInProgress
+	}
+	if err := app.checkRewrite(); err != nil {
+		return err
+	}
+	defer app.noteActivity()
+
+	dec := json.NewDecoder(r)
+	var hdr backupHeader
+	if err := dec.Decode(&hdr); err != nil {
+		return fmt.Errorf("invalid backup header: %w", err)
+	}
+	if hdr.Version != backupVersion {
+		return fmt.Errorf("unsupported backup version %d", hdr.Version)
+	}
+	var entries []backupEntry
+	for {
+		var e backupEntry
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid backup entry %d: %w", len(entries), err)
+		}
+		entries = append(entries, e)
+	}
+
+	// blocks left unflushed by commit batching go first
+	if err := app.flush(); err != nil {
+		return err
+	}
+	err := app.commitOutsideBlock(false, func() error {
+		if hdr.Full {
+			kvs := make([]KV, len(entries))
+			for i, e := range entries {
+				kvs[i] = KV{Key: e.Key, Value: e.Value, Type: e.Type}
+			}
+			if err := app.replaceUserKeys(kvs); err != nil {
+				return err
+			}
+		} else {
+			restored, ok, err := readHeight(app.currentBatch, restoredKey)
+			if err != nil {
+				return err
+			}
+			if !ok || restored < hdr.From || restored > hdr.To {
+				return ErrBackupGap
+			}
+			for i, e := range entries {
+				if e.Deleted {
+					err = app.remove(app.normalizeKey(e.Key))
+				} else {
+					err = app.setChecked(e.Key, e.Value, e.Type)
+				}
+				if r, ok := asRejection(err); ok {
+					return fmt.Errorf("invalid backup entry %d: %s", i, r.log)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return app.writeHeight(restoredKey, hdr.To)
+	})
+	if err != nil {
+		return err
+	}
+	app.logger.Info("restored a backup", "full", hdr.Full, "from", hdr.From, "to", hdr.To, "entries", len(entries),
+		"keys", app.committed.KeyCount)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIncrementalBackup(t *testing.T) {
+	src := newTestApp(t, WithChangeIndex(0))
+	deliverBlock(src, 1, "a=1", "b=2", "c=3;type=int")
+	var base, inc bytes.Buffer
+	if full, err := src.BackupSince(0, &base); err != nil || !full {
+		t.Fatalf("the base backup got %v, full %v", err, full)
+	}
+	deliverBlock(src, 2, "a=10", "delprefix:b", "d=4")
+	deliverBlock(src, 3, "e=5")
+	if full, err := src.BackupSince(1, &inc); err != nil || full {
+		t.Fatalf("the incremental backup got %v, full %v", err, full)
+	}
+	if bytes.Contains(inc.Bytes(), []byte(`"key":"Yw=="`)) {
+		t.Error("the incremental backup has c, which didn't change")
+	}
+
+	dst := newTestApp(t)
+	if err := dst.RestoreBackup(bytes.NewReader(inc.Bytes())); err != ErrBackupGap {
+		t.Fatalf("restoring the incremental backup first got %v", err)
+	}
+	if err := dst.RestoreBackup(&base); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.RestoreBackup(&inc); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		want, wantExists, _ := src.get([]byte(key))
+		got, exists, _ := dst.get([]byte(key))
+		if !bytes.Equal(got, want) || exists != wantExists {
+			t.Errorf("%s is %q, want %q", key, got, want)
+		}
+	}
+	if dst.committed.KeyCount != src.committed.KeyCount || dst.committed.ValueBytes != src.committed.ValueBytes {
+		t.Errorf("restored %d keys, want %d", dst.committed.KeyCount, src.committed.KeyCount)
+	}
+}
+
+func TestBackupSinceFallsBackToFull(t *testing.T) {
+	app := newTestApp(t, WithChangeIndex(1))
+	deliverBlock(app, 1, "a=1")
+	deliverBlock(app, 2, "a=2")
+	deliverBlock(app, 3, "a=3")
+	var b bytes.Buffer
+	if full, err := app.BackupSince(1, &b); err != nil || !full {
+		t.Errorf("a backup since a pruned height got %v, full %v", err, full)
+	}
+
+	app = NewKVStoreApplicationWithStore(app.store, WithChangeIndex(0), WithOfflineRewrites(true))
+	if err := app.ReplaceAll([]KV{{Key: []byte("z"), Value: []byte("1")}}); err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if full, err := app.BackupSince(2, &b); err != nil || !full {
+		t.Errorf("a backup since a replacement got %v, full %v", err, full)
+	}
+}
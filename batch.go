@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A batch transaction writes several keys at once, one 'key=value' per
+// line, marked with a 0x00 byte and the batch scheme byte like the hex
+// scheme, see encoding.go, each line can have its own options
+//
+//	0x00 'b' line ['\n' line ...]
+//
+// e.g. '\x00ba=1\nb=2;type=int', EncodeBatch is the client side of it
+// a transaction starting with 0x00 used to always be rejected, so batches
+// don't change the meaning of any existing transaction, a 'key=value' with
+// newlines in its value is still a single write, a line can be a hex
+// scheme write but not an op or another batch
+// a batch is all or nothing, every line is checked before anything is
+// written, and it's rejected as a duplicate only if every line is, a batch
+// can also have conditions that must all hold, see guard.go
+//
+// A key can show up on more than one line, what happens then is decided by
+// the BatchDuplicatePolicy, by default the last line for the key wins and
+// the earlier ones are dropped before the batch is applied, so the key is
+// written once, duplicates are found after key normalization
+//
+// A batch can have at most defaultMaxBatchOps lines, see WithMaxBatchOps,
+// one with more is rejected with BATCH_TOO_LARGE before any of its keys are
+// looked at, the limit is part of consensus like the other transaction
+// limits, every node has to have the same one
+
+// defaultMaxBatchOps is the default limit on the lines of a batch
+const defaultMaxBatchOps = 1000
+
+// mset:value:key1,key2 sets every key in the comma separated list to value,
+// it's short for the batch '\x00bkey1=value\nkey2=value' and is turned into it
+// when it's parsed, so it's all or nothing, bounded by WithMaxBatchOps and
+// a key listed twice is handled per the BatchDuplicatePolicy like any other
+// batch, the value is stored as bytes and can't contain ':'
+func init() {
+	registerOp(&txOp{
+		name: "mset",
+		args: 2,
+		parse: func(args [][]byte) error {
+			if len(args[1]) == 0 {
+				return reject(INVALID_FORMAT, "mset needs at least one key")
+			}
+			return nil
+		},
+		batch: func(args [][]byte) []transaction {
+			keys := bytes.Split(args[1], []byte(","))
+			lines := make([]transaction, len(keys))
+			for i, key := range keys {
+				lines[i] = transaction{key: key, value: args[0]}
+			}
+			return lines
+		},
+	})
+}
+
+// BatchDuplicatePolicy decides what happens to a batch that sets a key twice
+type BatchDuplicatePolicy int
+
+const (
+	// BatchLastWins keeps the last line for every key
+	BatchLastWins BatchDuplicatePolicy = iota
+	// BatchRejectDuplicates rejects the batch with INVALID_FORMAT
+	BatchRejectDuplicates
+)
+
+// EncodeBatch returns the batch transaction of the lines, each one a
+// 'key=value' with any options, a guard, see guard.go, or a hex scheme write
+func EncodeBatch(lines ...[]byte) []byte {
+	tx := []byte{schemeMarker, schemeBatch}
+	return append(tx, bytes.Join(lines, []byte("\n"))...)
+}
+
+// parseBatch parses a batch transaction, returns false if tx isn't one
+func parseBatch(tx []byte, t *transaction) (bool, error) {
+	if len(tx) < 2 || tx[0] != schemeMarker || tx[1] != schemeBatch {
+		return false, nil
+	}
+	for i, line := range bytes.Split(tx[2:], []byte("\n")) {
+		g, isGuard, err := parseGuard(line)
+		if isGuard && err == nil {
+			t.guards = append(t.guards, g)
+			continue
+		}
+		var w transaction
+		if !isGuard {
+			w, err = parseTx(line)
+		}
+		if err != nil {
+			if r, ok := asRejection(err); ok {
+				return true, reject(r.code, fmt.Sprintf("line %d: %s", i+1, r.log))
+			}
+			return true, err
+		}
+		if w.op != nil {
+			return true, reject(INVALID_FORMAT, fmt.Sprintf("line %d: ops can't be part of a batch", i+1))
+		}
+		if w.batch != nil {
+			return true, reject(INVALID_FORMAT, fmt.Sprintf("line %d: batches can't be nested", i+1))
+		}
+		t.batch = append(t.batch, w)
+	}
+	if len(t.batch) == 0 {
+		return true, reject(INVALID_FORMAT, "a guarded batch needs at least one write")
+	}
+	return true, setBatchOptions(t)
+}
+
+// setBatchOptions sets the options of a batch from those of its lines
+func setBatchOptions(t *transaction) error {
+	// a batch has one receipt, so it only has the memo of its last line
+	// and it's applied as a whole, so the same goes for the idempotency key
+	for i, w := range t.batch[:len(t.batch)-1] {
+		if w.memo != "" {
+			return reject(INVALID_FORMAT, fmt.Sprintf("line %d: only the last line of a batch can have a memo", i+1))
+		}
+		if w.idempotencyKey != "" {
+			return reject(INVALID_FORMAT, fmt.Sprintf("line %d: only the last line of a batch can have an idempotency_key", i+1))
+		}
+	}
+	t.memo = t.batch[len(t.batch)-1].memo
+	t.idempotencyKey = t.batch[len(t.batch)-1].idempotencyKey
+	return nil
+}
+
+// dedupeBatch applies the BatchDuplicatePolicy to the lines of a batch
+func (app *KVStoreApplication) dedupeBatch(lines []transaction) ([]transaction, error) {
+	last := make(map[string]int, len(lines))
+	for i, w := range lines {
+		if _, ok := last[string(w.key)]; ok && app.batchDuplicates == BatchRejectDuplicates {
+			return nil, reject(INVALID_FORMAT, fmt.Sprintf("key %q is set more than once in the batch", w.key))
+		}
+		last[string(w.key)] = i
+	}
+	if len(last) == len(lines) {
+		return lines, nil
+	}
+	deduped := make([]transaction, 0, len(last))
+	for i, w := range lines {
+		if last[string(w.key)] == i {
+			deduped = append(deduped, w)
+		}
+	}
+	return deduped, nil
+}
+
+// checkBatch checks a batch against the state visible to txn, with s the
+// matching counters, the committed ones in CheckTx, the pending ones in
+// DeliverTx, it covers everything set can reject so that a batch that
+// passes is written in full
+func (app *KVStoreApplication) checkBatch(txn Txn, s state, t transaction) error {
+	if err := checkGuards(txn, t.guards); err != nil {
+		return err
+	}
+	duplicate := true
+	var created [][]byte
+	for _, w := range t.batch {
+		value, _, exists, err := lookup(txn, w.key)
+		if err != nil {
+			return err
+		}
+		if !exists || !bytes.Equal(value, w.value) || w.expires() {
+			duplicate = false
+		}
+		if (exists || w.expires()) && app.appendOnly {
+			return errOverwriteForbidden
+		}
+		if !exists {
+			created = append(created, w.key)
+		}
+	}
+	if duplicate {
+		return app.duplicateRejection()
+	}
+
+	for _, l := range app.keyLimits {
+		var n int64
+		for _, key := range created {
+			if bytes.HasPrefix(key, l.prefix) {
+				n++
+			}
+		}
+		if n == 0 || s.keysUnder(l.prefix)+n <= l.max {
+			continue
+		}
+		if len(l.prefix) == 0 {
+			return reject(KEY_LIMIT, fmt.Sprintf("the batch would take the store over its limit of %d keys", l.max))
+		}
+		return reject(KEY_LIMIT, fmt.Sprintf("the batch would take prefix %q over its limit of %d keys", l.prefix, l.max))
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestMultilineValueIsASingleWrite(t *testing.T) {
+	app := newTestApp(t)
+	res := deliverBlock(app, 1, "k=line1\nline2;ttl=5", "a=1\nb=2")
+	if res[0].Code != VALID_TX {
+		t.Fatalf("a multi-line value got code %d: %s", res[0].Code, res[0].Log)
+	}
+	if res[1].Code != INVALID_FORMAT {
+		t.Errorf("an unmarked batch got code %d, want INVALID_FORMAT", res[1].Code)
+	}
+	value, _, _ := app.get([]byte("k"))
+	if string(value) != "line1\nline2" {
+		t.Errorf("k is %q", value)
+	}
+	if _, exists, _ := app.get([]byte("a")); exists {
+		t.Error("the unmarked batch was written")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	app := newTestApp(t)
+	res := deliverBlock(app, 1,
+		string(EncodeBatch([]byte("a=1"), []byte("b=2;type=int"))),
+		string(EncodeBatch([]byte("c=1"), EncodeBatch([]byte("d=1")))),
+		string(EncodeBatch([]byte("a=1"), []byte("b=2;type=int"))),
+	)
+	if res[0].Code != VALID_TX {
+		t.Fatalf("got code %d: %s", res[0].Code, res[0].Log)
+	}
+	if res[1].Code != INVALID_FORMAT {
+		t.Errorf("a nested batch got code %d", res[1].Code)
+	}
+	if res[2].Code != DUPLICATE_TX {
+		t.Errorf("a repeated batch got code %d", res[2].Code)
+	}
+	for key, want := range map[string]string{"a": "1", "b": "2"} {
+		if value, _, _ := app.get([]byte(key)); string(value) != want {
+			t.Errorf("%s is %q", key, value)
+		}
+	}
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: EncodeBatch([]byte("lock=!="), []byte("e=1"))}); r.Code != VALID_TX {
+		t.Errorf("a guarded batch got code %d: %s", r.Code, r.Log)
+	}
+}
+
+func TestMaxBatchOps(t *testing.T) {
+	batch := func(prefix string, n int) []byte {
+		lines := make([][]byte, n)
+		for i := range lines {
+			lines[i] = []byte(fmt.Sprintf("%s%d=v", prefix, i))
+		}
+		return EncodeBatch(lines...)
+	}
+
+	app := newTestApp(t, WithMaxBatchOps(3))
+	at, over := batch("a", 3), batch("b", 4)
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: over}); r.Code != BATCH_TOO_LARGE {
+		t.Errorf("CheckTx of 4 lines got code %d, want BATCH_TOO_LARGE", r.Code)
+	}
+	res := deliverBlock(app, 1, string(at), string(over))
+	if res[0].Code != VALID_TX || res[1].Code != BATCH_TOO_LARGE {
+		t.Errorf("DeliverTx of 3 and 4 lines got codes %d and %d", res[0].Code, res[1].Code)
+	}
+	if app.committed.KeyCount != 3 {
+		t.Errorf("got %d keys, want only the 3 of the batch at the limit", app.committed.KeyCount)
+	}
+
+	// the default limit, and no limit
+	for _, c := range []struct {
+		opts  []Option
+		lines int
+		code  uint32
+	}{
+		{nil, defaultMaxBatchOps, VALID_TX},
+		{nil, defaultMaxBatchOps + 1, BATCH_TOO_LARGE},
+		{[]Option{WithMaxBatchOps(0)}, 2 * defaultMaxBatchOps, VALID_TX},
+	} {
+		app := newTestApp(t, c.opts...)
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: batch("k", c.lines)}); r.Code != c.code {
+			t.Errorf("%d lines got code %d, want %d: %s", c.lines, r.Code, c.code, r.Log)
+		}
+	}
+}
+
+func TestMset(t *testing.T) {
+	app := newTestApp(t, WithMaxBatchOps(3), WithKeyLimit(4))
+	res := deliverBlock(app, 1, "mset:v:", "mset:v:a,,b", "mset:v:a,b,c,d", "mset:v:a,b,a", "mset:v:a,b", "x=1", "mset:w:c,d")
+	for i, want := range []uint32{INVALID_FORMAT, INVALID_FORMAT, BATCH_TOO_LARGE, VALID_TX, DUPLICATE_TX, VALID_TX, KEY_LIMIT} {
+		if res[i].Code != want {
+			t.Errorf("tx %d got code %d, want %d: %s", i, res[i].Code, want, res[i].Log)
+		}
+	}
+	// every key gets the value, and the one over the key limit takes c
+	// down with it
+	for key, want := range map[string]string{"a": "v", "b": "v", "c": "", "d": ""} {
+		if value, _, _ := app.get([]byte(key)); string(value) != want {
+			t.Errorf("%s is %q, want %q", key, value, want)
+		}
+	}
+	if app.committed.KeyCount != 3 {
+		t.Errorf("got %d keys, want 3", app.committed.KeyCount)
+	}
+
+	if r := newTestApp(t, WithDisabledOps("mset")).CheckTx(abcitypes.RequestCheckTx{Tx: []byte("mset:v:a")}); r.Code != OP_DISABLED {
+		t.Errorf("disabled mset got code %d", r.Code)
+	}
+}
+
+func TestBatchDuplicates(t *testing.T) {
+	dup := string(EncodeBatch([]byte("k=1"), []byte("j=3"), []byte("k=2;type=int")))
+	for _, c := range []struct {
+		policy BatchDuplicatePolicy
+		code   uint32
+		k, j   string
+		keys   int64
+	}{
+		{BatchLastWins, VALID_TX, "2", "3", 2},
+		{BatchRejectDuplicates, INVALID_FORMAT, "", "", 0},
+	} {
+		app := newTestApp(t, WithBatchDuplicates(c.policy))
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(dup)}); r.Code != c.code {
+			t.Errorf("policy %d: CheckTx got code %d, want %d: %s", c.policy, r.Code, c.code, r.Log)
+		}
+		if r := deliverBlock(app, 1, dup)[0]; r.Code != c.code {
+			t.Errorf("policy %d: DeliverTx got code %d, want %d: %s", c.policy, r.Code, c.code, r.Log)
+		}
+		for key, want := range map[string]string{"k": c.k, "j": c.j} {
+			if value, _, _ := app.get([]byte(key)); string(value) != want {
+				t.Errorf("policy %d: %s is %q, want %q", c.policy, key, value, want)
+			}
+		}
+		// the key set twice is counted once
+		if app.committed.KeyCount != c.keys {
+			t.Errorf("policy %d: got %d keys, want %d", c.policy, app.committed.KeyCount, c.keys)
+		}
+	}
+
+	// a batch without a repeated key is the same under both
+	app := newTestApp(t, WithBatchDuplicates(BatchRejectDuplicates))
+	if r := deliverBlock(app, 1, string(EncodeBatch([]byte("a=1"), []byte("b=2"))))[0]; r.Code != VALID_TX {
+		t.Errorf("a batch of distinct keys got code %d: %s", r.Code, r.Log)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// The benchmarks measure the ABCI path, CheckTx, DeliverTx, on its own and
+// with batches, and Commit, run them with 'kvstore bench', or with go test
+// -bench, see bench_test.go, the runners are the same, they only need the
+// timer of a *testing.B, which the bench command has its own of, so the
+// binary doesn't link the testing package
+// badger 1.6 has no in-memory mode, so every benchmark gets a db in a
+// temporary directory, opened with OpenDB but without syncing writes, so
+// they measure the app more than the disk
+// every benchmark runs with small and with large values, a large one is over
+// the default value threshold, so it goes to the value log
+// the Commit benchmarks also run with the writes synced, by badger on every
+// write (CommitSyncWrites) and by the app once per flush (CommitSyncOnFlush),
+// see WithSyncOnFlush, to compare what durability costs, and DeliverTx runs
+// again without the duplicate check, see WithSkipDeliverDuplicateCheck
+// BenchmarkValueThreshold in bench_test.go runs the Commit benchmark with
+// values either side of the value threshold and the threshold raised, to see
+// what keeping values in the LSM tree does to write throughput, see
+// WithValueThreshold
+//
+// the numbers are only comparable between runs on the same machine, they're
+// a baseline to measure a change against, not a promise of throughput
+
+const (
+	benchSmallValue = 16
+	benchLargeValue = 64 << 10
+	// benchBlockSize is the number of transactions per block, DeliverTx
+	// benchmarks commit a block every so often to keep the batch bounded
+	benchBlockSize = 1000
+	benchBatchSize = 100
+)
+
+// benchTimer is the part of a *testing.B a runner uses
+type benchTimer interface {
+	ResetTimer()
+	StartTimer()
+	StopTimer()
+	Fatal(args ...interface{})
+}
+
+// bench is a run of a benchmark, the runner does N ops
+type bench struct {
+	benchTimer
+	N int
+}
+
+// benchmark is a benchmark along with the size of the values it writes
+type benchmark struct {
+	name string
+	run  func(b *bench, valueSize int)
+}
+
+var benchmarks = []benchmark{
+	{"CheckTx", benchCheckTx},
+	{"DeliverTx", benchDeliverTx},
+	{"DeliverTxSkipDup", benchDeliverTxSkipDuplicates},
+	{"DeliverTxBatch", benchDeliverTxBatch},
+	{"Commit", benchCommit},
+	{"CommitSyncWrites", benchCommitSyncWrites},
+	{"CommitSyncOnFlush", benchCommitSyncOnFlush},
+}
+
+// benchTime is how long the bench command runs a benchmark for at least,
+// go test -bench's default
+const benchTime = time.Second
+
+// RunBenchmarks runs every benchmark with small and large values and writes
+// a line per run with its ops/sec to w
+func RunBenchmarks(w io.Writer) {
+	for _, bm := range benchmarks {
+		for _, size := range []int{benchSmallValue, benchLargeValue} {
+			r, err := runBenchmark(bm.run, size)
+			if err != nil {
+				fmt.Fprintf(w, "%-16s %6dB FAIL %v\n", bm.name, size, err)
+				continue
+			}
+			opsPerSec := 0.0
+			if r.elapsed > 0 {
+				opsPerSec = float64(r.n) / r.elapsed.Seconds()
+			}
+			n := int64(r.n)
+			fmt.Fprintf(w, "%-16s %6dB %10d ops %12.0f ops/sec %10d ns/op %8d B/op %6d allocs/op\n",
+				bm.name, size, r.n, opsPerSec, r.elapsed.Nanoseconds()/n, int64(r.bytes)/n, int64(r.allocs)/n)
+		}
+	}
+}
+
+// benchFailure is what benchClock.Fatal panics with
+type benchFailure string
+
+// benchClock is the timer of the bench command, it times and counts the
+// allocations of a run the way a *testing.B does
+type benchClock struct {
+	n         int
+	running   bool
+	start     time.Time
+	elapsed   time.Duration
+	mem       runtime.MemStats
+	allocs    uint64
+	bytes     uint64
+	startMem  uint64
+	startSize uint64
+}
+
+func (c *benchClock) StartTimer() {
+	if c.running {
+		return
+	}
+	runtime.ReadMemStats(&c.mem)
+	c.startMem, c.startSize = c.mem.Mallocs, c.mem.TotalAlloc
+	c.start = time.Now()
+	c.running = true
+}
+
+func (c *benchClock) StopTimer() {
+	if !c.running {
+		return
+	}
+	c.elapsed += time.Since(c.start)
+	runtime.ReadMemStats(&c.mem)
+	c.allocs += c.mem.Mallocs - c.startMem
+	c.bytes += c.mem.TotalAlloc - c.startSize
+	c.running = false
+}
+
+func (c *benchClock) ResetTimer() {
+	if c.running {
+		runtime.ReadMemStats(&c.mem)
+		c.startMem, c.startSize = c.mem.Mallocs, c.mem.TotalAlloc
+		c.start = time.Now()
+	}
+	c.elapsed, c.allocs, c.bytes = 0, 0, 0
+}
+
+func (c *benchClock) Fatal(args ...interface{}) {
+	panic(benchFailure(fmt.Sprint(args...)))
+}
+
+// runBenchmark runs a benchmark with more and more ops until a run takes
+// benchTime, and returns the clock of the last run
+func runBenchmark(run func(b *bench, valueSize int), valueSize int) (c *benchClock, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			f, ok := r.(benchFailure)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s", string(f))
+		}
+	}()
+	for n := 1; ; {
+		runtime.GC()
+		c = &benchClock{n: n}
+		c.StartTimer()
+		run(&bench{benchTimer: c, N: n}, valueSize)
+		c.StopTimer()
+		if c.elapsed >= benchTime || n >= 1e9 {
+			return c, nil
+		}
+		// aim 20% past benchTime, growing at most 100x at a time
+		next := 100 * n
+		if c.elapsed > 0 {
+			next = int(1.2 * float64(n) * float64(benchTime) / float64(c.elapsed))
+		}
+		if next > 100*n {
+			next = 100 * n
+		}
+		if next <= n {
+			next = n + 1
+		}
+		n = next
+	}
+}
+
+// benchApp returns an app on a fresh db, and a function removing it
+func benchApp(b *bench) (*KVStoreApplication, func()) {
+	return benchAppWith(b, false)
+}
+
+// benchAppWith is benchApp with the db's writes synced or not
+func benchAppWith(b *bench, syncWrites bool, opts ...Option) (*KVStoreApplication, func()) {
+	return benchAppOn(b, []DBOption{WithSyncWrites(syncWrites)}, opts...)
+}
+
+// benchAppOn is benchApp with the db opened with dbOpts
+func benchAppOn(b *bench, dbOpts []DBOption, opts ...Option) (*KVStoreApplication, func()) {
+	dir, err := ioutil.TempDir("", "kvstore-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	dbOpts = append([]DBOption{WithSyncWrites(false)}, dbOpts...)
+	db, err := OpenDB(dir, append(dbOpts, func(opts *badger.Options) {
+		*opts = opts.WithLogger(nil)
+	})...)
+	if err != nil {
+		os.RemoveAll(dir)
+		b.Fatal(err)
+	}
+	app := NewKVStoreApplication(db, opts...)
+	return app, func() {
+		app.Close()
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// benchTx returns the i'th transaction, every one writes a different key
+func benchTx(i, valueSize int) []byte {
+	return []byte("key" + strconv.Itoa(i) + "=" + strings.Repeat("v", valueSize))
+}
+
+func benchBeginBlock(app *KVStoreApplication) {
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: app.committed.Height + 1}})
+}
+
+func benchEndBlock(app *KVStoreApplication) {
+	app.EndBlock(abcitypes.RequestEndBlock{})
+	app.Commit()
+}
+
+func benchCheckTx(b *bench, valueSize int) {
+	app, done := benchApp(b)
+	defer done()
+	txs := make([][]byte, b.N)
+	for i := range txs {
+		txs[i] = benchTx(i, valueSize)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if res := app.CheckTx(abcitypes.RequestCheckTx{Tx: txs[i]}); res.Code != VALID_TX {
+			b.Fatal(res.Log)
+		}
+	}
+}
+
+func benchDeliverTx(b *bench, valueSize int) {
+	app, done := benchApp(b)
+	benchDelivers(b, app, done, valueSize)
+}
+
+func benchDeliverTxSkipDuplicates(b *bench, valueSize int) {
+	app, done := benchAppWith(b, false, WithSkipDeliverDuplicateCheck(true))
+	benchDelivers(b, app, done, valueSize)
+}
+
+func benchDelivers(b *bench, app *KVStoreApplication, done func(), valueSize int) {
+	defer done()
+	txs := make([][]byte, b.N)
+	for i := range txs {
+		txs[i] = benchTx(i, valueSize)
+	}
+	b.ResetTimer()
+	benchBeginBlock(app)
+	for i := 0; i < b.N; i++ {
+		if res := app.DeliverTx(abcitypes.RequestDeliverTx{Tx: txs[i]}); res.Code != VALID_TX {
+			b.Fatal(res.Log)
+		}
+		if (i+1)%benchBlockSize == 0 {
+			b.StopTimer()
+			benchEndBlock(app)
+			benchBeginBlock(app)
+			b.StartTimer()
+		}
+	}
+	b.StopTimer()
+	benchEndBlock(app)
+}
+
+// benchDeliverTxBatch delivers batch transactions, an op is one batch of
+// benchBatchSize writes
+func benchDeliverTxBatch(b *bench, valueSize int) {
+	app, done := benchApp(b)
+	defer done()
+	txs := make([][]byte, b.N)
+	for i := range txs {
+		lines := make([]string, benchBatchSize)
+		for j := range lines {
+			lines[j] = string(benchTx(i*benchBatchSize+j, valueSize))
+		}
+		txs[i] = append([]byte{schemeMarker, schemeBatch}, strings.Join(lines, "\n")...)
+	}
+	b.ResetTimer()
+	benchBeginBlock(app)
+	for i := 0; i < b.N; i++ {
+		if res := app.DeliverTx(abcitypes.RequestDeliverTx{Tx: txs[i]}); res.Code != VALID_TX {
+			b.Fatal(res.Log)
+		}
+		if (i+1)%(benchBlockSize/benchBatchSize) == 0 {
+			b.StopTimer()
+			benchEndBlock(app)
+			benchBeginBlock(app)
+			b.StartTimer()
+		}
+	}
+	b.StopTimer()
+	benchEndBlock(app)
+}
+
+// benchCommit commits blocks of benchBatchSize transactions, an op is one
+// Commit, the transactions are delivered with the timer stopped
+func benchCommit(b *bench, valueSize int) {
+	app, done := benchApp(b)
+	benchCommits(b, app, done, valueSize)
+}
+
+func benchCommitSyncWrites(b *bench, valueSize int) {
+	app, done := benchAppWith(b, true)
+	benchCommits(b, app, done, valueSize)
+}
+
+func benchCommitSyncOnFlush(b *bench, valueSize int) {
+	app, done := benchAppWith(b, false, WithSyncOnFlush(true))
+	benchCommits(b, app, done, valueSize)
+}
+
+// benchCommitValueThreshold is benchCommit on a db with the given value
+// threshold
+func benchCommitValueThreshold(threshold int) func(b *bench, valueSize int) {
+	return func(b *bench, valueSize int) {
+		app, done := benchAppOn(b, []DBOption{WithValueThreshold(threshold)})
+		benchCommits(b, app, done, valueSize)
+	}
+}
+
+func benchCommits(b *bench, app *KVStoreApplication, done func(), valueSize int) {
+	defer done()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		benchBeginBlock(app)
+		for j := 0; j < benchBatchSize; j++ {
+			app.DeliverTx(abcitypes.RequestDeliverTx{Tx: benchTx(i*benchBatchSize+j, valueSize)})
+		}
+		app.EndBlock(abcitypes.RequestEndBlock{})
+		b.StartTimer()
+		app.Commit()
+	}
+}
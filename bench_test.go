@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// the benchmarks of bench.go, run with go test -bench, each one with small
+// and with large values
+
+func benchmarkSizes(b *testing.B, run func(b *bench, valueSize int)) {
+	for _, size := range []int{benchSmallValue, benchLargeValue} {
+		size := size
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			b.ReportAllocs()
+			run(&bench{benchTimer: b, N: b.N}, size)
+		})
+	}
+}
+
+func BenchmarkCheckTx(b *testing.B)           { benchmarkSizes(b, benchCheckTx) }
+func BenchmarkDeliverTx(b *testing.B)         { benchmarkSizes(b, benchDeliverTx) }
+func BenchmarkDeliverTxSkipDup(b *testing.B)  { benchmarkSizes(b, benchDeliverTxSkipDuplicates) }
+func BenchmarkDeliverTxBatch(b *testing.B)    { benchmarkSizes(b, benchDeliverTxBatch) }
+func BenchmarkCommit(b *testing.B)            { benchmarkSizes(b, benchCommit) }
+func BenchmarkCommitSyncWrites(b *testing.B)  { benchmarkSizes(b, benchCommitSyncWrites) }
+func BenchmarkCommitSyncOnFlush(b *testing.B) { benchmarkSizes(b, benchCommitSyncOnFlush) }
+
+// BenchmarkValueThreshold commits values under and over the default value
+// threshold, with the default threshold and with one that keeps them all in
+// the LSM tree
+func BenchmarkValueThreshold(b *testing.B) {
+	for _, threshold := range []int{defaultValueThreshold, 32 << 10} {
+		run := benchCommitValueThreshold(threshold)
+		for _, size := range []int{256, 4 << 10, 16 << 10} {
+			size := size
+			b.Run(fmt.Sprintf("threshold=%d/%dB", threshold, size), func(b *testing.B) {
+				b.ReportAllocs()
+				run(&bench{benchTimer: b, N: b.N}, size)
+			})
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+)
+
+// The block write budget (WithBlockWriteBudget) caps the bytes a block can
+// write, keys and values, however many transactions it has, tendermint's
+// max_bytes only bounds the size of the transactions, and a small one can
+// write a lot, e.g. a push onto a long list rewrites all of it
+// once a block has written its budget any transaction after it that writes
+// is rejected with BLOCK_FULL, a transaction's writes are only known once
+// it's applied, so the one that goes over the budget is still applied
+//
+// it's a safety valve for the node's disk and memory, not a limit a client
+// can plan around, but it's applied in DeliverTx, so it's part of consensus,
+// every node has to run with the same budget or they'll disagree on which
+// transactions were applied
+
+// blockBudgetSpent returns true if the block has written its budget
+func (app *KVStoreApplication) blockBudgetSpent() bool {
+	return app.blockBudget > 0 && app.blockBytes >= app.blockBudget
+}
+
+// countBlockBytes counts the writes in changes against the block's budget
+func (app *KVStoreApplication) countBlockBytes(changes []change) {
+	for _, c := range changes {
+		app.blockBytes += int64(len(c.key) + len(c.value))
+	}
+}
+
+// errBlockFull rejects a write made after the block's budget was spent
+func (app *KVStoreApplication) errBlockFull() error {
+	return reject(BLOCK_FULL, fmt.Sprintf("the block has already written its budget of %d bytes", app.blockBudget))
+}
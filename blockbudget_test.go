@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestBlockWriteBudget(t *testing.T) {
+	app := newTestApp(t, WithBlockWriteBudget(10))
+
+	// a=123 and b=1234 write 9 bytes, c=1 is still applied and takes the
+	// block over its budget, the writes after it are rejected, a removal
+	// writes nothing, so it isn't
+	res := deliverBlock(app, 1, "a=123", "b=1234", "c=1", "d=1", "swap:a:b", "delprefix:a")
+	for i, want := range []uint32{VALID_TX, VALID_TX, VALID_TX, BLOCK_FULL, BLOCK_FULL, VALID_TX} {
+		if res[i].Code != want {
+			t.Errorf("tx %d got code %d, want %d: %s", i, res[i].Code, want, res[i].Log)
+		}
+	}
+	if _, exists, _ := app.get([]byte("d")); exists {
+		t.Error("d was written over the budget")
+	}
+
+	// the budget is per block, and CheckTx doesn't spend it
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("x=123456789")}); r.Code != VALID_TX {
+		t.Errorf("CheckTx got code %d: %s", r.Code, r.Log)
+	}
+	res = deliverBlock(app, 2, "d=1", "x=123456789", "y=1")
+	for i, want := range []uint32{VALID_TX, VALID_TX, BLOCK_FULL} {
+		if res[i].Code != want {
+			t.Errorf("block 2 tx %d got code %d, want %d: %s", i, res[i].Code, want, res[i].Log)
+		}
+	}
+
+	// without a budget nothing is rejected
+	app = newTestApp(t)
+	for i, r := range deliverBlock(app, 1, "a=123", "b=1234", "c=1", "d=1") {
+		if r.Code != VALID_TX {
+			t.Errorf("tx %d without a budget got code %d", i, r.Code)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Tendermint drives a block with BeginBlock, a DeliverTx for each
+// transaction, EndBlock and then Commit, in that order and one block at a
+// time, a call out of that order, e.g. a DeliverTx without a BeginBlock,
+// is a bug in whatever drives the app, an embedding or a test harness,
+// carrying on could commit a block that was only half built, or build one
+// on top of another, so it halts the node straight away, with the call it
+// got and the one it expected
+
+// blockPhase is where the app is in the block sequence
+type blockPhase int
+
+const (
+	// phaseIdle is between blocks, only BeginBlock can come next
+	phaseIdle blockPhase = iota
+	// phaseDelivering is after BeginBlock, until EndBlock
+	phaseDelivering
+	// phaseEnded is after EndBlock, only Commit can come next
+	phaseEnded
+)
+
+// expected returns the calls allowed in the phase
+func (p blockPhase) expected() string {
+	switch p {
+	case phaseDelivering:
+		return "DeliverTx or EndBlock"
+	case phaseEnded:
+		return "Commit"
+	}
+	return "BeginBlock"
+}
+
+// advancePhase moves the app from phase from to phase to for method, and
+// halts the node if it isn't in phase from, see blockphase.go
+func (app *KVStoreApplication) advancePhase(method string, from, to blockPhase) {
+	if app.phase != from {
+		halt(method, fmt.Errorf("%s called out of order, expected %s", method, app.phase.expected()))
+	}
+	app.phase = to
+}
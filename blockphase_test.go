@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// halts runs f and returns what it halted the node with, "" if it didn't
+func halts(f func()) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = fmt.Sprint(r)
+		}
+	}()
+	f()
+	return ""
+}
+
+func TestOutOfOrderCallsHalt(t *testing.T) {
+	app := newTestApp(t)
+	begin := func(height int64) func() {
+		return func() { app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: height}}) }
+	}
+	deliver := func() { app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("b=1")}) }
+	end := func() { app.EndBlock(abcitypes.RequestEndBlock{Height: 1}) }
+	commit := func() { app.Commit() }
+	initChain := func() { app.InitChain(abcitypes.RequestInitChain{}) }
+	check := func(name string, f func(), expected string) {
+		t.Helper()
+		msg := halts(f)
+		if !strings.Contains(msg, "called out of order, expected "+expected) {
+			t.Errorf("%s: got %q", name, msg)
+		}
+	}
+
+	check("DeliverTx before BeginBlock", deliver, "BeginBlock")
+	check("EndBlock before BeginBlock", end, "BeginBlock")
+	check("Commit before BeginBlock", commit, "BeginBlock")
+	begin(1)()
+	check("BeginBlock twice", begin(2), "DeliverTx or EndBlock")
+	check("Commit before EndBlock", commit, "DeliverTx or EndBlock")
+	check("InitChain in a block", initChain, "DeliverTx or EndBlock")
+	app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("a=1")})
+	end()
+	check("DeliverTx after EndBlock", deliver, "Commit")
+	check("BeginBlock after EndBlock", begin(2), "Commit")
+	commit()
+
+	// the calls that halted changed nothing
+	if value, _, _ := app.get([]byte("a")); string(value) != "1" {
+		t.Errorf("a is %q", value)
+	}
+	if _, exists, _ := app.get([]byte("b")); exists {
+		t.Error("a DeliverTx that halted was applied")
+	}
+	if msg := halts(begin(2)); msg != "" {
+		t.Errorf("the next block halted: %s", msg)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"hash/fnv"
+	"math"
+	"sync"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// The "bloom" query returns a bloom filter of the keys in the store, or
+// under a prefix, so a client can tell a key is definitely absent without a
+// round trip, e.g. {"prefix": "user/", "fp_rate": 0.001}
+// a key that's in the store always tests positive, a key that isn't tests
+// negative except for about fp_rate of them, the filter is as of the last
+// flushed Commit, like every query but "pending", so writes since then are
+// missing from it
+//
+// The filter is a BloomFilter, as JSON
+//
+//	{"height": 10, "prefix": "user/", "keys": 2, "fp_rate": 0.001,
+//	 "bits": 64, "hashes": 10, "filter": "<base64>"}
+//
+// filter is bits bits, bit i is byte i/8 masked with 1<<(i%8), a key sets
+// (or tests) hashes bits, with h the 64 bit FNV-1a of the key, a its low 32
+// bits and b its high 32 bits, bit j, for j from 0 to hashes-1, is
+// (a + j*b) mod bits, computed in 64 bits
+// bits and hashes are the usual optimum for keys at fp_rate,
+// bits = ceil(-keys*ln(fp_rate)/ln(2)^2), at least 64, and
+// hashes = round(-ln(fp_rate)/ln(2)), at least 1
+// keys are hashed as stored, so a client has to apply the node's key
+// normalization (see the config query) to a key before testing it
+//
+// A filter is built from a keys only scan, so it costs a full read of the
+// prefix, the last one built is kept and handed out again until the next
+// Commit, after which the first query for it builds it again, a bloom filter
+// can't have keys removed from it, so it's never updated in place
+
+const (
+	defaultBloomFPRate = 0.01
+	// maxBloomBits caps a filter at 8MB, a request that would need more
+	// is refused with QUERY_TOO_LARGE
+	maxBloomBits = 8 << 20 * 8
+	minBloomBits = 64
+)
+
+// BloomFilter is the "bloom" query's response, see bloom.go for the format
+type BloomFilter struct {
+	Height int64   `json:"height"`
+	Prefix string  `json:"prefix"`
+	Keys   int     `json:"keys"`
+	FPRate float64 `json:"fp_rate"`
+	Bits   uint64  `json:"bits"`
+	Hashes int     `json:"hashes"`
+	Filter []byte  `json:"filter"`
+}
+
+// bloomSize returns the number of bits and hashes for n keys at fpRate
+func bloomSize(n int, fpRate float64) (uint64, int) {
+	if n == 0 {
+		return minBloomBits, 1
+	}
+	bits := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	if bits < minBloomBits {
+		bits = minBloomBits
+	}
+	hashes := int(math.Round(-math.Log(fpRate) / math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return uint64(bits), hashes
+}
+
+// bloomBits calls fn with each of the filter's bits for key
+func (f *BloomFilter) bloomBits(key []byte, fn func(bit uint64) bool) bool {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	a, b := sum&0xffffffff, sum>>32
+	for j := 0; j < f.Hashes; j++ {
+		if !fn((a + uint64(j)*b) % f.Bits) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *BloomFilter) add(key []byte) {
+	f.bloomBits(key, func(bit uint64) bool {
+		f.Filter[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+// MayContain returns false if the key is definitely not in the filter
+func (f *BloomFilter) MayContain(key []byte) bool {
+	if f.Bits == 0 || uint64(len(f.Filter))*8 < f.Bits {
+		return true
+	}
+	return f.bloomBits(key, func(bit uint64) bool {
+		return f.Filter[bit/8]&(1<<(bit%8)) != 0
+	})
+}
+
+// bloomCache keeps the last filter built, see bloom.go
+// the filter is only reused at the height and app hash it was built at
+type bloomCache struct {
+	mtx     sync.Mutex
+	appHash []byte
+	filter  *BloomFilter
+}
+
+type bloomRequest struct {
+	Prefix string  `json:"prefix"`
+	FPRate float64 `json:"fp_rate"`
+}
+
+// buildBloomFilter builds the filter of the user keys under prefix
+// returns false if it would need more than maxBloomBits
+func buildBloomFilter(txn Txn, prefix []byte, fpRate float64) (*BloomFilter, bool) {
+	opts := DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var keys [][]byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		key := it.Item().Key()
+		if isInternalKey(key) {
+			continue
+		}
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+
+	f := &BloomFilter{Prefix: string(prefix), Keys: len(keys), FPRate: fpRate}
+	f.Bits, f.Hashes = bloomSize(len(keys), fpRate)
+	if f.Bits > maxBloomBits {
+		return f, false
+	}
+	f.Filter = make([]byte, (f.Bits+7)/8)
+	for _, key := range keys {
+		f.add(key)
+	}
+	return f, true
+}
+
+// queryBloom returns a bloom filter of the keys under a prefix, see bloom.go
+// the request is optional, it defaults to every key at a 1% false positive
+// rate
+func (app *KVStoreApplication) queryBloom(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var breq bloomRequest
+	if len(req.Data) > 0 && !parseRequest(req, &res, &breq) {
+		return
+	}
+	if breq.FPRate == 0 {
+		breq.FPRate = defaultBloomFPRate
+	}
+	if breq.FPRate < 0 || breq.FPRate >= 1 {
+		res.Code = QUERY_INVALID
+		res.Log = "fp_rate must be between 0 and 1"
+		return
+	}
+	prefix := app.normalizeKey([]byte(breq.Prefix))
+	res.Height = app.committed.Height
+
+	c := app.bloom
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if f := c.filter; f != nil && f.Height == app.committed.Height && bytes.Equal(c.appHash, app.committed.AppHash) &&
+		f.Prefix == string(prefix) && f.FPRate == breq.FPRate {
+		respondJSON(&res, f)
+		return
+	}
+
+	var f *BloomFilter
+	fits := false
+	err := app.store.View(func(txn Txn) error {
+		f, fits = buildBloomFilter(txn, prefix, breq.FPRate)
+		return nil
+	})
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+	if !fits {
+		res.Code = QUERY_TOO_LARGE
+		res.Log = "the filter would be too large, use a higher fp_rate or a narrower prefix"
+		return
+	}
+	f.Height = app.committed.Height
+	c.filter, c.appHash = f, app.committed.AppHash
+	respondJSON(&res, f)
+	return
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestBloom(t *testing.T) {
+	app := newTestApp(t)
+	var keys, txs []string
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("a/k%d", i)
+		if i%2 == 1 {
+			key = fmt.Sprintf("b/k%d", i)
+		}
+		keys, txs = append(keys, key), append(txs, key+"=v")
+	}
+	deliverBlock(app, 1, txs...)
+
+	for _, c := range []struct {
+		prefix string
+		fpRate float64
+		keys   int
+	}{{"", 0.01, 2000}, {"a/", 0.01, 1000}, {"b/", 0.001, 1000}} {
+		var f BloomFilter
+		queryJSON(t, app, "bloom", []byte(fmt.Sprintf(`{"prefix": %q, "fp_rate": %v}`, c.prefix, c.fpRate)), &f)
+		if f.Height != 1 || f.Keys != c.keys || f.Prefix != c.prefix || f.FPRate != c.fpRate {
+			t.Errorf("%q: filter of %d keys at height %d, want %d keys", c.prefix, f.Keys, f.Height, c.keys)
+		}
+		// no false negatives
+		for _, key := range keys {
+			if strings.HasPrefix(key, c.prefix) && !f.MayContain([]byte(key)) {
+				t.Errorf("%q: filter doesn't contain %s", c.prefix, key)
+			}
+		}
+		// and about fp_rate false positives, well within 3 times it
+		fp := 0
+		for i := 0; i < 10000; i++ {
+			if f.MayContain([]byte(fmt.Sprintf("%sz%d", c.prefix, i))) {
+				fp++
+			}
+		}
+		if rate := float64(fp) / 10000; rate > 3*c.fpRate {
+			t.Errorf("%q: false positive rate %v, want about %v", c.prefix, rate, c.fpRate)
+		}
+	}
+
+	// a new block's keys are in the next filter
+	deliverBlock(app, 2, "c/new=v")
+	var f BloomFilter
+	queryJSON(t, app, "bloom", nil, &f)
+	if f.Height != 2 || f.Keys != 2001 || !f.MayContain([]byte("c/new")) {
+		t.Errorf("filter after block 2 has %d keys at height %d", f.Keys, f.Height)
+	}
+
+	for _, data := range []string{`{"fp_rate": 1}`, `{"fp_rate": -0.1}`} {
+		if res := app.Query(abcitypes.RequestQuery{Path: "bloom", Data: []byte(data)}); res.Code != QUERY_INVALID {
+			t.Errorf("%s got code %d, want QUERY_INVALID", data, res.Code)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// readCache is a small LRU cache in front of key lookups
+// it remembers both found and missing keys, entries are removed on Commit
+// for every key written in the block so it never serves stale values
+type readCache struct {
+	mtx     sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key    string
+	value  []byte
+	exists bool
+}
+
+func newReadCache(size int) *readCache {
+	return &readCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// get returns the cached lookup for key, ok is false on a cache miss
+func (c *readCache) get(key []byte) (value []byte, exists bool, ok bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	el, ok := c.entries[string(key)]
+	if !ok {
+		return nil, false, false
+	}
+	c.order.MoveToFront(el)
+	entry := el.Value.(*cacheEntry)
+	return entry.value, entry.exists, true
+}
+
+// add caches the result of a lookup, evicting the least recently used
+// entry if the cache is full
+func (c *readCache) add(key, value []byte, exists bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if el, ok := c.entries[string(key)]; ok {
+		c.order.MoveToFront(el)
+		entry := el.Value.(*cacheEntry)
+		entry.value, entry.exists = value, exists
+		return
+	}
+
+	entry := &cacheEntry{key: string(key), value: value, exists: exists}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// invalidate removes the given keys from the cache
+func (c *readCache) invalidate(changes []change) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, ch := range changes {
+		if el, ok := c.entries[string(ch.key)]; ok {
+			c.order.Remove(el)
+			delete(c.entries, string(ch.key))
+		}
+	}
+}
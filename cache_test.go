@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+func TestReadCache(t *testing.T) {
+	app := newTestApp(t, WithReadCache(2))
+	get := func(key string) string {
+		return string(app.Query(abcitypes.RequestQuery{Data: []byte(key)}).Value)
+	}
+	cached := func(key string) bool {
+		_, _, ok := app.cache.get([]byte(key))
+		return ok
+	}
+
+	deliverBlock(app, 1, "a=1", "c=3")
+	if get("a") != "1" || get("b") != "" {
+		t.Fatal("the first reads went wrong")
+	}
+	if !cached("a") || !cached("b") {
+		t.Error("found and missing keys should both be cached")
+	}
+	if get("a") != "1" {
+		t.Error("a cache hit returned the wrong value")
+	}
+
+	// the pending block can't leak into the cache before Commit
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 2}})
+	app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("a=2")})
+	app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("b=2")})
+	if get("a") != "1" || get("b") != "" {
+		t.Error("the cache served uncommitted writes")
+	}
+	app.EndBlock(abcitypes.RequestEndBlock{Height: 2})
+	app.Commit()
+	if cached("a") || cached("b") {
+		t.Error("Commit kept the written keys cached")
+	}
+	if get("a") != "2" || get("b") != "2" {
+		t.Errorf("got a=%q b=%q after the write", get("a"), get("b"))
+	}
+
+	// a third key pushes out the least recently used one
+	get("c")
+	if cached("a") || !cached("b") || !cached("c") {
+		t.Error("the cache didn't evict the least recently used key")
+	}
+}
+
+func TestReadCacheWithCommitBatching(t *testing.T) {
+	app := newTestApp(t, WithReadCache(4), WithCommitBatching(10, 0))
+	deliverBlock(app, 1, "a=1")
+	app.Flush()
+	get := func() string { return string(app.Query(abcitypes.RequestQuery{Data: []byte("a")}).Value) }
+	if get() != "1" {
+		t.Fatal("the first read went wrong")
+	}
+	deliverBlock(app, 2, "a=2")
+	app.Flush()
+	if got := get(); got != "2" {
+		t.Errorf("got %q, the flush should have invalidated a", got)
+	}
+}
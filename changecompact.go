@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// Compacting the change index (WithChangeIndexCompaction, CompactChangeIndex)
+// replaces the records of old heights with summaries of span heights each,
+// which only keep the last change to every key in the range, and whether it
+// existed before the range, that's all a diff needs, so the index shrinks to
+// roughly the number of keys changed per span
+//
+//	changeSummaryPrefix | last height (8 bytes) -> {"from": h, "changes": [...]}
+//
+// a summary covers the heights after from up to its last height, a diff that
+// starts or ends inside one is widened to its edges, the response's from and
+// to say what it's actually between, the versions in between are gone, the
+// history query sees compacted heights as pruned
+// the change index isn't part of the app hash, so nodes can compact theirs,
+// or not, independently
+// summaries are aligned on multiples of span, the records of a range are
+// only compacted once all of it is older than the threshold
+
+var changeSummaryPrefix = internalKey("chgs/")
+
+func changeSummaryKey(height int64) []byte {
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], uint64(height))
+	return append(append([]byte{}, changeSummaryPrefix...), h[:]...)
+}
+
+// changeSummary is the changes of the heights after From, up to the height
+// it's stored under, one per key in key order, Existed is from the key's
+// first change in the range, the rest from its last
+type changeSummary struct {
+	From    int64           `json:"from"`
+	Changes []indexedChange `json:"changes"`
+}
+
+// readChangeSummary returns the first summary that ends at or after height,
+// along with the height it ends at, ErrKeyNotFound if there's none
+func readChangeSummary(txn Txn, height int64) (s changeSummary, end int64, err error) {
+	opts := DefaultIteratorOptions
+	opts.Prefix = changeSummaryPrefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	it.Seek(changeSummaryKey(height))
+	if !it.Valid() {
+		return s, 0, ErrKeyNotFound
+	}
+	end = int64(binary.BigEndian.Uint64(it.Item().Key()[len(changeSummaryPrefix):]))
+	err = it.Item().Value(func(val []byte) error { return json.Unmarshal(val, &s) })
+	return s, end, err
+}
+
+// errNoChangeCompaction is returned by CompactChangeIndex without
+// WithChangeIndexCompaction
+var errNoChangeCompaction = errors.New("change index compaction is disabled")
+
+// CompactChangeIndex compacts the records of every range of heights that's
+// entirely older than the threshold set with WithChangeIndexCompaction,
+// returns the number of summaries written
+// it's a maintenance operation, so it fails during a block, blocks left
+// unflushed by commit batching are flushed first, every range is written in
+// a transaction of its own, so a range's changes have to fit in one
+func (app *KVStoreApplication) CompactChangeIndex() (int, error) {
+	if app.replica {
+		return 0, ErrReplica
+	}
+	if app.inBlock() {
+		return 0, ErrBlockInProgress
+	}
+	if !app.changeIndex || app.changeCompactSpan <= 0 {
+		return 0, errNoChangeCompaction
+	}
+	if err := app.flush(); err != nil {
+		return 0, err
+	}
+
+	span, limit := app.changeCompactSpan, app.committed.Height-app.changeCompactAfter
+	compacted := 0
+	for {
+		oldest, err := app.oldestChangeRecord()
+		if err == ErrKeyNotFound {
+			break
+		}
+		if err != nil {
+			return compacted, err
+		}
+		end := ((oldest-1)/span + 1) * span
+		if end > limit {
+			break
+		}
+		if err := app.store.Update(func(txn Txn) error {
+			return compactChanges(txn, end-span, end)
+		}); err != nil {
+			return compacted, err
+		}
+		compacted++
+	}
+	if compacted > 0 {
+		app.logger.Info("compacted change index", "summaries", compacted, "span", span)
+	}
+	return compacted, nil
+}
+
+// oldestChangeRecord returns the lowest height above 0 with a record, the
+// record of height 0 is the genesis state's, it's never compacted
+func (app *KVStoreApplication) oldestChangeRecord() (height int64, err error) {
+	err = app.store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = changeIndexPrefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		it.Seek(changeIndexKey(1))
+		if !it.Valid() {
+			return ErrKeyNotFound
+		}
+		height = int64(binary.BigEndian.Uint64(it.Item().Key()[len(changeIndexPrefix):]))
+		return nil
+	})
+	return height, err
+}
+
+// compactChanges replaces the records of the heights after start up to end
+// with a summary, a gap in the records, e.g. from pruning, moves the start
+// of the summary up to it, as a diff can't be made across the gap anyway
+// the summary ends at the last height with a record
+func compactChanges(txn Txn, start, end int64) error {
+	var heights []int64
+	var records [][]byte
+	opts := DefaultIteratorOptions
+	opts.Prefix = changeIndexPrefix
+	it := txn.NewIterator(opts)
+	for it.Seek(changeIndexKey(start + 1)); it.Valid(); it.Next() {
+		height := int64(binary.BigEndian.Uint64(it.Item().Key()[len(changeIndexPrefix):]))
+		if height > end {
+			break
+		}
+		val, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			it.Close()
+			return err
+		}
+		heights, records = append(heights, height), append(records, val)
+	}
+	it.Close()
+	if len(heights) == 0 {
+		return nil
+	}
+
+	s := changeSummary{From: start}
+	first := map[string]indexedChange{}
+	last := map[string]indexedChange{}
+	for i, height := range heights {
+		if height != s.From+1 && (i == 0 || height != heights[i-1]+1) {
+			s.From = height - 1
+			first, last = map[string]indexedChange{}, map[string]indexedChange{}
+		}
+		var changes []indexedChange
+		if err := json.Unmarshal(records[i], &changes); err != nil {
+			return err
+		}
+		for _, c := range changes {
+			if _, ok := first[string(c.Key)]; !ok {
+				first[string(c.Key)] = c
+			}
+			last[string(c.Key)] = c
+		}
+		if err := txn.Delete(changeIndexKey(height)); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(last))
+	for key := range last {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	s.Changes = make([]indexedChange, len(keys))
+	for i, key := range keys {
+		c := last[key]
+		c.Existed = first[key].Existed
+		s.Changes[i] = c
+	}
+	val, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return txn.Set(changeSummaryKey(heights[len(heights)-1]), val)
+}
+
+// queryCompactChanges is the privileged query path for CompactChangeIndex
+func (app *KVStoreApplication) queryCompactChanges(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if !app.adminQueries {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "admin queries are disabled"
+		return
+	}
+	n, err := app.CompactChangeIndex()
+	if err != nil {
+		res.Code = QUERY_FAILED
+		res.Log = err.Error()
+		return
+	}
+	res.Log = "compacted " + strconv.Itoa(n) + " ranges"
+	return
+}
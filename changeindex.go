@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// The change index records every block's changes under its height, so a
+// consumer that has the store as of some height can catch up by applying
+// the diff to the current height instead of copying everything again
+//
+//	changeIndexPrefix | height (8 bytes) -> the block's changes as JSON
+//
+// every block gets a record, even an empty one, so a missing record means
+// the height was pruned, or indexed before the index was enabled
+
+var changeIndexPrefix = internalKey("chg/")
+
+func changeIndexKey(height int64) []byte {
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], uint64(height))
+	return append(append([]byte{}, changeIndexPrefix...), h[:]...)
+}
+
+// indexedChange is a change as stored in the index
+type indexedChange struct {
+	Key     []byte `json:"key"`
+	Value   []byte `json:"value,omitempty"`
+	Type    byte   `json:"type"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Existed bool   `json:"existed,omitempty"`
+}
+
+// readChanges returns the changes recorded for height as seen by txn, the
+// error is ErrKeyNotFound if there's no record of it
+func readChanges(txn Txn, height int64) ([]indexedChange, error) {
+	item, err := txn.Get(changeIndexKey(height))
+	if err != nil {
+		return nil, err
+	}
+	var changes []indexedChange
+	err = item.Value(func(val []byte) error { return json.Unmarshal(val, &changes) })
+	return changes, err
+}
+
+// indexChanges records the current block's changes, and prunes the blocks
+// that have fallen out of the retention window
+func (app *KVStoreApplication) indexChanges() error {
+	if !app.changeIndex {
+		return nil
+	}
+	changes := make([]indexedChange, len(app.changes))
+	for i, c := range app.changes {
+		changes[i] = indexedChange{Key: c.key, Value: c.value, Type: byte(c.contentType), Deleted: c.deleted, Existed: c.existed}
+	}
+	val, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	if err := app.currentBatch.Set(changeIndexKey(app.pending.Height), val); err != nil {
+		return err
+	}
+
+	if app.changeIndexRetain <= 0 || app.pending.Height <= app.changeIndexRetain {
+		return nil
+	}
+	cutoffHeight := app.pending.Height - app.changeIndexRetain
+	cutoff := changeIndexKey(cutoffHeight)
+	var stale [][]byte
+	opts := DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = changeIndexPrefix
+	it := app.currentBatch.NewIterator(opts)
+	for it.Seek(changeIndexPrefix); it.Valid() && bytes.Compare(it.Item().Key(), cutoff) <= 0; it.Next() {
+		stale = append(stale, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+	// a summary goes once the last height it covers does, see changecompact.go
+	cutoff = changeSummaryKey(cutoffHeight)
+	opts.Prefix = changeSummaryPrefix
+	it = app.currentBatch.NewIterator(opts)
+	for it.Seek(changeSummaryPrefix); it.Valid() && bytes.Compare(it.Item().Key(), cutoff) <= 0; it.Next() {
+		stale = append(stale, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+	for _, key := range stale {
+		if err := app.currentBatch.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxDiffBlocks bounds the heights one diff query reads
+const maxDiffBlocks = 10000
+
+type diffRequest struct {
+	From int64 `json:"from"`
+	// To defaults to the last flushed height, the last committed one
+	// without commit batching
+	To int64 `json:"to"`
+}
+
+// diffResponse is the diff between from and to, which are the request's
+// unless the change index has been compacted, see changecompact.go
+type diffResponse struct {
+	From     int64    `json:"from"`
+	To       int64    `json:"to"`
+	Added    []kvPair `json:"added"`
+	Modified []kvPair `json:"modified"`
+	Deleted  []string `json:"deleted"`
+}
+
+// queryDiff returns the keys added, modified and deleted between the store
+// as of height from and as of height to, e.g. {"from": 100, "to": 120}
+// each key shows up once with its value as of to, in key order
+// fails with QUERY_PRUNED if the change index doesn't cover the range,
+// the consumer then needs to resync from scratch, and with QUERY_UNFLUSHED
+// if to is a height commit batching hasn't flushed yet
+// to is at most maxDiffBlocks after from, a consumer further behind than
+// that catches up in steps, asking again from the to of the response
+// a from or to inside a compacted range is moved out to its edges
+func (app *KVStoreApplication) queryDiff(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if !app.changeIndex {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "the change index is disabled"
+		return
+	}
+	var dreq diffRequest
+	if !parseRequest(req, &res, &dreq) {
+		return
+	}
+	defaultTo := dreq.To == 0
+	if defaultTo {
+		dreq.To = app.committed.Height
+	}
+	if dreq.From < 0 || dreq.From > dreq.To || dreq.To > app.committed.Height {
+		res.Code = QUERY_INVALID
+		res.Log = fmt.Sprintf("from and to must be heights with from <= to <= %d", app.committed.Height)
+		return
+	}
+
+	// first and last are the first and last change to each key in the range
+	// from and to only differ from the request's if they're in a summary
+	first := map[string]indexedChange{}
+	last := map[string]indexedChange{}
+	from, to := dreq.From, dreq.To
+	pruned, unflushed := false, false
+	err := app.store.View(func(txn Txn) error {
+		s, err := readState(txn)
+		if err != nil {
+			return err
+		}
+		if defaultTo && to > s.Height && from <= s.Height {
+			to = s.Height
+		}
+		if to > s.Height {
+			unflushed = true
+			return nil
+		}
+		if to-from > maxDiffBlocks {
+			to = from + maxDiffBlocks
+		}
+		for height := from + 1; height <= to; height++ {
+			changes, err := readChanges(txn, height)
+			if err == ErrKeyNotFound {
+				var s changeSummary
+				var end int64
+				s, end, err = readChangeSummary(txn, height)
+				// a diff can only start inside a summary, a gap
+				// anywhere else is pruned heights
+				if err == ErrKeyNotFound || s.From >= height || (s.From < height-1 && height != from+1) {
+					pruned = true
+					return nil
+				}
+				if err == nil {
+					if s.From < from {
+						from = s.From
+					}
+					if end > to {
+						to = end
+					}
+					changes, height = s.Changes, end
+				}
+			}
+			if err != nil {
+				return err
+			}
+			for _, c := range changes {
+				if _, ok := first[string(c.Key)]; !ok {
+					first[string(c.Key)] = c
+				}
+				last[string(c.Key)] = c
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		halt("Query", err)
+	}
+	if unflushed {
+		res.Code = QUERY_UNFLUSHED
+		res.Log = "these heights aren't flushed yet, try again once they are"
+		return
+	}
+	if pruned {
+		res.Code = QUERY_PRUNED
+		res.Log = "the change index doesn't cover these heights, a full resync is needed"
+		return
+	}
+
+	keys := make([]string, 0, len(last))
+	for key := range last {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	dres := diffResponse{From: from, To: to, Added: []kvPair{}, Modified: []kvPair{}, Deleted: []string{}}
+	for _, key := range keys {
+		existed, c := first[key].Existed, last[key]
+		pair := kvPair{Key: key, Value: string(c.Value), Type: contentType(c.Type).String()}
+		switch {
+		case !existed && !c.Deleted:
+			dres.Added = append(dres.Added, pair)
+		case existed && !c.Deleted:
+			dres.Modified = append(dres.Modified, pair)
+		// a consumer as of the requested from can have a key that came
+		// and went after the summary's from
+		case c.Deleted && (existed || from < dreq.From):
+			dres.Deleted = append(dres.Deleted, key)
+		}
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, dres)
+	return
+}
+
+const (
+	defaultHistoryCount = 10
+	maxHistoryCount     = 100
+	// maxHistoryScan bounds the blocks one history query reads
+	maxHistoryScan = 10000
+)
+
+type historyRequest struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+	// Before optionally only returns versions from before that height,
+	// the next of the previous response
+	Before int64 `json:"before"`
+}
+
+// keyVersion is the value a key had as of a height
+type keyVersion struct {
+	Height  int64  `json:"height"`
+	Value   string `json:"value,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+type historyResponse struct {
+	Versions []keyVersion `json:"versions"`
+	// Next is set if the query stopped after maxHistoryScan blocks, pass
+	// it as before to carry on further back
+	Next int64 `json:"next,omitempty"`
+	// Pruned is set if the change index doesn't go back any further, any
+	// versions older than the last one returned are gone
+	Pruned bool `json:"pruned,omitempty"`
+}
+
+// queryHistory returns the latest versions of a key, newest first, e.g.
+// {"key": "a", "count": 5}, a version is the value as of the end of a
+// block that changed the key, a removal is a version with deleted set
+// the versions come from the change index, which has to be read a block at
+// a time going back from the last flushed height, blocks commit batching
+// hasn't flushed yet have no record, so at most maxHistoryScan blocks are
+// read per query
+func (app *KVStoreApplication) queryHistory(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if !app.changeIndex {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "the change index is disabled"
+		return
+	}
+	var hreq historyRequest
+	if !parseRequest(req, &res, &hreq) {
+		return
+	}
+	key := app.normalizeKey([]byte(hreq.Key))
+	if len(key) == 0 {
+		res.Code = QUERY_INVALID
+		res.Log = "key can't be empty"
+		return
+	}
+	count := clampLimit(hreq.Count, defaultHistoryCount, maxHistoryCount)
+
+	var flushed int64
+	hres := historyResponse{Versions: []keyVersion{}}
+	err := app.store.View(func(txn Txn) error {
+		s, err := readState(txn)
+		if err != nil {
+			return err
+		}
+		flushed = s.Height
+		height := flushed
+		if hreq.Before > 0 && hreq.Before <= height {
+			height = hreq.Before - 1
+		}
+		for scanned := 0; height >= 0 && len(hres.Versions) < count; height, scanned = height-1, scanned+1 {
+			if scanned == maxHistoryScan {
+				hres.Next = height + 1
+				return nil
+			}
+			// compacted heights are as good as pruned, a summary
+			// only has the last version of a key
+			changes, err := readChanges(txn, height)
+			if err == ErrKeyNotFound {
+				// there is only a record for height 0 if the chain
+				// started with a genesis state
+				hres.Pruned = height > 0
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			// the last change in the block is the version as of its end
+			for i := len(changes) - 1; i >= 0; i-- {
+				c := changes[i]
+				if !bytes.Equal(c.Key, key) {
+					continue
+				}
+				v := keyVersion{Height: height, Deleted: c.Deleted}
+				if !c.Deleted {
+					v.Value, v.Type = string(c.Value), contentType(c.Type).String()
+				}
+				hres.Versions = append(hres.Versions, v)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		halt("Query", err)
+	}
+
+	res.Height = flushed
+	respondJSON(&res, hres)
+	return
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestDiffUnflushedHeights(t *testing.T) {
+	app := newTestApp(t, WithChangeIndex(0), WithCommitBatching(10, 0))
+	for h := int64(1); h <= 3; h++ {
+		deliverBlock(app, h, fmt.Sprintf("k%d=v", h))
+	}
+	res := app.Query(abcitypes.RequestQuery{Path: "diff", Data: []byte(`{"from": 0, "to": 3}`)})
+	if res.Code != QUERY_UNFLUSHED {
+		t.Errorf("a diff to an unflushed height got code %d: %s", res.Code, res.Log)
+	}
+	if err := app.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var dres diffResponse
+	queryJSON(t, app, "diff", []byte(`{"from": 0, "to": 3}`), &dres)
+	if len(dres.Added) != 3 {
+		t.Errorf("got %d keys added once flushed, want 3", len(dres.Added))
+	}
+}
+
+func TestDiffRangeIsCapped(t *testing.T) {
+	app := newTestApp(t, WithChangeIndex(0))
+	deliverBlock(app, 1, "a=1")
+	// the empty blocks in between, recorded directly to keep the test fast
+	err := app.store.Update(func(txn Txn) error {
+		for h := int64(2); h <= maxDiffBlocks+2; h++ {
+			if err := txn.Set(changeIndexKey(h), []byte("[]")); err != nil {
+				return err
+			}
+		}
+		app.committed.Height = maxDiffBlocks + 2
+		return app.committed.save(txn)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliverBlock(app, maxDiffBlocks+3, "b=1")
+
+	var dres diffResponse
+	queryJSON(t, app, "diff", []byte(`{"from": 0}`), &dres)
+	if dres.To != maxDiffBlocks || len(dres.Added) != 1 {
+		t.Fatalf("the first step is %d to %d with %d keys", dres.From, dres.To, len(dres.Added))
+	}
+	queryJSON(t, app, "diff", []byte(fmt.Sprintf(`{"from": %d}`, dres.To)), &dres)
+	if dres.To != maxDiffBlocks+3 || len(dres.Added) != 1 || dres.Added[0].Key != "b" {
+		t.Errorf("the second step is %d to %d with %v", dres.From, dres.To, dres.Added)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	app := newTestApp(t, WithChangeIndex(0))
+	deliverBlock(app, 1, "a=1")
+	deliverBlock(app, 2, "b=1")
+	deliverBlock(app, 3, "a=2", "a=3;type=int")
+	deliverBlock(app, 4, "delprefix:a")
+	var hres historyResponse
+	queryJSON(t, app, "history", []byte(`{"key": "a"}`), &hres)
+	want := []keyVersion{{Height: 4, Deleted: true}, {Height: 3, Value: "3", Type: "int"}, {Height: 1, Value: "1", Type: "bytes"}}
+	if !reflect.DeepEqual(hres.Versions, want) || hres.Pruned {
+		t.Errorf("got %+v", hres)
+	}
+	queryJSON(t, app, "history", []byte(`{"key": "a", "count": 1, "before": 4}`), &hres)
+	if len(hres.Versions) != 1 || hres.Versions[0].Height != 3 {
+		t.Errorf("before 4 got %+v", hres.Versions)
+	}
+}
+
+func TestHistoryUnflushedHeights(t *testing.T) {
+	app := newTestApp(t, WithChangeIndex(0), WithCommitBatching(10, 0))
+	deliverBlock(app, 1, "a=1")
+	deliverBlock(app, 2, "a=2")
+	if err := app.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	deliverBlock(app, 3, "a=3")
+	var hres historyResponse
+	queryJSON(t, app, "history", []byte(`{"key": "a"}`), &hres)
+	if len(hres.Versions) != 2 || hres.Versions[0].Height != 2 || hres.Pruned {
+		t.Errorf("got %+v", hres)
+	}
+}
+
+func TestChangeIndexCompaction(t *testing.T) {
+	app := newTestApp(t, WithChangeIndex(0), WithChangeIndexCompaction(5, 4), WithAdminQueries(true))
+	txs := map[int64][]string{1: {"a=1", "b=1"}, 2: {"c=1"}, 3: {"x=1", "delprefix:x"}, 4: {"c=2"}, 5: {"a=2"},
+		6: {"e=1"}, 7: {"c=3"}, 8: {"f=1"}, 9: {"g=1"}, 10: {"a=3"}, 11: {"h=1"}, 12: {"i=1"}}
+	for h := int64(1); h <= 12; h++ {
+		deliverBlock(app, h, txs[h]...)
+	}
+	diff := func(from, to int64) (dres diffResponse) {
+		queryJSON(t, app, "diff", []byte(fmt.Sprintf(`{"from": %d, "to": %d}`, from, to)), &dres)
+		return dres
+	}
+	aligned := [][2]int64{{0, 4}, {0, 8}, {4, 8}, {4, 12}, {8, 12}, {0, 12}}
+	before := map[[2]int64]diffResponse{}
+	for _, r := range aligned {
+		before[r] = diff(r[0], r[1])
+	}
+
+	// only heights 1 to 4 are entirely more than 5 blocks old, compacting
+	// again has nothing left to do until more blocks are committed
+	for _, want := range []string{"compacted 1 ranges", "compacted 0 ranges"} {
+		if res := app.Query(abcitypes.RequestQuery{Path: "compactchanges"}); res.Code != 0 || res.Log != want {
+			t.Errorf("compactchanges got code %d: %s, want %s", res.Code, res.Log, want)
+		}
+	}
+
+	// diffs on the edges of the summary are as exact as before
+	for _, r := range aligned {
+		if got := diff(r[0], r[1]); !reflect.DeepEqual(got, before[r]) {
+			t.Errorf("diff %v is %+v after compacting, was %+v", r, got, before[r])
+		}
+	}
+	// one from inside it is widened to its start, and a key that came and
+	// went inside the summary shows up as deleted, as a consumer as of 2
+	// might have it
+	got := diff(2, 6)
+	want := diffResponse{From: 0, To: 6,
+		Added: []kvPair{
+			{Key: "a", Value: "2", Type: "bytes"}, {Key: "b", Value: "1", Type: "bytes"},
+			{Key: "c", Value: "2", Type: "bytes"}, {Key: "e", Value: "1", Type: "bytes"},
+		},
+		Modified: []kvPair{},
+		Deleted:  []string{"x"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diff 2 to 6 is %+v, want %+v", got, want)
+	}
+	// heights past it are untouched
+	if got := diff(8, 9); got.From != 8 || got.To != 9 || len(got.Added) != 1 || got.Added[0].Key != "g" {
+		t.Errorf("diff 8 to 9 is %+v", got)
+	}
+
+	if _, err := newTestApp(t, WithChangeIndex(0)).CompactChangeIndex(); err != errNoChangeCompaction {
+		t.Errorf("compacting without WithChangeIndexCompaction got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+type fixedDisk uint64
+
+func (d fixedDisk) FreeSpace() (uint64, error) { return uint64(d), nil }
+
+// run with -race, CheckTx is called from many goroutines at once
+func TestConcurrentCheckTx(t *testing.T) {
+	app := newTestApp(t, WithMaxConcurrentCheckTx(4), WithDeadLetterLog(50), WithReadCache(16),
+		WithDiskGuard(fixedDisk(1<<40), 1, time.Nanosecond))
+	var txs []string
+	for i := 0; i < 100; i++ {
+		txs = append(txs, fmt.Sprintf("k%d=v", i))
+	}
+	deliverBlock(app, 1, txs...)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				k := (g*7 + i) % 150
+				res := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(fmt.Sprintf("k%d=v", k))})
+				want := uint32(VALID_TX)
+				if k < 100 {
+					want = DUPLICATE_TX
+				}
+				if res.Code != want {
+					t.Errorf("k%d got code %d, want %d: %s", k, res.Code, want, res.Log)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestCheckTxWaitsForASlot(t *testing.T) {
+	app := newTestApp(t, WithMaxConcurrentCheckTx(2))
+	app.checkSlots <- struct{}{}
+	app.checkSlots <- struct{}{}
+	done := make(chan uint32)
+	go func() { done <- app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("a=1")}).Code }()
+	select {
+	case <-done:
+		t.Fatal("CheckTx ran over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-app.checkSlots
+	if code := <-done; code != VALID_TX {
+		t.Errorf("got code %d", code)
+	}
+}
+
+func TestDuplicateInfo(t *testing.T) {
+	for _, mark := range []bool{false, true} {
+		app := newTestApp(t, WithDuplicateInfo(mark))
+		deliverBlock(app, 1, "a=1")
+		check := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("a=1")})
+		deliver := deliverBlock(app, 2, "a=1")[0]
+
+		wantLog, wantInfo := "key=value pair already exists", ""
+		if mark {
+			wantLog = "key=value pair is already present in the store, it is safe to drop this transaction"
+			wantInfo = INFO_ALREADY_PRESENT
+		}
+		for name, res := range map[string]abcitypes.ResponseCheckTx{"CheckTx": check, "DeliverTx": {Code: deliver.Code, Log: deliver.Log, Info: deliver.Info}} {
+			if res.Code != DUPLICATE_TX || res.Log != wantLog || res.Info != wantInfo {
+				t.Errorf("marked %v: %s got code %d, log %q and info %q", mark, name, res.Code, res.Log, res.Info)
+			}
+		}
+	}
+}
+
+func TestMaxTxSize(t *testing.T) {
+	store := &faultStore{Store: NewMemStore()}
+	app := NewKVStoreApplicationWithStore(store, WithMaxTxSize(5))
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("ab=cd")}); r.Code != VALID_TX {
+		t.Errorf("a tx at the limit got code %d: %s", r.Code, r.Log)
+	}
+
+	// with the store failing any read would halt, so the size is checked first
+	store.err = errors.New("the store was read")
+	for _, tx := range []string{"ab=cde", "abcdef", "\x00b\na=1\nb=2"} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}); r.Code != TX_TOO_LARGE {
+			t.Errorf("%q got code %d", tx, r.Code)
+		}
+	}
+	if msg := halts(func() { app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("ab=cd")}) }); msg == "" {
+		t.Error("the store wasn't read for a tx under the limit")
+	}
+}
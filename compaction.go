@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+)
+
+// With compaction hints (WithCompactionHints) a block that deletes at least
+// a threshold of keys schedules a flatten, as reads keep skipping over the
+// tombstones until compaction gets to them
+// badger v1.6 can only compact the whole tree, not a key range, so the range
+// of the deleted keys is only recorded in the logs, the flatten itself runs
+// on the maintenance goroutine in the next idle window, never in Commit
+// hints from several blocks before a window are merged into one flatten
+
+// compactionHint describes the deletes since the last hinted flatten
+type compactionHint struct {
+	from, to []byte
+	deletes  int
+	height   int64
+}
+
+func (h *compactionHint) merge(o compactionHint) {
+	if h.from == nil || bytes.Compare(o.from, h.from) < 0 {
+		h.from = o.from
+	}
+	if bytes.Compare(o.to, h.to) > 0 {
+		h.to = o.to
+	}
+	h.deletes += o.deletes
+	h.height = o.height
+}
+
+// hintCompaction schedules a flatten if the block deleted enough keys
+func (app *KVStoreApplication) hintCompaction() {
+	if app.compactionThreshold <= 0 {
+		return
+	}
+	var hint compactionHint
+	for _, c := range app.changes {
+		if !c.deleted {
+			continue
+		}
+		if hint.from == nil || bytes.Compare(c.key, hint.from) < 0 {
+			hint.from = c.key
+		}
+		if bytes.Compare(c.key, hint.to) > 0 {
+			hint.to = c.key
+		}
+		hint.deletes++
+	}
+	if hint.deletes < app.compactionThreshold {
+		return
+	}
+	hint.height = app.pending.Height
+	app.logger.Info("scheduling compaction", "height", hint.height, "deletes", hint.deletes, "from", string(hint.from), "to", string(hint.to))
+	app.maintainer.hint(hint)
+}
+
+// hint schedules a flatten for the next maintenance window
+func (m *maintainer) hint(h compactionHint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.compaction == nil {
+		m.compaction = &h
+		return
+	}
+	m.compaction.merge(h)
+}
+
+// takeHint returns the scheduled compaction, if any, and clears it
+func (m *maintainer) takeHint() *compactionHint {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.compaction
+	m.compaction = nil
+	return h
+}
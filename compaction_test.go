@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCompactionHints(t *testing.T) {
+	app := NewKVStoreApplication(testDB(t), WithCompactionHints(3))
+	defer app.Close()
+	deliverBlock(app, 1, "d/1=1", "d/2=1", "d/3=1", "d/4=1", "e/1=1", "e/2=1", "f=1;ttl=1")
+
+	// under the threshold, a delete and an expiry
+	deliverBlock(app, 2, "delprefix:e/1")
+	if h := app.maintainer.compaction; h != nil {
+		t.Fatalf("two deletes scheduled a compaction: %+v", h)
+	}
+
+	deliverBlock(app, 3, "delprefix:d/", "a=1")
+	h := app.maintainer.compaction
+	if h == nil {
+		t.Fatal("four deletes didn't schedule a compaction")
+	}
+	if string(h.from) != "d/1" || string(h.to) != "d/4" || h.deletes != 4 || h.height != 3 {
+		t.Fatalf("got hint %s to %s with %d deletes at %d", h.from, h.to, h.deletes, h.height)
+	}
+
+	// a block under the threshold leaves the hint alone, one over it before
+	// the window merges into the same flatten
+	deliverBlock(app, 4, "delprefix:e/", "delprefix:a", "d/1=2")
+	deliverBlock(app, 5, "c/1=1", "c/2=1", "c/3=1")
+	deliverBlock(app, 6, "delprefix:c/")
+	h = app.maintainer.compaction
+	if string(h.from) != "c/1" || string(h.to) != "d/4" || h.deletes != 7 || h.height != 6 {
+		t.Fatalf("got merged hint %s to %s with %d deletes at %d", h.from, h.to, h.deletes, h.height)
+	}
+
+	app.maintainer.window()
+	if h := app.maintainer.compaction; h != nil {
+		t.Fatalf("the window didn't take the hint: %+v", h)
+	}
+	if value, _, _ := app.get([]byte("d/1")); string(value) != "2" {
+		t.Fatalf("d/1 is %q after the flatten", value)
+	}
+}
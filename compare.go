@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"sort"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// The "compare" query tells a client holding a copy of a prefix whether its
+// copy matches the node's, and if it doesn't, which parts of it don't, so it
+// can resync only those, e.g.
+//
+//	{"prefix": "users/", "checksum": "<hex>", "ranges": {"users/a": "<hex>", "users/b": "<hex>"}}
+//
+// checksum is the client's checksum of the prefix, as the "checksum" query
+// computes it, and ranges are its checksums of the sub-ranges of the prefix,
+// one for each prefix one byte longer that it has keys under, e.g. "users/a",
+// plus the prefix itself for the single key equal to it, if there's one
+//
+//	{"height": 10, "match": false, "checksum": "<hex>", "keys": 120,
+//	 "differing": [{"prefix": "users/b", "checksum": "<hex>", "keys": 3}]}
+//
+// differing are the sub-ranges whose checksum differs, with the node's
+// checksum and key count, a sub-range it has no keys under has 0 keys and
+// the checksum of nothing, the client compares again with each one as the
+// prefix to narrow the difference down a byte at a time, until the ranges
+// are small enough to copy
+// it's a single pass over the prefix, reading every value under it, as
+// expensive as a checksum of it, each step down reads less
+
+type compareRequest struct {
+	Prefix   string            `json:"prefix"`
+	Checksum string            `json:"checksum"`
+	Ranges   map[string]string `json:"ranges"`
+}
+
+type compareRange struct {
+	Prefix   string `json:"prefix"`
+	Checksum string `json:"checksum"`
+	Keys     int64  `json:"keys"`
+}
+
+type compareResponse struct {
+	Height    int64          `json:"height"`
+	Match     bool           `json:"match"`
+	Checksum  string         `json:"checksum"`
+	Keys      int64          `json:"keys"`
+	Differing []compareRange `json:"differing,omitempty"`
+}
+
+// rangeChecksum is the checksum of a sub-range as it's being built
+type rangeChecksum struct {
+	h    hash.Hash
+	keys int64
+}
+
+// checksumSubRanges returns the checksum of the user entries under prefix,
+// as checksumRange does, along with the checksum of each of its sub-ranges,
+// see compare.go
+func checksumSubRanges(txn Txn, prefix []byte) (compareRange, map[string]*rangeChecksum, error) {
+	total := sha256.New()
+	var keys int64
+	ranges := map[string]*rangeChecksum{}
+	opts := DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		item := it.Item()
+		if isInternalKey(item.Key()) {
+			continue
+		}
+		sub := item.Key()[:len(prefix)]
+		if len(item.Key()) > len(prefix) {
+			sub = item.Key()[:len(prefix)+1]
+		}
+		r := ranges[string(sub)]
+		if r == nil {
+			r = &rangeChecksum{h: sha256.New()}
+			ranges[string(sub)] = r
+		}
+		err := item.Value(func(val []byte) error {
+			val, err := decodeValue(item.Key(), item.UserMeta(), val)
+			if err != nil {
+				return err
+			}
+			entry := encodeEntry(item.Key(), val, itemType(item))
+			total.Write(entry)
+			r.h.Write(entry)
+			return nil
+		})
+		if err != nil {
+			return compareRange{}, nil, err
+		}
+		keys++
+		r.keys++
+	}
+	return compareRange{Prefix: string(prefix), Checksum: hex.EncodeToString(total.Sum(nil)), Keys: keys}, ranges, nil
+}
+
+// queryCompare compares the client's checksums of a prefix with the node's,
+// see compare.go
+func (app *KVStoreApplication) queryCompare(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var creq compareRequest
+	if !parseRequest(req, &res, &creq) {
+		return
+	}
+	if _, err := hex.DecodeString(creq.Checksum); err != nil || len(creq.Checksum) != 2*sha256.Size {
+		res.Code = QUERY_INVALID
+		res.Log = "checksum must be a hex encoded sha256 hash"
+		return
+	}
+	prefix := app.normalizeKey([]byte(creq.Prefix))
+	// the client's sub-ranges, by normalized prefix
+	theirs := map[string]string{}
+	for sub, sum := range creq.Ranges {
+		key := app.normalizeKey([]byte(sub))
+		if !bytes.HasPrefix(key, prefix) || len(key) > len(prefix)+1 {
+			res.Code = QUERY_INVALID
+			res.Log = "ranges must be the prefix or one byte longer than it"
+			return
+		}
+		theirs[string(key)] = sum
+	}
+
+	var total compareRange
+	var ours map[string]*rangeChecksum
+	err := app.store.View(func(txn Txn) (err error) {
+		total, ours, err = checksumSubRanges(txn, prefix)
+		return err
+	})
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+
+	cres := compareResponse{Height: app.committed.Height, Checksum: total.Checksum, Keys: total.Keys}
+	cres.Match = total.Checksum == creq.Checksum
+	if !cres.Match {
+		empty := sha256.Sum256(nil)
+		for sub, r := range ours {
+			sum := hex.EncodeToString(r.h.Sum(nil))
+			if theirs[sub] != sum {
+				cres.Differing = append(cres.Differing, compareRange{Prefix: sub, Checksum: sum, Keys: r.keys})
+			}
+		}
+		for sub := range theirs {
+			if ours[sub] == nil {
+				cres.Differing = append(cres.Differing, compareRange{Prefix: sub, Checksum: hex.EncodeToString(empty[:])})
+			}
+		}
+		sort.Slice(cres.Differing, func(i, j int) bool { return cres.Differing[i].Prefix < cres.Differing[j].Prefix })
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, cres)
+	return
+}
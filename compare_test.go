@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+var compareBase = []string{"u/=root", "u/alice=1", "u/anna=2", "u/bob=3", "u/carl=4", "v/x=9"}
+
+// compareWith compares the prefix of client, the client's copy, with app
+func compareWith(t *testing.T, app, client *KVStoreApplication, prefix string) compareResponse {
+	t.Helper()
+	req := compareRequest{Prefix: prefix, Ranges: map[string]string{}}
+	err := client.store.View(func(txn Txn) error {
+		total, ranges, err := checksumSubRanges(txn, []byte(prefix))
+		req.Checksum = total.Checksum
+		for sub, r := range ranges {
+			req.Ranges[sub] = hex.EncodeToString(r.h.Sum(nil))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(req)
+	var cres compareResponse
+	queryJSON(t, app, "compare", data, &cres)
+	return cres
+}
+
+func compareApp(t *testing.T, txs ...string) *KVStoreApplication {
+	t.Helper()
+	app := newTestApp(t)
+	deliverBlock(app, 1, txs...)
+	return app
+}
+
+func TestCompareMatch(t *testing.T) {
+	app := compareApp(t, compareBase...)
+	cres := compareWith(t, app, compareApp(t, compareBase...), "u/")
+	if !cres.Match || cres.Keys != 5 || len(cres.Differing) != 0 {
+		t.Errorf("got %+v", cres)
+	}
+	var ck checksumResponse
+	queryJSON(t, app, "checksum", []byte(`{"prefix": "u/"}`), &ck)
+	if ck.Checksum != cres.Checksum {
+		t.Error("the checksum isn't the checksum query's")
+	}
+}
+
+func TestCompareNarrowsAnEdit(t *testing.T) {
+	app := compareApp(t, compareBase...)
+	client := compareApp(t, append(compareBase, "u/bob=33")...)
+	for _, step := range []struct{ prefix, differing string }{{"u/", "u/b"}, {"u/b", "u/bo"}, {"u/bo", "u/bob"}} {
+		cres := compareWith(t, app, client, step.prefix)
+		if cres.Match || len(cres.Differing) != 1 || cres.Differing[0].Prefix != step.differing || cres.Differing[0].Keys != 1 {
+			t.Errorf("comparing %s got %+v", step.prefix, cres)
+		}
+	}
+
+	// a key only the client has, and the key equal to the prefix
+	cres := compareWith(t, app, compareApp(t, append(compareBase, "u/zed=1")...), "u/")
+	if len(cres.Differing) != 1 || cres.Differing[0].Prefix != "u/z" || cres.Differing[0].Keys != 0 {
+		t.Errorf("an extra key got %+v", cres)
+	}
+	cres = compareWith(t, app, compareApp(t, compareBase[1:]...), "u/")
+	if len(cres.Differing) != 1 || cres.Differing[0].Prefix != "u/" || cres.Differing[0].Keys != 1 {
+		t.Errorf("a missing key equal to the prefix got %+v", cres)
+	}
+}
+
+func TestCompareInvalid(t *testing.T) {
+	app := compareApp(t, compareBase...)
+	zero := strings.Repeat("00", 32)
+	for _, data := range []string{
+		`{"prefix": "u/", "checksum": "zz"}`,
+		`{"prefix": "u/", "checksum": "` + zero + `", "ranges": {"x": "00"}}`,
+		`{"prefix": "u/", "checksum": "` + zero + `", "ranges": {"u/ab": "00"}}`,
+	} {
+		if res := app.Query(abcitypes.RequestQuery{Path: "compare", Data: []byte(data)}); res.Code != QUERY_INVALID {
+			t.Errorf("%s got code %d", data, res.Code)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/hex"
+	"sort"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// configResponse is the effective configuration of the app, everything not
+// set is left out, fields are only ever added here one by one, so nothing
+// that should stay private ends up in it by accident
+type configResponse struct {
+	AppVersion       uint64 `json:"app_version"`
+	StoreFormat      int    `json:"store_format"`
+	AdminQueries     bool   `json:"admin_queries,omitempty"`
+	InternalQuery    bool   `json:"internal_query,omitempty"`
+	ForceGenesis     bool   `json:"force_genesis,omitempty"`
+	OfflineRewrites  bool   `json:"offline_rewrites,omitempty"`
+	GenesisFiles     bool   `json:"genesis_files,omitempty"`
+	Replica          bool   `json:"replica,omitempty"`
+	KeyNormalization struct {
+		FoldCase  bool `json:"fold_case,omitempty"`
+		TrimSpace bool `json:"trim_space,omitempty"`
+	} `json:"key_normalization"`
+	TrimValues      bool     `json:"trim_values,omitempty"`
+	UTF8Keys        bool     `json:"utf8_keys,omitempty"`
+	AppendOnly      bool     `json:"append_only,omitempty"`
+	AtomicBlocks    bool     `json:"atomic_blocks,omitempty"`
+	MerkleAppHash   bool     `json:"merkle_app_hash,omitempty"`
+	ReceiptsRoot    bool     `json:"receipts_root,omitempty"`
+	ProofCache      bool     `json:"proof_cache,omitempty"`
+	ValueCRC        bool     `json:"value_crc,omitempty"`
+	MarkDuplicates  bool     `json:"mark_duplicates,omitempty"`
+	SkipDupCheck    bool     `json:"skip_deliver_duplicate_check,omitempty"`
+	RejectNoOps     bool     `json:"reject_no_ops,omitempty"`
+	InvalidTxPolicy string   `json:"invalid_tx_policy"`
+	BatchDuplicates string   `json:"batch_duplicates"`
+	UnknownOps      string   `json:"unknown_ops"`
+	HeightCheck     string   `json:"height_check"`
+	StartupCheck    string   `json:"startup_check"`
+	DisabledOps     []string `json:"disabled_ops,omitempty"`
+	LocalPrefixes   []string `json:"local_prefixes,omitempty"`
+	// SigningKey is the hex public key query responses are signed with
+	SigningKey string `json:"signing_key,omitempty"`
+
+	Limits struct {
+		MaxTxSize     int           `json:"max_tx_size,omitempty"`
+		MaxListLength int64         `json:"max_list_length,omitempty"`
+		Keys          []limitConfig `json:"keys,omitempty"`
+		KeyDepth      *depthConfig  `json:"key_depth,omitempty"`
+		// MaxQueries is the limit on queries in flight, 0 is no limit
+		MaxQueries int `json:"max_concurrent_queries"`
+		// MaxResponseSize is the limit on a query response, 0 is no limit
+		MaxResponseSize int `json:"max_query_response_size"`
+		// QueryBudget is the time budget of the expensive scans
+		QueryBudget string `json:"query_budget,omitempty"`
+		// MaxCheckTx is the limit on CheckTx calls in flight, 0 is no limit
+		MaxCheckTx int `json:"max_concurrent_check_tx"`
+		// MaxWatchers is the limit on watches waiting, 0 is no limit
+		MaxWatchers int `json:"max_watchers"`
+		// MaxPrefixDelete is the limit on a delprefix, 0 is no limit
+		MaxPrefixDelete int `json:"max_prefix_delete"`
+		// MaxBatchOps is the limit on the lines of a batch, 0 is no limit
+		MaxBatchOps int `json:"max_batch_ops"`
+		// BlockWriteBudget is the bytes a block can write, 0 is no limit
+		BlockWriteBudget int64 `json:"block_write_budget,omitempty"`
+	} `json:"limits"`
+
+	Gas *GasSchedule `json:"gas,omitempty"`
+	// FreezeWindows are the spans of block time the state is frozen in
+	FreezeWindows []freezeWindowConfig `json:"freeze_windows,omitempty"`
+
+	CacheSize     int    `json:"cache_size,omitempty"`
+	FlushBlocks   int    `json:"flush_blocks"`
+	FlushInterval string `json:"flush_interval,omitempty"`
+	SyncOnFlush   bool   `json:"sync_on_flush,omitempty"`
+	DeadLetterLog int    `json:"dead_letter_log,omitempty"`
+	// DedupWindow is the number of transactions CheckTx remembers
+	DedupWindow int `json:"check_tx_dedup_window,omitempty"`
+	// PrefixMetrics is the cap on namespaces in the per prefix metrics
+	PrefixMetrics int `json:"prefix_metrics,omitempty"`
+	// SlowOpThreshold is how long an operation runs before it's logged
+	SlowOpThreshold string `json:"slow_op_threshold,omitempty"`
+	// ReadStats is the number of keys the read stats track
+	ReadStats int `json:"read_stats,omitempty"`
+
+	Watchdog          *watchdogConfig          `json:"watchdog,omitempty"`
+	WriteVerification *writeVerificationConfig `json:"write_verification,omitempty"`
+	DiskGuard         *diskGuardConfig         `json:"disk_guard,omitempty"`
+	ShutdownSnapshot  string                   `json:"shutdown_snapshot,omitempty"`
+
+	TxIndex     *txIndexConfig     `json:"tx_index,omitempty"`
+	ChangeIndex *changeIndexConfig `json:"change_index,omitempty"`
+	ModIndex    bool               `json:"mod_index,omitempty"`
+	KeyVersions bool               `json:"key_versions,omitempty"`
+	TimeIndex   *timeIndexConfig   `json:"time_index,omitempty"`
+	// TimeBuckets is the width of a time bucket
+	TimeBuckets string             `json:"time_buckets,omitempty"`
+	Idempotency *idempotencyConfig `json:"idempotency_keys,omitempty"`
+	Ranking     *rankingConfig     `json:"ranking,omitempty"`
+
+	Maintenance         *maintenanceConfig `json:"maintenance,omitempty"`
+	CompactionThreshold int                `json:"compaction_threshold,omitempty"`
+
+	Invariants      []string `json:"invariants,omitempty"`
+	HaltOnInvariant bool     `json:"halt_on_invariant,omitempty"`
+	BlockPredicate  bool     `json:"block_predicate,omitempty"`
+
+	Shadow  bool `json:"shadow,omitempty"`
+	Events  bool `json:"event_sink,omitempty"`
+	TxLog   bool `json:"tx_log,omitempty"`
+	Metrics bool `json:"metrics,omitempty"`
+	Tracing bool `json:"tracing,omitempty"`
+	// Diagnostics is true if the gateway serves /debug
+	Diagnostics  bool                `json:"diagnostics,omitempty"`
+	GatewayETags *gatewayETagsConfig `json:"gateway_etags,omitempty"`
+}
+
+type freezeWindowConfig struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+type limitConfig struct {
+	// Prefix is left out for the limit on the whole store
+	Prefix string `json:"prefix,omitempty"`
+	Max    int64  `json:"max"`
+}
+
+type depthConfig struct {
+	Separator string `json:"separator"`
+	Max       int    `json:"max"`
+}
+
+type gatewayETagsConfig struct {
+	MaxAge string `json:"max_age"`
+}
+
+type watchdogConfig struct {
+	Timeout string `json:"timeout"`
+	Halt    bool   `json:"halt,omitempty"`
+}
+
+type writeVerificationConfig struct {
+	Every int  `json:"every"`
+	Halt  bool `json:"halt,omitempty"`
+}
+
+type diskGuardConfig struct {
+	MinFree  uint64 `json:"min_free"`
+	Interval string `json:"interval"`
+}
+
+type txIndexConfig struct {
+	RetainBlocks   int64  `json:"retain_blocks,omitempty"`
+	RetainReceipts int64  `json:"retain_receipts,omitempty"`
+	RetainAge      string `json:"retain_age,omitempty"`
+}
+
+type timeIndexConfig struct {
+	Retain string `json:"retain,omitempty"`
+}
+
+type rankingConfig struct {
+	Prefix string `json:"prefix"`
+}
+
+type idempotencyConfig struct {
+	RetainBlocks int64 `json:"retain_blocks,omitempty"`
+}
+
+type changeIndexConfig struct {
+	RetainBlocks int64 `json:"retain_blocks,omitempty"`
+	CompactAfter int64 `json:"compact_after,omitempty"`
+	CompactSpan  int64 `json:"compact_span,omitempty"`
+}
+
+type maintenanceConfig struct {
+	Interval       string  `json:"interval"`
+	IdleThreshold  string  `json:"idle_threshold"`
+	MaxDuration    string  `json:"max_duration,omitempty"`
+	GCDiscardRatio float64 `json:"gc_discard_ratio,omitempty"`
+	GCRetryBackoff string  `json:"gc_retry_backoff"`
+	GCMaxBackoff   string  `json:"gc_max_backoff"`
+	FlattenEvery   string  `json:"flatten_every,omitempty"`
+	FlattenWorkers int     `json:"flatten_workers"`
+}
+
+// config builds the configResponse of the app
+func (app *KVStoreApplication) config() configResponse {
+	c := configResponse{
+		AppVersion:          app.appVersion,
+		StoreFormat:         storeFormatVersion,
+		AdminQueries:        app.adminQueries,
+		InternalQuery:       app.internalQuery,
+		ForceGenesis:        app.forceGenesis,
+		OfflineRewrites:     app.offlineRewrites,
+		GenesisFiles:        app.genesisDir != "",
+		Replica:             app.replica,
+		TrimValues:          app.trimValues,
+		UTF8Keys:            app.utf8Keys,
+		AppendOnly:          app.appendOnly,
+		AtomicBlocks:        app.atomicBlocks,
+		MerkleAppHash:       app.merkleAppHash,
+		ReceiptsRoot:        app.receiptsRoot,
+		ProofCache:          app.proofCache != nil,
+		ValueCRC:            app.valueCRC,
+		MarkDuplicates:      app.markDuplicates,
+		SkipDupCheck:        app.skipDeliverDuplicates,
+		RejectNoOps:         app.rejectNoOps,
+		InvalidTxPolicy:     "count",
+		BatchDuplicates:     "last_wins",
+		UnknownOps:          "reject",
+		HeightCheck:         app.heightCheck.String(),
+		StartupCheck:        app.startupCheck.String(),
+		FlushBlocks:         app.flushBlocks,
+		SyncOnFlush:         app.syncOnFlush,
+		ModIndex:            app.modIndex,
+		KeyVersions:         app.keyVersions,
+		CompactionThreshold: app.compactionThreshold,
+		HaltOnInvariant:     app.haltOnInvariant,
+		ShutdownSnapshot:    app.shutdownSnapshot,
+		BlockPredicate:      app.blockPredicate != nil,
+		Shadow:              app.shadow != nil,
+		Events:              app.events != nil,
+		TxLog:               app.txLog != nil,
+		Metrics:             app.metricsRegistry != nil,
+		Tracing:             app.tracing,
+		Diagnostics:         app.diag != nil,
+		Gas:                 app.gas,
+	}
+	c.KeyNormalization.FoldCase = app.keyNormalization&NormalizeFoldCase != 0
+	c.KeyNormalization.TrimSpace = app.keyNormalization&NormalizeTrimSpace != 0
+	if app.invalidTxPolicy == InvalidTxHalt {
+		c.InvalidTxPolicy = "halt"
+	}
+	if app.unknownOps == UnknownOpWrite {
+		c.UnknownOps = "write"
+	}
+	if app.batchDuplicates == BatchRejectDuplicates {
+		c.BatchDuplicates = "reject"
+	}
+
+	c.Limits.MaxTxSize = app.maxTxSize
+	c.Limits.MaxListLength = app.maxListLength
+	c.Limits.MaxPrefixDelete = app.maxPrefixDelete
+	c.Limits.MaxBatchOps = app.maxBatchOps
+	c.Limits.BlockWriteBudget = app.blockBudget
+	c.Limits.MaxQueries = cap(app.querySlots)
+	c.Limits.MaxResponseSize = app.maxResponseSize
+	for _, w := range app.freezeWindows {
+		c.FreezeWindows = append(c.FreezeWindows, freezeWindowConfig{
+			Start: w.Start.UTC().Format(time.RFC3339Nano),
+			End:   w.End.UTC().Format(time.RFC3339Nano),
+		})
+	}
+	if app.queryBudget > 0 {
+		c.Limits.QueryBudget = app.queryBudget.String()
+	}
+	c.Limits.MaxCheckTx = cap(app.checkSlots)
+	c.Limits.MaxWatchers = cap(app.watchSlots)
+	if app.maxKeyDepth > 0 {
+		c.Limits.KeyDepth = &depthConfig{Separator: string(app.keyDepthSeparator), Max: app.maxKeyDepth}
+	}
+	for _, l := range app.keyLimits {
+		c.Limits.Keys = append(c.Limits.Keys, limitConfig{Prefix: string(l.prefix), Max: l.max})
+	}
+
+	if app.cache != nil {
+		c.CacheSize = app.cache.size
+	}
+	if app.flushInterval > 0 {
+		c.FlushInterval = app.flushInterval.String()
+	}
+	if app.gatewayETags {
+		c.GatewayETags = &gatewayETagsConfig{MaxAge: app.gatewayMaxAge.String()}
+	}
+	if app.watchdogTimeout > 0 {
+		c.Watchdog = &watchdogConfig{Timeout: app.watchdogTimeout.String(), Halt: app.watchdogHalt}
+	}
+	if g := app.diskGuard; g != nil {
+		c.DiskGuard = &diskGuardConfig{MinFree: g.minFree, Interval: g.interval.String()}
+	}
+	if app.verifyEvery > 0 {
+		c.WriteVerification = &writeVerificationConfig{Every: app.verifyEvery, Halt: app.verifyHalt}
+	}
+
+	if app.txIndex {
+		r := app.txIndexRetention
+		c.TxIndex = &txIndexConfig{RetainBlocks: r.Blocks, RetainReceipts: r.Receipts}
+		if r.Age > 0 {
+			c.TxIndex.RetainAge = r.Age.String()
+		}
+	}
+	if app.changeIndex {
+		c.ChangeIndex = &changeIndexConfig{RetainBlocks: app.changeIndexRetain, CompactAfter: app.changeCompactAfter, CompactSpan: app.changeCompactSpan}
+	}
+	if app.timeIndex {
+		c.TimeIndex = &timeIndexConfig{}
+		if app.timeIndexRetain > 0 {
+			c.TimeIndex.Retain = app.timeIndexRetain.String()
+		}
+	}
+	if app.bucketWidth > 0 {
+		c.TimeBuckets = app.bucketWidth.String()
+	}
+	if app.idempotencyKeys {
+		c.Idempotency = &idempotencyConfig{RetainBlocks: app.idempotencyRetain}
+	}
+	if app.signingKey != nil {
+		c.SigningKey = hex.EncodeToString(app.signingKey.PubKey().Bytes())
+	}
+	if app.deadLetters != nil {
+		c.DeadLetterLog = cap(app.deadLetters.entries)
+	}
+	if app.seenTxs != nil {
+		c.DedupWindow = app.seenTxs.size
+	}
+	if app.metrics != nil && app.metrics.prefixes != nil {
+		c.PrefixMetrics = app.metrics.prefixes.max
+	}
+	if app.slowOpThreshold > 0 {
+		c.SlowOpThreshold = app.slowOpThreshold.String()
+	}
+	if app.readStats != nil {
+		c.ReadStats = app.readStats.size
+	}
+	if app.ranking {
+		c.Ranking = &rankingConfig{Prefix: string(app.rankingPrefix)}
+	}
+
+	if m := app.maintainer; m != nil {
+		c.Maintenance = &maintenanceConfig{
+			Interval:       m.cfg.Interval.String(),
+			IdleThreshold:  m.cfg.IdleThreshold.String(),
+			GCDiscardRatio: m.cfg.GCDiscardRatio,
+			GCRetryBackoff: m.cfg.GCRetryBackoff.String(),
+			GCMaxBackoff:   m.cfg.GCMaxBackoff.String(),
+			FlattenWorkers: m.cfg.FlattenWorkers,
+		}
+		if m.cfg.MaxDuration > 0 {
+			c.Maintenance.MaxDuration = m.cfg.MaxDuration.String()
+		}
+		if m.cfg.FlattenEvery > 0 {
+			c.Maintenance.FlattenEvery = m.cfg.FlattenEvery.String()
+		}
+	}
+
+	for name := range app.disabledOps {
+		c.DisabledOps = append(c.DisabledOps, name)
+	}
+	sort.Strings(c.DisabledOps)
+	for _, p := range app.localPrefixes {
+		c.LocalPrefixes = append(c.LocalPrefixes, string(p))
+	}
+
+	for _, inv := range app.invariants {
+		c.Invariants = append(c.Invariants, inv.name)
+	}
+	return c
+}
+
+// queryConfig returns the configuration the app is running with, every
+// option passed to NewKVStoreApplication that changes a default shows up,
+// operators can compare it across nodes, the options that have to match on
+// every node (key normalization, value trimming, append only, merkle app
+// hash, ...) are all included
+func (app *KVStoreApplication) queryConfig(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	res.Height = app.committed.Height
+	respondJSON(&res, app.config())
+	return
+}
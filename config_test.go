@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+)
+
+func TestConfig(t *testing.T) {
+	var c configResponse
+	queryJSON(t, newTestApp(t), "config", nil, &c)
+	if c.InvalidTxPolicy != "count" || c.TxIndex != nil || c.Limits.Keys != nil || c.FlushBlocks != 1 || c.StoreFormat != storeFormatVersion {
+		t.Errorf("the defaults got %+v", c)
+	}
+
+	app := newTestApp(t, WithAppVersion(2), WithKeyLimit(100), WithPrefixKeyLimit("u/", 10), WithKeyNormalization(NormalizeFoldCase),
+		WithTxIndexRetention(TxIndexRetention{Blocks: 5, Age: time.Hour}), WithMaxTxSize(512), WithAppendOnly(true))
+	c = configResponse{}
+	queryJSON(t, app, "config", nil, &c)
+	if c.AppVersion != 2 || !c.KeyNormalization.FoldCase || c.KeyNormalization.TrimSpace || !c.AppendOnly || c.Limits.MaxTxSize != 512 {
+		t.Errorf("got %+v", c)
+	}
+	if want := []limitConfig{{Max: 100}, {Prefix: "u/", Max: 10}}; !reflect.DeepEqual(c.Limits.Keys, want) {
+		t.Errorf("got key limits %+v, want %+v", c.Limits.Keys, want)
+	}
+	if want := (txIndexConfig{RetainBlocks: 5, RetainAge: "1h0m0s"}); c.TxIndex == nil || *c.TxIndex != want {
+		t.Errorf("got tx index %+v, want %+v", c.TxIndex, want)
+	}
+}
+
+func TestConfigLeavesOutTheSigningKey(t *testing.T) {
+	key := ed25519.GenPrivKey()
+	app := newTestApp(t, WithQuerySigning(key))
+	res := app.Query(abcitypes.RequestQuery{Path: "config"})
+	if strings.Contains(string(res.Value), hex.EncodeToString(key.Bytes())) {
+		t.Fatal("the config has the private signing key")
+	}
+	var c configResponse
+	queryJSON(t, app, "config", nil, &c)
+	if c.SigningKey != hex.EncodeToString(key.PubKey().Bytes()) {
+		t.Errorf("got signing key %q, want the public key", c.SigningKey)
+	}
+}
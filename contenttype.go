@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"unicode/utf8"
+)
+
+// contentType tags what kind of data a value holds so queries can filter on
+// it without having to load and inspect the value, the tag is stored in
+// badger's per entry UserMeta byte and set with the 'type' transaction option
+// e.g. 'count=10;type=int', untagged values are plain bytes
+type contentType byte
+
+const (
+	typeBytes contentType = iota
+	typeText
+	typeInt
+	typeJSON
+	// typeList is a JSON array of strings, see list.go
+	typeList
+	// typeSet is a JSON array of distinct strings in sorted order, see
+	// set.go
+	typeSet
+)
+
+var contentTypeNames = map[contentType]string{
+	typeBytes: "bytes",
+	typeText:  "text",
+	typeInt:   "int",
+	typeJSON:  "json",
+	typeList:  "list",
+	typeSet:   "set",
+}
+
+func (ct contentType) String() string {
+	return contentTypeNames[ct]
+}
+
+// parseContentType returns the content type with the given name
+func parseContentType(name string) (contentType, bool) {
+	for ct, n := range contentTypeNames {
+		if n == name {
+			return ct, true
+		}
+	}
+	return typeBytes, false
+}
+
+// validate checks the value actually is of the content type
+// so a tag can always be trusted by whoever reads the value
+func (ct contentType) validate(value []byte) error {
+	var ok bool
+	switch ct {
+	case typeBytes:
+		ok = true
+	case typeText:
+		ok = utf8.Valid(value)
+	case typeInt:
+		_, err := strconv.ParseInt(string(value), 10, 64)
+		ok = err == nil
+	case typeJSON:
+		ok = json.Valid(value)
+	case typeList:
+		var list []string
+		ok = json.Unmarshal(value, &list) == nil && list != nil
+	case typeSet:
+		var members []string
+		ok = json.Unmarshal(value, &members) == nil && members != nil && validSet(members)
+	}
+	if !ok {
+		return reject(INVALID_VALUE, "value is not of type "+ct.String())
+	}
+	return nil
+}
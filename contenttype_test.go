@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestTypedValues(t *testing.T) {
+	app := newTestApp(t)
+	res := deliverBlock(app, 1, "c/a=1;type=int", "c/b=x;type=int", `c/c={"n":1};type=json`,
+		"c/d=raw", "c/e=-5;type=int", "c/f=1;type=float")
+	for i, want := range []uint32{VALID_TX, INVALID_VALUE, VALID_TX, VALID_TX, VALID_TX, INVALID_FORMAT} {
+		if res[i].Code != want {
+			t.Errorf("tx %d got code %d, want %d: %s", i, res[i].Code, want, res[i].Log)
+		}
+	}
+
+	var pairs []kvPair
+	queryJSON(t, app, "prefix", []byte(`{"prefix": "c/", "type": "int"}`), &pairs)
+	want := []kvPair{{"c/a", "1", "int"}, {"c/e", "-5", "int"}}
+	if !reflect.DeepEqual(pairs, want) {
+		t.Errorf("the int filter got %+v", pairs)
+	}
+	queryJSON(t, app, "prefix", []byte(`{"prefix": "c/", "type": "json"}`), &pairs)
+	if want := []kvPair{{"c/c", `{"n":1}`, "json"}}; !reflect.DeepEqual(pairs, want) {
+		t.Errorf("the json filter got %+v", pairs)
+	}
+	queryJSON(t, app, "prefix", []byte(`{"prefix": "c/"}`), &pairs)
+	want = []kvPair{{"c/a", "1", "int"}, {"c/c", `{"n":1}`, "json"}, {"c/d", "raw", "bytes"}, {"c/e", "-5", "int"}}
+	if !reflect.DeepEqual(pairs, want) {
+		t.Errorf("without a filter got %+v", pairs)
+	}
+	queryJSON(t, app, "prefix", []byte(`{"prefix": "c/", "type": "int", "limit": 1}`), &pairs)
+	if len(pairs) != 1 || pairs[0].Key != "c/a" {
+		t.Errorf("the limit got %+v", pairs)
+	}
+
+	if res := app.Query(abcitypes.RequestQuery{Path: "prefix", Data: []byte(`{"prefix": "c/", "type": "float"}`)}); res.Code != QUERY_INVALID {
+		t.Errorf("an unknown type got code %d", res.Code)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// With value CRCs enabled (WithValueCRC) every user value is stored with a
+// CRC32 of it appended, and checked whenever it's read, so a value that
+// rotted on disk is caught instead of being served or, worse, used to
+// validate transactions
+//
+// values stored with a CRC have crcFlag set in their user meta, next to the
+// content type, so reads don't depend on the option, a db can have values
+// written with and without it, only the keys' values change so iteration
+// isn't affected
+
+// crcFlag marks a value stored with a CRC, content types never use the bit
+const crcFlag byte = 0x80
+
+const crcSize = crc32.Size
+
+// entry builds the store entry for a user key
+func (app *KVStoreApplication) entry(key, value []byte, ct contentType) *Entry {
+	if !app.valueCRC {
+		return &Entry{Key: key, Value: value, UserMeta: byte(ct)}
+	}
+	stored := make([]byte, len(value)+crcSize)
+	copy(stored, value)
+	binary.BigEndian.PutUint32(stored[len(value):], crc32.ChecksumIEEE(value))
+	return &Entry{Key: key, Value: stored, UserMeta: byte(ct) | crcFlag}
+}
+
+// corruptValueError is returned when reading a value that fails its CRC
+type corruptValueError struct {
+	key []byte
+}
+
+func (e *corruptValueError) Error() string {
+	return fmt.Sprintf("the value of %q failed its CRC check, it's corrupted", e.key)
+}
+
+// itemType returns the content type of a user value
+func itemType(item Item) contentType {
+	return contentType(item.UserMeta() &^ crcFlag)
+}
+
+// decodeValue returns the user value of a stored one, checking its CRC if
+// it has one, the result shares stored's memory
+func decodeValue(key []byte, meta byte, stored []byte) ([]byte, error) {
+	if meta&crcFlag == 0 {
+		return stored, nil
+	}
+	if len(stored) < crcSize {
+		return nil, &corruptValueError{key: key}
+	}
+	n := len(stored) - crcSize
+	value := stored[:n]
+	if crc32.ChecksumIEEE(value) != binary.BigEndian.Uint32(stored[n:]) {
+		return nil, &corruptValueError{key: key}
+	}
+	return value, nil
+}
+
+// itemValue returns a copy of the user value of item
+func itemValue(item Item) ([]byte, error) {
+	stored, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeValue(item.Key(), item.UserMeta(), stored)
+}
+
+// queryReadFailed handles an error reading values in a query, a corrupted
+// value fails the query, it's the node's data that's broken rather than the
+// db, so the node keeps serving everything else, anything else halts the node
+// transactions reading a corrupted value still halt it, as it can't apply
+// them the same way as the rest of the network
+func (app *KVStoreApplication) queryReadFailed(res *abcitypes.ResponseQuery, err error) {
+	corrupt, ok := err.(*corruptValueError)
+	if !ok {
+		halt("Query", err)
+	}
+	app.logger.Error("corrupted value", "key", string(corrupt.key))
+	res.Code = QUERY_FAILED
+	res.Log = err.Error()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+// corrupt flips a bit of a stored value behind the app's back
+func corrupt(t *testing.T, store Store, key string) {
+	t.Helper()
+	err := store.Update(func(txn Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		value[0] ^= 1
+		return txn.SetEntry(&Entry{Key: []byte(key), Value: value, UserMeta: item.UserMeta()})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValueCRC(t *testing.T) {
+	var logs bytes.Buffer
+	store := NewMemStore()
+	// b is written before the option is on, so it has no CRC
+	deliverBlock(NewKVStoreApplicationWithStore(store), 1, "b=5;type=int")
+	app := NewKVStoreApplicationWithStore(store, WithValueCRC(true), WithLogger(log.NewTMLogger(&logs)))
+	deliverBlock(app, 2, "a=hello")
+
+	if res := app.Query(abcitypes.RequestQuery{Data: []byte("a")}); res.Code != 0 || string(res.Value) != "hello" {
+		t.Fatalf("a got code %d and %q, the CRC isn't stripped", res.Code, res.Value)
+	}
+	if value, ct := typedValue(t, app, "b"); value != "5" || ct != typeInt {
+		t.Fatalf("b without a CRC is %q (%s)", value, ct)
+	}
+
+	corrupt(t, store, "a")
+	for _, req := range []abcitypes.RequestQuery{
+		{Data: []byte("a")},
+		{Path: "prefix", Data: []byte(`{"prefix": ""}`)},
+		{Path: "search", Data: []byte(`{"substring": "5"}`)},
+	} {
+		res := app.Query(req)
+		if res.Code != QUERY_FAILED || !strings.Contains(res.Log, "failed its CRC check") {
+			t.Errorf("%q query of %s got code %d: %s", req.Path, req.Data, res.Code, res.Log)
+		}
+	}
+	if !strings.Contains(logs.String(), "corrupted value") {
+		t.Errorf("the corrupted value wasn't logged:\n%s", logs.String())
+	}
+
+	// the rest of the keys are still served
+	if res := app.Query(abcitypes.RequestQuery{Data: []byte("b")}); res.Code != 0 || string(res.Value) != "5" {
+		t.Errorf("b got code %d and %q next to the corrupted a", res.Code, res.Value)
+	}
+
+	// a transaction reading the value can't be applied, so the node halts
+	msg := halts(func() { deliverBlock(app, 3, "expr:a:append:!") })
+	if !strings.Contains(msg, "failed its CRC check") {
+		t.Fatalf("reading the corrupted value in a block didn't halt: %q", msg)
+	}
+}
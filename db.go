@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/dgraph-io/badger"
+)
+
+// Badger keeps values smaller than ValueThreshold inline in the LSM tree and
+// anything bigger in the value log, reading a value from the value log costs
+// an extra disk read, so for a key=value store where most values are small
+// it's worth keeping more of them in the LSM tree than badger's default of 32
+
+const (
+	// defaultValueThreshold keeps values up to 1KB in the LSM tree
+	defaultValueThreshold = 1 << 10
+	// defaultMaxTableSize is badger's own default
+	defaultMaxTableSize = 64 << 20
+	// defaultNumMemtables is badger's own default
+	defaultNumMemtables = 5
+)
+
+// DBOption configures how OpenDB opens the badger db
+type DBOption func(opts *badger.Options)
+
+// OpenDB opens the badger db in dir with options tuned for this application
+func OpenDB(dir string, opts ...DBOption) (*badger.DB, error) {
+	options := badger.DefaultOptions(dir).
+		WithValueThreshold(defaultValueThreshold).
+		WithMaxTableSize(defaultMaxTableSize).
+		WithNumMemtables(defaultNumMemtables)
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return badger.Open(options)
+}
+
+// WithValueThreshold sets the size in bytes above which values are stored
+// in the value log instead of the LSM tree, defaults to 1KB
+// raising it speeds up reads of small values at the cost of a bigger LSM tree
+// badger rejects thresholds above badger.ValueThresholdLimit (just under 64KB)
+func WithValueThreshold(n int) DBOption {
+	return func(opts *badger.Options) {
+		*opts = opts.WithValueThreshold(n)
+	}
+}
+
+// WithMaxTableSize sets the size in bytes of each LSM table, defaults to 64MB
+func WithMaxTableSize(n int64) DBOption {
+	return func(opts *badger.Options) {
+		*opts = opts.WithMaxTableSize(n)
+	}
+}
+
+// WithSyncWrites sets whether badger syncs every write to disk before it
+// returns, the default, a flush is a single badger transaction, so it's one
+// synced write per flushed block, or per batch of blocks with commit
+// batching, without it a flushed block can be lost to a crash of the machine,
+// not just of the process, until the OS writes it out, see WithSyncOnFlush
+// for a sync per flush instead
+// a validator must keep its blocks durable, either with synced writes or with
+// WithSyncOnFlush, tendermint has already voted on the app hash by the time
+// a block would be lost
+func WithSyncWrites(enabled bool) DBOption {
+	return func(opts *badger.Options) {
+		*opts = opts.WithSyncWrites(enabled)
+	}
+}
+
+// WithReadOnly opens the db read only, for a replica, see replica.go
+// the db has to exist, and can't be open for writing by another process
+func WithReadOnly() DBOption {
+	return func(opts *badger.Options) {
+		*opts = opts.WithReadOnly(true)
+	}
+}
+
+// WithNumMemtables sets how many memtables are kept in memory before writes
+// stall waiting for a flush, defaults to 5
+func WithNumMemtables(n int) DBOption {
+	return func(opts *badger.Options) {
+		*opts = opts.WithNumMemtables(n)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// lsmSize writes values of valueSize to a db opened with opts and returns
+// the size of its LSM tree once they've been flushed to it
+func lsmSize(t *testing.T, valueSize int, opts ...DBOption) int64 {
+	t.Helper()
+	dir := t.TempDir()
+	opts = append(opts, func(o *badger.Options) { *o = o.WithLogger(nil) })
+	db, err := OpenDB(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := NewKVStoreApplication(db)
+	var txs []string
+	for i := 0; i < 100; i++ {
+		txs = append(txs, string(benchTx(i, valueSize)))
+	}
+	deliverBlock(app, 1, txs...)
+	// closing flushes the memtables, reopening measures the tables
+	app.Close()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = OpenDB(dir, opts...); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	lsm, _ := db.Size()
+	return lsm
+}
+
+func TestValueThreshold(t *testing.T) {
+	// 4KB values go to the value log under the default threshold of 1KB and
+	// stay in the LSM tree under a threshold of 8KB
+	small := lsmSize(t, 4<<10)
+	large := lsmSize(t, 4<<10, WithValueThreshold(8<<10))
+	if small > 100*(4<<10)/2 || large < 100*(4<<10)/2 {
+		t.Errorf("the LSM tree is %d bytes with the default threshold and %d with values kept in it", small, large)
+	}
+}
+
+func TestMaxTableSize(t *testing.T) {
+	db, err := OpenDB(t.TempDir(), WithMaxTableSize(8<<20), func(o *badger.Options) { *o = o.WithLogger(nil) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// badger sizes its write batches off the table size
+	if got, want := db.MaxBatchSize(), int64(15*(8<<20)/100); got != want {
+		t.Errorf("got a max batch size of %d, want %d", got, want)
+	}
+}
+
+func TestNumMemtables(t *testing.T) {
+	opts := badger.DefaultOptions("")
+	WithNumMemtables(1)(&opts)
+	if opts.NumMemtables != 1 {
+		t.Errorf("got %d memtables, want 1", opts.NumMemtables)
+	}
+	// and a db with a single memtable still takes writes past a flush
+	db, err := OpenDB(t.TempDir(), WithNumMemtables(1), WithMaxTableSize(1<<20), func(o *badger.Options) { *o = o.WithLogger(nil) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	app := NewKVStoreApplication(db)
+	for h := int64(1); h <= 4; h++ {
+		var txs []string
+		for i := 0; i < 100; i++ {
+			txs = append(txs, string(benchTx(int(h)*100+i, 1<<10)))
+		}
+		deliverBlock(app, h, txs...)
+	}
+	if app.committed.KeyCount != 400 {
+		t.Errorf("got %d keys, want 400", app.committed.KeyCount)
+	}
+}
+
+func TestLargeValues(t *testing.T) {
+	// a value goes to the value log whole, however big, there's no limit
+	// under the block size to chunk around
+	dir := t.TempDir()
+	quiet := func(o *badger.Options) { *o = o.WithLogger(nil) }
+	db, err := OpenDB(dir, quiet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := NewKVStoreApplication(db)
+	sizes := []int{4 << 20, 16 << 20}
+	for i, size := range sizes {
+		if r := deliverBlock(app, int64(i+1), fmt.Sprintf("k%d=%s", i, strings.Repeat("x", size)))[0]; r.Code != VALID_TX {
+			t.Fatalf("%d byte value got code %d: %s", size, r.Code, r.Log)
+		}
+	}
+	app.Close()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if db, err = OpenDB(dir, quiet); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	app = NewKVStoreApplication(db)
+	for i, size := range sizes {
+		res := app.Query(abcitypes.RequestQuery{Data: []byte(fmt.Sprintf("k%d", i))})
+		if len(res.Value) != size || strings.Trim(string(res.Value), "x") != "" {
+			t.Errorf("read back %d bytes of a %d byte value", len(res.Value), size)
+		}
+	}
+}
+
+func TestSyncOnFlush(t *testing.T) {
+	quiet := func(o *badger.Options) { *o = o.WithLogger(nil) }
+	for _, c := range []struct {
+		syncWrites, syncOnFlush bool
+	}{{true, false}, {false, true}} {
+		dir := t.TempDir()
+		db, err := OpenDB(dir, WithSyncWrites(c.syncWrites), quiet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// two flushes of two blocks each
+		app := NewKVStoreApplication(db, WithSyncOnFlush(c.syncOnFlush), WithCommitBatching(2, 0))
+		for h := int64(1); h <= 4; h++ {
+			deliverBlock(app, h, fmt.Sprintf("k%d=v", h))
+		}
+		var conf configResponse
+		queryJSON(t, app, "config", nil, &conf)
+		if conf.SyncOnFlush != c.syncOnFlush {
+			t.Errorf("%+v: config reports sync_on_flush %v", c, conf.SyncOnFlush)
+		}
+		app.Close()
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if db, err = OpenDB(dir, quiet); err != nil {
+			t.Fatal(err)
+		}
+		app = NewKVStoreApplication(db)
+		if info := app.Info(abcitypes.RequestInfo{}); info.LastBlockHeight != 4 {
+			t.Errorf("%+v: reopened at height %d, want 4", c, info.LastBlockHeight)
+		}
+		for h := 1; h <= 4; h++ {
+			if value, _, _ := app.get([]byte(fmt.Sprintf("k%d", h))); string(value) != "v" {
+				t.Errorf("%+v: k%d is %q after a reopen", c, h, value)
+			}
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// The dead letter log (WithDeadLetterLog) keeps the last transactions CheckTx
+// rejected, so when a client's transactions never make it into a block the
+// node can be asked why, with the "rejected" query
+// it's the node's own view of its mempool, it isn't part of the state or the
+// app hash, and it's only kept in memory, a flood of invalid transactions
+// costs a slot each in a fixed size ring rather than a write to the db, the
+// oldest entries are overwritten first and it starts empty after a restart
+
+type deadLetter struct {
+	// Hash is the sha256 of the transaction, like the tx index's
+	Hash string    `json:"hash"`
+	Code uint32    `json:"code"`
+	Log  string    `json:"log"`
+	Time time.Time `json:"time"`
+}
+
+// deadLetters is a ring of the last rejections, CheckTx adds to it while
+// queries read it, so it has its own lock
+type deadLetters struct {
+	mtx     sync.Mutex
+	entries []deadLetter
+	next    int
+	total   int64
+}
+
+func newDeadLetters(size int) *deadLetters {
+	return &deadLetters{entries: make([]deadLetter, 0, size)}
+}
+
+// add records a rejected transaction, overwriting the oldest entry if full
+func (d *deadLetters) add(tx []byte, r *rejection) {
+	hash := sha256.Sum256(tx)
+	entry := deadLetter{Hash: hex.EncodeToString(hash[:]), Code: r.code, Log: r.log, Time: time.Now().UTC()}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if len(d.entries) < cap(d.entries) {
+		d.entries = append(d.entries, entry)
+	} else {
+		d.entries[d.next] = entry
+	}
+	d.next = (d.next + 1) % cap(d.entries)
+	d.total++
+}
+
+// newest returns up to limit entries, the most recent first
+func (d *deadLetters) newest(limit int) ([]deadLetter, int64) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	out := []deadLetter{}
+	for i := 1; i <= len(d.entries) && len(out) < limit; i++ {
+		out = append(out, d.entries[(d.next-i+cap(d.entries))%cap(d.entries)])
+	}
+	return out, d.total
+}
+
+const defaultRejectedLimit = 100
+
+type rejectedRequest struct {
+	Limit int `json:"limit"`
+}
+
+type rejectedResponse struct {
+	Rejected []deadLetter `json:"rejected"`
+	// Total is the number of rejections since the node started, including
+	// the ones that have been overwritten
+	Total int64 `json:"total"`
+}
+
+// queryRejected returns the last transactions CheckTx rejected, the most
+// recent first, e.g. {"limit": 10}, only available with the dead letter log
+func (app *KVStoreApplication) queryRejected(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if app.deadLetters == nil {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "the dead letter log is disabled"
+		return
+	}
+	var rreq rejectedRequest
+	if len(req.Data) > 0 && !parseRequest(req, &res, &rreq) {
+		return
+	}
+	limit := clampLimit(rreq.Limit, defaultRejectedLimit, cap(app.deadLetters.entries))
+
+	var rres rejectedResponse
+	rres.Rejected, rres.Total = app.deadLetters.newest(limit)
+	respondJSON(&res, rres)
+	return
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestDeadLetterLog(t *testing.T) {
+	app := newTestApp(t, WithDeadLetterLog(3))
+	plain := newTestApp(t)
+	for _, a := range []*KVStoreApplication{app, plain} {
+		a.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("ok=1")})
+		for i := 0; i < 5; i++ {
+			a.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(fmt.Sprintf("bad%d", i))})
+		}
+		deliverBlock(a, 1, "ok=1")
+	}
+
+	// only the rejections are kept, the last 3 of them, newest first
+	var rres rejectedResponse
+	queryJSON(t, app, "rejected", nil, &rres)
+	if rres.Total != 5 {
+		t.Errorf("total is %d, want 5", rres.Total)
+	}
+	if len(rres.Rejected) != 3 {
+		t.Fatalf("got %d rejections, want 3", len(rres.Rejected))
+	}
+	for i, want := range []string{"bad4", "bad3", "bad2"} {
+		got := rres.Rejected[i]
+		if got.Hash != string(txHash(want)) || got.Code != INVALID_FORMAT || got.Log == "" || got.Time.IsZero() {
+			t.Errorf("rejection %d is %+v, want %s with INVALID_FORMAT", i, got, want)
+		}
+	}
+
+	queryJSON(t, app, "rejected", []byte(`{"limit":1}`), &rres)
+	if len(rres.Rejected) != 1 || rres.Rejected[0].Hash != string(txHash("bad4")) {
+		t.Errorf("limit 1 got %+v", rres.Rejected)
+	}
+
+	// the log is outside the state
+	if !bytes.Equal(app.committed.AppHash, plain.committed.AppHash) {
+		t.Errorf("app hash %X differs from %X without the dead letter log", app.committed.AppHash, plain.committed.AppHash)
+	}
+	if res := plain.Query(abcitypes.RequestQuery{Path: "rejected"}); res.Code != QUERY_NOT_ALLOWED {
+		t.Errorf("rejected query without the log got code %d", res.Code)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+)
+
+// delprefix:prefix removes every key starting with prefix, e.g.
+// 'delprefix:session/' clears a whole namespace in one transaction
+// the whole block is a single badger transaction, it can't be split, so
+// instead the number of keys one delprefix can remove is bounded, one over
+// the limit is rejected with DELETE_TOO_LARGE, see WithMaxPrefixDelete
+// a prefix without any keys is rejected with MISSING_KEY
+
+// defaultMaxPrefixDelete is the default limit on the keys a delprefix removes
+const defaultMaxPrefixDelete = 1000
+
+// keysWithPrefix returns the keys under prefix visible to txn, at most max+1
+// of them, so a caller can tell the limit was passed, max 0 is no limit
+func keysWithPrefix(txn Txn, prefix []byte, max int) [][]byte {
+	var keys [][]byte
+	opts := DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+		if max > 0 && len(keys) > max {
+			break
+		}
+	}
+	return keys
+}
+
+func init() {
+	registerOp(&txOp{
+		name:    "delprefix",
+		args:    1,
+		keys:    []int{0},
+		removes: true,
+		check: func(app *KVStoreApplication, txn Txn, t transaction) error {
+			prefix := t.args[0]
+			keys := keysWithPrefix(txn, prefix, app.maxPrefixDelete)
+			if len(keys) == 0 {
+				return reject(MISSING_KEY, fmt.Sprintf("there are no keys under %q", prefix))
+			}
+			if app.maxPrefixDelete > 0 && len(keys) > app.maxPrefixDelete {
+				return reject(DELETE_TOO_LARGE, fmt.Sprintf("there are more than %d keys under %q", app.maxPrefixDelete, prefix))
+			}
+			if app.appendOnly {
+				return errOverwriteForbidden
+			}
+			return nil
+		},
+		apply: func(app *KVStoreApplication, t transaction) error {
+			// remove clears the key's indexes and counters along with it
+			for _, key := range keysWithPrefix(app.currentBatch, t.args[0], 0) {
+				if err := app.remove(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
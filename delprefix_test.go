@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestDelPrefix(t *testing.T) {
+	app := newTestApp(t, WithMaxPrefixDelete(4), WithModIndex(true), WithPrefixKeyLimit("ns/", 10))
+	deliverBlock(app, 1, "ns/a=1", "ns/b=2", "ns/c=3", "nt=4", "ns/d=5;ttl=5", `ns/l=["x"];type=list`)
+
+	// over the limit, nothing is removed
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("delprefix:ns/")}); r.Code != DELETE_TOO_LARGE {
+		t.Fatalf("CheckTx of a delete over the limit got code %d", r.Code)
+	}
+	res := deliverBlock(app, 2, "delprefix:ns/", "delprefix:ns/x", "delprefix:\x00", "delprefix:ns/l")
+	for i, want := range []uint32{DELETE_TOO_LARGE, MISSING_KEY, RESERVED_KEY, VALID_TX} {
+		if res[i].Code != want {
+			t.Errorf("tx %d got code %d, want %d: %s", i, res[i].Code, want, res[i].Log)
+		}
+	}
+	if n := app.committed.keysUnder([]byte("ns/")); n != 4 {
+		t.Fatalf("%d keys are counted under ns/ after the rejected delete, want 4", n)
+	}
+
+	// a key written earlier in the block is removed along with the rest
+	res = deliverBlock(app, 3, "ns/e=6", "delprefix:ns/e", "ns/e=7", "delprefix:ns/")
+	if res[3].Code != DELETE_TOO_LARGE {
+		t.Fatalf("five keys got code %d", res[3].Code)
+	}
+	res = deliverBlock(app, 4, "delprefix:ns/e", "delprefix:ns/")
+	if res[0].Code != VALID_TX || res[1].Code != VALID_TX {
+		t.Fatalf("got codes %d and %d: %s", res[0].Code, res[1].Code, res[1].Log)
+	}
+	for _, key := range []string{"ns/a", "ns/b", "ns/c", "ns/d", "ns/e", "ns/l"} {
+		if _, exists, _ := app.get([]byte(key)); exists {
+			t.Errorf("%s survived the delete", key)
+		}
+	}
+	if value, _, _ := app.get([]byte("nt")); string(value) != "4" {
+		t.Errorf("nt, next to the prefix, is %q", value)
+	}
+	if app.committed.KeyCount != 1 || app.committed.keysUnder([]byte("ns/")) != 0 {
+		t.Errorf("got %d keys, %d under ns/", app.committed.KeyCount, app.committed.keysUnder([]byte("ns/")))
+	}
+
+	// the deleted key's expiry doesn't fire on a key that isn't there
+	for h := int64(5); h < 8; h++ {
+		deliverBlock(app, h)
+	}
+	if app.committed.KeyCount != 1 {
+		t.Errorf("got %d keys after the expiry height", app.committed.KeyCount)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// With diagnostics (WithDiagnostics) the gateway serves the state of the
+// block being processed and of the process, for debugging a node that's
+// stuck, e.g. one that hangs between BeginBlock and Commit
+//
+//	GET /debug             the block and runtime state, as Diagnostics JSON
+//	GET /debug/goroutines  a dump of every goroutine's stack, as text
+//
+// the ABCI methods record where they are as they go, so the state can be
+// read while one of them is stuck, without waiting on it, it's off by
+// default, it shows what the node is doing at the moment and shouldn't be
+// reachable from outside on a node in production
+
+// Diagnostics is the state served by /debug, as of the last ABCI call
+type Diagnostics struct {
+	Time time.Time `json:"time"`
+	// InCall is the ABCI method running, empty between calls, InCallFor
+	// how long it has been running
+	InCall    string `json:"in_call,omitempty"`
+	InCallFor string `json:"in_call_for,omitempty"`
+	// BlockOpen is true between BeginBlock and Commit
+	BlockOpen    bool   `json:"block_open"`
+	BlockOpenFor string `json:"block_open_for,omitempty"`
+	// Height is the height of the open block, the committed height
+	// otherwise
+	Height          int64 `json:"height"`
+	CommittedHeight int64 `json:"committed_height"`
+	// Txs is the number of transactions delivered in the open block and
+	// PendingWrites the number of keys they wrote or removed
+	Txs           int `json:"txs"`
+	PendingWrites int `json:"pending_writes"`
+	// UnflushedBlocks and UnflushedWrites are what commit batching is
+	// holding in the current batch on top of the open block
+	UnflushedBlocks int `json:"unflushed_blocks"`
+	UnflushedWrites int `json:"unflushed_writes"`
+
+	Goroutines int    `json:"goroutines"`
+	HeapAlloc  uint64 `json:"heap_alloc"`
+	HeapSys    uint64 `json:"heap_sys"`
+	NumGC      uint32 `json:"num_gc"`
+}
+
+// diagnostics is updated by the ABCI methods and read by the gateway, the
+// methods are no-ops on a nil *diagnostics, i.e. without WithDiagnostics
+type diagnostics struct {
+	mtx        sync.Mutex
+	state      Diagnostics
+	callStart  time.Time
+	blockStart time.Time
+}
+
+// enter records that method started
+func (d *diagnostics) enter(method string) {
+	if d == nil {
+		return
+	}
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.state.InCall = method
+	d.callStart = time.Now()
+	switch method {
+	case "BeginBlock":
+		d.blockStart = d.callStart
+		d.state.Txs = 0
+	case "DeliverTx":
+		d.state.Txs++
+	}
+}
+
+// leave records the app's state once the running method is done, it has to
+// be called from the ABCI method, which is the only one that can read it
+func (d *diagnostics) leave(app *KVStoreApplication) {
+	if d == nil {
+		return
+	}
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.state.InCall = ""
+	d.state.BlockOpen = app.blockOpen
+	d.state.CommittedHeight = app.committed.Height
+	d.state.Height = app.committed.Height
+	if app.blockOpen {
+		d.state.Height = app.pending.Height
+	}
+	d.state.PendingWrites = len(app.changes)
+	d.state.UnflushedBlocks = app.unflushedBlocks
+	d.state.UnflushedWrites = len(app.unflushed)
+}
+
+func (d *diagnostics) snapshot() Diagnostics {
+	d.mtx.Lock()
+	s := d.state
+	now := time.Now()
+	if s.InCall != "" {
+		s.InCallFor = now.Sub(d.callStart).String()
+	}
+	if s.BlockOpen {
+		s.BlockOpenFor = now.Sub(d.blockStart).String()
+	}
+	d.mtx.Unlock()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	s.Time = now
+	s.Goroutines = runtime.NumGoroutine()
+	s.HeapAlloc, s.HeapSys, s.NumGC = mem.HeapAlloc, mem.HeapSys, mem.NumGC
+	return s
+}
+
+// Diagnostics returns the state served by /debug, false without
+// WithDiagnostics, it's safe to call from any goroutine, see diagnostics.go
+func (app *KVStoreApplication) Diagnostics() (Diagnostics, bool) {
+	if app.diag == nil {
+		return Diagnostics{}, false
+	}
+	return app.diag.snapshot(), true
+}
+
+func (g *Gateway) debug(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d, _ := g.app.Diagnostics()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+func (g *Gateway) debugGoroutines(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	pprof.Lookup("goroutine").WriteTo(w, 2)
+}
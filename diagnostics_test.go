@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+func TestDiagnostics(t *testing.T) {
+	app := newTestApp(t, WithDiagnostics(true))
+	deliverBlock(app, 1, "a=1")
+	srv := httptest.NewServer(NewGateway(app))
+	defer srv.Close()
+
+	debug := func() Diagnostics {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/debug")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var d Diagnostics
+		if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	// a block left open, as on a node stuck before Commit
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 2}})
+	app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("b=2")})
+	app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("a=")})
+	app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("c=3")})
+	d := debug()
+	if !d.BlockOpen || d.BlockOpenFor == "" || d.InCall != "" {
+		t.Errorf("open block shows as open %v for %q, in call %q", d.BlockOpen, d.BlockOpenFor, d.InCall)
+	}
+	if d.Height != 2 || d.CommittedHeight != 1 || d.Txs != 3 || d.PendingWrites != 3 {
+		t.Errorf("open block at %d after %d has %d txs and %d writes, want 3 and 3", d.Height, d.CommittedHeight, d.Txs, d.PendingWrites)
+	}
+	if d.Goroutines == 0 || d.HeapAlloc == 0 {
+		t.Errorf("no runtime stats in %+v", d)
+	}
+
+	app.EndBlock(abcitypes.RequestEndBlock{Height: 2})
+	app.Commit()
+	if d = debug(); d.BlockOpen || d.Height != 2 || d.CommittedHeight != 2 || d.PendingWrites != 0 {
+		t.Errorf("after Commit got %+v", d)
+	}
+
+	resp, err := http.Get(srv.URL + "/debug/goroutines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "goroutine ") {
+		t.Errorf("/debug/goroutines got %q", body)
+	}
+
+	// off by default
+	plain := newTestApp(t)
+	if _, ok := plain.Diagnostics(); ok {
+		t.Error("diagnostics without WithDiagnostics")
+	}
+	psrv := httptest.NewServer(NewGateway(plain))
+	defer psrv.Close()
+	for _, path := range []string{"/debug", "/debug/goroutines"} {
+		resp, err := http.Get(psrv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s without diagnostics got status %d", path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// The disk guard (WithDiskGuard) rejects transactions that write in CheckTx
+// with DISK_FULL once free disk space drops below a threshold, so the
+// mempool stops taking writes before badger runs out of space and crashes
+// the node, queries and transactions that only remove keys still work
+//
+// it's only consulted in CheckTx, how full a node's disk is isn't part
+// of consensus, a block with writes in it is still delivered in full
+// the free space is sampled at most once per interval, not per transaction
+
+// DiskUsage reports the free space of the disk the db is on
+type DiskUsage interface {
+	FreeSpace() (uint64, error)
+}
+
+const defaultDiskGuardInterval = 10 * time.Second
+
+// diskGuard keeps the last sample of the free disk space, CheckTx can run
+// from many goroutines, so the sample has its own lock
+type diskGuard struct {
+	usage    DiskUsage
+	minFree  uint64
+	interval time.Duration
+
+	mtx     sync.Mutex
+	sampled time.Time
+	full    bool
+}
+
+// diskFull returns true if the last sample of the free space, taken again
+// if it's older than the interval, is below the threshold
+// a failed sample is logged and keeps the previous state
+func (app *KVStoreApplication) diskFull() bool {
+	g := app.diskGuard
+	if g == nil {
+		return false
+	}
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if time.Since(g.sampled) < g.interval {
+		return g.full
+	}
+	g.sampled = time.Now()
+	free, err := g.usage.FreeSpace()
+	if err != nil {
+		app.logger.Error("can't read the free disk space", "err", err)
+		return g.full
+	}
+	full := free < g.minFree
+	if full && !g.full {
+		app.logger.Error("disk is nearly full, rejecting writes", "free", free, "min_free", g.minFree)
+	} else if !full && g.full {
+		app.logger.Info("disk has space again, accepting writes", "free", free, "min_free", g.minFree)
+	}
+	g.full = full
+	return full
+}
+
+// errDiskFull rejects writes while the disk is nearly full
+var errDiskFull = reject(DISK_FULL, "the node's disk is nearly full, it isn't accepting writes")
+
+// writesData returns true if the transaction can add data to the store
+func (t transaction) writesData() bool {
+	if t.op != nil {
+		return !t.op.removes
+	}
+	return true
+}
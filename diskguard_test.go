@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// fakeUsage reports a set amount of free space and counts the samples
+type fakeUsage struct {
+	free    uint64
+	err     error
+	samples int
+}
+
+func (u *fakeUsage) FreeSpace() (uint64, error) {
+	u.samples++
+	return u.free, u.err
+}
+
+func TestDiskGuard(t *testing.T) {
+	usage := &fakeUsage{free: 100}
+	app := newTestApp(t, WithDiskGuard(usage, 1000, time.Hour))
+	deliverBlock(app, 1, "a/1=x", "a/2=y")
+
+	for tx, want := range map[string]uint32{
+		"a/3=1":        DISK_FULL,
+		"\x00ba/3=1":   DISK_FULL,
+		"swap:a/1:a/2": DISK_FULL,
+		"push:l:x":     DISK_FULL,
+		"delprefix:a/": VALID_TX,
+	} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}); r.Code != want {
+			t.Errorf("CheckTx %q got code %d, want %d", tx, r.Code, want)
+		}
+	}
+	if usage.samples != 1 {
+		t.Errorf("the free space was sampled %d times within the interval", usage.samples)
+	}
+
+	// reads still work, and blocks are still applied in full
+	if res := app.Query(abcitypes.RequestQuery{Data: []byte("a/1")}); string(res.Value) != "x" {
+		t.Errorf("a/1 reads %q on a full disk", res.Value)
+	}
+	if r := deliverBlock(app, 2, "b=1")[0]; r.Code != VALID_TX {
+		t.Errorf("DeliverTx on a full disk got code %d", r.Code)
+	}
+
+	// the space only counts once it's sampled again
+	usage.free = 5000
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("a/3=1")}); r.Code != DISK_FULL {
+		t.Errorf("a write before the next sample got code %d", r.Code)
+	}
+	app.diskGuard.sampled = time.Time{}
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("a/3=1")}); r.Code != VALID_TX {
+		t.Errorf("a write after the space came back got code %d", r.Code)
+	}
+
+	// a failed sample keeps the last state
+	usage.err = errors.New("statfs failed")
+	app.diskGuard.sampled = time.Time{}
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("a/3=1")}); r.Code != VALID_TX {
+		t.Errorf("a write after a failed sample got code %d", r.Code)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import "errors"
+
+// dirUsage is the DiskUsage of the filesystem a directory is on
+type dirUsage string
+
+// DirUsage returns the DiskUsage of the filesystem dir is on, reading it
+// is only supported on linux and darwin, elsewhere every sample fails,
+// which the disk guard logs and ignores
+func DirUsage(dir string) DiskUsage {
+	return dirUsage(dir)
+}
+
+func (d dirUsage) FreeSpace() (uint64, error) {
+	return 0, errors.New("reading the free disk space isn't supported on this platform")
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package main
+
+import "syscall"
+
+// dirUsage is the DiskUsage of the filesystem a directory is on
+type dirUsage string
+
+// DirUsage returns the DiskUsage of the filesystem dir is on
+func DirUsage(dir string) DiskUsage {
+	return dirUsage(dir)
+}
+
+func (d dirUsage) FreeSpace() (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(string(d), &st); err != nil {
+		return 0, err
+	}
+	// Bavail is the space available to unprivileged users
+	return st.Bavail * uint64(st.Bsize), nil
+}
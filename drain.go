@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SnapshotAndClear lets an app embedding the store use a prefix as a queue,
+// it returns every entry under the prefix and removes them, in one go, so
+// a consumer never sees an entry twice or loses one in between
+//
+// the removal is a change to the state like any other, so it's made to the
+// current block and has to happen at the same point of the same block on
+// every node, e.g. from the embedding app's EndBlock, it isn't in the
+// transaction log, so ReplayLog can't reproduce it
+
+// ErrNoBlock is returned by SnapshotAndClear outside of a block
+var ErrNoBlock = errors.New("no block is being processed")
+
+// KV is an entry returned by SnapshotAndClear
+type KV struct {
+	Key   []byte
+	Value []byte
+	Type  string
+}
+
+// SnapshotAndClear removes every key under prefix in the current block and
+// returns them, with their values, in key order, it sees the writes made
+// earlier in the block, the prefix is normalized like a key
+// like delprefix it's bounded by WithMaxPrefixDelete, a prefix with more
+// keys than that is left alone and an error returned
+func (app *KVStoreApplication) SnapshotAndClear(prefix []byte) ([]KV, error) {
+	if app.replica {
+		return nil, ErrReplica
+	}
+	if !app.inBlock() {
+		return nil, ErrNoBlock
+	}
+	prefix = app.normalizeKey(prefix)
+	if err := app.checkKey(prefix); err != nil {
+		return nil, err
+	}
+
+	keys := keysWithPrefix(app.currentBatch, prefix, app.maxPrefixDelete)
+	if app.maxPrefixDelete > 0 && len(keys) > app.maxPrefixDelete {
+		return nil, fmt.Errorf("there are more than %d keys under %q", app.maxPrefixDelete, prefix)
+	}
+	if len(keys) > 0 && app.appendOnly {
+		return nil, errOverwriteForbidden
+	}
+
+	entries := make([]KV, 0, len(keys))
+	for _, key := range keys {
+		value, ct, _, err := lookup(app.currentBatch, key)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, KV{Key: key, Value: append([]byte{}, value...), Type: ct.String()})
+	}
+	for _, key := range keys {
+		if err := app.remove(key); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
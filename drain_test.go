@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+func TestSnapshotAndClear(t *testing.T) {
+	app := newTestApp(t, WithMaxPrefixDelete(3))
+	deliverBlock(app, 1, "q/1=a", "q/2=2;type=int", "r/1=b")
+	if _, err := app.SnapshotAndClear([]byte("q/")); err != ErrNoBlock {
+		t.Fatalf("got %v outside a block", err)
+	}
+
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 2}})
+	app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("q/3=c")})
+	entries, err := app.SnapshotAndClear([]byte("q/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []KV{
+		{Key: []byte("q/1"), Value: []byte("a"), Type: "bytes"},
+		{Key: []byte("q/2"), Value: []byte("2"), Type: "int"},
+		{Key: []byte("q/3"), Value: []byte("c"), Type: "bytes"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got %+v", entries)
+	}
+	app.EndBlock(abcitypes.RequestEndBlock{Height: 2})
+	app.Commit()
+
+	n := 0
+	app.store.View(func(txn Txn) error {
+		n = len(keysWithPrefix(txn, []byte("q/"), 0))
+		return nil
+	})
+	if n != 0 || app.committed.KeyCount != 1 {
+		t.Errorf("%d keys left under the prefix, %d in all", n, app.committed.KeyCount)
+	}
+	if _, exists, _ := app.get([]byte("r/1")); !exists {
+		t.Error("a key under another prefix was removed")
+	}
+}
+
+func TestSnapshotAndClearLimit(t *testing.T) {
+	app := newTestApp(t, WithMaxPrefixDelete(2))
+	deliverBlock(app, 1, "q/1=a", "q/2=b", "q/3=c")
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 2}})
+	if _, err := app.SnapshotAndClear([]byte("q/")); err == nil {
+		t.Error("a prefix over the limit was drained")
+	}
+	app.EndBlock(abcitypes.RequestEndBlock{Height: 2})
+	app.Commit()
+	if app.committed.KeyCount != 3 {
+		t.Errorf("%d keys left, want 3", app.committed.KeyCount)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+)
+
+// A 'key=value' transaction can't have a key containing '=', or a value
+// that looks like an option, so besides this text scheme there
+// is a hex scheme for arbitrary bytes, the key and value hex encoded and
+// marked with a 0x00 byte and the scheme byte
+//
+//	0x00 'x' hex(key) '=' hex(value) [;name=value ...]
+//
+// options are added in their text form, e.g. '\x00x6b=76;ttl=10' sets 'k'
+// to 'v' for 10 blocks, the key is normalized and validated like any other
+// a transaction starting with 0x00 used to always be rejected, as the key
+// would be reserved, so the scheme doesn't change any valid transaction
+// EncodeTx is the client side of it
+
+const (
+	schemeMarker = 0x00
+	schemeHex    = 'x'
+	schemeProto  = 'p' // see proto.go
+	schemeBatch  = 'b' // see batch.go
+)
+
+// EncodeTx returns the transaction setting key to value in the hex scheme,
+// it round trips any key and value, e.g. ones containing '=' or newlines
+// options can be appended as ';name=value'
+func EncodeTx(key, value []byte) []byte {
+	tx := make([]byte, 0, 3+2*(len(key)+len(value)))
+	tx = append(tx, schemeMarker, schemeHex)
+	tx = append(tx, hex.EncodeToString(key)...)
+	tx = append(tx, '=')
+	return append(tx, hex.EncodeToString(value)...)
+}
+
+// parseEncoded parses a hex scheme transaction, returns false if tx isn't one
+func parseEncoded(tx []byte, t *transaction) (bool, error) {
+	if len(tx) < 2 || tx[0] != schemeMarker || tx[1] != schemeHex {
+		return false, nil
+	}
+	body, err := parseOptions(tx[2:], t)
+	if err != nil {
+		return true, err
+	}
+	parts := bytes.Split(body, []byte("="))
+	if len(parts) != 2 {
+		return true, reject(INVALID_FORMAT, "hex transaction must be of the format hex(key)=hex(value)")
+	}
+	if t.key, err = decodeHex(parts[0]); err != nil {
+		return true, reject(INVALID_FORMAT, "hex transaction key isn't valid hex")
+	}
+	if t.value, err = decodeHex(parts[1]); err != nil {
+		return true, reject(INVALID_FORMAT, "hex transaction value isn't valid hex")
+	}
+	if len(t.key) == 0 {
+		return true, reject(INVALID_FORMAT, "hex transaction key can't be empty")
+	}
+	return true, nil
+}
+
+func decodeHex(s []byte) ([]byte, error) {
+	out := make([]byte, hex.DecodedLen(len(s)))
+	_, err := hex.Decode(out, s)
+	return out, err
+}
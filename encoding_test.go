@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestEncodeTxRoundTrips(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		key := make([]byte, 1+rng.Intn(20))
+		rng.Read(key)
+		value := make([]byte, rng.Intn(40))
+		rng.Read(value)
+		parsed, err := parseTx(EncodeTx(key, value))
+		if err != nil {
+			t.Fatalf("%x=%x: %v", key, value, err)
+		}
+		if !bytes.Equal(parsed.key, key) || !bytes.Equal(parsed.value, value) {
+			t.Fatalf("%x=%x parsed as %x=%x", key, value, parsed.key, parsed.value)
+		}
+	}
+
+	// the delimiters of the text scheme, with options after the encoding
+	parsed, err := parseTx(append(EncodeTx([]byte("a=b"), []byte("x\ny;ttl=1")), ";ttl=3"...))
+	if err != nil || string(parsed.key) != "a=b" || string(parsed.value) != "x\ny;ttl=1" || parsed.ttl != 3 {
+		t.Fatalf("got %q=%q with ttl %d: %v", parsed.key, parsed.value, parsed.ttl, err)
+	}
+	// and the text scheme is left as it is
+	if parsed, err := parseTx([]byte("a=b")); err != nil || string(parsed.key) != "a" || string(parsed.value) != "b" {
+		t.Fatalf("the text scheme got %q=%q: %v", parsed.key, parsed.value, err)
+	}
+}
+
+func TestEncodeTxThroughTheApp(t *testing.T) {
+	app := newTestApp(t)
+	key, value := []byte("k=\n\xff"), []byte("1\n2=3\x00")
+	if r := deliverBlock(app, 1, string(EncodeTx(key, value)))[0]; r.Code != VALID_TX {
+		t.Fatalf("got code %d: %s", r.Code, r.Log)
+	}
+	if got, _, _ := app.get(key); !bytes.Equal(got, value) {
+		t.Fatalf("%q is %q, want %q", key, got, value)
+	}
+
+	for _, c := range []struct {
+		tx   []byte
+		code uint32
+	}{
+		{EncodeTx([]byte{0, 1}, []byte("x")), RESERVED_KEY},
+		{EncodeTx(nil, []byte("x")), INVALID_FORMAT},
+		{[]byte("\x00xzz=00"), INVALID_FORMAT},
+		{[]byte("\x00x00=zz"), INVALID_FORMAT},
+		{[]byte("\x00x00"), INVALID_FORMAT},
+		{[]byte("\x00x00=00=00"), INVALID_FORMAT},
+	} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: c.tx}); r.Code != c.code {
+			t.Errorf("%q got code %d, want %d: %s", c.tx, r.Code, c.code, r.Log)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+)
+
+// There are two very different ways a transaction can fail
+//
+// The transaction itself can be bad (wrong format, duplicate etc.)
+// every node sees the same transaction and the same state so every node
+// rejects it the same way, all we need to do is return a non-zero code
+//
+// Or something can go wrong with the node itself (db full, disk errors etc.)
+// the transaction might have been perfectly fine on every other node, if we
+// carried on our state would silently diverge from the rest of the network
+// so the only safe thing to do is stop the node and let the operator fix it
+//
+// Anything that validates or applies a transaction returns an error,
+// a *rejection means the first case, any other error means the second
+
+// rejection is a deterministic transaction failure
+// it is reported back to tendermint core as a response code
+// info is an optional machine readable hint for clients, like log it
+// isn't part of consensus so it can change without affecting the app hash
+type rejection struct {
+	code uint32
+	log  string
+	info string
+}
+
+func reject(code uint32, log string) *rejection {
+	return &rejection{code: code, log: log}
+}
+
+func (r *rejection) Error() string {
+	return fmt.Sprintf("transaction rejected with code %d: %s", r.code, r.log)
+}
+
+// asRejection returns the rejection wrapped in err if there is one
+func asRejection(err error) (*rejection, bool) {
+	r, ok := err.(*rejection)
+	return r, ok
+}
+
+// halt stops the node because of an infrastructure failure
+// panicking is deliberate here, tendermint core will stop the node
+// and the message should make it obvious why
+func halt(method string, err error) {
+	panic(fmt.Sprintf("kvstore: %s hit an unrecoverable error, halting the node so state can't diverge: %v", method, err))
+}
+
+// InvalidTxPolicy decides what DeliverTx does with a malformed transaction,
+// one CheckTx rejects no matter what state it's checked against (too large,
+// bad format, reserved or invalid key, invalid value, disabled op), a correct
+// proposer never puts one in a block, so seeing one means a faulty or
+// malicious proposer
+// rejections that depend on state (duplicates, expiry, ...) are a normal
+// result of the order transactions end up in, they aren't affected
+type InvalidTxPolicy int
+
+const (
+	// InvalidTxCount logs the transaction, counts it and rejects it
+	// like any other, tendermint keeps it in the block without effect
+	InvalidTxCount InvalidTxPolicy = iota
+	// InvalidTxHalt halts the node
+	InvalidTxHalt
+)
+
+// malformed returns true if the rejection doesn't depend on state
+func (r *rejection) malformed() bool {
+	switch r.code {
+	case INVALID_FORMAT, RESERVED_KEY, INVALID_VALUE, INVALID_KEY, TX_TOO_LARGE, OP_DISABLED, KEY_TOO_DEEP, BATCH_TOO_LARGE, OUT_OF_GAS:
+		return true
+	}
+	return false
+}
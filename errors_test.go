@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/log"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// faultStore is a Store whose reads and writes fail with err once it's set
+type faultStore struct {
+	Store
+	err error
+}
+
+func (s *faultStore) View(fn func(txn Txn) error) error {
+	return s.Store.View(func(txn Txn) error { return fn(faultTxn{txn, s}) })
+}
+
+func (s *faultStore) Update(fn func(txn Txn) error) error {
+	return s.Store.Update(func(txn Txn) error { return fn(faultTxn{txn, s}) })
+}
+
+func (s *faultStore) NewBatch() Txn { return faultTxn{s.Store.NewBatch(), s} }
+
+type faultTxn struct {
+	Txn
+	s *faultStore
+}
+
+func (t faultTxn) Get(key []byte) (Item, error) {
+	if t.s.err != nil {
+		return nil, t.s.err
+	}
+	return t.Txn.Get(key)
+}
+
+func (t faultTxn) Set(key, value []byte) error {
+	if t.s.err != nil {
+		return t.s.err
+	}
+	return t.Txn.Set(key, value)
+}
+
+func (t faultTxn) SetEntry(e *Entry) error {
+	if t.s.err != nil {
+		return t.s.err
+	}
+	return t.Txn.SetEntry(e)
+}
+
+func (t faultTxn) Delete(key []byte) error {
+	if t.s.err != nil {
+		return t.s.err
+	}
+	return t.Txn.Delete(key)
+}
+
+func TestRejectedTxsAreNotFatal(t *testing.T) {
+	app := newTestApp(t)
+	res := deliverBlock(app, 1, "a=1", "bad", "\x00state=x", "a=1")
+	for i, want := range []uint32{VALID_TX, INVALID_FORMAT, RESERVED_KEY, DUPLICATE_TX} {
+		if res[i].Code != want {
+			t.Errorf("tx %d got code %d, want %d", i, res[i].Code, want)
+		}
+	}
+}
+
+func TestStoreFailuresHalt(t *testing.T) {
+	store := &faultStore{Store: NewMemStore()}
+	app := NewKVStoreApplicationWithStore(store)
+	deliverBlock(app, 1, "a=1")
+
+	store.err = errors.New("no space left on device")
+	msg := halts(func() { app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("b=1")}) })
+	if !strings.Contains(msg, "CheckTx") || !strings.Contains(msg, "no space left on device") {
+		t.Errorf("a failed read in CheckTx got %q", msg)
+	}
+
+	store.err = nil
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 2}})
+	store.err = errors.New("no space left on device")
+	msg = halts(func() { app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("b=1")}) })
+	if !strings.Contains(msg, "DeliverTx") || !strings.Contains(msg, "no space left on device") {
+		t.Errorf("a failed write in DeliverTx got %q", msg)
+	}
+}
+
+func TestInvalidTxCounted(t *testing.T) {
+	var logs bytes.Buffer
+	app := newTestApp(t, WithLogger(log.NewTMLogger(&logs)))
+	res := deliverBlock(app, 1, "a=1", "bad", "a=1")
+	if res[1].Code != INVALID_FORMAT || res[2].Code != DUPLICATE_TX {
+		t.Fatalf("got codes %d and %d", res[1].Code, res[2].Code)
+	}
+	var stats statsResponse
+	queryJSON(t, app, "stats", nil, &stats)
+	if stats.MalformedTxs != 1 {
+		t.Errorf("counted %d malformed txs, the duplicate depends on state and shouldn't count", stats.MalformedTxs)
+	}
+	if !strings.Contains(logs.String(), "malformed transaction in block") {
+		t.Errorf("the malformed tx wasn't logged:\n%s", logs.String())
+	}
+}
+
+func TestInvalidTxHalts(t *testing.T) {
+	app := newTestApp(t, WithInvalidTxPolicy(InvalidTxHalt))
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 1}})
+	app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("a=1")})
+	if r := app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("a=1")}); r.Code != DUPLICATE_TX {
+		t.Fatalf("a duplicate got code %d", r.Code)
+	}
+	msg := halts(func() { app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("bad")}) })
+	if !strings.Contains(msg, "block at height 1 contains a malformed transaction") {
+		t.Errorf("got %q", msg)
+	}
+}
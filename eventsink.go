@@ -0,0 +1,105 @@
+package main
+
+import (
+	"time"
+)
+
+// An event sink (WithEventSink) gets every block's changes, e.g. to stream
+// them into a message bus for other systems to consume
+// unlike a shadow writer it never slows the node down, blocks are handed to
+// the sink from a queue by its own goroutine, once they've been flushed, a
+// sink that keeps failing or can't keep up loses blocks, they're logged and
+// counted, so a consumer that has to see everything still needs a way to
+// resync, e.g. from the change index, see changeindex.go
+
+// EventSink receives the changes of every committed block, in order
+// Publish is called from a single goroutine, never for two blocks at once
+type EventSink interface {
+	Publish(height int64, changes []Change) error
+}
+
+// EventSinkPolicy is what happens to blocks the sink doesn't take in time
+type EventSinkPolicy struct {
+	// Buffer is the number of blocks queued for the sink, a block that
+	// doesn't fit is dropped, 0 is DefaultEventSinkPolicy's
+	Buffer int
+	// Retries is the number of times a failed Publish is tried again
+	// before the block is dropped, RetryDelay is the wait in between
+	Retries    int
+	RetryDelay time.Duration
+}
+
+// DefaultEventSinkPolicy queues up to 1024 blocks and retries a failed block
+// 3 times, a second apart
+var DefaultEventSinkPolicy = EventSinkPolicy{Buffer: 1024, Retries: 3, RetryDelay: time.Second}
+
+type eventBlock struct {
+	height  int64
+	changes []Change
+}
+
+// eventSink runs the sink on its own goroutine
+type eventSink struct {
+	sink    EventSink
+	policy  EventSinkPolicy
+	queue   chan eventBlock
+	done    chan struct{}
+	pending []eventBlock
+}
+
+// start starts the goroutine handing blocks to the sink
+func (s *eventSink) start(app *KVStoreApplication) {
+	if s.policy.Buffer <= 0 {
+		s.policy.Buffer = DefaultEventSinkPolicy.Buffer
+	}
+	s.queue = make(chan eventBlock, s.policy.Buffer)
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		for b := range s.queue {
+			err := s.sink.Publish(b.height, b.changes)
+			for i := 0; err != nil && i < s.policy.Retries; i++ {
+				time.Sleep(s.policy.RetryDelay)
+				err = s.sink.Publish(b.height, b.changes)
+			}
+			if err != nil {
+				app.logger.Error("dropping block, the event sink failed", "height", b.height, "err", err)
+				app.metrics.eventBlockDropped()
+			}
+		}
+	}()
+}
+
+// stop waits for the queued blocks to be handed to the sink
+func (s *eventSink) stop() {
+	close(s.queue)
+	<-s.done
+}
+
+// queueEvents keeps the block being committed for the sink until it's flushed
+func (app *KVStoreApplication) queueEvents() {
+	if app.events == nil || len(app.changes) == 0 {
+		return
+	}
+	changes := make([]Change, len(app.changes))
+	for i, c := range app.changes {
+		changes[i] = publicChange(app.pending.Height, c)
+	}
+	app.events.pending = append(app.events.pending, eventBlock{height: app.pending.Height, changes: changes})
+}
+
+// sendEvents queues the flushed blocks for the sink, without waiting for it
+func (app *KVStoreApplication) sendEvents() {
+	if app.events == nil {
+		return
+	}
+	for _, b := range app.events.pending {
+		select {
+		case app.events.queue <- b:
+		default:
+			app.logger.Error("dropping block, the event sink is behind", "height", b.height)
+			app.metrics.eventBlockDropped()
+		}
+	}
+	app.events.pending = nil
+}
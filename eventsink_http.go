@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HTTPEventSink is an EventSink that POSTs every block as JSON to a URL, e.g.
+// a Kafka REST proxy or a webhook bridge in front of NATS
+//
+//	{"height": 10, "changes": [{"height": 10, "key": "a", "value": "1"}]}
+//
+// the changes are encoded like the gateway's stream events, any response
+// other than a 2xx is an error, so the block is retried per the policy
+type HTTPEventSink struct {
+	URL string
+	// Client defaults to a client with a 10s timeout
+	Client *http.Client
+}
+
+var defaultEventSinkClient = &http.Client{Timeout: 10 * time.Second}
+
+type eventSinkBody struct {
+	Height  int64         `json:"height"`
+	Changes []streamEvent `json:"changes"`
+}
+
+func (s HTTPEventSink) Publish(height int64, changes []Change) error {
+	body := eventSinkBody{Height: height, Changes: make([]streamEvent, len(changes))}
+	for i, c := range changes {
+		body.Changes[i] = streamEvent{Height: c.Height, Key: string(c.Key), Deleted: c.Deleted, Value: string(c.Value), Type: c.Type}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	client := s.Client
+	if client == nil {
+		client = defaultEventSinkClient
+	}
+	res, err := client.Post(s.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("event sink responded with %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSink keeps what it's given, failing the first fail calls
+type fakeSink struct {
+	mtx     sync.Mutex
+	heights []int64
+	changes []Change
+	fail    int
+}
+
+func (f *fakeSink) Publish(height int64, changes []Change) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.fail > 0 {
+		f.fail--
+		return errors.New("unavailable")
+	}
+	f.heights = append(f.heights, height)
+	f.changes = append(f.changes, changes...)
+	return nil
+}
+
+func TestEventSink(t *testing.T) {
+	// the first block is retried twice before the sink takes it
+	sink := &fakeSink{fail: 2}
+	app := newTestApp(t, WithEventSink(sink, EventSinkPolicy{Retries: 2, RetryDelay: time.Millisecond}))
+	for h := int64(1); h <= 3; h++ {
+		deliverBlock(app, h, fmt.Sprintf("a%d=%d", h, h), fmt.Sprintf("b=%d", h))
+	}
+	// a block without changes isn't published
+	deliverBlock(app, 4)
+	app.Close()
+
+	if want := []int64{1, 2, 3}; !reflect.DeepEqual(sink.heights, want) {
+		t.Errorf("published heights %v, want %v", sink.heights, want)
+	}
+	var want []Change
+	for h := int64(1); h <= 3; h++ {
+		want = append(want,
+			Change{Height: h, Key: []byte(fmt.Sprintf("a%d", h)), Value: []byte(fmt.Sprint(h)), Type: "bytes"},
+			Change{Height: h, Key: []byte("b"), Value: []byte(fmt.Sprint(h)), Type: "bytes"})
+	}
+	if !reflect.DeepEqual(sink.changes, want) {
+		t.Errorf("got %+v, want %+v", sink.changes, want)
+	}
+
+	// a block the sink keeps failing is dropped, the next ones still go out
+	sink = &fakeSink{fail: 2}
+	app = newTestApp(t, WithEventSink(sink, EventSinkPolicy{Retries: 1, RetryDelay: time.Millisecond}))
+	deliverBlock(app, 1, "a=1")
+	deliverBlock(app, 2, "a=2")
+	app.Close()
+	if want := []int64{2}; !reflect.DeepEqual(sink.heights, want) {
+		t.Errorf("published heights %v, want %v", sink.heights, want)
+	}
+}
+
+func TestHTTPEventSink(t *testing.T) {
+	var bodies []eventSinkBody
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body eventSinkBody
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Error(err)
+		}
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink := HTTPEventSink{URL: srv.URL}
+	changes := []Change{{Height: 1, Key: []byte("a"), Value: []byte("1"), Type: "bytes"}, {Height: 1, Key: []byte("b"), Deleted: true}}
+	if err := sink.Publish(1, changes); err != nil {
+		t.Fatal(err)
+	}
+	want := eventSinkBody{Height: 1, Changes: []streamEvent{
+		{Height: 1, Key: "a", Value: "1", Type: "bytes"},
+		{Height: 1, Key: "b", Deleted: true},
+	}}
+	if len(bodies) != 1 || !reflect.DeepEqual(bodies[0], want) {
+		t.Errorf("got %+v, want %+v", bodies, want)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := sink.Publish(2, changes); err == nil {
+		t.Error("expected an error for a 503")
+	}
+}
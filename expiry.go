@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// A key written with the 'ttl' option expires that many blocks later,
+// 'lease=alice;ttl=10' delivered at height 100 expires at height 110 and is
+// removed at the end of that block, after its transactions, which can still
+// read it, so it's readable up to height 109 by queries and up to height 110
+// by transactions, EndBlock reports each key removed as an "expire" event,
+// and it's a change like any other removal for subscribers
+// expiry is by height rather than wall-clock time, as every node has to
+// remove the key in the same block for the app hash to agree
+// a later write to the key replaces its expiry, writing it again with a ttl
+// refreshes the lease, writing it without one makes it permanent
+//
+// the 'delete_at' option schedules the removal at a height rather than a
+// number of blocks from now, 'draft=v1;delete_at=500' is readable by
+// queries up to height 499 and removed at the end of block 500, it's the
+// same expiry as a ttl, so a write can't have both, and it's rejected with
+// TX_EXPIRED if the height has passed by the time it's delivered, the
+// "expiring" query lists the keys removed at a height, e.g.
+// {"height": 500, "limit": 100}
+//
+//	expiryPrefix       | key                 -> height the key expires at
+//	expiryHeightPrefix | height (8 bytes) key -> nothing, to find what expires
+//
+// the expiry recorded under expiryPrefix is the one expireKeys enforces and
+// the one the meta query reports
+//
+// reap:key removes a key that has expired, anyone can send it, it's rejected
+// with NOT_EXPIRED if the key doesn't expire or expires after the block it's
+// in, and with MISSING_KEY if it's gone, the sweep at the end of the block
+// removes every expired key anyway, so a reap only ever finds one in the
+// block the key expires in, it removes the key before the block's later
+// transactions rather than after all of them
+
+var (
+	expiryPrefix       = internalKey("exp/")
+	expiryHeightPrefix = internalKey("exph/")
+)
+
+func expiryKey(key []byte) []byte {
+	return append(append([]byte{}, expiryPrefix...), key...)
+}
+
+func expiryHeightKey(height int64, key []byte) []byte {
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], uint64(height))
+	return append(append(append([]byte{}, expiryHeightPrefix...), h[:]...), key...)
+}
+
+// readExpiry returns the height the key expires at as seen by txn, 0 if
+// it doesn't expire
+func readExpiry(txn Txn, key []byte) (int64, error) {
+	item, err := txn.Get(expiryKey(key))
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var height int64
+	err = item.Value(func(val []byte) error {
+		height = int64(binary.BigEndian.Uint64(val))
+		return nil
+	})
+	return height, err
+}
+
+// clearExpiry removes the key's expiry, if it has one, from the current batch
+func (app *KVStoreApplication) clearExpiry(key []byte) error {
+	height, err := readExpiry(app.currentBatch, key)
+	if err != nil || height == 0 {
+		return err
+	}
+	if err := app.currentBatch.Delete(expiryHeightKey(height, key)); err != nil {
+		return err
+	}
+	return app.currentBatch.Delete(expiryKey(key))
+}
+
+// setExpiry makes the key expire at the given height, replacing any
+// expiry it already had
+func (app *KVStoreApplication) setExpiry(key []byte, height int64) error {
+	if err := app.clearExpiry(key); err != nil {
+		return err
+	}
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], uint64(height))
+	if err := app.currentBatch.Set(expiryKey(key), h[:]); err != nil {
+		return err
+	}
+	return app.currentBatch.Set(expiryHeightKey(height, key), nil)
+}
+
+// expireKeys removes every key that expires at or before the current block
+// and returns them, it runs in EndBlock, after the block's transactions and
+// before the app hash is computed, so the removals are part of the block
+// only the expiry index up to the current height is read, not the keys
+func (app *KVStoreApplication) expireKeys() ([][]byte, error) {
+	var expired [][]byte
+	opts := DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = expiryHeightPrefix
+	it := app.currentBatch.NewIterator(opts)
+	for it.Seek(expiryHeightPrefix); it.Valid(); it.Next() {
+		key := it.Item().Key()
+		if int64(binary.BigEndian.Uint64(key[len(expiryHeightPrefix):])) > app.pending.Height {
+			break
+		}
+		expired = append(expired, append([]byte{}, key[len(expiryHeightPrefix)+8:]...))
+	}
+	it.Close()
+
+	// remove clears the expiry along with the key
+	for _, key := range expired {
+		if err := app.remove(key); err != nil {
+			return nil, err
+		}
+	}
+	return expired, nil
+}
+
+const (
+	defaultExpiringLimit = 100
+	maxExpiringLimit     = 1000
+)
+
+type expiringRequest struct {
+	Height int64 `json:"height"`
+	Limit  int   `json:"limit"`
+	// Start continues a previous listing from that key
+	Start string `json:"start"`
+}
+
+type expiringResponse struct {
+	Height int64 `json:"height"`
+	// At is the height the keys are removed at
+	At   int64    `json:"at"`
+	Keys []string `json:"keys"`
+	// Next is set if there are more keys, where to carry on from
+	Next string `json:"next,omitempty"`
+}
+
+// queryExpiring lists the keys that are removed at the end of the block at
+// a height, see expiry.go
+func (app *KVStoreApplication) queryExpiring(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var ereq expiringRequest
+	if !parseRequest(req, &res, &ereq) {
+		return
+	}
+	if ereq.Height < 1 {
+		res.Code = QUERY_INVALID
+		res.Log = "height must be a positive height"
+		return
+	}
+	limit := clampLimit(ereq.Limit, defaultExpiringLimit, maxExpiringLimit)
+	prefix := expiryHeightKey(ereq.Height, nil)
+	seek := prefix
+	if ereq.Start != "" {
+		seek = expiryHeightKey(ereq.Height, app.normalizeKey([]byte(ereq.Start)))
+	}
+
+	eres := expiringResponse{Height: app.committed.Height, At: ereq.Height, Keys: []string{}}
+	err := app.store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(seek); it.Valid(); it.Next() {
+			key := string(it.Item().Key()[len(prefix):])
+			if len(eres.Keys) == limit {
+				eres.Next = key
+				return nil
+			}
+			eres.Keys = append(eres.Keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		halt("Query", err)
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, eres)
+	return
+}
+
+// expiryEvents returns an "expire" event for each expired key, with the key
+// as its "key" attribute, so a subscriber can query e.g. expire.key='lease'
+func expiryEvents(expired [][]byte) []abcitypes.Event {
+	if len(expired) == 0 {
+		return nil
+	}
+	events := make([]abcitypes.Event, len(expired))
+	for i, key := range expired {
+		events[i] = abcitypes.Event{
+			Type:       "expire",
+			Attributes: []abcitypes.EventAttribute{{Key: []byte("key"), Value: key, Index: true}},
+		}
+	}
+	return events
+}
+
+func init() {
+	registerOp(&txOp{
+		name:    "reap",
+		args:    1,
+		keys:    []int{0},
+		removes: true,
+		check: func(app *KVStoreApplication, txn Txn, t transaction) error {
+			key := t.args[0]
+			_, _, exists, err := lookup(txn, key)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return reject(MISSING_KEY, fmt.Sprintf("key %q doesn't exist", key))
+			}
+			height, err := readExpiry(txn, key)
+			if err != nil {
+				return err
+			}
+			if height == 0 {
+				return reject(NOT_EXPIRED, fmt.Sprintf("key %q doesn't expire", key))
+			}
+			if height > app.txHeight() {
+				return reject(NOT_EXPIRED, fmt.Sprintf("key %q expires at height %d", key, height))
+			}
+			if app.appendOnly {
+				return errOverwriteForbidden
+			}
+			return nil
+		},
+		apply: func(app *KVStoreApplication, t transaction) error {
+			return app.remove(t.args[0])
+		},
+	})
+}
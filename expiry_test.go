@@ -0,0 +1,194 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// keyMeta queries the meta of key
+func keyMeta(t *testing.T, app *KVStoreApplication, key string) metaResponse {
+	t.Helper()
+	var meta metaResponse
+	queryJSON(t, app, "meta", []byte(key), &meta)
+	return meta
+}
+
+func TestTTLExpiry(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "lease=a;ttl=3", "perm=x")
+	if meta := keyMeta(t, app, "lease"); !meta.Found || meta.ExpiresAt != 4 {
+		t.Errorf("a ttl of 3 at height 1 got %+v, want it to expire at 4", meta)
+	}
+	if meta := keyMeta(t, app, "perm"); !meta.Found || meta.ExpiresAt != 0 {
+		t.Errorf("a key without a ttl got %+v", meta)
+	}
+
+	// the same pair written again refreshes the expiry
+	deliverBlock(app, 2, "lease=a;ttl=3")
+	if meta := keyMeta(t, app, "lease"); meta.ExpiresAt != 5 {
+		t.Errorf("the refreshed lease got %+v", meta)
+	}
+	deliverBlock(app, 3)
+	deliverBlock(app, 4)
+	if meta := keyMeta(t, app, "lease"); !meta.Found {
+		t.Error("the lease expired early")
+	}
+	// the reported height is the one it's actually removed at
+	deliverBlock(app, 5)
+	if meta := keyMeta(t, app, "lease"); meta.Found {
+		t.Errorf("the lease is still there at its expiry height: %+v", meta)
+	}
+	if app.committed.KeyCount != 1 {
+		t.Errorf("got %d keys after the expiry", app.committed.KeyCount)
+	}
+
+	// a write without a ttl clears it
+	deliverBlock(app, 6, "lease=b;ttl=2")
+	deliverBlock(app, 7, "lease=c")
+	deliverBlock(app, 8)
+	deliverBlock(app, 9)
+	if meta := keyMeta(t, app, "lease"); !meta.Found || meta.ExpiresAt != 0 {
+		t.Errorf("an overwrite without a ttl got %+v", meta)
+	}
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("lease=c;ttl=1")}); r.Code != VALID_TX {
+		t.Errorf("adding a ttl to the same pair got code %d", r.Code)
+	}
+}
+
+func TestExpiryEndBlock(t *testing.T) {
+	app := newTestApp(t)
+	block := func(height int64, txs ...string) []abcitypes.Event {
+		t.Helper()
+		app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: height}})
+		for _, tx := range txs {
+			if r := app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte(tx)}); r.Code != VALID_TX {
+				t.Fatalf("%s got code %d: %s", tx, r.Code, r.Log)
+			}
+		}
+		res := app.EndBlock(abcitypes.RequestEndBlock{Height: height})
+		app.Commit()
+		return res.Events
+	}
+	expired := func(events []abcitypes.Event) []string {
+		var keys []string
+		for _, e := range events {
+			if e.Type == "expire" && len(e.Attributes) > 0 && string(e.Attributes[0].Key) == "key" {
+				keys = append(keys, string(e.Attributes[0].Value))
+			}
+		}
+		return keys
+	}
+
+	block(1, "a=1;ttl=2", "b=1;ttl=2", "c=1;ttl=3")
+	if keys := expired(block(2)); len(keys) != 0 {
+		t.Errorf("expired %q before any expiry height", keys)
+	}
+	// the block the keys expire in can still read them, they're only
+	// removed in its EndBlock
+	keys := expired(block(3, "cp:a:x", "cp:b:y"))
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("block 3 expired %q, want a and b", keys)
+	}
+	for k, want := range map[string]bool{"a": false, "b": false, "c": true, "x": true, "y": true} {
+		if meta := keyMeta(t, app, k); meta.Found != want {
+			t.Errorf("after block 3 %s found is %v, want %v", k, meta.Found, want)
+		}
+	}
+	if keys := expired(block(4)); len(keys) != 1 || keys[0] != "c" {
+		t.Errorf("block 4 expired %q, want c", keys)
+	}
+	if app.committed.KeyCount != 2 {
+		t.Errorf("got %d keys after the expiry, want the 2 copies", app.committed.KeyCount)
+	}
+}
+
+func TestReap(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "lease=a;ttl=2", "perm=x")
+
+	// the lease expires at 3, it's live in block 2
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("reap:lease")}); r.Code != NOT_EXPIRED {
+		t.Errorf("reaping the live lease in CheckTx got code %d", r.Code)
+	}
+	res := deliverBlock(app, 2, "reap:lease", "reap:perm", "reap:nope")
+	for i, want := range []uint32{NOT_EXPIRED, NOT_EXPIRED, MISSING_KEY} {
+		if res[i].Code != want {
+			t.Errorf("reap %d got code %d, want %d: %s", i, res[i].Code, want, res[i].Log)
+		}
+	}
+	if meta := keyMeta(t, app, "lease"); !meta.Found {
+		t.Error("a rejected reap removed the lease")
+	}
+
+	// in the block it expires in the reap removes it before the later txs,
+	// and the sweep has nothing left to remove
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("reap:lease")}); r.Code != VALID_TX {
+		t.Errorf("reaping the expired lease in CheckTx got code %d: %s", r.Code, r.Log)
+	}
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 3}})
+	for _, tx := range []string{"reap:lease", "cp:perm:lease"} {
+		if r := app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte(tx)}); r.Code != VALID_TX {
+			t.Errorf("%s got code %d: %s", tx, r.Code, r.Log)
+		}
+	}
+	if end := app.EndBlock(abcitypes.RequestEndBlock{Height: 3}); len(end.Events) != 0 {
+		t.Errorf("the sweep after a reap got events %v", end.Events)
+	}
+	app.Commit()
+	if value, _, _ := app.get([]byte("lease")); string(value) != "x" {
+		t.Errorf("the lease written after the reap is %q", value)
+	}
+}
+
+func TestDeleteAt(t *testing.T) {
+	app := newTestApp(t)
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("a=1;delete_at=3;ttl=2")}); r.Code != INVALID_FORMAT {
+		t.Errorf("delete_at with a ttl got code %d", r.Code)
+	}
+	res := deliverBlock(app, 1, "a=1;delete_at=3", "b=1;delete_at=0", "c=1;delete_at=1")
+	for i, want := range []uint32{VALID_TX, INVALID_FORMAT, VALID_TX} {
+		if res[i].Code != want {
+			t.Errorf("tx %d got code %d, want %d: %s", i, res[i].Code, want, res[i].Log)
+		}
+	}
+	// removed at the end of the block it was written in
+	if meta := keyMeta(t, app, "c"); meta.Found {
+		t.Error("c is still there after its delete_at height")
+	}
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("x=1;delete_at=1")}); r.Code != TX_EXPIRED {
+		t.Errorf("a delete_at that's passed got code %d", r.Code)
+	}
+
+	// the scheduled removal can be queried
+	if meta := keyMeta(t, app, "a"); meta.ExpiresAt != 3 {
+		t.Errorf("a expires at %d, want 3", meta.ExpiresAt)
+	}
+	var expiring expiringResponse
+	queryJSON(t, app, "expiring", []byte(`{"height": 3}`), &expiring)
+	if expiring.At != 3 || !reflect.DeepEqual(expiring.Keys, []string{"a"}) {
+		t.Errorf("expiring at 3 got %+v", expiring)
+	}
+
+	// present up to the height, gone after it
+	deliverBlock(app, 2)
+	if meta := keyMeta(t, app, "a"); !meta.Found {
+		t.Error("a was removed before its delete_at height")
+	}
+	deliverBlock(app, 3)
+	if meta := keyMeta(t, app, "a"); meta.Found {
+		t.Error("a is still there after its delete_at height")
+	}
+
+	// the same pair written again schedules the removal
+	deliverBlock(app, 4, "d=1")
+	if res := deliverBlock(app, 5, "d=1;delete_at=6"); res[0].Code != VALID_TX {
+		t.Errorf("scheduling the removal of d got code %d: %s", res[0].Code, res[0].Log)
+	}
+	deliverBlock(app, 6)
+	if meta := keyMeta(t, app, "d"); meta.Found {
+		t.Error("d is still there after its delete_at height")
+	}
+}
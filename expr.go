@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// expr:key:expression updates a key based on its current value, atomically
+// as the read and the write happen in the same transaction
+// the expressions are a small fixed set, there is nothing to evaluate
+// beyond them
+//
+//	+N, -N       add or subtract N, the value has to be an integer
+//	append:S     add S to the end of the value
+//	prepend:S    add S to the start of the value
+//
+// a missing key counts as 0 (int) or as an empty value (bytes) and is created
+// the value keeps its type, so the result has to still be valid for it,
+// adding to an int overflowing or appending to a json value that doesn't
+// stay valid json is rejected with INCOMPATIBLE_VALUE
+
+type exprKind int
+
+const (
+	exprAdd exprKind = iota
+	exprAppend
+	exprPrepend
+)
+
+type expr struct {
+	kind  exprKind
+	delta int64
+	text  []byte
+}
+
+// parseExpr parses an expression, see above
+func parseExpr(s []byte) (expr, error) {
+	if len(s) > 0 && (s[0] == '+' || s[0] == '-') {
+		// ParseInt would accept a second sign, e.g. +-5
+		digits := s[1:]
+		if len(digits) == 0 || digits[0] < '0' || digits[0] > '9' {
+			return expr{}, reject(INVALID_FORMAT, "expr: expected a number after "+string(s[:1]))
+		}
+		delta, err := strconv.ParseInt(string(s), 10, 64)
+		if err != nil {
+			return expr{}, reject(INVALID_FORMAT, fmt.Sprintf("expr: invalid number %q", s))
+		}
+		return expr{kind: exprAdd, delta: delta}, nil
+	}
+
+	i := bytes.IndexByte(s, ':')
+	if i < 0 {
+		return expr{}, reject(INVALID_FORMAT, fmt.Sprintf("expr: unknown expression %q", s))
+	}
+	var e expr
+	switch string(s[:i]) {
+	case "append":
+		e.kind = exprAppend
+	case "prepend":
+		e.kind = exprPrepend
+	default:
+		return expr{}, reject(INVALID_FORMAT, fmt.Sprintf("expr: unknown operation %q", s[:i]))
+	}
+	e.text = s[i+1:]
+	if len(e.text) == 0 {
+		return expr{}, reject(INVALID_FORMAT, "expr: "+string(s[:i])+" needs a value")
+	}
+	return e, nil
+}
+
+// eval returns the new value and type of a key, given its current ones
+func (e expr) eval(value []byte, ct contentType, exists bool) ([]byte, contentType, error) {
+	switch e.kind {
+	case exprAdd:
+		if !exists {
+			value, ct = []byte("0"), typeInt
+		}
+		n, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return nil, 0, reject(INCOMPATIBLE_VALUE, "expr: the value isn't an integer")
+		}
+		if (e.delta > 0 && n > math.MaxInt64-e.delta) || (e.delta < 0 && n < math.MinInt64-e.delta) {
+			return nil, 0, reject(INCOMPATIBLE_VALUE, "expr: the result overflows")
+		}
+		return []byte(strconv.FormatInt(n+e.delta, 10)), ct, nil
+	case exprAppend:
+		value = append(append([]byte{}, value...), e.text...)
+	case exprPrepend:
+		value = append(append([]byte{}, e.text...), value...)
+	}
+	if ct.validate(value) != nil {
+		return nil, 0, reject(INCOMPATIBLE_VALUE, "expr: the result isn't a valid "+ct.String()+" value")
+	}
+	return value, ct, nil
+}
+
+// evalOp evaluates an expr op against the state visible to txn
+func evalOp(app *KVStoreApplication, txn Txn, t transaction) ([]byte, contentType, error) {
+	// parse already validated it in parseOp
+	e, _ := parseExpr(t.args[1])
+	value, ct, exists, err := lookup(txn, t.args[0])
+	if err != nil {
+		return nil, 0, err
+	}
+	if exists && app.appendOnly {
+		return nil, 0, errOverwriteForbidden
+	}
+	return e.eval(value, ct, exists)
+}
+
+func init() {
+	registerOp(&txOp{
+		name: "expr",
+		args: 2,
+		keys: []int{0},
+		rest: true,
+		parse: func(args [][]byte) error {
+			_, err := parseExpr(args[1])
+			return err
+		},
+		check: func(app *KVStoreApplication, txn Txn, t transaction) error {
+			_, _, err := evalOp(app, txn, t)
+			return err
+		},
+		apply: func(app *KVStoreApplication, t transaction) error {
+			value, ct, err := evalOp(app, app.currentBatch, t)
+			if err != nil {
+				return err
+			}
+			return app.set(t.args[0], value, ct)
+		},
+	})
+}
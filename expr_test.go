@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestExpr(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "n=5;type=int", "s=b", `j={};type=json`, "max=9223372036854775806;type=int")
+
+	height := int64(1)
+	for _, c := range []struct {
+		tx, key, want string
+		ct            contentType
+	}{
+		{"expr:n:+3", "n", "8", typeInt},
+		{"expr:n:-10", "n", "-2", typeInt},
+		{"expr:s:append:c", "s", "bc", typeBytes},
+		{"expr:s:prepend:a:", "s", "a:bc", typeBytes},
+		{"expr:new:+2", "new", "2", typeInt},
+		{"expr:text:append:hi", "text", "hi", typeBytes},
+		{"expr:max:+1", "max", "9223372036854775807", typeInt},
+	} {
+		height++
+		if r := deliverBlock(app, height, c.tx)[0]; r.Code != VALID_TX {
+			t.Errorf("%s got code %d: %s", c.tx, r.Code, r.Log)
+			continue
+		}
+		if value, ct := typedValue(t, app, c.key); value != c.want || ct != c.ct {
+			t.Errorf("after %s %s is %q (%s), want %q (%s)", c.tx, c.key, value, ct, c.want, c.ct)
+		}
+	}
+
+	for _, c := range []struct {
+		tx   string
+		code uint32
+	}{
+		{"expr:n", INVALID_FORMAT},
+		{"expr:n:*2", INVALID_FORMAT},
+		{"expr:n:+", INVALID_FORMAT},
+		{"expr:n:++1", INVALID_FORMAT},
+		{"expr:n:+-1", INVALID_FORMAT},
+		{"expr:n:+1x", INVALID_FORMAT},
+		{"expr:n:+99999999999999999999", INVALID_FORMAT},
+		{"expr:s:append:", INVALID_FORMAT},
+		{"expr:s:+1", INCOMPATIBLE_VALUE},
+		{"expr:max:+1", INCOMPATIBLE_VALUE},
+		{"expr:n:-9223372036854775807", INCOMPATIBLE_VALUE},
+		{"expr:j:append:x", INCOMPATIBLE_VALUE},
+	} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(c.tx)}); r.Code != c.code {
+			t.Errorf("CheckTx %s got code %d, want %d: %s", c.tx, r.Code, c.code, r.Log)
+		}
+		height++
+		if r := deliverBlock(app, height, c.tx)[0]; r.Code != c.code {
+			t.Errorf("DeliverTx %s got code %d, want %d: %s", c.tx, r.Code, c.code, r.Log)
+		}
+	}
+	if value, _ := typedValue(t, app, "n"); value != "-2" {
+		t.Errorf("the rejected expressions changed n to %q", value)
+	}
+}
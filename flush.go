@@ -0,0 +1,69 @@
+package main
+
+import (
+	"time"
+)
+
+// Normally every Commit flushes the block to badger straight away
+// With commit batching the batch is kept open for several blocks and only
+// flushed once enough blocks, or enough time, have gone by, which saves a
+// badger commit (and an fsync) per block
+//
+// Until a flush Query and CheckTx only see the state as of the last flush,
+// and a crash loses every unflushed block, Info reports the last flushed
+// height on restart so tendermint core replays the lost blocks from its own
+// block store, but that is the only thing protecting the data
+// this is meant for non-validating nodes that need throughput more than
+// finality, validators must not enable it
+//
+// With WithSyncOnFlush every flush ends with a sync of badger's value log,
+// on a db opened without synced writes (WithSyncWrites) that makes each
+// flush durable with a single sync rather than one per write badger makes
+
+// shouldFlush decides if the batch should be flushed at the end of a Commit
+func (app *KVStoreApplication) shouldFlush() bool {
+	if app.unflushedBlocks >= app.flushBlocks {
+		return true
+	}
+	return app.flushInterval > 0 && time.Since(app.lastFlush) >= app.flushInterval
+}
+
+// flush commits the current batch to the store
+func (app *KVStoreApplication) flush() error {
+	if app.currentBatch == nil {
+		return nil
+	}
+	if err := app.currentBatch.Commit(); err != nil {
+		return err
+	}
+	// with synced writes there's nothing left to sync
+	if app.syncOnFlush {
+		if err := app.db.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := app.verifyWrites(app.unflushed); err != nil {
+		return err
+	}
+	if app.cache != nil {
+		app.cache.invalidate(app.unflushed)
+	}
+	app.writeShadow()
+	app.publish()
+	app.sendEvents()
+	app.currentBatch = nil
+	app.unflushed = nil
+	app.unflushedBlocks = 0
+	app.lastFlush = time.Now()
+	return nil
+}
+
+// Flush writes every committed block that hasn't been flushed yet to badger
+// it must be called before shutting down when commit batching is enabled
+func (app *KVStoreApplication) Flush() error {
+	if app.inBlock() {
+		return ErrBlockInProgress
+	}
+	defer app.noteActivity()
+	return app.flush()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// The format version describes how the store lays out its data on disk
+// (internal keys, how values and their metadata are encoded), it has to be
+// bumped whenever a change means an older binary would misread the store
+// it's written once, with the first block, so migration tooling can tell
+// what it's looking at, and a binary refuses to open a store written in a
+// newer format than it understands rather than silently corrupting it
+
+// storeFormatVersion is the format this binary reads and writes
+const storeFormatVersion = 1
+
+var formatKey = internalKey("format")
+
+// loadFormatVersion reads the store's format version
+// 0 means the marker hasn't been written yet, i.e. a fresh store
+func loadFormatVersion(store Store) (version int, err error) {
+	err = store.View(func(txn Txn) error {
+		item, err := txn.Get(formatKey)
+		if err == ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			version, err = strconv.Atoi(string(val))
+			if err != nil {
+				return fmt.Errorf("invalid store format version %q", val)
+			}
+			return nil
+		})
+	})
+	return
+}
+
+// checkFormatVersion returns an error if this binary can't run against a
+// store with the given format version
+func checkFormatVersion(version int) error {
+	if version > storeFormatVersion {
+		return fmt.Errorf("store format version %d is newer than the supported version %d, upgrade the binary", version, storeFormatVersion)
+	}
+	return nil
+}
+
+// saveFormatVersion adds the format marker to the given batch if the
+// store doesn't have one yet
+func (app *KVStoreApplication) saveFormatVersion(txn Txn) error {
+	if app.formatVersion != 0 {
+		return nil
+	}
+	if err := txn.Set(formatKey, []byte(strconv.Itoa(storeFormatVersion))); err != nil {
+		return err
+	}
+	app.formatVersion = storeFormatVersion
+	return nil
+}
+
+type formatResponse struct {
+	// Version is 0 until the first block has been committed
+	Version   int `json:"version"`
+	Supported int `json:"supported"`
+}
+
+// queryFormat returns the store's format version and the version this
+// binary supports
+func (app *KVStoreApplication) queryFormat(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	res.Height = app.committed.Height
+	respondJSON(&res, formatResponse{Version: app.formatVersion, Supported: storeFormatVersion})
+	return
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatVersion(t *testing.T) {
+	app := newTestApp(t)
+	var format formatResponse
+	queryJSON(t, app, "format", nil, &format)
+	if format != (formatResponse{Version: 0, Supported: storeFormatVersion}) {
+		t.Errorf("a fresh store got %+v", format)
+	}
+
+	deliverBlock(app, 1)
+	queryJSON(t, NewKVStoreApplicationWithStore(app.store), "format", nil, &format)
+	if format != (formatResponse{Version: storeFormatVersion, Supported: storeFormatVersion}) {
+		t.Errorf("after the first block got %+v", format)
+	}
+}
+
+func TestNewerFormatVersionRefused(t *testing.T) {
+	for value, want := range map[string]string{
+		"2": "store format version 2 is newer than the supported version 1",
+		"x": `invalid store format version "x"`,
+	} {
+		store := NewMemStore()
+		store.Update(func(txn Txn) error { return txn.Set(formatKey, []byte(value)) })
+		msg := halts(func() { NewKVStoreApplicationWithStore(store) })
+		if !strings.Contains(msg, want) {
+			t.Errorf("format %q got %q", value, msg)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Freeze windows (WithFreezeWindows) stop the state from changing for a
+// while, e.g. during a maintenance window, every transaction delivered in a
+// block whose time falls in a window is rejected with FROZEN, queries are
+// answered as usual, a window is [Start, End), in block time, never the
+// node's clock, so every node rejects the same transactions, CheckTx checks
+// the last block's time, so a transaction sent just before a window opens
+// can still get into the mempool, and be rejected once it's delivered, and
+// one sent just after it closes is turned away until the next block
+// the windows are part of consensus, every node has to have the same ones
+
+// FreezeWindow is a span of block time in which no transaction is applied
+type FreezeWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// frozenWindow returns the window t falls in, if any
+func (app *KVStoreApplication) frozenWindow(t time.Time) (FreezeWindow, bool) {
+	for _, w := range app.freezeWindows {
+		if !t.Before(w.Start) && t.Before(w.End) {
+			return w, true
+		}
+	}
+	return FreezeWindow{}, false
+}
+
+// checkFrozen rejects a transaction in a freeze window
+func (app *KVStoreApplication) checkFrozen() error {
+	w, ok := app.frozenWindow(app.blockTime)
+	if !ok {
+		return nil
+	}
+	return reject(FROZEN, fmt.Sprintf("the state is frozen until %s", w.End.UTC().Format(time.RFC3339)))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestFreezeWindows(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	app := newTestApp(t, WithFreezeWindows(FreezeWindow{Start: base.Add(time.Hour), End: base.Add(2 * time.Hour)}))
+
+	if res := deliverBlockAt(app, 1, base, "a=1"); res[0].Code != VALID_TX {
+		t.Errorf("a write before the window got code %d: %s", res[0].Code, res[0].Log)
+	}
+	// from the start of the window every write is rejected
+	for i, at := range []time.Time{base.Add(time.Hour), base.Add(2*time.Hour - time.Second)} {
+		for _, r := range deliverBlockAt(app, int64(i+2), at, "b=1", "delprefix:a") {
+			if r.Code != FROZEN {
+				t.Errorf("a write at %s got code %d, want FROZEN", at.Format(time.Kitchen), r.Code)
+			}
+		}
+	}
+	// CheckTx goes by the last block's time
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("b=1")}); r.Code != FROZEN {
+		t.Errorf("CheckTx in the window got code %d", r.Code)
+	}
+	// reads are still answered
+	if value, _, _ := app.get([]byte("a")); string(value) != "1" {
+		t.Errorf("a is %q in the window", value)
+	}
+
+	// and at its end writes go through again
+	if res := deliverBlockAt(app, 4, base.Add(2*time.Hour), "b=1"); res[0].Code != VALID_TX {
+		t.Errorf("a write at the end of the window got code %d: %s", res[0].Code, res[0].Log)
+	}
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("c=1")}); r.Code != VALID_TX {
+		t.Errorf("CheckTx after the window got code %d", r.Code)
+	}
+}
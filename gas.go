@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+)
+
+// With a gas schedule (WithGasSchedule) every transaction is priced by what
+// it runs, a 'key=value' write costs the schedule's Set, each line of a
+// batch too, and an op its own cost, or Set if it doesn't have one, each cost
+// is a base plus a price per byte of the transaction
+//
+// the gas is the transaction's GasWanted in CheckTx, and both its GasWanted
+// and GasUsed in DeliverTx, it's known before the transaction runs, so the
+// two are always the same, and a rejected transaction is priced at 1 like
+// without a schedule, a transaction that costs more than Max is rejected with
+// OUT_OF_GAS
+// the gas is part of the block results tendermint hashes, like the
+// response codes, so every node has to run with the same schedule
+// without one every transaction wants 1 gas and reports none used, as it
+// always has
+
+// GasCost is the cost of a transaction, Base plus PerByte for every byte
+type GasCost struct {
+	Base    int64 `json:"base"`
+	PerByte int64 `json:"per_byte,omitempty"`
+}
+
+// GasSchedule is what each kind of transaction costs
+type GasSchedule struct {
+	// Set is the cost of a 'key=value' write, and of every line of a
+	// batch, the per byte price is charged once for the whole batch
+	Set GasCost `json:"set"`
+	// Ops is the cost of each op by name, mset is priced as the batch
+	// it's short for
+	Ops map[string]GasCost `json:"ops,omitempty"`
+	// Max rejects a transaction that costs more, 0 is no limit
+	Max int64 `json:"max,omitempty"`
+}
+
+// DefaultGasSchedule charges 1 for a write and 100 for a delprefix, which
+// can remove up to WithMaxPrefixDelete keys at once
+var DefaultGasSchedule = GasSchedule{
+	Set: GasCost{Base: 1},
+	Ops: map[string]GasCost{"delprefix": {Base: 100}},
+}
+
+// txGas returns the gas of a parsed transaction, tx is its raw bytes
+func (g *GasSchedule) txGas(tx []byte, t transaction) int64 {
+	cost, n := g.Set, int64(1)
+	switch {
+	case t.op != nil:
+		if c, ok := g.Ops[t.op.name]; ok {
+			cost = c
+		}
+	case t.batch != nil:
+		n = int64(len(t.batch))
+	}
+	return n*cost.Base + cost.PerByte*int64(len(tx))
+}
+
+// checkGas rejects a transaction that costs more than the schedule's Max
+func (app *KVStoreApplication) checkGas(tx []byte, t transaction) error {
+	if app.gas == nil || app.gas.Max <= 0 {
+		return nil
+	}
+	if gas := app.gas.txGas(tx, t); gas > app.gas.Max {
+		return reject(OUT_OF_GAS, fmt.Sprintf("transaction costs %d gas, over the limit of %d", gas, app.gas.Max))
+	}
+	return nil
+}
+
+// gasWanted returns the GasWanted of a valid transaction
+func (app *KVStoreApplication) gasWanted(tx []byte, t transaction) int64 {
+	if app.gas == nil {
+		return 1
+	}
+	return app.gas.txGas(tx, t)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestGasSchedule(t *testing.T) {
+	g := DefaultGasSchedule
+	g.Set.PerByte = 1
+	g.Ops = map[string]GasCost{"delprefix": {Base: 100}, "swap": {Base: 10, PerByte: 2}}
+	g.Max = 150
+	app := newTestApp(t, WithGasSchedule(g))
+	deliverBlock(app, 1, "a=1", "b=2", "x/1=1", "x/2=2")
+
+	batch := string(EncodeBatch([]byte("c=1"), []byte("d=2")))
+	for tx, want := range map[string]int64{
+		"c=1":         1 + 3,
+		batch:         2*1 + int64(len(batch)),
+		"swap:a:b":    10 + 2*8,
+		"delprefix:x": 100,
+		// rejected, so priced at 1
+		"a=1": 1,
+	} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}); r.GasWanted != want {
+			t.Errorf("CheckTx %q wants %d gas, want %d", tx, r.GasWanted, want)
+		}
+	}
+
+	// a prefix delete is priced over a single set in DeliverTx too, where
+	// the gas used is the gas wanted
+	res := deliverBlock(app, 2, "c=1", "delprefix:x")
+	if res[0].GasUsed != 4 || res[1].GasUsed != 100 || res[1].GasUsed <= res[0].GasUsed {
+		t.Errorf("set used %d gas and delprefix %d", res[0].GasUsed, res[1].GasUsed)
+	}
+	for _, r := range res {
+		if r.Code != VALID_TX || r.GasWanted != r.GasUsed {
+			t.Errorf("got code %d with %d gas wanted and %d used", r.Code, r.GasWanted, r.GasUsed)
+		}
+	}
+
+	// over the cap
+	big := "k=" + strings.Repeat("x", 200)
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(big)}); r.Code != OUT_OF_GAS {
+		t.Errorf("a 202 byte write got code %d, want OUT_OF_GAS", r.Code)
+	}
+	if r := deliverBlock(app, 3, big)[0]; r.Code != OUT_OF_GAS {
+		t.Errorf("DeliverTx of a 202 byte write got code %d, want OUT_OF_GAS", r.Code)
+	}
+
+	// without a schedule everything wants 1
+	plain := newTestApp(t)
+	deliverBlock(plain, 1, "x/1=1")
+	for _, tx := range []string{"a=1", "delprefix:x"} {
+		if r := plain.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}); r.GasWanted != 1 {
+			t.Errorf("%s wants %d gas without a schedule", tx, r.GasWanted)
+		}
+	}
+}
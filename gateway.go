@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The HTTP gateway serves the store to clients that don't speak
+// tendermint's RPC, e.g. browsers
+//
+//	GET /key?key=<key>             the committed value of the key, with an
+//	                               ETag with WithGatewayETags, see
+//	                               gatewaycache.go
+//	GET /stream[?prefix=<prefix>]  committed changes as server-sent events
+//	GET /watch?key=<key>[&since=<height>][&timeout=<duration>]
+//	                               waits for a change to the key, see watch.go
+//	GET /metrics                   prometheus metrics, only with WithMetrics
+//	GET /debug[/goroutines]        block and runtime state, only with
+//	                               WithDiagnostics, see diagnostics.go
+//
+// it reads from the app directly, so it only makes sense on a node that
+// runs the app, and it doesn't go through consensus, so nothing served
+// from here comes with a proof
+
+// Gateway is the http.Handler for the HTTP gateway
+type Gateway struct {
+	app *KVStoreApplication
+	mux *http.ServeMux
+}
+
+func NewGateway(app *KVStoreApplication) *Gateway {
+	g := &Gateway{app: app, mux: http.NewServeMux()}
+	g.mux.HandleFunc("/key", g.key)
+	g.mux.HandleFunc("/stream", g.stream)
+	g.mux.HandleFunc("/watch", g.watch)
+	if app.metricsRegistry != nil {
+		g.mux.Handle("/metrics", promhttp.HandlerFor(app.metricsRegistry, promhttp.HandlerOpts{}))
+	}
+	if app.diag != nil {
+		g.mux.HandleFunc("/debug", g.debug)
+		g.mux.HandleFunc("/debug/goroutines", g.debugGoroutines)
+	}
+	return g
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// key responds with the committed value of a key as is, its type in the
+// X-Kvstore-Type header, 404 if it doesn't exist
+func (g *Gateway) key(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := g.app.normalizeKey([]byte(r.URL.Query().Get("key")))
+	if len(key) == 0 {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+
+	var value []byte
+	var ct contentType
+	var exists bool
+	var modified int64
+	var indexed bool
+	err := g.app.store.View(func(txn Txn) error {
+		if isInternalKey(key) {
+			return nil
+		}
+		v, t, ok, err := lookup(txn, key)
+		if err != nil || !ok {
+			return err
+		}
+		value, ct, exists = append([]byte{}, v...), t, true
+		modified, indexed, err = readModified(txn, key)
+		return err
+	})
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case !exists:
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+
+	if g.app.gatewayETags && g.app.serveCached(w, r, valueETag(modified, indexed, ct, value)) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Kvstore-Type", ct.String())
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(value)
+}
+
+// streamEvent is the data of a change event
+type streamEvent struct {
+	Height  int64  `json:"height"`
+	Key     string `json:"key"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Type    string `json:"type,omitempty"`
+}
+
+// stream sends every committed change, optionally only those under a
+// prefix, as a 'change' event, the stream ends with a 'dropped' event if
+// the client fell too far behind, see Subscribe
+func (g *Gateway) stream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	prefix := g.app.normalizeKey([]byte(r.URL.Query().Get("prefix")))
+
+	changes, cancel := g.app.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case c, ok := <-changes:
+			if !ok {
+				fmt.Fprint(w, "event: dropped\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			if !bytes.HasPrefix(c.Key, prefix) {
+				continue
+			}
+			data, err := json.Marshal(streamEvent{Height: c.Height, Key: string(c.Key), Deleted: c.Deleted, Value: string(c.Value), Type: c.Type})
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: change\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+const (
+	defaultWatchTimeout = 30 * time.Second
+	maxWatchTimeout     = 5 * time.Minute
+)
+
+// watch long-polls for a change to a key written after since, 0 if unset,
+// it responds with the change as JSON, like a stream event, or with 204 No
+// Content if there's none before the timeout, 30s by default
+func (g *Gateway) watch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	key := q.Get("key")
+	if key == "" {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+	var since int64
+	if v := q.Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseInt(v, 10, 64); err != nil || since < 0 {
+			http.Error(w, "since must be a height", http.StatusBadRequest)
+			return
+		}
+	}
+	timeout := defaultWatchTimeout
+	if v := q.Get("timeout"); v != "" {
+		var err error
+		if timeout, err = time.ParseDuration(v); err != nil || timeout <= 0 || timeout > maxWatchTimeout {
+			http.Error(w, fmt.Sprintf("timeout must be a duration of up to %s", maxWatchTimeout), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	c, changed, err := g.app.WatchKey(ctx, []byte(key), since)
+	switch {
+	case err == ErrTooManyWatchers:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err == errWatchNoModIndex:
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case !changed:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(streamEvent{Height: c.Height, Key: string(c.Key), Deleted: c.Deleted, Value: string(c.Value), Type: c.Type})
+}
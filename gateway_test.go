@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// readEvent reads the next server-sent event off the stream
+func readEvent(t *testing.T, rd *bufio.Reader) (event, data string) {
+	t.Helper()
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestGatewayStream(t *testing.T) {
+	app := newTestApp(t)
+	srv := httptest.NewServer(NewGateway(app))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stream?prefix=u/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("got content type %q", ct)
+	}
+	deliverBlock(app, 1, "x=1", "u/a=1;type=int")
+	deliverBlock(app, 2, "delprefix:u/")
+
+	rd := bufio.NewReader(resp.Body)
+	for _, want := range []string{
+		`{"height":1,"key":"u/a","value":"1","type":"int"}`,
+		`{"height":2,"key":"u/a","deleted":true}`,
+	} {
+		if event, data := readEvent(t, rd); event != "change" || data != want {
+			t.Errorf("got a %q event with %s, want a change with %s", event, data, want)
+		}
+	}
+
+	// disconnecting unsubscribes
+	resp.Body.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for app.subscriptions.active() {
+		if time.Now().After(deadline) {
+			t.Fatal("the stream is still subscribed after the client went away")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGatewayStreamDropped(t *testing.T) {
+	app := newTestApp(t)
+	srv := httptest.NewServer(NewGateway(app))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// drop the subscriber the way publish does when its buffer is full
+	s := app.subscriptions
+	s.mtx.Lock()
+	for id, sub := range s.subs {
+		delete(s.subs, id)
+		close(sub.ch)
+	}
+	s.mtx.Unlock()
+
+	if event, _ := readEvent(t, bufio.NewReader(resp.Body)); event != "dropped" {
+		t.Errorf("a dropped client got a %q event", event)
+	}
+}
+
+func TestGatewayMetrics(t *testing.T) {
+	app := newTestApp(t, WithMetrics(prometheus.NewRegistry()))
+	deliverBlock(app, 1, "a=1", "bad")
+	srv := httptest.NewServer(NewGateway(app))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"kvstore_app_blocks_total 1",
+		"kvstore_app_height 1",
+		"kvstore_app_keys 1",
+		`kvstore_app_txs_total{code="0"} 1`,
+		`kvstore_app_txs_total{code="1"} 1`,
+	} {
+		if !strings.Contains(string(body), "\n"+want+"\n") {
+			t.Errorf("/metrics is missing %q", want)
+		}
+	}
+
+	// without metrics there is no endpoint
+	plain := httptest.NewServer(NewGateway(newTestApp(t)))
+	defer plain.Close()
+	resp, err = http.Get(plain.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("/metrics without metrics got status %d", resp.StatusCode)
+	}
+}
+
+func TestGatewayETags(t *testing.T) {
+	for _, modIndex := range []bool{false, true} {
+		app := newTestApp(t, WithGatewayETags(true, 0), WithModIndex(modIndex))
+		g := NewGateway(app)
+		get := func(key, etag string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodGet, "/key?key="+key, nil)
+			if etag != "" {
+				r.Header.Set("If-None-Match", etag)
+			}
+			w := httptest.NewRecorder()
+			g.ServeHTTP(w, r)
+			return w
+		}
+		deliverBlock(app, 1, "a=1")
+
+		w := get("a", "")
+		etag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || w.Body.String() != "1" || etag == "" || w.Header().Get("Cache-Control") != "no-cache" {
+			t.Fatalf("mod index %v: got %d %q with ETag %q", modIndex, w.Code, w.Body.String(), etag)
+		}
+		// unchanged, even after another key's written
+		deliverBlock(app, 2)
+		deliverBlock(app, 3, "b=1")
+		if w := get("a", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("mod index %v: unchanged got %d", modIndex, w.Code)
+		}
+		if w := get("a", `"other", W/`+etag); w.Code != http.StatusNotModified {
+			t.Errorf("mod index %v: a weak match in a list got %d", modIndex, w.Code)
+		}
+
+		// a write gives a new ETag
+		deliverBlock(app, 4, "a=2")
+		w = get("a", etag)
+		if w.Code != http.StatusOK || w.Body.String() != "2" || w.Header().Get("ETag") == etag {
+			t.Errorf("mod index %v: after a write got %d %q with ETag %q", modIndex, w.Code, w.Body.String(), w.Header().Get("ETag"))
+		}
+		// writing the old value back only gives the old ETag without the
+		// modification index
+		deliverBlock(app, 5, "a=1")
+		want := http.StatusNotModified
+		if modIndex {
+			want = http.StatusOK
+		}
+		if w := get("a", etag); w.Code != want {
+			t.Errorf("mod index %v: the old value back got %d, want %d", modIndex, w.Code, want)
+		}
+		if w := get("missing", ""); w.Code != http.StatusNotFound {
+			t.Errorf("mod index %v: a missing key got %d", modIndex, w.Code)
+		}
+	}
+
+	app := newTestApp(t, WithGatewayETags(true, time.Minute))
+	deliverBlock(app, 1, "a=1")
+	w := httptest.NewRecorder()
+	NewGateway(app).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/key?key=a", nil))
+	if cc := w.Header().Get("Cache-Control"); cc != "max-age=60" {
+		t.Errorf("a max age of a minute got Cache-Control %q", cc)
+	}
+	// off by default
+	app = newTestApp(t)
+	deliverBlock(app, 1, "a=1")
+	w = httptest.NewRecorder()
+	NewGateway(app).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/key?key=a", nil))
+	if etag := w.Header().Get("ETag"); w.Code != http.StatusOK || etag != "" {
+		t.Errorf("without ETags got %d with ETag %q", w.Code, etag)
+	}
+}
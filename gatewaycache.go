@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With gateway ETags (WithGatewayETags) a /key response has an ETag, so a
+// client that polls a key, e.g. a dashboard, can send it back in
+// If-None-Match and get a 304 Not Modified without the value while the key
+// hasn't changed
+//
+//	ETag: "<modified height>-<hash>"
+//
+// the hash is of the value and its type, with the modification index, see
+// modified.go, the height is the one the key was last written at, so every
+// write gives the key a new ETag, without it the ETag is "-<hash>" and a
+// write of the value the key already had keeps the ETag it had
+// the response is cached for maxAge with Cache-Control, 0 has the client
+// check with the ETag every time, the ETag only depends on the key, not on
+// the height the node is at, so it's the same from any node behind a load
+// balancer that has the key as it is
+
+// valueETag returns the ETag of a value last written at height modified,
+// indexed is false if the height isn't known
+func valueETag(modified int64, indexed bool, ct contentType, value []byte) string {
+	h := sha256.New()
+	h.Write([]byte{byte(ct)})
+	h.Write(value)
+	height := ""
+	if indexed {
+		height = strconv.FormatInt(modified, 10)
+	}
+	return fmt.Sprintf(`"%s-%s"`, height, hex.EncodeToString(h.Sum(nil)[:16]))
+}
+
+// etagMatches returns true if the If-None-Match header matches etag, the
+// comparison is weak, as RFC 7232 has it for If-None-Match
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// serveCached sets the caching headers of a response with the ETag, returns
+// true if it's responded with 304 Not Modified, in which case the response
+// is done
+func (app *KVStoreApplication) serveCached(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if app.gatewayMaxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int64(app.gatewayMaxAge/time.Second)))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// The genesis app_state seeds the store before the first block, it's a
+// JSON array of entries e.g. [{"key": "a", "value": "1", "type": "int"}]
+// the type is optional and defaults to bytes, entries are written in the
+// order they are listed, so a key listed twice ends up with the last value
+// with WithGenesisFiles it can be a reference to a file of entries instead,
+// see genesisfile.go
+//
+// The genesis entries are hashed like the changes of a block, on top of an
+// empty app hash, so every node that loads the same genesis starts from the
+// same root, which is returned to tendermint as the genesis app hash
+//
+// Tendermint calls InitChain again whenever the app reports height 0, e.g.
+// after a crash before the first block, or after its own data was reset
+// without the app's, seeding a store that already has keys or blocks would
+// write the genesis on top of them, so by default it's skipped, with the
+// store's app hash returned as is, unless WithForceGenesis is set
+
+// genesisEntry is a single key in the genesis app_state
+type genesisEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Type  string `json:"type,omitempty"`
+}
+
+// InitChain loads the genesis app_state, if there is one, and returns the
+// resulting app hash, otherwise the chain starts from an empty store
+// an invalid genesis halts the node, it can't start from a state the rest
+// of the network may not agree on
+func (app *KVStoreApplication) InitChain(req abcitypes.RequestInitChain) abcitypes.ResponseInitChain {
+	app.refuseOnReplica("InitChain")
+	app.advancePhase("InitChain", phaseIdle, phaseIdle)
+	if len(req.AppStateBytes) == 0 {
+		return abcitypes.ResponseInitChain{}
+	}
+	var load func() error
+	if isGenesisFileRef(req.AppStateBytes) {
+		f, err := app.openGenesisFile(req.AppStateBytes)
+		if err != nil {
+			halt("InitChain", err)
+		}
+		defer f.Close()
+		load = func() error { return app.loadGenesisFile(f) }
+	} else {
+		var entries []genesisEntry
+		if err := json.Unmarshal(req.AppStateBytes, &entries); err != nil {
+			halt("InitChain", fmt.Errorf("invalid genesis app_state: %w", err))
+		}
+		load = func() error { return app.loadGenesis(entries) }
+	}
+	if app.hasState() && !app.forceGenesis {
+		app.logger.Info("skipping genesis, the store already has state", "height", app.committed.Height, "keys", app.committed.KeyCount)
+		return abcitypes.ResponseInitChain{AppHash: app.committed.AppHash}
+	}
+	// genesis entries are written at the genesis time
+	app.blockTime = req.Time
+	if err := load(); err != nil {
+		halt("InitChain", err)
+	}
+	app.logger.Info("loaded genesis", "keys", app.committed.KeyCount, "app_hash", fmt.Sprintf("%X", app.committed.AppHash))
+	return abcitypes.ResponseInitChain{AppHash: app.committed.AppHash}
+}
+
+// loadGenesis writes the genesis entries and commits them as height 0
+func (app *KVStoreApplication) loadGenesis(entries []genesisEntry) error {
+	return app.commitOutsideBlock(true, func() error {
+		for i, e := range entries {
+			if err := app.setGenesisEntry(i, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// setGenesisEntry writes the i'th genesis entry
+func (app *KVStoreApplication) setGenesisEntry(i int, e genesisEntry) error {
+	if err := app.setChecked([]byte(e.Key), []byte(e.Value), e.Type); err != nil {
+		if r, ok := asRejection(err); ok {
+			return fmt.Errorf("invalid genesis entry %d: %s", i, r.log)
+		}
+		return err
+	}
+	return nil
+}
+
+// hasState returns whether the store has been written to, by a genesis with
+// keys in it or by a block
+func (app *KVStoreApplication) hasState() bool {
+	return app.committed.Height > 0 || app.committed.KeyCount > 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestGenesisAppHash(t *testing.T) {
+	genesis := []byte(`[{"key": "a", "value": "1", "type": "int"}, {"key": "b", "value": "x"}]`)
+	for _, merkle := range []bool{false, true} {
+		// two nodes, one on badger, loading the same genesis
+		var hashes [][]byte
+		for _, app := range []*KVStoreApplication{
+			newTestApp(t, WithMerkleAppHash(merkle)),
+			NewKVStoreApplication(testDB(t), WithMerkleAppHash(merkle)),
+		} {
+			res := app.InitChain(abcitypes.RequestInitChain{AppStateBytes: genesis})
+			if len(res.AppHash) == 0 {
+				t.Fatal("InitChain returned no app hash for the genesis")
+			}
+			if info := app.Info(abcitypes.RequestInfo{}); info.LastBlockHeight != 0 || !bytes.Equal(info.LastBlockAppHash, res.AppHash) {
+				t.Fatalf("Info reported height %d and hash %x, want 0 and %x", info.LastBlockHeight, info.LastBlockAppHash, res.AppHash)
+			}
+			if value, ct := typedValue(t, app, "a"); value != "1" || ct != typeInt {
+				t.Fatalf("a is %q (%s) after the genesis", value, ct)
+			}
+			hashes = append(hashes, res.AppHash)
+		}
+		if !bytes.Equal(hashes[0], hashes[1]) {
+			t.Errorf("merkle %v: the nodes got genesis hashes %x and %x", merkle, hashes[0], hashes[1])
+		}
+	}
+
+	other := newTestApp(t).InitChain(abcitypes.RequestInitChain{AppStateBytes: []byte(`[{"key": "a", "value": "2"}]`)})
+	same := newTestApp(t).InitChain(abcitypes.RequestInitChain{AppStateBytes: genesis})
+	if bytes.Equal(other.AppHash, same.AppHash) {
+		t.Error("a different genesis got the same app hash")
+	}
+	if res := newTestApp(t).InitChain(abcitypes.RequestInitChain{}); res.AppHash != nil {
+		t.Errorf("an empty genesis returned app hash %x", res.AppHash)
+	}
+}
+
+func TestInitChainTwice(t *testing.T) {
+	first := []byte(`[{"key": "a", "value": "1"}]`)
+	second := []byte(`[{"key": "a", "value": "2"}, {"key": "b", "value": "1"}]`)
+
+	// the second genesis is skipped, the app hash is the first one's
+	app := newTestApp(t)
+	r1 := app.InitChain(abcitypes.RequestInitChain{AppStateBytes: first})
+	r2 := app.InitChain(abcitypes.RequestInitChain{AppStateBytes: second})
+	if !bytes.Equal(r1.AppHash, r2.AppHash) {
+		t.Errorf("second InitChain returned app hash %X, want %X", r2.AppHash, r1.AppHash)
+	}
+	if value, _, _ := app.get([]byte("a")); string(value) != "1" || app.committed.KeyCount != 1 {
+		t.Errorf("a is %q with %d keys after the second InitChain", value, app.committed.KeyCount)
+	}
+	// and so is one on a store with blocks but no keys
+	app = newTestApp(t)
+	deliverBlock(app, 1)
+	if r := app.InitChain(abcitypes.RequestInitChain{AppStateBytes: first}); app.committed.KeyCount != 0 || !bytes.Equal(r.AppHash, app.committed.AppHash) {
+		t.Errorf("InitChain after a block wrote %d keys", app.committed.KeyCount)
+	}
+
+	// forced, the second genesis is written on top of the first
+	app = newTestApp(t, WithForceGenesis(true))
+	app.InitChain(abcitypes.RequestInitChain{AppStateBytes: first})
+	r2 = app.InitChain(abcitypes.RequestInitChain{AppStateBytes: second})
+	if value, _, _ := app.get([]byte("a")); string(value) != "2" || app.committed.KeyCount != 2 {
+		t.Errorf("a is %q with %d keys after a forced InitChain", value, app.committed.KeyCount)
+	}
+	if bytes.Equal(r2.AppHash, r1.AppHash) {
+		t.Errorf("forced InitChain returned the first genesis's app hash %X", r2.AppHash)
+	}
+}
+
+func TestGenesisFile(t *testing.T) {
+	dir := t.TempDir()
+	entries := "{\"key\": \"a\", \"value\": \"1\", \"type\": \"int\"}\n{\"key\": \"b\", \"value\": \"x\"}\n\n"
+	if err := os.WriteFile(filepath.Join(dir, "state.ndjson"), []byte(entries), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(entries))
+	initChain := func(app *KVStoreApplication, state string) abcitypes.ResponseInitChain {
+		return app.InitChain(abcitypes.RequestInitChain{AppStateBytes: []byte(state)})
+	}
+
+	// the file gets the same state, and app hash, as the entries inline
+	inline := initChain(newTestApp(t), `[{"key": "a", "value": "1", "type": "int"}, {"key": "b", "value": "x"}]`)
+	for _, c := range []struct {
+		dir, state string
+	}{
+		{dir, `{"file": "state.ndjson", "sha256": "` + hex.EncodeToString(sum[:]) + `"}`},
+		{dir, `{"file": "state.ndjson"}`},
+		// an absolute path or URI isn't resolved against the directory
+		{"/nonexistent", `{"file": "file://` + filepath.Join(dir, "state.ndjson") + `"}`},
+		{"/nonexistent", `{"file": "` + filepath.Join(dir, "state.ndjson") + `"}`},
+	} {
+		app := newTestApp(t, WithGenesisFiles(c.dir))
+		if res := initChain(app, c.state); !bytes.Equal(res.AppHash, inline.AppHash) {
+			t.Errorf("%s got app hash %X, want the inline genesis's %X", c.state, res.AppHash, inline.AppHash)
+		}
+		if value, ct := typedValue(t, app, "a"); value != "1" || ct != typeInt {
+			t.Errorf("%s: a is %q (%s)", c.state, value, ct)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "bad.ndjson"), []byte("{\"key\": \"a\", \"value\": \"1\"}\nnope\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		opts  []Option
+		state string
+	}{
+		{[]Option{WithGenesisFiles(dir)}, `{"file": "missing.ndjson"}`},
+		{[]Option{WithGenesisFiles(dir)}, `{"file": "state.ndjson", "sha256": "` + strings.Repeat("00", 32) + `"}`},
+		{[]Option{WithGenesisFiles(dir)}, `{"file": "bad.ndjson"}`},
+		// a file reference needs the option
+		{nil, `{"file": "state.ndjson"}`},
+	} {
+		app := newTestApp(t, c.opts...)
+		if msg := halts(func() { initChain(app, c.state) }); msg == "" {
+			t.Errorf("%s didn't halt", c.state)
+		}
+		// nothing from a bad file is written
+		if _, exists, _ := app.get([]byte("a")); exists {
+			t.Errorf("%s wrote a before halting", c.state)
+		}
+	}
+}
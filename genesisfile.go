@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// With WithGenesisFiles the genesis app_state can refer to a file of entries
+// rather than list them, so a large genesis doesn't have to be embedded in
+// the genesis doc, the app_state is then an object
+//
+//	{"file": "state.ndjson", "sha256": "<hex>"}
+//
+// file is a path, or a file:// URI, a relative path is resolved against the
+// directory given to WithGenesisFiles, it's read as JSON lines, one entry
+// per line, in the same format as an inline entry
+//
+//	{"key": "a", "value": "1", "type": "int"}
+//
+// and streamed into the store, rather than read whole, sha256 is optional,
+// if it's set the file has to hash to it, every node has to load the same
+// entries to agree on the genesis app hash, and the file, unlike the inline
+// entries, isn't covered by the genesis doc's own hash
+// a missing file, a file that doesn't hash to sha256 or an invalid entry
+// halts the node, like an invalid inline genesis, the entries are still
+// committed as a single badger transaction, so they have to fit within
+// badger's transaction size limit
+
+// genesisFileRef is the app_state referring to a genesis file
+type genesisFileRef struct {
+	File   string `json:"file"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// genesisFile is an open genesis file, it checks its hash once it's read
+type genesisFile struct {
+	*os.File
+	path     string
+	expected []byte
+	hash     hash.Hash
+}
+
+// isGenesisFileRef returns true if the app_state is an object, i.e. a file
+// reference rather than a list of entries
+func isGenesisFileRef(appState []byte) bool {
+	trimmed := bytes.TrimLeft(appState, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+// openGenesisFile opens the genesis file the app_state refers to
+func (app *KVStoreApplication) openGenesisFile(appState []byte) (*genesisFile, error) {
+	if app.genesisDir == "" {
+		return nil, errors.New("genesis app_state refers to a file, but genesis files aren't enabled, see WithGenesisFiles")
+	}
+	var ref genesisFileRef
+	if err := json.Unmarshal(appState, &ref); err != nil {
+		return nil, fmt.Errorf("invalid genesis app_state: %w", err)
+	}
+	path := strings.TrimPrefix(ref.File, "file://")
+	if path == "" {
+		return nil, errors.New("invalid genesis app_state: file can't be empty")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(app.genesisDir, path)
+	}
+	var expected []byte
+	if ref.SHA256 != "" {
+		var err error
+		if expected, err = hex.DecodeString(ref.SHA256); err != nil || len(expected) != sha256.Size {
+			return nil, errors.New("invalid genesis app_state: sha256 must be a hex encoded sha256 hash")
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open the genesis file: %w", err)
+	}
+	return &genesisFile{File: f, path: path, expected: expected, hash: sha256.New()}, nil
+}
+
+// loadGenesisFile streams the entries of a genesis file into the store and
+// commits them as height 0, nothing is committed unless the whole file is
+// valid
+func (app *KVStoreApplication) loadGenesisFile(f *genesisFile) error {
+	return app.commitOutsideBlock(true, func() error {
+		dec := json.NewDecoder(io.TeeReader(f, f.hash))
+		for i := 0; ; i++ {
+			var e genesisEntry
+			err := dec.Decode(&e)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("invalid genesis entry %d in %s: %w", i, f.path, err)
+			}
+			if err := app.setGenesisEntry(i, e); err != nil {
+				return err
+			}
+		}
+		if f.expected != nil && !bytes.Equal(f.hash.Sum(nil), f.expected) {
+			return fmt.Errorf("genesis file %s doesn't match its sha256", f.path)
+		}
+		return nil
+	})
+}
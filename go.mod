@@ -3,6 +3,10 @@ module github.com/iammadab/kvstore
 go 1.16
 
 require (
-	github.com/dgraph-io/badger v1.6.2 // indirect
+	github.com/dgraph-io/badger v1.6.2
+	github.com/prometheus/client_golang v1.8.0
 	github.com/tendermint/tendermint v0.34.11
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	google.golang.org/protobuf v1.25.0
 )
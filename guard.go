@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A guarded batch is a batch with conditions, its writes are only applied
+// if every condition holds, otherwise it's rejected with CONDITION_FAILED
+// and nothing is written, like an etcd txn without the else branch, a
+// condition is a line of its own, anywhere in the batch
+//
+//	key==value   key exists and holds exactly value, which can be empty
+//	key=!=       key doesn't exist
+//
+// e.g. '\x00block/a=!=\nbalance/a==10\nbalance/a=5\nbalance/b=15' moves 5 from
+// a to b if a holds 10 and isn't locked, the conditions are checked against
+// the state the batch is applied to, in DeliverTx after the writes earlier
+// in the block, a guarded batch needs at least one write, a condition is
+// checked before the writes, so it sees the value a key had before the
+// batch, even if the batch writes it too
+// a line whose first '=' is followed by another '=', or that ends in '=!='
+// after a key, was never a valid line of a batch, so no batch changes
+// meaning
+
+// guard is a condition of a guarded batch
+type guard struct {
+	key   []byte
+	value []byte
+	// absent is set for a key=!= condition
+	absent bool
+}
+
+// parseGuard parses a condition line of a batch, returns false if the line
+// isn't one
+func parseGuard(line []byte) (guard, bool, error) {
+	i := bytes.IndexByte(line, '=')
+	switch {
+	case i < 0:
+		return guard{}, false, nil
+	case bytes.Equal(line[i:], []byte("=!=")):
+		g := guard{key: line[:i], absent: true}
+		if len(g.key) == 0 {
+			return g, true, reject(INVALID_FORMAT, "condition keys can't be empty")
+		}
+		return g, true, nil
+	case i+1 < len(line) && line[i+1] == '=':
+		g := guard{key: line[:i], value: line[i+2:]}
+		if len(g.key) == 0 {
+			return g, true, reject(INVALID_FORMAT, "condition keys can't be empty")
+		}
+		return g, true, nil
+	}
+	return guard{}, false, nil
+}
+
+// checkGuards checks the conditions of a guarded batch against the state
+// visible to txn
+func checkGuards(txn Txn, guards []guard) error {
+	for _, g := range guards {
+		value, _, exists, err := lookup(txn, g.key)
+		if err != nil {
+			return err
+		}
+		switch {
+		case g.absent && exists:
+			return reject(CONDITION_FAILED, fmt.Sprintf("the condition key %q exists", g.key))
+		case g.absent:
+		case !exists:
+			return reject(CONDITION_FAILED, fmt.Sprintf("the condition key %q doesn't exist", g.key))
+		case !bytes.Equal(value, g.value):
+			return reject(CONDITION_FAILED, fmt.Sprintf("the condition key %q doesn't hold the expected value", g.key))
+		}
+	}
+	return nil
+}
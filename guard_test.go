@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestGuardedBatch(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "balance/a=10", "balance/b=10", "empty=")
+	batch := func(lines ...string) string {
+		var b [][]byte
+		for _, l := range lines {
+			b = append(b, []byte(l))
+		}
+		return string(EncodeBatch(b...))
+	}
+
+	// every condition holds, the writes apply
+	move := batch("lock/a=!=", "balance/a==10", "balance/a=5", "balance/b=15", "empty==")
+	if r := deliverBlock(app, 2, move)[0]; r.Code != VALID_TX {
+		t.Fatalf("the guarded batch got code %d: %s", r.Code, r.Log)
+	}
+	for key, want := range map[string]string{"balance/a": "5", "balance/b": "15"} {
+		if value, _, _ := app.get([]byte(key)); string(value) != want {
+			t.Errorf("%s is %q, want %q", key, value, want)
+		}
+	}
+
+	// one fails, nothing is written, in CheckTx and DeliverTx
+	for _, c := range []struct {
+		tx, log string
+	}{
+		{batch("balance/a==10", "balance/a=0", "balance/b=20"), "doesn't hold the expected value"},
+		{batch("balance/a==5", "lock/a==1", "balance/a=0"), "doesn't exist"},
+		{batch("balance/a=!=", "balance/a=0"), "exists"},
+	} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(c.tx)}); r.Code != CONDITION_FAILED || !strings.Contains(r.Log, c.log) {
+			t.Errorf("CheckTx %q got code %d: %s", c.tx, r.Code, r.Log)
+		}
+		if r := deliverBlock(app, app.committed.Height+1, c.tx)[0]; r.Code != CONDITION_FAILED {
+			t.Errorf("DeliverTx %q got code %d: %s", c.tx, r.Code, r.Log)
+		}
+	}
+	if value, _, _ := app.get([]byte("balance/a")); string(value) != "5" {
+		t.Errorf("a failed guard left balance/a at %q", value)
+	}
+	if app.committed.KeyCount != 3 {
+		t.Errorf("got %d keys after the failed guards", app.committed.KeyCount)
+	}
+
+	// conditions see the state before the batch, after the block's earlier
+	// writes
+	res := deliverBlock(app, 10, "lock/a=1", batch("lock/a==1", "lock/a=2"), batch("lock/a==1", "lock/a=3"))
+	if res[1].Code != VALID_TX || res[2].Code != CONDITION_FAILED {
+		t.Errorf("guards after earlier writes got codes %d and %d", res[1].Code, res[2].Code)
+	}
+
+	for _, tx := range []string{batch("a=!="), batch("==1", "a=1"), batch("=!=", "a=1")} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}); r.Code != INVALID_FORMAT {
+			t.Errorf("%q got code %d, want INVALID_FORMAT", tx, r.Code)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+)
+
+// With the height check (WithHeightCheck) BeginBlock makes sure every block
+// is the one after the last committed one, a gap or a block that goes back
+// means the app's store and tendermint's block store don't belong together,
+// e.g. a db restored from an older backup, or tendermint pointed at another
+// chain's data, the earlier that's caught the less there is to untangle
+// the first block after the genesis isn't checked, a chain can start at any
+// initial height
+// it's off by default, tendermint's handshake already replays blocks the app
+// is missing, the check is there for setups that get around it
+
+// HeightCheckPolicy decides what BeginBlock does with an unexpected height
+type HeightCheckPolicy int
+
+const (
+	// HeightCheckOff doesn't check the height
+	HeightCheckOff HeightCheckPolicy = iota
+	// HeightCheckLog logs the block as an error and carries on
+	HeightCheckLog
+	// HeightCheckHalt halts the node
+	HeightCheckHalt
+)
+
+func (p HeightCheckPolicy) String() string {
+	switch p {
+	case HeightCheckLog:
+		return "log"
+	case HeightCheckHalt:
+		return "halt"
+	}
+	return "off"
+}
+
+// checkBlockHeight applies the HeightCheckPolicy to the height of a new block
+func (app *KVStoreApplication) checkBlockHeight(height int64) {
+	if app.heightCheck == HeightCheckOff || app.committed.Height == 0 || height == app.committed.Height+1 {
+		return
+	}
+	if app.heightCheck == HeightCheckHalt {
+		halt("BeginBlock", fmt.Errorf("block %d doesn't follow the last committed height %d", height, app.committed.Height))
+	}
+	app.logger.Error("block doesn't follow the last committed height", "height", height, "committed", app.committed.Height)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/log"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+func TestHeightCheck(t *testing.T) {
+	begin := func(app *KVStoreApplication, height int64) func() {
+		return func() { app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: height}}) }
+	}
+
+	// the first block can be at any height, the ones after it have to
+	// follow it, a gap, a block that goes back and a repeat all halt
+	for _, height := range []int64{8, 5, 6} {
+		app := newTestApp(t, WithHeightCheck(HeightCheckHalt))
+		deliverBlock(app, 5)
+		if msg := halts(begin(app, 6)); msg != "" {
+			t.Fatalf("the next block halted: %s", msg)
+		}
+		app.EndBlock(abcitypes.RequestEndBlock{Height: 6})
+		app.Commit()
+		if msg := halts(begin(app, height)); !strings.Contains(msg, "doesn't follow the last committed height 6") {
+			t.Errorf("block %d after 6 got %q, want a halt", height, msg)
+		}
+	}
+
+	// logged, the block goes ahead
+	var logs bytes.Buffer
+	app := newTestApp(t, WithHeightCheck(HeightCheckLog), WithLogger(log.NewTMLogger(&logs)))
+	deliverBlock(app, 1)
+	if msg := halts(func() { deliverBlock(app, 1) }); msg != "" || !strings.Contains(logs.String(), "doesn't follow the last committed height") {
+		t.Errorf("a repeated height with HeightCheckLog got %q, logged %q", msg, logs.String())
+	}
+
+	// and nothing's checked by default
+	app = newTestApp(t)
+	deliverBlock(app, 3)
+	if msg := halts(func() { deliverBlock(app, 10) }); msg != "" {
+		t.Errorf("a gap without the height check halted: %s", msg)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// With idempotency keys (WithIdempotencyKeys) a transaction can carry a key
+// chosen by the client, e.g. 'key=value;idempotency_key=order-1234', the
+// first transaction with a key that's applied records it, any later one
+// with the same key is delivered as a success that changes nothing, so a
+// client can retry a transaction it isn't sure made it into a block
+//
+//	idempotencyPrefix       | idempotency key                -> height it was applied at
+//	idempotencyHeightPrefix | height (8 bytes) idempotency key -> nothing, ordered by height
+//
+// only applied transactions record their key, a rejected one can be fixed
+// and retried with the same key, the records older than the retention are
+// pruned at the end of every block, after which the key can be used again
+// the retention decides what a retry does, and the record is part of the
+// state, so it's part of consensus, every node has to have the same one
+
+var (
+	idempotencyPrefix       = internalKey("idem/")
+	idempotencyHeightPrefix = internalKey("idemh/")
+)
+
+// maxIdempotencyKeySize bounds the idempotency_key option
+const maxIdempotencyKeySize = 128
+
+func idempotencyKey(key string) []byte {
+	return append(append([]byte{}, idempotencyPrefix...), key...)
+}
+
+func idempotencyHeightKey(height int64, key string) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(height))
+	return append(append(append([]byte{}, idempotencyHeightPrefix...), b[:]...), key...)
+}
+
+// alreadyApplied is returned by deliverTx for a transaction whose
+// idempotency key was already used, it isn't a rejection, the transaction
+// is delivered as valid, it just doesn't change anything
+type alreadyApplied struct {
+	key    string
+	height int64
+}
+
+func (e *alreadyApplied) Error() string {
+	return fmt.Sprintf("a transaction with idempotency key %q was already applied at height %d", e.key, e.height)
+}
+
+// rejection is what CheckTx returns instead, clients can tell the
+// transaction they're retrying is already in and stop
+func (e *alreadyApplied) rejection() *rejection {
+	r := reject(DUPLICATE_TX, e.Error())
+	r.info = INFO_ALREADY_PRESENT
+	return r
+}
+
+// readIdempotency returns the height the idempotency key was applied at as
+// seen by txn, 0 if it wasn't
+func readIdempotency(txn Txn, key string) (int64, error) {
+	item, err := txn.Get(idempotencyKey(key))
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var height int64
+	err = item.Value(func(val []byte) error {
+		height = int64(binary.BigEndian.Uint64(val))
+		return nil
+	})
+	return height, err
+}
+
+// checkIdempotency returns an *alreadyApplied if t's idempotency key was
+// already used as seen by txn
+func checkIdempotency(txn Txn, t transaction) error {
+	if t.idempotencyKey == "" {
+		return nil
+	}
+	height, err := readIdempotency(txn, t.idempotencyKey)
+	if err != nil || height == 0 {
+		return err
+	}
+	return &alreadyApplied{key: t.idempotencyKey, height: height}
+}
+
+// recordIdempotency records t's idempotency key as applied in the current block
+func (app *KVStoreApplication) recordIdempotency(t transaction) error {
+	if t.idempotencyKey == "" {
+		return nil
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(app.pending.Height))
+	if err := app.currentBatch.Set(idempotencyKey(t.idempotencyKey), b[:]); err != nil {
+		return err
+	}
+	return app.currentBatch.Set(idempotencyHeightKey(app.pending.Height, t.idempotencyKey), nil)
+}
+
+// pruneIdempotency drops the records older than the retention, it runs at
+// the end of every block
+func (app *KVStoreApplication) pruneIdempotency() error {
+	if !app.idempotencyKeys || app.idempotencyRetain <= 0 {
+		return nil
+	}
+	cutoff := app.pending.Height - app.idempotencyRetain
+	if cutoff < 1 {
+		return nil
+	}
+	end := idempotencyHeightKey(cutoff+1, "")
+
+	var stale [][]byte
+	opts := DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = idempotencyHeightPrefix
+	it := app.currentBatch.NewIterator(opts)
+	for it.Seek(idempotencyHeightPrefix); it.Valid() && bytes.Compare(it.Item().Key(), end) < 0; it.Next() {
+		stale = append(stale, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+
+	for _, key := range stale {
+		if err := app.currentBatch.Delete(key); err != nil {
+			return err
+		}
+		if err := app.currentBatch.Delete(idempotencyKey(string(key[len(idempotencyHeightPrefix)+8:]))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	app := newTestApp(t, WithIdempotencyKeys(3))
+	const tx = "a=1;idempotency_key=order-1"
+
+	// a retry after a later write, in the same block or the next, doesn't
+	// undo the later write
+	res := deliverBlock(app, 1, tx, "a=2", tx)
+	if res[0].Code != VALID_TX || res[2].Code != VALID_TX || !strings.Contains(res[2].Log, "already applied at height 1") {
+		t.Fatalf("got %+v and %+v", res[0], res[2])
+	}
+	if r := deliverBlock(app, 2, tx)[0]; r.Code != VALID_TX {
+		t.Fatalf("the retry got code %d", r.Code)
+	}
+	if value, _, _ := app.get([]byte("a")); string(value) != "2" {
+		t.Fatalf("a is %q, the transaction was applied more than once", value)
+	}
+
+	// CheckTx tells the client it's already in
+	r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)})
+	if r.Code != DUPLICATE_TX || r.Info != INFO_ALREADY_PRESENT {
+		t.Errorf("CheckTx of the retry got code %d and info %q", r.Code, r.Info)
+	}
+
+	// a rejected transaction doesn't use up its key
+	res = deliverBlock(app, 3, "n=x;type=int;idempotency_key=order-2", "n=1;type=int;idempotency_key=order-2")
+	if res[0].Code != INVALID_VALUE || res[1].Code != VALID_TX {
+		t.Fatalf("got codes %d and %d", res[0].Code, res[1].Code)
+	}
+
+	// past the retention the key can be used again
+	deliverBlock(app, 4)
+	deliverBlock(app, 5, tx)
+	if value, _, _ := app.get([]byte("a")); string(value) != "1" {
+		t.Fatalf("a is %q, the retry after the retention wasn't applied", value)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// incr:key:delta adds delta to the int at key, a key that doesn't exist
+// starts at 0, e.g. 'incr:hits:1', 'incr:stock:-3'
+// incr:key:delta:min:max also rejects the increment with OUT_OF_RANGE if the
+// result would be below min or above max, either bound can be left empty,
+// 'incr:quota/alice:1::100' counts up to 100 and never past it
+//
+// the bounds are checked against the value the increment is applied to, in
+// CheckTx that's the committed one, so two increments can both get into a
+// block, the one applied second is then rejected in DeliverTx and the
+// counter never overshoots, a rejected increment changes nothing
+
+// incrBounds are the bounds of an increment, the whole int64 range if unset
+type incrBounds struct {
+	min, max int64
+}
+
+// parseIncr parses the delta and the bounds of an increment
+func parseIncr(args [][]byte) (delta int64, b incrBounds, err error) {
+	delta, err = strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return 0, b, reject(INVALID_FORMAT, fmt.Sprintf("incr delta %q isn't an int", args[1]))
+	}
+	b = incrBounds{min: math.MinInt64, max: math.MaxInt64}
+	if len(args) == 2 {
+		return delta, b, nil
+	}
+	if len(args[2]) > 0 {
+		if b.min, err = strconv.ParseInt(string(args[2]), 10, 64); err != nil {
+			return 0, b, reject(INVALID_FORMAT, fmt.Sprintf("incr min %q isn't an int", args[2]))
+		}
+	}
+	if len(args[3]) > 0 {
+		if b.max, err = strconv.ParseInt(string(args[3]), 10, 64); err != nil {
+			return 0, b, reject(INVALID_FORMAT, fmt.Sprintf("incr max %q isn't an int", args[3]))
+		}
+	}
+	if b.min > b.max {
+		return 0, b, reject(INVALID_FORMAT, "incr min can't be above max")
+	}
+	return delta, b, nil
+}
+
+// incrResult returns the value the increment would write, as seen by txn
+func incrResult(app *KVStoreApplication, txn Txn, t transaction) (int64, error) {
+	key := t.args[0]
+	delta, b, err := parseIncr(t.args)
+	if err != nil {
+		return 0, err
+	}
+	value, ct, exists, err := lookup(txn, key)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if exists {
+		if ct != typeInt {
+			return 0, reject(INCOMPATIBLE_VALUE, fmt.Sprintf("can't increment %q, it isn't an int", key))
+		}
+		if app.appendOnly {
+			return 0, errOverwriteForbidden
+		}
+		if n, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, err
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, reject(OUT_OF_RANGE, fmt.Sprintf("incrementing %q would overflow", key))
+	}
+	n += delta
+	if n < b.min || n > b.max {
+		return 0, reject(OUT_OF_RANGE, fmt.Sprintf("incrementing %q would take it to %d, outside its bounds", key, n))
+	}
+	return n, nil
+}
+
+func init() {
+	registerOp(&txOp{
+		name:     "incr",
+		args:     4,
+		optional: 2,
+		keys:     []int{0},
+		parse: func(args [][]byte) error {
+			_, _, err := parseIncr(args)
+			return err
+		},
+		check: func(app *KVStoreApplication, txn Txn, t transaction) error {
+			_, err := incrResult(app, txn, t)
+			return err
+		},
+		apply: func(app *KVStoreApplication, t transaction) error {
+			n, err := incrResult(app, app.currentBatch, t)
+			if err != nil {
+				return err
+			}
+			return app.set(t.args[0], []byte(strconv.FormatInt(n, 10)), typeInt)
+		},
+		writes: func(app *KVStoreApplication, txn Txn, t transaction) ([]transaction, error) {
+			n, err := incrResult(app, txn, t)
+			return []transaction{{key: t.args[0], value: []byte(strconv.FormatInt(n, 10)), contentType: typeInt}}, err
+		},
+	})
+}
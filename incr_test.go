@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestIncrBounds(t *testing.T) {
+	app := newTestApp(t)
+
+	// three increments reach the max, the fourth would pass it, in CheckTx
+	// as well as in DeliverTx
+	res := deliverBlock(app, 1, "incr:q:1::3", "incr:q:1::3", "incr:q:1::3", "incr:q:1::3")
+	for i, want := range []uint32{VALID_TX, VALID_TX, VALID_TX, OUT_OF_RANGE} {
+		if res[i].Code != want {
+			t.Errorf("incr %d got code %d, want %d: %s", i, res[i].Code, want, res[i].Log)
+		}
+	}
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("incr:q:1::3")}); r.Code != OUT_OF_RANGE {
+		t.Errorf("CheckTx over the max got code %d", r.Code)
+	}
+	if value, ct := typedValue(t, app, "q"); value != "3" || ct != typeInt {
+		t.Errorf("q is %q of type %s, want 3", value, ct)
+	}
+
+	for _, c := range []struct {
+		tx    string
+		code  uint32
+		value string
+	}{
+		// a min without a max
+		{"incr:q:-6:-3:", VALID_TX, "-3"},
+		{"incr:q:-1:-3:", OUT_OF_RANGE, "-3"},
+		// min over max, or one bound without the other's ':'
+		{"incr:q:1:5:2", INVALID_FORMAT, "-3"},
+		{"incr:q:1:5", INVALID_FORMAT, "-3"},
+		{"incr:q:1:x:", INVALID_FORMAT, "-3"},
+		// the bounds are inclusive
+		{"incr:q:1:-2:-2", VALID_TX, "-2"},
+		// an overflow is out of range whatever the bounds
+		{"incr:q:9223372036854775807", VALID_TX, "9223372036854775805"},
+		{"incr:q:3", OUT_OF_RANGE, "9223372036854775805"},
+	} {
+		if r := deliverBlock(app, app.committed.Height+1, c.tx)[0]; r.Code != c.code {
+			t.Errorf("%s got code %d, want %d: %s", c.tx, r.Code, c.code, r.Log)
+		}
+		if value, _ := typedValue(t, app, "q"); value != c.value {
+			t.Errorf("after %s q is %q, want %q", c.tx, value, c.value)
+		}
+	}
+
+	deliverBlock(app, app.committed.Height+1, "s=x")
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("incr:s:1::10")}); r.Code != INCOMPATIBLE_VALUE {
+		t.Errorf("incr of a bytes value got code %d, want INCOMPATIBLE_VALUE", r.Code)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+)
+
+// init:key:value sets key to value once, for singletons and bootstrapped
+// config that must never be set up twice, e.g. 'init:config/chain:v1', the
+// key is marked as initialized and the marker outlives the key
+//
+//	initPrefix | key -> nothing
+//
+// an init of a key that exists is rejected with KEY_EXISTS, of one that's
+// been initialized before with ALREADY_INITIALIZED, even if it's been removed
+// since, so deleting a key doesn't let it be initialized again, the key can
+// still be written and removed like any other, only init looks at the marker
+// the markers are only written by init, a key that was set some other way and
+// removed before its first init can be initialized, the value is everything
+// after the key, ':' included, and is stored as bytes
+
+var initPrefix = internalKey("init/")
+
+func initKey(key []byte) []byte {
+	return append(append([]byte{}, initPrefix...), key...)
+}
+
+// initialized returns whether the key has been initialized as seen by txn
+func initialized(txn Txn, key []byte) (bool, error) {
+	_, err := txn.Get(initKey(key))
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func init() {
+	registerOp(&txOp{
+		name: "init",
+		args: 2,
+		keys: []int{0},
+		rest: true,
+		check: func(app *KVStoreApplication, txn Txn, t transaction) error {
+			key := t.args[0]
+			done, err := initialized(txn, key)
+			if err != nil {
+				return err
+			}
+			if done {
+				return reject(ALREADY_INITIALIZED, fmt.Sprintf("key %q has already been initialized", key))
+			}
+			_, _, exists, err := lookup(txn, key)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return reject(KEY_EXISTS, fmt.Sprintf("can't initialize %q, it already exists", key))
+			}
+			return nil
+		},
+		apply: func(app *KVStoreApplication, t transaction) error {
+			if err := app.set(t.args[0], t.args[1], typeBytes); err != nil {
+				return err
+			}
+			return app.currentBatch.Set(initKey(t.args[0]), nil)
+		},
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestInitOp(t *testing.T) {
+	app := newTestApp(t)
+	check := func(tx string) uint32 {
+		return app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}).Code
+	}
+
+	if res := deliverBlock(app, 1, "init:cfg:v1:x"); res[0].Code != VALID_TX {
+		t.Fatalf("the first init got code %d: %s", res[0].Code, res[0].Log)
+	}
+	if value, _, _ := app.get([]byte("cfg")); string(value) != "v1:x" {
+		t.Errorf("cfg is %q after its init", value)
+	}
+	if code := check("init:cfg:v2"); code != ALREADY_INITIALIZED {
+		t.Errorf("a second init got code %d", code)
+	}
+	// a key set some other way exists, it's not been initialized
+	deliverBlock(app, 2, "b=1")
+	if code := check("init:b:2"); code != KEY_EXISTS {
+		t.Errorf("an init of a key that exists got code %d", code)
+	}
+
+	// deleted, the key can't be initialized again, unlike setnx
+	deliverBlock(app, 3, "delprefix:cfg")
+	if _, exists, _ := app.get([]byte("cfg")); exists {
+		t.Fatal("cfg is still there after the delete")
+	}
+	if res := deliverBlock(app, 4, "init:cfg:v2"); res[0].Code != ALREADY_INITIALIZED {
+		t.Errorf("an init after the delete got code %d", res[0].Code)
+	}
+	if _, exists, _ := app.get([]byte("cfg")); exists {
+		t.Error("the rejected init recreated cfg")
+	}
+
+	// the marker is seen by the same block's later inits
+	res := deliverBlock(app, 5, "init:z:1", "init:z:2")
+	if res[0].Code != VALID_TX || res[1].Code != ALREADY_INITIALIZED {
+		t.Errorf("two inits in a block got codes %d and %d", res[0].Code, res[1].Code)
+	}
+}
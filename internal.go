@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// The "internal" query (WithInternalQuery) dumps the app's own bookkeeping,
+// the keys under internalPrefix and their raw values, both in hex, for
+// debugging e.g. a node whose app hash disagrees with the rest of the
+// network, without having to know what the keys are called
+// it's off by default, the response is the store's layout as is, not a
+// contract, and it should never be enabled on a node that's publicly
+// queryable
+// only what's in the db is read, the keys the node holds itself, like the
+// query signing key, are never written to it, so they can't be part of the
+// dump, what is there is either derived from the user entries or from the
+// transactions, e.g. the tx and change indexes
+
+const (
+	defaultInternalLimit = 100
+	maxInternalLimit     = 1000
+)
+
+type internalRequest struct {
+	// Prefix is the name the keys start with, without internalPrefix,
+	// e.g. "tx/", empty is every internal key
+	Prefix string `json:"prefix"`
+	// Start is the next_key of the previous page, empty for the first
+	Start string `json:"start"`
+	Limit int    `json:"limit"`
+}
+
+type internalEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type internalResponse struct {
+	Entries []internalEntry `json:"entries"`
+	// NextKey is the start of the next page, empty on the last page
+	NextKey string `json:"next_key,omitempty"`
+}
+
+// queryInternal pages through the internal keys, see internal.go
+// e.g. {"prefix": "state"} or {"start": <next_key>, "limit": 10}
+func (app *KVStoreApplication) queryInternal(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if !app.internalQuery {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "the internal query is disabled"
+		return
+	}
+	var ireq internalRequest
+	if len(req.Data) > 0 && !parseRequest(req, &res, &ireq) {
+		return
+	}
+	limit := clampLimit(ireq.Limit, defaultInternalLimit, maxInternalLimit)
+	prefix := internalKey(ireq.Prefix)
+	start := prefix
+	if ireq.Start != "" {
+		key, err := hex.DecodeString(ireq.Start)
+		if err != nil {
+			res.Code = QUERY_INVALID
+			res.Log = "start must be hex"
+			return
+		}
+		if bytes.Compare(key, start) > 0 {
+			start = key
+		}
+	}
+
+	ires := internalResponse{Entries: []internalEntry{}}
+	err := app.store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(start); it.Valid(); it.Next() {
+			item := it.Item()
+			if len(ires.Entries) == limit {
+				ires.NextKey = hex.EncodeToString(item.Key())
+				return nil
+			}
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			ires.Entries = append(ires.Entries, internalEntry{Key: hex.EncodeToString(item.Key()), Value: hex.EncodeToString(value)})
+		}
+		return nil
+	})
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, ires)
+	return
+}
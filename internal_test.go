@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+)
+
+func TestInternalQuery(t *testing.T) {
+	if res := newTestApp(t).Query(abcitypes.RequestQuery{Path: "internal"}); res.Code != QUERY_NOT_ALLOWED {
+		t.Errorf("internal query got code %d without WithInternalQuery", res.Code)
+	}
+
+	key := ed25519.GenPrivKey()
+	app := newTestApp(t, WithInternalQuery(true), WithTxIndex(0), WithQuerySigning(key))
+	for h := int64(1); h <= 3; h++ {
+		deliverBlock(app, h, fmt.Sprintf("k%d=v", h))
+	}
+
+	var ires internalResponse
+	queryJSON(t, app, "internal", nil, &ires)
+	keys := map[string]string{}
+	for _, e := range ires.Entries {
+		k, err := hex.DecodeString(e.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isInternalKey(k) {
+			t.Errorf("internal query returned the user key %q", k)
+		}
+		keys[string(k)] = e.Value
+		// the signing key is only held in memory
+		if strings.Contains(e.Value, hex.EncodeToString(key.Bytes()[:32])) {
+			t.Errorf("%q has the signing key in it", k)
+		}
+	}
+	want := []string{string(internalKey("format")), string(internalKey("state"))}
+	for h := 1; h <= 3; h++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("k%d=v", h)))
+		want = append(want, string(append(internalKey("tx/"), hash[:]...)))
+	}
+	for _, k := range want {
+		if _, ok := keys[k]; !ok {
+			t.Errorf("internal query is missing %q", k)
+		}
+	}
+
+	// the state is as committed
+	queryJSON(t, app, "internal", []byte(`{"prefix": "state"}`), &ires)
+	if len(ires.Entries) != 1 {
+		t.Fatalf("got %d entries under state", len(ires.Entries))
+	}
+	raw, _ := hex.DecodeString(ires.Entries[0].Value)
+	var s struct {
+		Height   int64 `json:"height"`
+		KeyCount int64 `json:"key_count"`
+	}
+	if err := json.Unmarshal(raw, &s); err != nil || s.Height != 3 || s.KeyCount != 3 {
+		t.Errorf("state is %s: %v", raw, err)
+	}
+
+	// paging gets every entry once
+	var paged []internalEntry
+	next := ""
+	for {
+		ires = internalResponse{}
+		queryJSON(t, app, "internal", []byte(fmt.Sprintf(`{"limit": 2, "start": %q}`, next)), &ires)
+		paged = append(paged, ires.Entries...)
+		if next = ires.NextKey; next == "" {
+			break
+		}
+	}
+	if len(paged) != len(keys) {
+		t.Errorf("paged through %d entries, want %d", len(paged), len(keys))
+	}
+	for i := 1; i < len(paged); i++ {
+		if bytes.Compare([]byte(paged[i-1].Key), []byte(paged[i].Key)) >= 0 {
+			t.Errorf("entry %d is out of order", i)
+		}
+	}
+}
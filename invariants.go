@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Invariant checks a property that must hold for the state after every block
+// e.g. the total of all counter keys never changes
+// it's given the current batch, so it sees the block's writes, and must only
+// read from it, a non-nil error means the invariant was violated
+type Invariant func(readTxn Txn) error
+
+// BlockPredicate decides whether a block's changes get committed at all, it's
+// given the changes delivered in the block, in order, a non-nil error
+// discards the whole block, as if none of its transactions had been applied
+// it runs in Commit, so they've already been delivered with VALID_TX
+//
+// nodes that don't run the same predicate end up with different app hashes,
+// so it's only safe on chains run by a single operator
+type BlockPredicate func(changes []Change) error
+
+type namedInvariant struct {
+	name  string
+	check Invariant
+}
+
+// checkBlockPredicate runs the block predicate, if there is one, against
+// the block's changes, the keys expiring in the block aren't included, they
+// expire whether the block is discarded or not
+func (app *KVStoreApplication) checkBlockPredicate() error {
+	if app.blockPredicate == nil || len(app.changes) == 0 {
+		return nil
+	}
+	changes := make([]Change, len(app.changes))
+	for i, c := range app.changes {
+		changes[i] = publicChange(app.pending.Height, c)
+	}
+	return app.blockPredicate(changes)
+}
+
+// checkInvariants runs every registered invariant against the current batch
+// returns the first violation found
+func (app *KVStoreApplication) checkInvariants() error {
+	for _, inv := range app.invariants {
+		if err := inv.check(app.currentBatch); err != nil {
+			return fmt.Errorf("invariant %q violated at height %d: %w", inv.name, app.pending.Height, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+func TestBlockPredicateKeepsEarlierBlocks(t *testing.T) {
+	app := newTestApp(t, WithBlockPredicate(func(changes []Change) error {
+		for _, c := range changes {
+			if string(c.Key) == "bad" {
+				return errors.New("bad key")
+			}
+		}
+		return nil
+	}))
+	deliverBlock(app, 1, "a=1")
+	deliverBlock(app, 2, "b=1", "bad=1")
+	if err := app.flush(); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{"a": true, "b": false, "bad": false} {
+		if _, exists, _ := app.get([]byte(key)); exists != want {
+			t.Errorf("%s exists is %v, want %v", key, exists, want)
+		}
+	}
+	if app.committed.Height != 2 || app.committed.KeyCount != 1 {
+		t.Errorf("committed height %d with %d keys", app.committed.Height, app.committed.KeyCount)
+	}
+}
+
+func TestBlockPredicateRefusesCommitBatching(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("a block predicate was accepted with commit batching")
+		}
+	}()
+	newTestApp(t, WithBlockPredicate(func([]Change) error { return nil }), WithCommitBatching(10, 0))
+}
+
+// noX is violated once the key x exists
+func noX(txn Txn) error {
+	if _, err := txn.Get([]byte("x")); err == nil {
+		return errors.New("x exists")
+	}
+	return nil
+}
+
+func TestInvariantViolationHalts(t *testing.T) {
+	app := newTestApp(t, WithInvariant("no-x", noX), WithInvariantHalt(true))
+	deliverBlock(app, 1, "a=1")
+	msg := halts(func() { deliverBlock(app, 2, "x=1") })
+	if !strings.Contains(msg, `invariant "no-x" violated at height 2: x exists`) {
+		t.Fatalf("got %q", msg)
+	}
+	if app.committed.Height != 1 {
+		t.Errorf("the violating block was committed at height %d", app.committed.Height)
+	}
+}
+
+func TestInvariantViolationIsLogged(t *testing.T) {
+	var logs bytes.Buffer
+	app := newTestApp(t, WithInvariant("no-x", noX), WithLogger(log.NewTMLogger(&logs)))
+	deliverBlock(app, 1, "x=1")
+	if !strings.Contains(logs.String(), "INVARIANT VIOLATION") || !strings.Contains(logs.String(), "no-x") {
+		t.Errorf("the violation wasn't logged:\n%s", logs.String())
+	}
+	if app.committed.Height != 1 {
+		t.Error("without WithInvariantHalt the block should still commit")
+	}
+}
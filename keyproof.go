@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/merkle"
+	tmcrypto "github.com/tendermint/tendermint/proto/tendermint/crypto"
+)
+
+// PROOF_OP_KEY is the proof op type of a KeyProof
+const PROOF_OP_KEY = "kvstore:key"
+
+// KeyProof proves the value of a single key, or that it doesn't exist
+// a key that exists comes with its entry and the entry's inclusion proof, one
+// that doesn't with the entries either side of where it would be, their
+// indexes being adjacent proves there's nothing in between
+type KeyProof struct {
+	Key []byte `json:"key"`
+	// Entry is nil if the key doesn't exist
+	Entry *ProofEntry `json:"entry,omitempty"`
+	// Left and Right are the neighbours of a key that doesn't exist, nil
+	// when it would start or end the tree
+	Left  *ProofEntry `json:"left,omitempty"`
+	Right *ProofEntry `json:"right,omitempty"`
+}
+
+// VerifyKeyProof checks the key proof against a merkle app hash
+// returns nil only if the proof's entry is the key's in the tree with that
+// root, or the key isn't in the tree and the proof has no entry
+func VerifyKeyProof(root []byte, p KeyProof) error {
+	if p.Entry != nil {
+		if !bytes.Equal(p.Entry.Key, p.Key) {
+			return errors.New("entry is for a different key")
+		}
+		if p.Left != nil || p.Right != nil {
+			return errors.New("proof has both an entry and neighbours")
+		}
+		return p.Entry.verify(root, p.Entry.Proof.Total)
+	}
+
+	var total int64
+	switch {
+	case p.Left != nil:
+		total = p.Left.Proof.Total
+	case p.Right != nil:
+		total = p.Right.Proof.Total
+	default:
+		if !bytes.Equal(root, merkle.HashFromByteSlices(nil)) {
+			return errors.New("proof has no entries for a non empty tree")
+		}
+		return nil
+	}
+
+	// the index the right neighbour must be at
+	next := int64(0)
+	if p.Left != nil {
+		if bytes.Compare(p.Left.Key, p.Key) >= 0 {
+			return errors.New("left neighbour doesn't sort before the key")
+		}
+		if err := p.Left.verify(root, total); err != nil {
+			return fmt.Errorf("left neighbour: %w", err)
+		}
+		next = p.Left.Proof.Index + 1
+	}
+	if p.Right == nil {
+		if next != total {
+			return errors.New("left neighbour isn't the end of the tree and there's no right neighbour")
+		}
+		return nil
+	}
+	if bytes.Compare(p.Right.Key, p.Key) <= 0 {
+		return errors.New("right neighbour doesn't sort after the key")
+	}
+	if p.Right.Proof.Index != next {
+		return errors.New("neighbours aren't adjacent")
+	}
+	if err := p.Right.verify(root, total); err != nil {
+		return fmt.Errorf("right neighbour: %w", err)
+	}
+	return nil
+}
+
+// keyProof builds the key proof for key
+func (pt *proofTree) keyProof(key []byte) (proof KeyProof) {
+	entries, proofs := pt.entries, pt.proofs
+	proofEntry := func(i int) *ProofEntry {
+		e := entries[i]
+		return &ProofEntry{Key: e.key, Value: e.value, Type: byte(e.ct), Proof: *proofs[i]}
+	}
+
+	proof.Key = key
+	i := sort.Search(len(entries), func(i int) bool {
+		return bytes.Compare(entries[i].key, key) >= 0
+	})
+	if i < len(entries) && bytes.Equal(entries[i].key, key) {
+		proof.Entry = proofEntry(i)
+		return proof
+	}
+	if i > 0 {
+		proof.Left = proofEntry(i - 1)
+	}
+	if i < len(entries) {
+		proof.Right = proofEntry(i)
+	}
+	return proof
+}
+
+// queryKeyProof looks up the key in the query data like the default path
+// and returns its value along with a KeyProof, as a single PROOF_OP_KEY
+// proof op, against the merkle app hash, so a light client gets both in
+// one round trip, a key that doesn't exist is proven absent
+// only available in merkle mode, like a range proof it reads the whole
+// store unless the proof cache has the tree
+func (app *KVStoreApplication) queryKeyProof(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	key := app.normalizeKey(req.Data)
+	// it would be proven absent, the tree doesn't have it
+	if app.isLocal(key) {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "local keys aren't part of the app hash"
+		return
+	}
+	tree, ok := app.committedProofTree(&res)
+	if !ok {
+		return
+	}
+	proof := tree.keyProof(key)
+	data, err := json.Marshal(proof)
+	if err != nil {
+		res.Code = QUERY_FAILED
+		res.Log = err.Error()
+		return
+	}
+
+	res.Key = key
+	res.Height = app.committed.Height
+	res.ProofOps = &tmcrypto.ProofOps{Ops: app.withStateProof([]tmcrypto.ProofOp{{Type: PROOF_OP_KEY, Key: key, Data: data}})}
+	if proof.Entry == nil {
+		res.Log = "does not exist"
+		return
+	}
+	res.Log = "exists"
+	res.Value = proof.Entry.Value
+	return
+}
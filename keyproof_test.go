@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// keyProof queries the value and key proof of key
+func keyProof(t *testing.T, app *KVStoreApplication, key string) (abcitypes.ResponseQuery, KeyProof) {
+	t.Helper()
+	res := app.Query(abcitypes.RequestQuery{Path: "keyproof", Data: []byte(key)})
+	if res.Code != 0 {
+		t.Fatalf("keyproof %q got code %d: %s", key, res.Code, res.Log)
+	}
+	var p KeyProof
+	if err := json.Unmarshal(res.ProofOps.Ops[0].Data, &p); err != nil {
+		t.Fatal(err)
+	}
+	return res, p
+}
+
+func TestKeyProof(t *testing.T) {
+	app := newTestApp(t, WithMerkleAppHash(true))
+	deliverBlock(app, 1, "b=1", "d=2", "f=3")
+	root := app.committed.AppHash
+
+	// the keys that exist, and the ones that don't before, between and
+	// after them
+	for key, want := range map[string]string{"a": "", "b": "1", "c": "", "d": "2", "e": "", "f": "3", "g": ""} {
+		res, p := keyProof(t, app, key)
+		if string(res.Value) != want || (p.Entry != nil) != (want != "") {
+			t.Errorf("%s: got %q with entry %v, want %q", key, res.Value, p.Entry != nil, want)
+		}
+		if err := VerifyKeyProof(root, p); err != nil {
+			t.Errorf("%s: %v", key, err)
+		}
+		if p.Entry == nil && p.Left != nil && p.Right != nil {
+			// without a neighbour the absence isn't proven
+			missing := p
+			missing.Right = nil
+			if VerifyKeyProof(root, missing) == nil {
+				t.Errorf("%s: proof without the right neighbour verified", key)
+			}
+		}
+	}
+
+	_, p := keyProof(t, app, "d")
+	p.Entry.Value = []byte("9")
+	if VerifyKeyProof(root, p) == nil {
+		t.Error("proof with a changed value verified")
+	}
+	p.Entry.Value = []byte("2")
+	p.Key = []byte("e")
+	if VerifyKeyProof(root, p) == nil {
+		t.Error("proof of d verified for e")
+	}
+
+	// every key is absent from an empty tree, and the proofs of either tree
+	// don't verify against the other's root
+	empty := newTestApp(t, WithMerkleAppHash(true))
+	deliverBlock(empty, 1)
+	if _, p := keyProof(t, empty, "x"); VerifyKeyProof(empty.committed.AppHash, p) != nil || VerifyKeyProof(root, p) == nil {
+		t.Errorf("empty tree proof %+v", p)
+	}
+	if _, p := keyProof(t, app, "c"); VerifyKeyProof(empty.committed.AppHash, p) == nil {
+		t.Error("absence proof verified against the empty tree's root")
+	}
+
+	if res := newTestApp(t).Query(abcitypes.RequestQuery{Path: "keyproof", Data: []byte("b")}); res.Code == 0 {
+		t.Error("keyproof without the merkle app hash succeeded")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+)
+
+// KeyNormalization controls how keys are rewritten before they touch the db
+// the normalized key is what gets stored and hashed, so every node on the
+// network must run with the same normalization or their state will diverge
+type KeyNormalization uint8
+
+const (
+	// NormalizeNone stores keys exactly as they were sent
+	NormalizeNone KeyNormalization = 0
+	// NormalizeFoldCase lower cases keys so 'Foo' and 'foo' are the same entry
+	NormalizeFoldCase KeyNormalization = 1 << (iota - 1)
+	// NormalizeTrimSpace removes leading and trailing whitespace from keys
+	NormalizeTrimSpace
+)
+
+// normalizeKey applies the configured normalization to a key
+// every path that reads or writes a user key has to go through here
+// otherwise writes and reads would disagree on what the key is
+func (app *KVStoreApplication) normalizeKey(key []byte) []byte {
+	if app.keyNormalization&NormalizeTrimSpace != 0 {
+		key = bytes.TrimSpace(key)
+	}
+	if app.keyNormalization&NormalizeFoldCase != 0 {
+		key = bytes.ToLower(key)
+	}
+	return key
+}
+
+// prefixEnd returns the first key that sorts after every key starting
+// with prefix, nil if there is no such key (the prefix is all 0xff)
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
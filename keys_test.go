@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestFoldCase(t *testing.T) {
+	app := newTestApp(t, WithKeyNormalization(NormalizeFoldCase|NormalizeTrimSpace))
+	res := deliverBlock(app, 1, " Foo =bar", "FOO=baz")
+	if res[0].Code != VALID_TX || res[1].Code != VALID_TX || app.committed.KeyCount != 1 {
+		t.Fatalf("got codes %d and %d with %d keys", res[0].Code, res[1].Code, app.committed.KeyCount)
+	}
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("fOo=baz")}); r.Code != DUPLICATE_TX {
+		t.Errorf("a write of the same pair under another casing got code %d", r.Code)
+	}
+	for _, key := range []string{"foo", "FOO", " Foo"} {
+		if res := app.Query(abcitypes.RequestQuery{Data: []byte(key)}); string(res.Value) != "baz" {
+			t.Errorf("%q reads %q", key, res.Value)
+		}
+	}
+}
+
+func TestNoNormalizationByDefault(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "Foo=bar", "foo=bar")
+	if app.committed.KeyCount != 2 {
+		t.Errorf("got %d keys, want 2", app.committed.KeyCount)
+	}
+	folded := newTestApp(t, WithKeyNormalization(NormalizeFoldCase))
+	deliverBlock(folded, 1, "Foo=bar", "foo=bar")
+	if bytes.Equal(app.committed.AppHash, folded.committed.AppHash) {
+		t.Error("folding doesn't change the app hash")
+	}
+}
+
+func TestUTF8Keys(t *testing.T) {
+	app := newTestApp(t, WithUTF8Keys(true))
+	for tx, want := range map[string]uint32{
+		"héllo=1":              VALID_TX,
+		"h\xffllo=1":           INVALID_KEY,
+		"swap:h\xffllo:héllo":  INVALID_KEY,
+		"\x00b" + "h\xffllo=1": INVALID_KEY,
+	} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}); r.Code != want {
+			t.Errorf("CheckTx %q got code %d, want %d", tx, r.Code, want)
+		}
+		if r := deliverBlock(app, app.committed.Height+1, tx)[0]; r.Code != want {
+			t.Errorf("DeliverTx %q got code %d, want %d", tx, r.Code, want)
+		}
+	}
+	if app.committed.KeyCount != 1 {
+		t.Errorf("got %d keys, only héllo should have been written", app.committed.KeyCount)
+	}
+
+	// binary keys are fine by default
+	app = newTestApp(t)
+	if r := deliverBlock(app, 1, "h\xffllo=1")[0]; r.Code != VALID_TX {
+		t.Errorf("a binary key got code %d without the option", r.Code)
+	}
+}
+
+func TestMaxKeyDepth(t *testing.T) {
+	app := newTestApp(t, WithMaxKeyDepth("/", 3))
+	for tx, want := range map[string]uint32{
+		"a=1":                 VALID_TX,
+		"a/b/c=1":             VALID_TX,
+		"a/b/=1":              VALID_TX,
+		"a/b/c/d=1":           KEY_TOO_DEEP,
+		"a/b/c/=1":            KEY_TOO_DEEP,
+		"swap:a:a/b/c/d":      KEY_TOO_DEEP,
+		"delprefix:a/b/c/":    KEY_TOO_DEEP,
+		"\x00bx=1\na/b/c/d=2": KEY_TOO_DEEP,
+	} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}); r.Code != want {
+			t.Errorf("CheckTx %q got code %d, want %d", tx, r.Code, want)
+		}
+		if r := deliverBlock(app, app.committed.Height+1, tx)[0]; r.Code != want {
+			t.Errorf("DeliverTx %q got code %d, want %d", tx, r.Code, want)
+		}
+	}
+	if app.committed.KeyCount != 3 {
+		t.Errorf("got %d keys, want the 3 at or under the limit", app.committed.KeyCount)
+	}
+
+	// no limit by default
+	if r := deliverBlock(newTestApp(t), 1, "a/b/c/d/e/f=1")[0]; r.Code != VALID_TX {
+		t.Errorf("a deep key without a limit got code %d", r.Code)
+	}
+}
+
+func TestEmptyKey(t *testing.T) {
+	app := newTestApp(t, WithKeyNormalization(NormalizeTrimSpace))
+	// a plain write, a batch line, an op's key, and a key normalization
+	// leaves empty
+	for _, tx := range []string{"=value", string(EncodeBatch([]byte("a=1"), []byte("=2"))), "swap::a", " =value"} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}); r.Code != INVALID_FORMAT {
+			t.Errorf("CheckTx %q got code %d, want INVALID_FORMAT", tx, r.Code)
+		}
+		if r := deliverBlock(app, app.committed.Height+1, tx)[0]; r.Code != INVALID_FORMAT {
+			t.Errorf("DeliverTx %q got code %d, want INVALID_FORMAT", tx, r.Code)
+		}
+	}
+	if app.committed.KeyCount != 0 {
+		t.Errorf("empty keys wrote %d keys", app.committed.KeyCount)
+	}
+}
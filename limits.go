@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Key limits cap how many keys the store, or a prefix of it, can hold
+// only creating a key counts against a limit, existing keys can always be
+// overwritten and removing a key frees its slot
+// the store wide count is state.KeyCount, the count for each limited prefix
+// is kept in state.PrefixKeys so it's committed along with the block
+
+// keyLimit caps the number of keys under prefix, an empty prefix is the
+// whole store
+type keyLimit struct {
+	prefix []byte
+	max    int64
+}
+
+type limitCount struct {
+	Prefix []byte `json:"prefix"`
+	Keys   int64  `json:"keys"`
+}
+
+// keysUnder returns the number of keys under a limited prefix
+func (s state) keysUnder(prefix []byte) int64 {
+	if len(prefix) == 0 {
+		return s.KeyCount
+	}
+	for _, c := range s.PrefixKeys {
+		if bytes.Equal(c.Prefix, prefix) {
+			return c.Keys
+		}
+	}
+	return 0
+}
+
+// countKey adds delta to the count of every limited prefix of key
+func (s *state) countKey(key []byte, delta int64) {
+	for i := range s.PrefixKeys {
+		if bytes.HasPrefix(key, s.PrefixKeys[i].Prefix) {
+			s.PrefixKeys[i].Keys += delta
+		}
+	}
+}
+
+// checkKeyLimits rejects creating key if it would go over a limit in s
+func (app *KVStoreApplication) checkKeyLimits(key []byte, s state) error {
+	for _, l := range app.keyLimits {
+		if bytes.HasPrefix(key, l.prefix) && s.keysUnder(l.prefix) >= l.max {
+			if len(l.prefix) == 0 {
+				return reject(KEY_LIMIT, fmt.Sprintf("the store is limited to %d keys", l.max))
+			}
+			return reject(KEY_LIMIT, fmt.Sprintf("prefix %q is limited to %d keys", l.prefix, l.max))
+		}
+	}
+	return nil
+}
+
+// countPrefixKeys returns the counts for the configured prefix limits,
+// reusing the committed counts, prefixes that weren't limited before are
+// counted from the db, counts for prefixes that are no longer limited are
+// dropped so they're counted again if the limit comes back
+func (app *KVStoreApplication) countPrefixKeys(committed []limitCount) ([]limitCount, error) {
+	var counts []limitCount
+	for _, l := range app.keyLimits {
+		if len(l.prefix) == 0 {
+			continue
+		}
+		c := limitCount{Prefix: l.prefix, Keys: -1}
+		for _, prev := range committed {
+			if bytes.Equal(prev.Prefix, l.prefix) {
+				c.Keys = prev.Keys
+			}
+		}
+		if c.Keys < 0 {
+			n, err := countKeys(app.store, l.prefix)
+			if err != nil {
+				return nil, err
+			}
+			c.Keys = n
+		}
+		counts = append(counts, c)
+	}
+	return counts, nil
+}
+
+// countKeys counts the user keys under prefix
+func countKeys(store Store, prefix []byte) (n int64, err error) {
+	err = store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.Valid(); it.Next() {
+			n++
+		}
+		return nil
+	})
+	return
+}
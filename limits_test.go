@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestKeyLimit(t *testing.T) {
+	app := newTestApp(t, WithKeyLimit(3))
+	res := deliverBlock(app, 1, "u/a=1", "u/b=1", "x=1", "u/c=1", "u/a=2")
+	for i, want := range []uint32{VALID_TX, VALID_TX, VALID_TX, KEY_LIMIT, VALID_TX} {
+		if res[i].Code != want {
+			t.Errorf("tx %d got code %d, want %d", i, res[i].Code, want)
+		}
+	}
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("z=1")}); r.Code != KEY_LIMIT {
+		t.Errorf("a new key past the limit got code %d in CheckTx", r.Code)
+	}
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("x=2")}); r.Code != VALID_TX {
+		t.Errorf("an overwrite at the limit got code %d", r.Code)
+	}
+
+	// a delete frees a slot for a key created later in the same block
+	res = deliverBlock(app, 2, "delprefix:x", "z=1", "y=1")
+	if res[0].Code != VALID_TX || res[1].Code != VALID_TX || res[2].Code != KEY_LIMIT {
+		t.Errorf("got codes %d, %d and %d", res[0].Code, res[1].Code, res[2].Code)
+	}
+	if app.committed.KeyCount != 3 {
+		t.Errorf("got %d keys", app.committed.KeyCount)
+	}
+}
+
+func TestPrefixKeyLimit(t *testing.T) {
+	store := NewMemStore()
+	deliverBlock(NewKVStoreApplicationWithStore(store), 1, "u/a=1", "x=1")
+
+	// the counts of a limit added to an existing store are worked out on startup
+	app := NewKVStoreApplicationWithStore(store, WithPrefixKeyLimit("u/", 2))
+	res := deliverBlock(app, 2, "u/b=1", "u/c=1", "y=1", "u/a=2")
+	for i, want := range []uint32{VALID_TX, KEY_LIMIT, VALID_TX, VALID_TX} {
+		if res[i].Code != want {
+			t.Errorf("tx %d got code %d, want %d", i, res[i].Code, want)
+		}
+	}
+
+	// and they're committed with the block
+	restarted := NewKVStoreApplicationWithStore(store, WithPrefixKeyLimit("u/", 2))
+	if n := restarted.committed.keysUnder([]byte("u/")); n != 2 {
+		t.Errorf("restarted with %d keys under u/", n)
+	}
+	res = deliverBlock(restarted, 3, "delprefix:u/b", "u/c=1", "u/d=1")
+	if res[0].Code != VALID_TX || res[1].Code != VALID_TX || res[2].Code != KEY_LIMIT {
+		t.Errorf("after a delete got codes %d, %d and %d", res[0].Code, res[1].Code, res[2].Code)
+	}
+}
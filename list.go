@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// A list is a value of type list, a JSON array of strings, e.g.
+// 'events=["a","b"];type=list', and 'push:key:element' appends an element to
+// one, creating it if the key doesn't exist
+//
+// with WithMaxListLength a list can't have more than that many elements,
+// a push onto a full list, or writing a longer one, is rejected with
+// LIST_TOO_LONG, the length of every list is kept next to it so a push
+// doesn't have to decode the list just to check it
+//
+//	listLenPrefix | key -> number of elements (8 bytes)
+//
+// set and remove keep the length up to date however the key is written
+
+var listLenPrefix = internalKey("len/")
+
+func listLenKey(key []byte) []byte {
+	return append(append([]byte{}, listLenPrefix...), key...)
+}
+
+// decodeList decodes a list value, it's already been validated
+func decodeList(value []byte) ([]string, error) {
+	var list []string
+	err := json.Unmarshal(value, &list)
+	return list, err
+}
+
+// checkListLength rejects a list with more elements than allowed
+func (app *KVStoreApplication) checkListLength(n int64) error {
+	if app.maxListLength > 0 && n > app.maxListLength {
+		return reject(LIST_TOO_LONG, fmt.Sprintf("lists can't have more than %d elements", app.maxListLength))
+	}
+	return nil
+}
+
+// readListLen returns the length of the list at key as seen by txn
+func readListLen(txn Txn, key []byte) (int64, error) {
+	item, err := txn.Get(listLenKey(key))
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	err = item.Value(func(val []byte) error {
+		n = int64(binary.BigEndian.Uint64(val))
+		return nil
+	})
+	return n, err
+}
+
+// updateListLen records the length of the value written to key, was is the
+// type the key had before, if it existed
+func (app *KVStoreApplication) updateListLen(key, value []byte, ct contentType, was contentType, existed bool) error {
+	if ct != typeList {
+		if existed && was == typeList {
+			return app.currentBatch.Delete(listLenKey(key))
+		}
+		return nil
+	}
+	list, err := decodeList(value)
+	if err != nil {
+		return err
+	}
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(list)))
+	return app.currentBatch.Set(listLenKey(key), n[:])
+}
+
+// checkPush checks an element can be pushed onto the list at key, as seen
+// by txn
+func (app *KVStoreApplication) checkPush(txn Txn, key []byte) error {
+	_, ct, exists, err := lookup(txn, key)
+	if err != nil || !exists {
+		return err
+	}
+	if ct != typeList {
+		return reject(INCOMPATIBLE_VALUE, fmt.Sprintf("can't push onto %q, it isn't a list", key))
+	}
+	if app.appendOnly {
+		return errOverwriteForbidden
+	}
+	n, err := readListLen(txn, key)
+	if err != nil {
+		return err
+	}
+	return app.checkListLength(n + 1)
+}
+
+// push appends element to the list at key in the current batch
+func (app *KVStoreApplication) push(key, element []byte) error {
+	value, _, exists, err := lookup(app.currentBatch, key)
+	if err != nil {
+		return err
+	}
+	var list []string
+	if exists {
+		if list, err = decodeList(value); err != nil {
+			return err
+		}
+	}
+	value, err = json.Marshal(append(list, string(element)))
+	if err != nil {
+		return err
+	}
+	return app.set(key, value, typeList)
+}
+
+// push:key:element appends element to the list at key, the element is
+// everything after the key, ':' included
+func init() {
+	registerOp(&txOp{
+		name: "push",
+		args: 2,
+		keys: []int{0},
+		rest: true,
+		check: func(app *KVStoreApplication, txn Txn, t transaction) error {
+			return app.checkPush(txn, t.args[0])
+		},
+		apply: func(app *KVStoreApplication, t transaction) error {
+			return app.push(t.args[0], t.args[1])
+		},
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// listLen reads the length kept next to a list
+func listLen(t *testing.T, app *KVStoreApplication, key string) int64 {
+	t.Helper()
+	var n int64
+	err := app.store.View(func(txn Txn) (err error) {
+		n, err = readListLen(txn, []byte(key))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestMaxListLength(t *testing.T) {
+	app := newTestApp(t, WithMaxListLength(3))
+	res := deliverBlock(app, 1, "push:l:a", "push:l:b:c", "push:l:d", "push:l:e", `m=["1","2","3","4"];type=list`, "s=x", "push:s:1")
+	for i, want := range []uint32{VALID_TX, VALID_TX, VALID_TX, LIST_TOO_LONG, LIST_TOO_LONG, VALID_TX, INCOMPATIBLE_VALUE} {
+		if res[i].Code != want {
+			t.Errorf("tx %d got code %d, want %d: %s", i, res[i].Code, want, res[i].Log)
+		}
+	}
+	if value, ct := typedValue(t, app, "l"); value != `["a","b:c","d"]` || ct != typeList {
+		t.Fatalf("l is %s (%s)", value, ct)
+	}
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("push:l:e")}); r.Code != LIST_TOO_LONG {
+		t.Errorf("CheckTx of a push onto a full list got code %d", r.Code)
+	}
+
+	// the length follows the list wherever it's written
+	deliverBlock(app, 2, `m=["1","2"];type=list`, "swap:m:l")
+	for key, want := range map[string]int64{"l": 2, "m": 3, "s": 0} {
+		if n := listLen(t, app, key); n != want {
+			t.Errorf("%s has length %d, want %d", key, n, want)
+		}
+	}
+	res = deliverBlock(app, 3, "push:l:x", "push:m:x", "delprefix:l")
+	if res[0].Code != VALID_TX || res[1].Code != LIST_TOO_LONG {
+		t.Errorf("pushes after the swap got codes %d and %d", res[0].Code, res[1].Code)
+	}
+	if n := listLen(t, app, "l"); n != 0 {
+		t.Errorf("the deleted list still has length %d", n)
+	}
+}
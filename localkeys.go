@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+)
+
+// Local keys (WithLocalPrefixes) are the keys under a prefix set aside for
+// data that doesn't need to be proven, e.g. scratch data written by the
+// chain's own clients, they're written and read like any other key but left
+// out of the app hash, in both modes, so they don't make the merkle tree, or
+// the proofs of the other keys, any bigger
+//
+// they're still part of consensus, DeliverTx results depend on them, a
+// duplicate write, append only mode, the key limits, the block write budget
+// and the conditions of ops like setif, cp and swap all read them, and
+// tendermint hashes the results of a block into the next header, so the
+// local keys have to stay the same on every node, written only through
+// transactions, a node that changes or drops its local keys forks
+//
+// what counts as local has to be the same on every node, and it can't be
+// changed on a running chain, it changes what the app hash is computed over
+// a local key is never in a proof, a key proof for one is refused and a
+// range proof leaves them out
+
+// localPrefix returns the prefix in local that key is under, nil if none
+func localPrefix(local [][]byte, key []byte) []byte {
+	for _, p := range local {
+		if bytes.HasPrefix(key, p) {
+			return p
+		}
+	}
+	return nil
+}
+
+// isLocal returns true if key is left out of the app hash
+func (app *KVStoreApplication) isLocal(key []byte) bool {
+	return localPrefix(app.localPrefixes, key) != nil
+}
+
+// hashedChanges returns the block's changes the app hash is computed over
+func (app *KVStoreApplication) hashedChanges() []change {
+	if len(app.localPrefixes) == 0 {
+		return app.changes
+	}
+	var changes []change
+	for _, c := range app.changes {
+		if !app.isLocal(c.key) {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
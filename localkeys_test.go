@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestLocalPrefixes(t *testing.T) {
+	for _, merkle := range []bool{false, true} {
+		app := newTestApp(t, WithLocalPrefixes("cache/"), WithMerkleAppHash(merkle))
+		plain := newTestApp(t, WithLocalPrefixes("cache/"), WithMerkleAppHash(merkle))
+		for i, tx := range []string{"a=1", "cache/x=1", "cache/x=2", "b=1", "cache/y=1"} {
+			before := app.committed.AppHash
+			if r := deliverBlock(app, int64(i+1), tx)[0]; r.Code != VALID_TX {
+				t.Fatalf("%s got code %d: %s", tx, r.Code, r.Log)
+			}
+			// only the state writes change the hash
+			local := bytes.HasPrefix([]byte(tx), []byte("cache/"))
+			if changed := !bytes.Equal(before, app.committed.AppHash); changed == local {
+				t.Errorf("merkle %v: %s changed the app hash is %v", merkle, tx, changed)
+			}
+			if local {
+				deliverBlock(plain, int64(i+1))
+			} else {
+				deliverBlock(plain, int64(i+1), tx)
+			}
+		}
+		// the same as a node that never wrote the local keys
+		if !bytes.Equal(app.committed.AppHash, plain.committed.AppHash) {
+			t.Errorf("merkle %v: app hash %X, want %X without the local writes", merkle, app.committed.AppHash, plain.committed.AppHash)
+		}
+		// they're still written and read like any other key
+		if value, _, _ := app.get([]byte("cache/x")); string(value) != "2" || app.committed.KeyCount != 4 {
+			t.Errorf("merkle %v: cache/x is %q with %d keys", merkle, value, app.committed.KeyCount)
+		}
+		if !merkle {
+			continue
+		}
+
+		if res := app.Query(abcitypes.RequestQuery{Path: "keyproof", Data: []byte("cache/x")}); res.Code != QUERY_NOT_ALLOWED {
+			t.Errorf("keyproof of a local key got code %d", res.Code)
+		}
+		if _, p := keyProof(t, app, "b"); VerifyKeyProof(app.committed.AppHash, p) != nil {
+			t.Error("keyproof of b doesn't verify with local keys in the store")
+		}
+		if p := rangeProof(t, app, ""); len(p.Entries) != 2 {
+			t.Errorf("range proof has %d entries, want the 2 state keys", len(p.Entries))
+		}
+		var sres stateHashResponse
+		queryJSON(t, app, "statehash", []byte(`{"force":true}`), &sres)
+		if sres.Matches == nil || !*sres.Matches {
+			t.Errorf("recomputed merkle root doesn't match: %+v", sres)
+		}
+	}
+}
@@ -1,7 +1,14 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		RunBenchmarks(os.Stdout)
+		return
+	}
 	fmt.Println("Hello, Tendermint Core")
 }
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// Maintenance operations rewrite large parts of the database, they are
+// only safe to run when no block is being processed, as that would mean
+// competing with the current batch for the same tables
+
+// ErrBlockInProgress is returned by maintenance operations that are
+// attempted between BeginBlock and Commit
+var ErrBlockInProgress = errors.New("a block is currently being processed")
+
+const defaultFlattenWorkers = 2
+
+// inBlock returns true if a block has been started but not yet committed
+func (app *KVStoreApplication) inBlock() bool {
+	return app.blockOpen
+}
+
+// Flatten forces a compaction of the LSM tree into a single level
+// after a lot of deletes reads have to skip over the tombstones until
+// compaction catches up, flattening gets rid of them on demand
+func (app *KVStoreApplication) Flatten(workers int) error {
+	if app.replica {
+		return ErrReplica
+	}
+	if app.inBlock() {
+		return ErrBlockInProgress
+	}
+	if app.db == nil {
+		return ErrNotBadger
+	}
+
+	lsm, vlog := app.db.Size()
+	app.logger.Info("flattening db", "lsm_size", lsm, "vlog_size", vlog, "workers", workers)
+
+	if err := app.db.Flatten(workers); err != nil {
+		return err
+	}
+
+	lsm, vlog = app.db.Size()
+	app.logger.Info("flattened db", "lsm_size", lsm, "vlog_size", vlog)
+	return nil
+}
+
+// rewriteDiscardRatio makes RewriteValueLog rewrite any value log file
+// with at least 1% of it discarded, badger's own recommendation is 0.5
+const rewriteDiscardRatio = 0.01
+
+// RewriteValueLog runs value log GC until there is nothing left to reclaim
+// e.g. after a lot of overwrites or deletes, the maintenance coordinator
+// only does a bounded amount of GC each window
+// valueDir is the badger value directory (the dir given to OpenDB), badger's
+// own size reporting lags by up to a minute so the files are measured instead
+func (app *KVStoreApplication) RewriteValueLog(valueDir string) error {
+	if app.replica {
+		return ErrReplica
+	}
+	if app.inBlock() {
+		return ErrBlockInProgress
+	}
+	if app.db == nil {
+		return ErrNotBadger
+	}
+
+	before, err := vlogDiskSize(valueDir)
+	if err != nil {
+		return err
+	}
+	app.logger.Info("rewriting value log", "vlog_size", before)
+
+	rewrites := 0
+	for {
+		err := app.db.RunValueLogGC(rewriteDiscardRatio)
+		if err == badger.ErrNoRewrite {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rewrites++
+	}
+
+	after, err := vlogDiskSize(valueDir)
+	if err != nil {
+		return err
+	}
+	app.logger.Info("rewrote value log", "files", rewrites, "vlog_size", after, "reclaimed", before-after)
+	return nil
+}
+
+// vlogDiskSize sums the size of the value log files in dir
+func vlogDiskSize(dir string) (int64, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.vlog"))
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if os.IsNotExist(err) {
+			// deleted by GC in the meantime
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+// queryFlatten is the privileged query path for Flatten
+// the number of workers can optionally be passed as the query data
+func (app *KVStoreApplication) queryFlatten(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if !app.adminQueries {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "admin queries are disabled"
+		return
+	}
+
+	workers := defaultFlattenWorkers
+	if len(req.Data) > 0 {
+		n, err := strconv.Atoi(string(req.Data))
+		if err != nil || n < 1 {
+			res.Code = QUERY_INVALID
+			res.Log = "workers must be a positive integer"
+			return
+		}
+		workers = n
+	}
+
+	if err := app.Flatten(workers); err != nil {
+		res.Code = QUERY_FAILED
+		res.Log = err.Error()
+		return
+	}
+
+	res.Log = "flattened"
+	return
+}
+
+// MaintenanceConfig configures background maintenance, see WithMaintenance
+type MaintenanceConfig struct {
+	// Interval is how often the coordinator checks if maintenance can run
+	Interval time.Duration
+	// IdleThreshold is how long the app has to have been idle, no block in
+	// progress and nothing waiting to be flushed, before maintenance runs
+	IdleThreshold time.Duration
+	// MaxDuration bounds a maintenance window, value log GC stops once it's
+	// passed, a flatten can't be interrupted so it only starts at the
+	// beginning of a window
+	MaxDuration time.Duration
+
+	// GCDiscardRatio is passed to badger's RunValueLogGC, 0 disables GC
+	GCDiscardRatio float64
+	// GCRetryBackoff is how long GC waits after a failure before it's
+	// tried again, it doubles with every failure in a row up to
+	// GCMaxBackoff, a minute and an hour by default, finding nothing to
+	// reclaim or being rejected as another GC is running isn't a failure
+	GCRetryBackoff time.Duration
+	GCMaxBackoff   time.Duration
+	// FlattenEvery is the minimum time between flattens, 0 disables them
+	FlattenEvery   time.Duration
+	FlattenWorkers int
+
+	// Metrics, if set, counts the value log GC runs
+	Metrics *BadgerMetrics
+}
+
+// maintainer runs value log GC and flattens in the background, only while
+// the app is idle, as running them alongside a block competes with the
+// current batch for the db and slows consensus down
+// a block that starts mid window stops the window from doing any more work
+// but whatever is running at the time finishes
+type maintainer struct {
+	app *KVStoreApplication
+	cfg MaintenanceConfig
+
+	// the coordinator runs on its own goroutine, busy and idleSince are
+	// set from the ABCI methods
+	mu        sync.Mutex
+	busy      bool
+	idleSince time.Time
+	// lastFlatten, gcFailures and gcRetryAt are only used by the
+	// coordinator, gcFailures counts the GC failures in a row
+	lastFlatten time.Time
+	gcFailures  int
+	gcRetryAt   time.Time
+	// runGC is the db's RunValueLogGC
+	runGC func(discardRatio float64) error
+	// compaction is set from Commit, see compaction.go
+	compaction *compactionHint
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newMaintainer(app *KVStoreApplication, cfg MaintenanceConfig) *maintainer {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+	if cfg.FlattenWorkers <= 0 {
+		cfg.FlattenWorkers = defaultFlattenWorkers
+	}
+	if cfg.GCRetryBackoff <= 0 {
+		cfg.GCRetryBackoff = defaultGCRetryBackoff
+	}
+	if cfg.GCMaxBackoff <= 0 {
+		cfg.GCMaxBackoff = defaultGCMaxBackoff
+	}
+	now := time.Now()
+	return &maintainer{
+		app:         app,
+		cfg:         cfg,
+		idleSince:   now,
+		lastFlatten: now,
+		runGC:       app.db.RunValueLogGC,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// setBusy records if the app is in the middle of processing blocks
+func (m *maintainer) setBusy(busy bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.busy && !busy {
+		m.idleSince = time.Now()
+	}
+	m.busy = busy
+}
+
+// idle returns true if maintenance is allowed to run right now
+func (m *maintainer) idle() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.busy && time.Since(m.idleSince) >= m.cfg.IdleThreshold
+}
+
+func (m *maintainer) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if m.idle() {
+				m.window()
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// window runs whatever maintenance is due until the app stops being idle
+// or the window runs out of time
+func (m *maintainer) window() {
+	db, logger := m.app.db, m.app.logger
+	var deadline time.Time
+	if m.cfg.MaxDuration > 0 {
+		deadline = time.Now().Add(m.cfg.MaxDuration)
+	}
+	inWindow := func() bool {
+		return m.idle() && (deadline.IsZero() || time.Now().Before(deadline))
+	}
+
+	hint := m.takeHint()
+	if hint != nil {
+		logger.Info("flattening db for a compaction hint", "height", hint.height, "deletes", hint.deletes,
+			"from", string(hint.from), "to", string(hint.to), "workers", m.cfg.FlattenWorkers)
+		if err := db.Flatten(m.cfg.FlattenWorkers); err != nil {
+			logger.Error("hinted flatten failed", "err", err)
+		}
+		m.lastFlatten = time.Now()
+	} else if m.cfg.FlattenEvery > 0 && time.Since(m.lastFlatten) >= m.cfg.FlattenEvery {
+		logger.Info("flattening db in the background", "workers", m.cfg.FlattenWorkers)
+		if err := db.Flatten(m.cfg.FlattenWorkers); err != nil {
+			logger.Error("background flatten failed", "err", err)
+		}
+		m.lastFlatten = time.Now()
+	}
+
+	if m.cfg.GCDiscardRatio <= 0 || time.Now().Before(m.gcRetryAt) {
+		return
+	}
+	// every successful run rewrites a single value log file
+	for inWindow() {
+		err := m.runGC(m.cfg.GCDiscardRatio)
+		switch err {
+		case nil:
+			m.gcFailures = 0
+			if m.cfg.Metrics != nil {
+				m.cfg.Metrics.ValueLogGCRan()
+			}
+			continue
+		case badger.ErrNoRewrite:
+			// nothing left to reclaim, GC is working
+			m.gcFailures = 0
+			return
+		case badger.ErrRejected:
+			// another GC is running, e.g. RewriteValueLog, or the db
+			// is closing, neither is a failure of GC
+			logger.Debug("background value log GC rejected", "err", err)
+			return
+		}
+		m.gcFailures++
+		backoff := m.gcBackoff()
+		m.gcRetryAt = time.Now().Add(backoff)
+		logger.Error("background value log GC failed", "err", err, "failures", m.gcFailures, "retry_in", backoff)
+		if m.cfg.Metrics != nil {
+			m.cfg.Metrics.ValueLogGCFailed()
+		}
+		return
+	}
+}
+
+const (
+	defaultGCRetryBackoff = time.Minute
+	defaultGCMaxBackoff   = time.Hour
+)
+
+// gcBackoff returns how long to wait before GC is tried again after
+// gcFailures failures in a row
+func (m *maintainer) gcBackoff() time.Duration {
+	backoff := m.cfg.GCRetryBackoff
+	for i := 1; i < m.gcFailures && backoff < m.cfg.GCMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > m.cfg.GCMaxBackoff {
+		backoff = m.cfg.GCMaxBackoff
+	}
+	return backoff
+}
+
+func (m *maintainer) close() {
+	close(m.stop)
+	<-m.done
+}
+
+// noteActivity tells the maintainer if there is block work in flight
+// with commit batching the app is busy until the batch is flushed
+func (app *KVStoreApplication) noteActivity() {
+	if app.maintainer != nil {
+		app.maintainer.setBusy(app.blockOpen || app.currentBatch != nil)
+	}
+}
+
+// Close stops the app's background maintenance and writes the shutdown
+// snapshot, if there is one, see snapshot.go, it waits for the event sink
+// to take the blocks already queued for it, it doesn't flush or close the
+// db, that's still up to the caller
+func (app *KVStoreApplication) Close() {
+	if app.maintainer != nil {
+		app.maintainer.close()
+		app.maintainer = nil
+	}
+	if app.events != nil {
+		app.events.stop()
+		app.events = nil
+	}
+	app.snapshotOnClose()
+}
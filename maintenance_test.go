@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/log"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+func TestFlattenAfterDeletes(t *testing.T) {
+	app := NewKVStoreApplication(testDB(t), WithAdminQueries(true))
+	var txs []string
+	for i := 0; i < 1000; i++ {
+		txs = append(txs, fmt.Sprintf("k%03d=v", i))
+	}
+	deliverBlock(app, 1, txs...)
+	deliverBlock(app, 2, "delprefix:k", "k999=v")
+
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 3}})
+	if err := app.Flatten(1); err != ErrBlockInProgress {
+		t.Errorf("flattening in a block got %v", err)
+	}
+	app.EndBlock(abcitypes.RequestEndBlock{Height: 3})
+	app.Commit()
+
+	if res := app.Query(abcitypes.RequestQuery{Path: "flatten", Data: []byte("2")}); res.Code != 0 {
+		t.Fatalf("got code %d: %s", res.Code, res.Log)
+	}
+	if value, _, _ := app.get([]byte("k999")); string(value) != "v" || app.committed.KeyCount != 1 {
+		t.Errorf("k999 is %q with %d keys", value, app.committed.KeyCount)
+	}
+}
+
+func TestFlattenQuery(t *testing.T) {
+	if res := newTestApp(t).Query(abcitypes.RequestQuery{Path: "flatten"}); res.Code != QUERY_NOT_ALLOWED {
+		t.Errorf("without admin queries got code %d", res.Code)
+	}
+	app := newTestApp(t, WithAdminQueries(true))
+	if res := app.Query(abcitypes.RequestQuery{Path: "flatten", Data: []byte("0")}); res.Code != QUERY_INVALID {
+		t.Errorf("0 workers got code %d", res.Code)
+	}
+	if res := app.Query(abcitypes.RequestQuery{Path: "flatten"}); res.Code != QUERY_FAILED || res.Log != ErrNotBadger.Error() {
+		t.Errorf("a memory store got code %d: %s", res.Code, res.Log)
+	}
+}
+
+func TestMaintenanceWaitsUntilIdle(t *testing.T) {
+	const idle = 200 * time.Millisecond
+	app := NewKVStoreApplication(testDB(t))
+	m := newMaintainer(app, MaintenanceConfig{Interval: time.Millisecond, IdleThreshold: idle, GCDiscardRatio: 0.5})
+	runs := make(chan time.Time, 1000)
+	m.runGC = func(float64) error {
+		runs <- time.Now()
+		return badger.ErrNoRewrite
+	}
+	app.maintainer = m
+	go m.run()
+	defer app.Close()
+
+	// a block left open for longer than the idle threshold
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 1}})
+	app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("a=1")})
+	time.Sleep(2 * idle)
+	if len(runs) != 0 {
+		t.Fatal("maintenance ran during a block")
+	}
+	app.EndBlock(abcitypes.RequestEndBlock{Height: 1})
+	app.Commit()
+	committed := time.Now()
+
+	// blocks arriving faster than the idle threshold keep it deferred
+	var last time.Time
+	for h := int64(2); time.Since(committed) < 2*idle; h++ {
+		time.Sleep(5 * time.Millisecond)
+		deliverBlock(app, h, fmt.Sprintf("k%d=v", h))
+		last = time.Now()
+	}
+	if len(runs) != 0 {
+		t.Fatal("maintenance ran between blocks arriving back to back")
+	}
+
+	select {
+	case ran := <-runs:
+		// the app went idle inside the last Commit, a little before last
+		if ran.Sub(last) < idle-10*time.Millisecond {
+			t.Errorf("maintenance ran %s after the last block, before the idle threshold", ran.Sub(last))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("maintenance never ran once the app was idle")
+	}
+}
+
+func TestRewriteValueLog(t *testing.T) {
+	dir := t.TempDir()
+	// small files and tables so overwrites are compacted into discard stats
+	db, err := OpenDB(dir, func(o *badger.Options) {
+		*o = o.WithValueLogFileSize(1 << 20).WithMaxTableSize(1 << 14).WithLogger(nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	app := NewKVStoreApplication(db)
+
+	big := strings.Repeat("x", 4000)
+	for h := int64(1); h <= 300; h++ {
+		var txs []string
+		for i := 0; i < 20; i++ {
+			txs = append(txs, fmt.Sprintf("k%d=%s%d", i, big, h))
+		}
+		deliverBlock(app, h, txs...)
+	}
+
+	before, err := vlogDiskSize(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.RewriteValueLog(dir); err != nil {
+		t.Fatal(err)
+	}
+	after, err := vlogDiskSize(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the last of the 300 values of each key is live, at least one
+	// value log file should have been reclaimed
+	if before-after < 1<<20 {
+		t.Errorf("the value log went from %d to %d bytes", before, after)
+	}
+	for i := 0; i < 20; i++ {
+		if value, _, _ := app.get([]byte(fmt.Sprintf("k%d", i))); string(value) != big+"300" {
+			t.Fatalf("k%d lost its value in the rewrite", i)
+		}
+	}
+
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 301}})
+	if err := app.RewriteValueLog(dir); err != ErrBlockInProgress {
+		t.Errorf("a rewrite during a block got %v", err)
+	}
+}
+
+func TestGCBackoff(t *testing.T) {
+	var logs bytes.Buffer
+	app := NewKVStoreApplication(testDB(t), WithLogger(log.NewTMLogger(&logs)))
+	m := newMaintainer(app, MaintenanceConfig{GCDiscardRatio: 0.5, GCRetryBackoff: time.Second, GCMaxBackoff: 3 * time.Second})
+	var results []error
+	calls := 0
+	m.runGC = func(float64) error {
+		calls++
+		err := results[0]
+		results = results[1:]
+		return err
+	}
+
+	// runs until there's nothing to reclaim, which isn't a failure
+	results = []error{nil, nil, badger.ErrNoRewrite}
+	m.window()
+	if calls != 3 || m.gcFailures != 0 || !m.gcRetryAt.IsZero() {
+		t.Errorf("got %d runs and %d failures", calls, m.gcFailures)
+	}
+	// and neither is another GC running
+	results = []error{badger.ErrRejected}
+	m.window()
+	if m.gcFailures != 0 || !m.gcRetryAt.IsZero() || strings.Contains(logs.String(), "GC failed") {
+		t.Errorf("a rejected GC got %d failures and logged %q", m.gcFailures, logs.String())
+	}
+
+	// a real error is logged and backs off
+	results = []error{errors.New("disk on fire")}
+	m.window()
+	if m.gcFailures != 1 || time.Until(m.gcRetryAt) < 900*time.Millisecond || !strings.Contains(logs.String(), "disk on fire") {
+		t.Errorf("a failure got %d failures, a retry in %s, and logged %q", m.gcFailures, time.Until(m.gcRetryAt), logs.String())
+	}
+	calls = 0
+	m.window()
+	if calls != 0 {
+		t.Error("GC ran during the backoff")
+	}
+	// doubling with every failure in a row, up to the max
+	for failures, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 3 * time.Second, 10: 3 * time.Second} {
+		m.gcFailures = failures
+		if got := m.gcBackoff(); got != want {
+			t.Errorf("%d failures back off %s, want %s", failures, got, want)
+		}
+	}
+	// and a run finding nothing to reclaim resets it
+	m.gcRetryAt = time.Time{}
+	results = []error{badger.ErrNoRewrite}
+	m.window()
+	if m.gcFailures != 0 {
+		t.Errorf("got %d failures after a clean run", m.gcFailures)
+	}
+
+	// badger with nothing to reclaim
+	m.runGC = app.db.RunValueLogGC
+	m.window()
+	if m.gcFailures != 0 {
+		t.Errorf("GC of a fresh db got %d failures", m.gcFailures)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A MemStore (NewMemStore) is a Store that keeps everything in memory, for
+// tests and tools that don't need the data to outlive the process, a View
+// sees the store as of its start, a batch is applied under a lock on Commit
+// and every commit copies the store, so it's meant for stores of a few
+// thousand keys rather than millions
+
+// errReadOnlyTxn is returned for a write in a View
+var errReadOnlyTxn = errors.New("the transaction is read only")
+
+// errTxnDone is returned for a Txn used after it's been committed or
+// discarded
+var errTxnDone = errors.New("the transaction has already been committed or discarded")
+
+type memEntry struct {
+	value []byte
+	meta  byte
+}
+
+// memSnapshot is the store as of a commit, it's never changed once it's
+// made, keys are sorted
+type memSnapshot struct {
+	entries map[string]memEntry
+	keys    []string
+}
+
+// MemStore is an in memory Store, see memstore.go
+type MemStore struct {
+	mtx  sync.Mutex
+	snap *memSnapshot
+}
+
+// NewMemStore returns an empty MemStore
+func NewMemStore() *MemStore {
+	return &MemStore{snap: &memSnapshot{entries: map[string]memEntry{}}}
+}
+
+func (s *MemStore) current() *memSnapshot {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.snap
+}
+
+func (s *MemStore) View(fn func(txn Txn) error) error {
+	return fn(&memTxn{base: s.current()})
+}
+
+func (s *MemStore) Update(fn func(txn Txn) error) error {
+	txn := s.NewBatch()
+	defer txn.Discard()
+	if err := fn(txn); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+func (s *MemStore) NewBatch() Txn {
+	return &memTxn{store: s, base: s.current(), writes: map[string]*memEntry{}}
+}
+
+// memTxn is a Txn on a MemStore, writes are nil for a View, a nil entry
+// in them is a delete
+type memTxn struct {
+	store  *MemStore
+	base   *memSnapshot
+	writes map[string]*memEntry
+	done   bool
+}
+
+func (t *memTxn) lookup(key string) (memEntry, bool) {
+	if e, ok := t.writes[key]; ok {
+		if e == nil {
+			return memEntry{}, false
+		}
+		return *e, true
+	}
+	e, ok := t.base.entries[key]
+	return e, ok
+}
+
+func (t *memTxn) Get(key []byte) (Item, error) {
+	if t.done {
+		return nil, errTxnDone
+	}
+	e, ok := t.lookup(string(key))
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return &memItem{key: append([]byte{}, key...), entry: e}, nil
+}
+
+func (t *memTxn) write(key []byte, e *memEntry) error {
+	switch {
+	case t.done:
+		return errTxnDone
+	case t.writes == nil:
+		return errReadOnlyTxn
+	case len(key) == 0:
+		return errors.New("keys can't be empty")
+	}
+	t.writes[string(key)] = e
+	return nil
+}
+
+func (t *memTxn) Set(key, value []byte) error {
+	return t.write(key, &memEntry{value: append([]byte{}, value...)})
+}
+
+func (t *memTxn) SetEntry(e *Entry) error {
+	return t.write(e.Key, &memEntry{value: append([]byte{}, e.Value...), meta: e.UserMeta})
+}
+
+func (t *memTxn) Delete(key []byte) error {
+	return t.write(key, nil)
+}
+
+func (t *memTxn) NewIterator(opts IteratorOptions) Iterator {
+	prefix := string(opts.Prefix)
+	var keys []string
+	i := sort.SearchStrings(t.base.keys, prefix)
+	for ; i < len(t.base.keys) && strings.HasPrefix(t.base.keys[i], prefix); i++ {
+		if _, written := t.writes[t.base.keys[i]]; !written {
+			keys = append(keys, t.base.keys[i])
+		}
+	}
+	for key, e := range t.writes {
+		if e != nil && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	it := &memIterator{prefix: opts.Prefix, reverse: opts.Reverse, items: make([]memItem, len(keys))}
+	for i, key := range keys {
+		if opts.Reverse {
+			i = len(keys) - 1 - i
+		}
+		e, _ := t.lookup(key)
+		it.items[i] = memItem{key: []byte(key), entry: e}
+	}
+	return it
+}
+
+func (t *memTxn) Commit() error {
+	if t.done {
+		return errTxnDone
+	}
+	t.done = true
+	if len(t.writes) == 0 {
+		return nil
+	}
+	s := t.store
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	entries := make(map[string]memEntry, len(s.snap.entries)+len(t.writes))
+	for key, e := range s.snap.entries {
+		entries[key] = e
+	}
+	for key, e := range t.writes {
+		if e == nil {
+			delete(entries, key)
+		} else {
+			entries[key] = *e
+		}
+	}
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	s.snap = &memSnapshot{entries: entries, keys: keys}
+	return nil
+}
+
+func (t *memTxn) Discard() {
+	t.done = true
+}
+
+type memItem struct {
+	key   []byte
+	entry memEntry
+}
+
+func (i *memItem) Key() []byte {
+	return i.key
+}
+
+func (i *memItem) KeyCopy(dst []byte) []byte {
+	return append(dst[:0], i.key...)
+}
+
+func (i *memItem) Value(fn func(val []byte) error) error {
+	return fn(i.entry.value)
+}
+
+func (i *memItem) ValueCopy(dst []byte) ([]byte, error) {
+	return append(dst[:0], i.entry.value...), nil
+}
+
+func (i *memItem) ValueSize() int64 {
+	return int64(len(i.entry.value))
+}
+
+func (i *memItem) UserMeta() byte {
+	return i.entry.meta
+}
+
+// memIterator iterates over the items of a memTxn as of its creation, in
+// the order it goes in
+type memIterator struct {
+	prefix  []byte
+	reverse bool
+	items   []memItem
+	pos     int
+}
+
+func (it *memIterator) Rewind() {
+	it.Seek(nil)
+}
+
+func (it *memIterator) Seek(key []byte) {
+	if len(key) == 0 {
+		key = it.prefix
+	}
+	if len(key) == 0 {
+		it.pos = 0
+		return
+	}
+	it.pos = sort.Search(len(it.items), func(i int) bool {
+		c := bytes.Compare(it.items[i].key, key)
+		if it.reverse {
+			return c <= 0
+		}
+		return c >= 0
+	})
+}
+
+func (it *memIterator) Valid() bool {
+	return it.pos < len(it.items)
+}
+
+func (it *memIterator) ValidForPrefix(prefix []byte) bool {
+	return it.Valid() && bytes.HasPrefix(it.items[it.pos].key, prefix)
+}
+
+func (it *memIterator) Next() {
+	it.pos++
+}
+
+func (it *memIterator) Item() Item {
+	return &it.items[it.pos]
+}
+
+func (it *memIterator) Close() {}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/merkle"
+	tmcrypto "github.com/tendermint/tendermint/proto/tendermint/crypto"
+)
+
+// In merkle mode the app hash is the root of a merkle tree (tendermint's
+// crypto/merkle, RFC 6962 style) whose leaves are every user entry in key
+// order, encoded as len(key) key len(value) value type
+// unlike the default chained hash this commits to the current contents of
+// the store rather than its history, so entries can be proven against it
+// the whole tree is rebuilt on every Commit, which is O(keys in the store)
+
+// leafEntry is a merkle leaf along with the entry it encodes
+type leafEntry struct {
+	key   []byte
+	value []byte
+	ct    contentType
+}
+
+func (e leafEntry) leaf() []byte {
+	return encodeEntry(e.key, e.value, e.ct)
+}
+
+// merkleEntries reads every user entry visible to txn in key order, but the
+// ones under a local prefix, see localkeys.go
+func merkleEntries(txn Txn, local [][]byte) ([]leafEntry, error) {
+	var entries []leafEntry
+	it := txn.NewIterator(DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(prefixEnd(internalPrefix)); it.Valid(); {
+		item := it.Item()
+		if p := localPrefix(local, item.Key()); p != nil {
+			end := prefixEnd(p)
+			if end == nil {
+				break
+			}
+			it.Seek(end)
+			continue
+		}
+		value, err := itemValue(item)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, leafEntry{key: item.KeyCopy(nil), value: value, ct: itemType(item)})
+		it.Next()
+	}
+	return entries, nil
+}
+
+func merkleLeaves(entries []leafEntry) [][]byte {
+	leaves := make([][]byte, len(entries))
+	for i, e := range entries {
+		leaves[i] = e.leaf()
+	}
+	return leaves
+}
+
+// merkleRoot computes the merkle app hash of the store as seen by txn
+func merkleRoot(txn Txn, local [][]byte) ([]byte, error) {
+	entries, err := merkleEntries(txn, local)
+	if err != nil {
+		return nil, err
+	}
+	return merkle.HashFromByteSlices(merkleLeaves(entries)), nil
+}
+
+// PROOF_OP_RANGE is the proof op type of a RangeProof
+const PROOF_OP_RANGE = "kvstore:range"
+
+// RangeProof proves the complete set of entries under a prefix
+// every entry comes with its inclusion proof, and the entries either side of
+// the range, which don't have the prefix, are included with theirs, as the
+// leaves are sorted by key, the indexes being contiguous from Left to Right
+// proves there is no other entry with the prefix
+type RangeProof struct {
+	Prefix  []byte       `json:"prefix"`
+	Entries []ProofEntry `json:"entries"`
+	// Left and Right are nil when the range starts or ends the tree
+	Left  *ProofEntry `json:"left,omitempty"`
+	Right *ProofEntry `json:"right,omitempty"`
+}
+
+// ProofEntry is an entry with its inclusion proof
+type ProofEntry struct {
+	Key   []byte       `json:"key"`
+	Value []byte       `json:"value"`
+	Type  byte         `json:"type"`
+	Proof merkle.Proof `json:"proof"`
+}
+
+func (e ProofEntry) verify(root []byte, total int64) error {
+	if e.Proof.Total != total {
+		return errors.New("proofs are for trees of different sizes")
+	}
+	return e.Proof.Verify(root, encodeEntry(e.Key, e.Value, contentType(e.Type)))
+}
+
+// VerifyRangeProof checks the range proof against a merkle app hash
+// returns nil only if the proof's entries are exactly the entries under
+// its prefix in the tree with that root
+func VerifyRangeProof(root []byte, p RangeProof) error {
+	var first *ProofEntry
+	switch {
+	case p.Left != nil:
+		first = p.Left
+	case len(p.Entries) > 0:
+		first = &p.Entries[0]
+	case p.Right != nil:
+		first = p.Right
+	default:
+		// only an empty tree has no entries at all
+		if !bytes.Equal(root, merkle.HashFromByteSlices(nil)) {
+			return errors.New("empty range proof for a non empty tree")
+		}
+		return nil
+	}
+	total := first.Proof.Total
+
+	// the index every entry must be at for the range to be contiguous
+	next := int64(0)
+	var prevKey []byte
+	if p.Left != nil {
+		if bytes.Compare(p.Left.Key, p.Prefix) >= 0 {
+			return errors.New("left neighbour doesn't sort before the range")
+		}
+		if err := p.Left.verify(root, total); err != nil {
+			return fmt.Errorf("left neighbour: %w", err)
+		}
+		next, prevKey = p.Left.Proof.Index+1, p.Left.Key
+	}
+
+	for i, e := range p.Entries {
+		if !bytes.HasPrefix(e.Key, p.Prefix) {
+			return fmt.Errorf("entry %d is outside the range", i)
+		}
+		if e.Proof.Index != next || (prevKey != nil && bytes.Compare(prevKey, e.Key) >= 0) {
+			return fmt.Errorf("entry %d is out of order", i)
+		}
+		if err := e.verify(root, total); err != nil {
+			return fmt.Errorf("entry %d: %w", i, err)
+		}
+		next, prevKey = next+1, e.Key
+	}
+
+	if p.Right == nil {
+		if next != total {
+			return errors.New("range doesn't reach the end of the tree and has no right neighbour")
+		}
+		return nil
+	}
+	if bytes.Compare(p.Right.Key, p.Prefix) <= 0 || bytes.HasPrefix(p.Right.Key, p.Prefix) {
+		return errors.New("right neighbour doesn't sort after the range")
+	}
+	if p.Right.Proof.Index != next || (prevKey != nil && bytes.Compare(prevKey, p.Right.Key) >= 0) {
+		return errors.New("right neighbour is out of order")
+	}
+	if err := p.Right.verify(root, total); err != nil {
+		return fmt.Errorf("right neighbour: %w", err)
+	}
+	return nil
+}
+
+// proofTree is the merkle tree of the store along with every leaf's proof
+type proofTree struct {
+	entries []leafEntry
+	root    []byte
+	proofs  []*merkle.Proof
+}
+
+// newProofTree builds the tree over the given entries, which must be every
+// user entry in key order
+func newProofTree(entries []leafEntry) *proofTree {
+	root, proofs := merkle.ProofsFromByteSlices(merkleLeaves(entries))
+	return &proofTree{entries: entries, root: root, proofs: proofs}
+}
+
+// rangeProof builds the range proof for prefix
+func (pt *proofTree) rangeProof(prefix []byte) (proof RangeProof) {
+	entries, proofs := pt.entries, pt.proofs
+	proofEntry := func(i int) *ProofEntry {
+		e := entries[i]
+		return &ProofEntry{Key: e.key, Value: e.value, Type: byte(e.ct), Proof: *proofs[i]}
+	}
+
+	proof.Prefix = prefix
+	proof.Entries = []ProofEntry{}
+	start := len(entries)
+	for i, e := range entries {
+		if bytes.Compare(e.key, prefix) >= 0 {
+			start = i
+			break
+		}
+	}
+	end := start
+	for end < len(entries) && bytes.HasPrefix(entries[end].key, prefix) {
+		proof.Entries = append(proof.Entries, *proofEntry(end))
+		end++
+	}
+	if start > 0 {
+		proof.Left = proofEntry(start - 1)
+	}
+	if end < len(entries) {
+		proof.Right = proofEntry(end)
+	}
+	return proof
+}
+
+// With the proof cache (WithProofCache) the proof tree is only built by the
+// first range proof query that needs it and kept for the ones after it, until
+// a block changes the store
+// the app hash itself is still computed in every Commit, tendermint needs it
+// in the Commit response, so only the proofs are lazy, a cached tree is only
+// used while its root is the app hash, so it's always the committed state
+type proofCache struct {
+	mtx  sync.Mutex
+	tree *proofTree
+}
+
+// loadProofTree returns the proof tree of the flushed store
+func (app *KVStoreApplication) loadProofTree() (*proofTree, error) {
+	if c := app.proofCache; c != nil {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		// the root commits to every entry, if it matches the tree is
+		// still the store's, even if blocks went by without changes
+		if c.tree != nil && bytes.Equal(c.tree.root, app.committed.stateHash()) {
+			return c.tree, nil
+		}
+	}
+
+	var entries []leafEntry
+	err := app.store.View(func(txn Txn) (err error) {
+		entries, err = merkleEntries(txn, app.localPrefixes)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	tree := newProofTree(entries)
+	if app.proofCache != nil {
+		app.proofCache.tree = tree
+	}
+	return tree, nil
+}
+
+// committedProofTree returns the proof tree of the committed store, or
+// false with res filled in if there's no proof of it to be had
+func (app *KVStoreApplication) committedProofTree(res *abcitypes.ResponseQuery) (*proofTree, bool) {
+	if !app.merkleAppHash {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "proofs need the merkle app hash"
+		return nil, false
+	}
+	// unflushed blocks aren't visible to queries yet, so the proof
+	// wouldn't match the last app hash
+	if app.unflushedBlocks > 0 {
+		res.Code = QUERY_FAILED
+		res.Log = "proofs aren't available until the current batch is flushed"
+		return nil, false
+	}
+
+	tree, err := app.loadProofTree()
+	if err != nil {
+		app.queryReadFailed(res, err)
+		return nil, false
+	}
+
+	// e.g. the first block after switching to merkle mode still has
+	// the chained app hash
+	if !bytes.Equal(tree.root, app.committed.stateHash()) {
+		res.Code = QUERY_FAILED
+		res.Log = "the store doesn't match the last app hash"
+		return nil, false
+	}
+	return tree, true
+}
+
+// queryRangeProof returns every entry under the prefix in the query data
+// along with a RangeProof, as a single PROOF_OP_RANGE proof op, against the
+// merkle app hash, only available in merkle mode
+// building the proof reads the whole store, so this is expensive
+func (app *KVStoreApplication) queryRangeProof(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	prefix := app.normalizeKey(req.Data)
+	tree, ok := app.committedProofTree(&res)
+	if !ok {
+		return
+	}
+	proof := tree.rangeProof(prefix)
+	data, err := json.Marshal(proof)
+	if err != nil {
+		res.Code = QUERY_FAILED
+		res.Log = err.Error()
+		return
+	}
+
+	res.Key = prefix
+	res.Height = app.committed.Height
+	res.ProofOps = &tmcrypto.ProofOps{Ops: app.withStateProof([]tmcrypto.ProofOp{{Type: PROOF_OP_RANGE, Key: prefix, Data: data}})}
+	pairs := make([]kvPair, len(proof.Entries))
+	for i, e := range proof.Entries {
+		pairs[i] = kvPair{Key: string(e.Key), Value: string(e.Value), Type: contentType(e.Type).String()}
+	}
+	respondJSON(&res, pairs)
+	return
+}
+
+// hashCostSample is the number of entries EstimateHashCost reads
+const hashCostSample = 10000
+
+// EstimateHashCost estimates what computing the merkle app hash would cost
+// on every Commit with the store as it is, to help decide on WithMerkleAppHash
+// it reads and hashes up to hashCostSample entries, the first ones in key
+// order, and scales the time it took and their size up to the whole store,
+// keys is the number of entries that would be hashed, leafBytes the size of
+// their leaves, it only reads, so it can be called at any time
+// the estimate is only as good as the sample is typical of the store, and a
+// store that no longer fits in memory costs more per entry to read
+func (app *KVStoreApplication) EstimateHashCost() (keys int, leafBytes int, est time.Duration, err error) {
+	keys = int(app.committed.KeyCount)
+	var sampled, sampledBytes int
+	var elapsed time.Duration
+	err = app.store.View(func(txn Txn) error {
+		start := time.Now()
+		var leaves [][]byte
+		it := txn.NewIterator(DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefixEnd(internalPrefix)); it.Valid() && len(leaves) < hashCostSample; it.Next() {
+			item := it.Item()
+			if app.isLocal(item.Key()) {
+				continue
+			}
+			value, err := itemValue(item)
+			if err != nil {
+				return err
+			}
+			leaf := encodeEntry(item.Key(), value, itemType(item))
+			leaves = append(leaves, leaf)
+			sampledBytes += len(leaf)
+		}
+		merkle.HashFromByteSlices(leaves)
+		elapsed = time.Since(start)
+		sampled = len(leaves)
+		return nil
+	})
+	if err != nil || sampled == 0 {
+		return keys, 0, 0, err
+	}
+	scale := float64(keys) / float64(sampled)
+	return keys, int(float64(sampledBytes) * scale), time.Duration(float64(elapsed) * scale), nil
+}
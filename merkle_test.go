@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// rangeProof queries the range proof of prefix
+func rangeProof(t *testing.T, app *KVStoreApplication, prefix string) RangeProof {
+	t.Helper()
+	res := app.Query(abcitypes.RequestQuery{Path: "rangeproof", Data: []byte(prefix)})
+	if res.Code != 0 {
+		t.Fatalf("rangeproof %q got code %d: %s", prefix, res.Code, res.Log)
+	}
+	var p RangeProof
+	if err := json.Unmarshal(res.ProofOps.Ops[0].Data, &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRangeProof(t *testing.T) {
+	app := newTestApp(t, WithMerkleAppHash(true))
+	deliverBlock(app, 1, "a=1", "b/1=2", "b/2=3", "c=4")
+	root := app.committed.AppHash
+
+	// the prefixes in the middle, at either end, covering everything and
+	// matching nothing
+	for prefix, keys := range map[string]int{"b/": 2, "a": 1, "c": 1, "": 4, "bb": 0, "0": 0, "z": 0} {
+		p := rangeProof(t, app, prefix)
+		if len(p.Entries) != keys {
+			t.Errorf("%q: got %d entries, want %d", prefix, len(p.Entries), keys)
+		}
+		if err := VerifyRangeProof(root, p); err != nil {
+			t.Errorf("%q: %v", prefix, err)
+		}
+	}
+
+	tampered := map[string]func(p *RangeProof){
+		"a dropped entry":       func(p *RangeProof) { p.Entries = p.Entries[1:] },
+		"a changed value":       func(p *RangeProof) { p.Entries[0].Value = []byte("9") },
+		"a dropped neighbour":   func(p *RangeProof) { p.Right = nil },
+		"a narrowed prefix":     func(p *RangeProof) { p.Prefix = []byte("b/1") },
+		"swapped entries":       func(p *RangeProof) { p.Entries[0], p.Entries[1] = p.Entries[1], p.Entries[0] },
+		"an entry from outside": func(p *RangeProof) { p.Entries = append(p.Entries, *p.Right) },
+	}
+	for name, tamper := range tampered {
+		p := rangeProof(t, app, "b/")
+		tamper(&p)
+		if VerifyRangeProof(root, p) == nil {
+			t.Errorf("a proof with %s verified", name)
+		}
+	}
+
+	p := rangeProof(t, app, "b/")
+	deliverBlock(app, 2, "b/3=5")
+	if VerifyRangeProof(app.committed.AppHash, p) == nil {
+		t.Error("a proof of the old range verified against the new app hash")
+	}
+	if err := VerifyRangeProof(app.committed.AppHash, rangeProof(t, app, "b/")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRangeProofNeedsMerkleMode(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "a=1")
+	if res := app.Query(abcitypes.RequestQuery{Path: "rangeproof", Data: []byte("a")}); res.Code == 0 {
+		t.Error("a chained app hash returned a range proof")
+	}
+}
+
+func TestProofCache(t *testing.T) {
+	lazy := newTestApp(t, WithMerkleAppHash(true), WithProofCache(true))
+	eager := newTestApp(t, WithMerkleAppHash(true))
+	block := func(height int64, txs ...string) {
+		t.Helper()
+		deliverBlock(lazy, height, txs...)
+		deliverBlock(eager, height, txs...)
+		if !bytes.Equal(lazy.committed.AppHash, eager.committed.AppHash) {
+			t.Fatalf("the app hashes differ at height %d", height)
+		}
+		// the cached proof is the same as one built from scratch, and
+		// checks out against the app hash
+		p := rangeProof(t, lazy, "b")
+		if !reflect.DeepEqual(p, rangeProof(t, eager, "b")) {
+			t.Fatalf("the cached proof differs at height %d", height)
+		}
+		if err := VerifyRangeProof(lazy.committed.AppHash, p); err != nil {
+			t.Fatalf("the cached proof at height %d: %v", height, err)
+		}
+		if !bytes.Equal(lazy.proofCache.tree.root, lazy.committed.AppHash) {
+			t.Fatalf("the cached tree's root isn't the app hash at height %d", height)
+		}
+	}
+
+	block(1, "a=1", "b=2")
+	tree := lazy.proofCache.tree
+	rangeProof(t, lazy, "a")
+	if lazy.proofCache.tree != tree {
+		t.Error("a second proof rebuilt the tree")
+	}
+	block(2)
+	if lazy.proofCache.tree != tree {
+		t.Error("an empty block rebuilt the tree")
+	}
+	block(3, "b2=3")
+	if lazy.proofCache.tree == tree {
+		t.Error("the tree wasn't rebuilt after a block changed the store")
+	}
+}
+
+func TestEstimateHashCost(t *testing.T) {
+	app := NewKVStoreApplication(testDB(t))
+	if keys, leafBytes, est, err := app.EstimateHashCost(); err != nil || keys != 0 || leafBytes != 0 || est != 0 {
+		t.Errorf("empty store estimate is %d keys, %d bytes, %s, %v", keys, leafBytes, est, err)
+	}
+
+	// every entry is the same size, so the bytes scale exactly, past the
+	// sample too
+	var perKey int
+	var last time.Duration
+	written := 0
+	for h, n := range []int{100, 1000, 2 * hashCostSample} {
+		var txs []string
+		for ; written < n; written++ {
+			txs = append(txs, fmt.Sprintf("k%06d=%0100d", written, written))
+		}
+		deliverBlock(app, int64(h+1), txs...)
+
+		keys, leafBytes, est, err := app.EstimateHashCost()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys != n {
+			t.Errorf("estimate is for %d keys, want %d", keys, n)
+		}
+		if perKey == 0 {
+			perKey = leafBytes / n
+		}
+		if leafBytes != perKey*n {
+			t.Errorf("%d keys estimated at %d bytes, want %d", n, leafBytes, perKey*n)
+		}
+		if est <= last {
+			t.Errorf("%d keys estimated at %s, no more than %s for fewer", n, est, last)
+		}
+		last = est
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/tendermint/tendermint/crypto/merkle"
+)
+
+// ExportMerkle writes the store as the merkle tree of merkle mode, see
+// merkle.go, and CompareMerkle compares two such streams, so two nodes can
+// find the entries they disagree on by exchanging hashes of ranges of the
+// store instead of the entries themselves, the start of an anti-entropy sync
+//
+// A stream is JSON lines, a header and then the tree's nodes in pre-order, a
+// node before its left subtree, the left subtree before the right one
+//
+//	{"version": 1, "height": 10, "leaves": 3, "root": "<hash>"}
+//	{"start": 0, "end": 3, "hash": "<hash>"}
+//	{"start": 0, "end": 2, "hash": "<hash>"}
+//	{"start": 0, "end": 1, "hash": "<hash>", "key": "YQ==", "value": "MQ=="}
+//	{"start": 1, "end": 2, "hash": "<hash>", "key": "Yg==", "value": "Mg==", "type": "int"}
+//	{"start": 2, "end": 3, "hash": "<hash>", "key": "Yw==", "value": "Mw=="}
+//
+// a node covers the leaves [start, end) of the entries in key order, it's a
+// leaf, with its entry, if it covers one, keys, values and hashes are base64,
+// type is left out for bytes, the hashes are tendermint's crypto/merkle ones,
+// an inner node splits at the largest power of two below its size, so the
+// root is the merkle app hash of the store, the state hash with the receipts
+// root, the keys under a local prefix are left out, like they are from it
+//
+// A MerkleExport can limit the stream to a subtree, by its range, which has
+// to be the range of a node, and to a depth below it, a node at that depth is
+// written without its subtree, so a node can first exchange the top of the
+// tree, then only the subtrees whose hashes differ
+// a difference can only be narrowed down to fewer leaves than the stores
+// have while they have as many entries before it, an entry missing from one
+// of them shifts the leaves after it, and all of them differ
+
+const merkleStreamVersion = 1
+
+type merkleStreamHeader struct {
+	Version int    `json:"version"`
+	Height  int64  `json:"height"`
+	Leaves  int    `json:"leaves"`
+	Root    []byte `json:"root"`
+}
+
+type merkleStreamNode struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Hash  []byte `json:"hash"`
+	Key   []byte `json:"key,omitempty"`
+	Value []byte `json:"value,omitempty"`
+	Type  string `json:"type,omitempty"`
+}
+
+// MerkleExport is the part of the tree ExportMerkle writes, the zero value
+// is the whole tree
+type MerkleExport struct {
+	// Start and End are the range of the subtree's root, both 0 for the
+	// root of the tree
+	Start, End int
+	// Depth is how many levels below the subtree's root are written, 0
+	// for all of them
+	Depth int
+}
+
+// MerkleDifference is a range of leaves two streams differ on
+type MerkleDifference struct {
+	Start, End int
+	// Keys are the keys of the leaves in the range either stream has, a
+	// range that's only covered by a node written without its subtree
+	// has none
+	Keys [][]byte
+}
+
+// merkleNode is a node of the tree built for a stream
+type merkleNode struct {
+	start, end  int
+	hash        []byte
+	left, right *merkleNode
+}
+
+// merkleSplit returns the size of the left subtree of a node with n leaves,
+// the largest power of two below n, as crypto/merkle splits
+func merkleSplit(n int) int {
+	k := 1
+	for k*2 < n {
+		k *= 2
+	}
+	return k
+}
+
+// merkleInnerHash hashes an inner node as crypto/merkle does
+func merkleInnerHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// buildMerkleTree builds the tree of the leaves [start, end)
+func buildMerkleTree(leaves [][]byte, start, end int) *merkleNode {
+	n := &merkleNode{start: start, end: end}
+	if end-start == 1 {
+		n.hash = merkle.HashFromByteSlices(leaves[start:end])
+		return n
+	}
+	mid := start + merkleSplit(end-start)
+	n.left, n.right = buildMerkleTree(leaves, start, mid), buildMerkleTree(leaves, mid, end)
+	n.hash = merkleInnerHash(n.left.hash, n.right.hash)
+	return n
+}
+
+// find returns the node of the subtree with the range [start, end), nil if
+// there isn't one
+func (n *merkleNode) find(start, end int) *merkleNode {
+	for n != nil {
+		if n.start == start && n.end == end {
+			return n
+		}
+		if n.left == nil {
+			return nil
+		}
+		if end <= n.left.end {
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return nil
+}
+
+// ExportMerkle writes the part of the merkle tree of the store selected by
+// export to w, as of the last flushed block, see merklestream.go
+func (app *KVStoreApplication) ExportMerkle(w io.Writer, export MerkleExport) error {
+	return app.store.View(func(txn Txn) error {
+		s, err := readState(txn)
+		if err != nil {
+			return err
+		}
+		entries, err := merkleEntries(txn, app.localPrefixes)
+		if err != nil {
+			return err
+		}
+		leaves := merkleLeaves(entries)
+
+		enc := json.NewEncoder(w)
+		hdr := merkleStreamHeader{Version: merkleStreamVersion, Height: s.Height, Leaves: len(leaves), Root: merkle.HashFromByteSlices(leaves)}
+		if err := enc.Encode(hdr); err != nil {
+			return err
+		}
+		if len(leaves) == 0 {
+			if export.Start != 0 || export.End != 0 {
+				return errors.New("the tree is empty")
+			}
+			return nil
+		}
+		tree := buildMerkleTree(leaves, 0, len(leaves))
+		node := tree
+		if export.Start != 0 || export.End != 0 {
+			if node = tree.find(export.Start, export.End); node == nil {
+				return fmt.Errorf("[%d, %d) isn't the range of a node of the tree", export.Start, export.End)
+			}
+		}
+		return writeMerkleNode(enc, entries, node, export.Depth, 0)
+	})
+}
+
+// writeMerkleNode writes n and its subtree down to depth levels below it
+func writeMerkleNode(enc *json.Encoder, entries []leafEntry, n *merkleNode, depth, level int) error {
+	rec := merkleStreamNode{Start: n.start, End: n.end, Hash: n.hash}
+	if n.left == nil {
+		e := entries[n.start]
+		rec.Key, rec.Value = e.key, e.value
+		if e.ct != typeBytes {
+			rec.Type = e.ct.String()
+		}
+	}
+	if err := enc.Encode(rec); err != nil {
+		return err
+	}
+	if n.left == nil || (depth > 0 && level == depth) {
+		return nil
+	}
+	if err := writeMerkleNode(enc, entries, n.left, depth, level+1); err != nil {
+		return err
+	}
+	return writeMerkleNode(enc, entries, n.right, depth, level+1)
+}
+
+// merkleStreamReader reads the nodes of a stream with one node of lookahead
+type merkleStreamReader struct {
+	dec  *json.Decoder
+	next *merkleStreamNode
+}
+
+func newMerkleStreamReader(r io.Reader) (*merkleStreamReader, merkleStreamHeader, error) {
+	sr := &merkleStreamReader{dec: json.NewDecoder(r)}
+	var hdr merkleStreamHeader
+	if err := sr.dec.Decode(&hdr); err != nil {
+		return nil, hdr, fmt.Errorf("reading the stream header: %w", err)
+	}
+	if hdr.Version != merkleStreamVersion {
+		return nil, hdr, fmt.Errorf("unsupported merkle stream version %d", hdr.Version)
+	}
+	return sr, hdr, nil
+}
+
+// peek returns the next node without reading it, nil at the end
+func (sr *merkleStreamReader) peek() (*merkleStreamNode, error) {
+	if sr.next != nil {
+		return sr.next, nil
+	}
+	var n merkleStreamNode
+	if err := sr.dec.Decode(&n); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if n.Start < 0 || n.End <= n.Start {
+		return nil, fmt.Errorf("invalid node range [%d, %d)", n.Start, n.End)
+	}
+	sr.next = &n
+	return sr.next, nil
+}
+
+// child reads the next node if it's in the subtree of n, nil if n was
+// written without its subtree
+func (sr *merkleStreamReader) child(n *merkleStreamNode) (*merkleStreamNode, error) {
+	next, err := sr.peek()
+	if err != nil || next == nil || next.Start < n.Start || next.End > n.End {
+		return nil, err
+	}
+	sr.next = nil
+	return next, nil
+}
+
+// skip reads the rest of the subtree of n, returns the keys of the leaves
+// it read
+func (sr *merkleStreamReader) skip(n *merkleStreamNode) ([][]byte, error) {
+	var keys [][]byte
+	for {
+		c, err := sr.child(n)
+		if err != nil || c == nil {
+			return keys, err
+		}
+		if c.End-c.Start == 1 {
+			keys = append(keys, c.Key)
+		}
+	}
+}
+
+// CompareMerkle reads two streams written by ExportMerkle and returns the
+// ranges of leaves they differ on, in order, a subtree with the same hash in
+// both isn't looked into, see merklestream.go
+func CompareMerkle(a, b io.Reader) ([]MerkleDifference, error) {
+	ra, ha, err := newMerkleStreamReader(a)
+	if err != nil {
+		return nil, err
+	}
+	rb, hb, err := newMerkleStreamReader(b)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(ha.Root, hb.Root) {
+		return nil, nil
+	}
+	na, err := ra.child(&merkleStreamNode{End: ha.Leaves})
+	if err != nil {
+		return nil, err
+	}
+	nb, err := rb.child(&merkleStreamNode{End: hb.Leaves})
+	if err != nil {
+		return nil, err
+	}
+	var diffs []MerkleDifference
+	// an empty tree has no nodes
+	if na == nil || nb == nil {
+		d := MerkleDifference{End: ha.Leaves}
+		if hb.Leaves > d.End {
+			d.End = hb.Leaves
+		}
+		err = differingNodes(&d, ra, na, nil)
+		if err == nil {
+			err = differingNodes(&d, rb, nb, nil)
+		}
+		d.Keys = distinctKeys(d.Keys)
+		return append(diffs, d), err
+	}
+	err = compareMerkleNodes(ra, rb, na, nb, &diffs)
+	return diffs, err
+}
+
+// differingNodes adds the keys of the subtree of n, the rest of which is
+// read from sr, to d, left is n's left child if it's already been read
+func differingNodes(d *MerkleDifference, sr *merkleStreamReader, n, left *merkleStreamNode) error {
+	if n == nil {
+		return nil
+	}
+	if n.End-n.Start == 1 {
+		d.Keys = append(d.Keys, n.Key)
+	}
+	for _, c := range []*merkleStreamNode{left, n} {
+		if c == nil {
+			continue
+		}
+		if c == left && c.End-c.Start == 1 {
+			d.Keys = append(d.Keys, c.Key)
+		}
+		keys, err := sr.skip(c)
+		if err != nil {
+			return err
+		}
+		d.Keys = append(d.Keys, keys...)
+	}
+	return nil
+}
+
+// compareMerkleNodes compares the subtrees of na and nb, which cover the
+// same leaves or start at the same one
+func compareMerkleNodes(ra, rb *merkleStreamReader, na, nb *merkleStreamNode, diffs *[]MerkleDifference) error {
+	if na.Start == nb.Start && na.End == nb.End && bytes.Equal(na.Hash, nb.Hash) {
+		if _, err := ra.skip(na); err != nil {
+			return err
+		}
+		_, err := rb.skip(nb)
+		return err
+	}
+
+	// the subtrees are only compared child by child while they split the
+	// same way
+	la, err := ra.child(na)
+	if err != nil {
+		return err
+	}
+	lb, err := rb.child(nb)
+	if err != nil {
+		return err
+	}
+	if la != nil && lb != nil && la.Start == lb.Start && la.End == lb.End {
+		if err := compareMerkleNodes(ra, rb, la, lb, diffs); err != nil {
+			return err
+		}
+		rightA, err := ra.child(na)
+		if err != nil {
+			return err
+		}
+		rightB, err := rb.child(nb)
+		if err != nil {
+			return err
+		}
+		if rightA == nil || rightB == nil {
+			return errors.New("a node has a left subtree without a right one")
+		}
+		return compareMerkleNodes(ra, rb, rightA, rightB, diffs)
+	}
+
+	d := MerkleDifference{Start: na.Start, End: na.End}
+	if nb.End > d.End {
+		d.End = nb.End
+	}
+	if err := differingNodes(&d, ra, na, la); err != nil {
+		return err
+	}
+	if err := differingNodes(&d, rb, nb, lb); err != nil {
+		return err
+	}
+	d.Keys = distinctKeys(d.Keys)
+	*diffs = append(*diffs, d)
+	return nil
+}
+
+// distinctKeys sorts keys and drops the duplicates
+func distinctKeys(keys [][]byte) [][]byte {
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	out := keys[:0]
+	for i, key := range keys {
+		if i == 0 || !bytes.Equal(key, keys[i-1]) {
+			out = append(out, key)
+		}
+	}
+	return out
+}
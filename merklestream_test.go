@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// merkleApp returns an app in merkle mode with keys k00 to k36, and extra
+func merkleApp(t *testing.T, extra ...string) *KVStoreApplication {
+	t.Helper()
+	app := newTestApp(t, WithMerkleAppHash(true))
+	var txs []string
+	for i := 0; i < 37; i++ {
+		txs = append(txs, fmt.Sprintf("k%02d=v", i))
+	}
+	deliverBlock(app, 1, append(txs, extra...)...)
+	return app
+}
+
+func exportMerkle(t *testing.T, app *KVStoreApplication, export MerkleExport) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := app.ExportMerkle(&b, export); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func compareMerkle(t *testing.T, a, b []byte) []MerkleDifference {
+	t.Helper()
+	diffs, err := CompareMerkle(bytes.NewReader(a), bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return diffs
+}
+
+func TestMerkleStreamsOfIdenticalStores(t *testing.T) {
+	a := exportMerkle(t, merkleApp(t), MerkleExport{})
+	app := merkleApp(t)
+	b := exportMerkle(t, app, MerkleExport{})
+	if !bytes.Equal(a, b) {
+		t.Fatal("identical stores have different streams")
+	}
+	var hdr merkleStreamHeader
+	if err := json.NewDecoder(bytes.NewReader(a)).Decode(&hdr); err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Leaves != 37 || !bytes.Equal(hdr.Root, app.committed.AppHash) {
+		t.Errorf("the stream has %d leaves and root %X, the app hash is %X", hdr.Leaves, hdr.Root, app.committed.AppHash)
+	}
+	if diffs := compareMerkle(t, a, b); len(diffs) != 0 {
+		t.Errorf("got differences %+v", diffs)
+	}
+}
+
+func TestMerkleStreamLocalizesADifference(t *testing.T) {
+	a, c := merkleApp(t), merkleApp(t, "k17=x")
+	want := []MerkleDifference{{Start: 17, End: 18, Keys: [][]byte{[]byte("k17")}}}
+	if diffs := compareMerkle(t, exportMerkle(t, a, MerkleExport{}), exportMerkle(t, c, MerkleExport{})); !reflect.DeepEqual(diffs, want) {
+		t.Errorf("got %+v", diffs)
+	}
+
+	// the top of the tree first, then the subtree that differs
+	diffs := compareMerkle(t, exportMerkle(t, a, MerkleExport{Depth: 2}), exportMerkle(t, c, MerkleExport{Depth: 2}))
+	if len(diffs) != 1 || diffs[0].Start > 17 || diffs[0].End <= 17 || diffs[0].End-diffs[0].Start >= 37 {
+		t.Fatalf("the top of the tree got %+v", diffs)
+	}
+	sub := MerkleExport{Start: diffs[0].Start, End: diffs[0].End}
+	if diffs := compareMerkle(t, exportMerkle(t, a, sub), exportMerkle(t, c, sub)); !reflect.DeepEqual(diffs, want) {
+		t.Errorf("the subtree got %+v", diffs)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BadgerMetrics exposes the health of the underlying badger db to prometheus
+// badger doesn't report compaction progress directly, a growing number of
+// tables in level 0 is the sign of compactions falling behind writes
+//
+// the db is sampled on an interval rather than on every scrape, as listing
+// the tables takes badger's level locks
+type BadgerMetrics struct {
+	db *badger.DB
+
+	tables   *prometheus.GaugeVec
+	lsmSize  prometheus.Gauge
+	vlogSize prometheus.Gauge
+	gcRuns   prometheus.Counter
+	gcFails  prometheus.Counter
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ prometheus.Collector = (*BadgerMetrics)(nil)
+
+const defaultMetricsInterval = 15 * time.Second
+
+// NewBadgerMetrics samples the db straight away and then every interval
+// until Close is called, it still has to be registered with a registry
+func NewBadgerMetrics(db *badger.DB, interval time.Duration) *BadgerMetrics {
+	if interval <= 0 {
+		interval = defaultMetricsInterval
+	}
+	m := &BadgerMetrics{
+		db: db,
+		tables: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "kvstore",
+			Subsystem: "badger",
+			Name:      "tables",
+			Help:      "Number of SSTables in each level of the LSM tree.",
+		}, []string{"level"}),
+		lsmSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kvstore",
+			Subsystem: "badger",
+			Name:      "lsm_size_bytes",
+			Help:      "Size of the LSM tree on disk.",
+		}),
+		vlogSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kvstore",
+			Subsystem: "badger",
+			Name:      "vlog_size_bytes",
+			Help:      "Size of the value log on disk.",
+		}),
+		gcRuns: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kvstore",
+			Subsystem: "badger",
+			Name:      "vlog_gc_runs_total",
+			Help:      "Number of value log garbage collection runs.",
+		}),
+		gcFails: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kvstore",
+			Subsystem: "badger",
+			Name:      "vlog_gc_failures_total",
+			Help:      "Number of value log garbage collection runs that failed.",
+		}),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	m.sample()
+	go m.run(interval)
+	return m
+}
+
+func (m *BadgerMetrics) run(interval time.Duration) {
+	defer close(m.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.sample()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *BadgerMetrics) sample() {
+	counts := map[int]int{}
+	for _, t := range m.db.Tables(false) {
+		counts[t.Level]++
+	}
+	// levels that emptied since the last sample have to be zeroed
+	m.tables.Reset()
+	for level, n := range counts {
+		m.tables.WithLabelValues(strconv.Itoa(level)).Set(float64(n))
+	}
+
+	lsm, vlog := m.db.Size()
+	m.lsmSize.Set(float64(lsm))
+	m.vlogSize.Set(float64(vlog))
+}
+
+// ValueLogGCRan records a value log garbage collection run
+func (m *BadgerMetrics) ValueLogGCRan() {
+	m.gcRuns.Inc()
+}
+
+// ValueLogGCFailed records a value log garbage collection run that failed,
+// finding nothing to reclaim isn't a failure
+func (m *BadgerMetrics) ValueLogGCFailed() {
+	m.gcFails.Inc()
+}
+
+// Close stops the sampling, it must be called before the db is closed
+func (m *BadgerMetrics) Close() {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+		<-m.done
+	})
+}
+
+func (m *BadgerMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.tables.Describe(ch)
+	m.lsmSize.Describe(ch)
+	m.vlogSize.Describe(ch)
+	m.gcRuns.Describe(ch)
+	m.gcFails.Describe(ch)
+}
+
+func (m *BadgerMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.tables.Collect(ch)
+	m.lsmSize.Collect(ch)
+	m.vlogSize.Collect(ch)
+	m.gcRuns.Collect(ch)
+	m.gcFails.Collect(ch)
+}
+
+// appMetrics are the app's own metrics, registered with the registry given
+// to WithMetrics, the methods are no-ops on a nil *appMetrics so the app
+// doesn't have to check if metrics are enabled
+type appMetrics struct {
+	blocks prometheus.Counter
+	height prometheus.Gauge
+	keys   prometheus.Gauge
+	txs    *prometheus.CounterVec
+	// eventsDropped counts the blocks the event sink lost
+	eventsDropped prometheus.Counter
+	// prefixes is nil unless WithPrefixMetrics is set, see prefixmetrics.go
+	prefixes *prefixMetrics
+}
+
+var _ prometheus.Collector = (*appMetrics)(nil)
+
+func newAppMetrics() *appMetrics {
+	return &appMetrics{
+		blocks: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kvstore",
+			Subsystem: "app",
+			Name:      "blocks_total",
+			Help:      "Number of blocks committed since the app started.",
+		}),
+		height: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kvstore",
+			Subsystem: "app",
+			Name:      "height",
+			Help:      "Height of the last committed block.",
+		}),
+		keys: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kvstore",
+			Subsystem: "app",
+			Name:      "keys",
+			Help:      "Number of keys in the store as of the last committed block.",
+		}),
+		txs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kvstore",
+			Subsystem: "app",
+			Name:      "txs_total",
+			Help:      "Number of delivered transactions by response code.",
+		}, []string{"code"}),
+		eventsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kvstore",
+			Subsystem: "app",
+			Name:      "event_blocks_dropped_total",
+			Help:      "Number of blocks the event sink failed to take, see WithEventSink.",
+		}),
+	}
+}
+
+func (m *appMetrics) txDelivered(code uint32) {
+	if m != nil {
+		m.txs.WithLabelValues(strconv.FormatUint(uint64(code), 10)).Inc()
+	}
+}
+
+func (m *appMetrics) eventBlockDropped() {
+	if m != nil {
+		m.eventsDropped.Inc()
+	}
+}
+
+// setState records the state the app is at without counting a block
+func (m *appMetrics) setState(s state) {
+	if m != nil {
+		m.height.Set(float64(s.Height))
+		m.keys.Set(float64(s.KeyCount))
+	}
+}
+
+func (m *appMetrics) committed(s state) {
+	if m != nil {
+		m.blocks.Inc()
+		m.setState(s)
+	}
+}
+
+func (m *appMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.blocks.Describe(ch)
+	m.height.Describe(ch)
+	m.keys.Describe(ch)
+	m.txs.Describe(ch)
+	m.eventsDropped.Describe(ch)
+	if m.prefixes != nil {
+		m.prefixes.Describe(ch)
+	}
+}
+
+func (m *appMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.blocks.Collect(ch)
+	m.height.Collect(ch)
+	m.keys.Collect(ch)
+	m.txs.Collect(ch)
+	m.eventsDropped.Collect(ch)
+	if m.prefixes != nil {
+		m.prefixes.Collect(ch)
+	}
+}
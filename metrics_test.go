@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestBadgerMetrics(t *testing.T) {
+	// closing the db flushes the block to an SSTable, which the reopened
+	// db reports
+	dir := t.TempDir()
+	opts := badger.DefaultOptions(dir).WithLogger(nil)
+	db, err := badger.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliverBlock(NewKVStoreApplication(db), 1, "a=1", "b=2")
+	db.Close()
+	if db, err = badger.Open(opts); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m := NewBadgerMetrics(db, time.Hour)
+	defer m.Close()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(m)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables float64
+	for _, mf := range mfs {
+		if mf.GetName() == "kvstore_badger_tables" {
+			for _, metric := range mf.Metric {
+				tables += metric.GetGauge().GetValue()
+			}
+		}
+	}
+	if tables == 0 || tables != float64(len(db.Tables(false))) {
+		t.Errorf("got %v tables, badger has %d", tables, len(db.Tables(false)))
+	}
+	if size := testutil.ToFloat64(m.lsmSize); size <= 0 {
+		t.Errorf("got an LSM size of %v", size)
+	}
+	if size := testutil.ToFloat64(m.vlogSize); size <= 0 {
+		t.Errorf("got a value log size of %v", size)
+	}
+	m.ValueLogGCRan()
+	m.ValueLogGCRan()
+	m.ValueLogGCFailed()
+	if runs, fails := testutil.ToFloat64(m.gcRuns), testutil.ToFloat64(m.gcFails); runs != 2 || fails != 1 {
+		t.Errorf("got %v gc runs and %v failures", runs, fails)
+	}
+	// the sizes, the gc counters and a table gauge for the one level
+	if n, err := testutil.GatherAndCount(reg); err != nil || n != 5 {
+		t.Errorf("gathered %d metrics: %v", n, err)
+	}
+
+	// Close stops the sampling and can be called again
+	m.Close()
+	m.Close()
+}
+
+func TestPrefixMetrics(t *testing.T) {
+	app := newTestApp(t, WithMetrics(prometheus.NewRegistry()), WithPrefixMetrics(2))
+	// a/ and b/ get their own label, c/ is over the cap and flat isn't in a
+	// namespace, both are "other"
+	deliverBlock(app, 1, "a/x=12", "a/y=1", "b/x=123", "c/x=1", "flat=1")
+	// a removed key is a write of 0 bytes
+	deliverBlock(app, 2, "a/y=2", "delprefix:a/y")
+	app.Query(abcitypes.RequestQuery{Data: []byte("a/x")})
+	app.Query(abcitypes.RequestQuery{Path: "mget", Data: []byte(`{"keys":["b/x","zz/q"]}`)})
+
+	p := app.metrics.prefixes
+	for _, c := range []struct {
+		name   string
+		metric *prometheus.CounterVec
+		label  string
+		want   float64
+	}{
+		{"writes", p.writes, "a/", 4},
+		{"write bytes", p.writeBytes, "a/", 4},
+		{"writes", p.writes, "b/", 1},
+		{"write bytes", p.writeBytes, "b/", 3},
+		{"writes", p.writes, otherPrefix, 2},
+		{"reads", p.reads, "a/", 1},
+		{"read bytes", p.readBytes, "a/", 2},
+		{"reads", p.reads, "b/", 1},
+		{"read bytes", p.readBytes, "b/", 3},
+		// zz/ is over the cap, and doesn't exist, a read of 0 bytes
+		{"reads", p.reads, otherPrefix, 1},
+		{"read bytes", p.readBytes, otherPrefix, 0},
+	} {
+		if got := testutil.ToFloat64(c.metric.WithLabelValues(c.label)); got != c.want {
+			t.Errorf("%s of %s is %v, want %v", c.name, c.label, got, c.want)
+		}
+	}
+	if n := testutil.CollectAndCount(p, "kvstore_app_prefix_writes_total"); n != 3 {
+		t.Errorf("writes has %d labels, want 3", n)
+	}
+}
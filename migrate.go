@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// MigratePrefix renames a namespace, every key under the old prefix is moved
+// to the new one with the rest of the key kept, 'users/alice' becomes
+// 'accounts/alice' when moving 'users/' to 'accounts/', it writes outside a
+// block like ReplaceAll, see replace.go, so every node has to run the same
+// migration before the chain starts, or on a stopped node that's resynced
+// the keys are moved in chunks of migrateChunkSize, each chunk is its own
+// badger transaction committed at the current height with a new app hash,
+// so a migration of any size fits within badger's transaction size limit,
+// a key is moved with its value, type and expiry through remove and set, so
+// the counters and the indexes follow it, the written time, modified height
+// and version of the new key are those of a new write
+// nothing is moved if any key would land on a key that already exists, or
+// isn't a key a transaction could write, the keys are checked before the
+// first chunk and again in each one, if a chunk fails, or a block starts in
+// between two, the chunks before it stay moved, the migration carries on
+// from where it stopped when it's run again
+
+// migrateChunkSize is the number of keys moved per badger transaction
+const migrateChunkSize = 1000
+
+// migrationTarget returns the key a key under oldPrefix is moved to
+func migrationTarget(key, oldPrefix, newPrefix []byte) []byte {
+	return append(append([]byte{}, newPrefix...), key[len(oldPrefix):]...)
+}
+
+// checkMigration checks every key under oldPrefix as seen by txn can be
+// moved to newPrefix, returns the number of keys to move
+func (app *KVStoreApplication) checkMigration(txn Txn, oldPrefix, newPrefix []byte) (int, error) {
+	opts := DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = oldPrefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	n := 0
+	for it.Rewind(); it.Valid(); it.Next() {
+		key := it.Item().Key()
+		if err := app.checkMigrationTarget(txn, key, migrationTarget(key, oldPrefix, newPrefix)); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// checkMigrationTarget checks key can be moved to target
+func (app *KVStoreApplication) checkMigrationTarget(txn Txn, key, target []byte) error {
+	if err := app.checkKey(target); err != nil {
+		if r, ok := asRejection(err); ok {
+			return fmt.Errorf("key %q can't be moved to %q: %s", key, target, r.log)
+		}
+		return err
+	}
+	_, _, exists, err := lookup(txn, target)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("key %q can't be moved to %q, which already exists", key, target)
+	}
+	return nil
+}
+
+// migrateChunk moves up to migrateChunkSize keys from oldPrefix to
+// newPrefix through the current batch, returns the number moved
+func (app *KVStoreApplication) migrateChunk(oldPrefix, newPrefix []byte) (int, error) {
+	var keys [][]byte
+	opts := DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = oldPrefix
+	it := app.currentBatch.NewIterator(opts)
+	for it.Rewind(); it.Valid() && len(keys) < migrateChunkSize; it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+
+	for _, key := range keys {
+		target := migrationTarget(key, oldPrefix, newPrefix)
+		if err := app.checkMigrationTarget(app.currentBatch, key, target); err != nil {
+			return 0, err
+		}
+		value, ct, _, err := lookup(app.currentBatch, key)
+		if err != nil {
+			return 0, err
+		}
+		expiry, err := readExpiry(app.currentBatch, key)
+		if err != nil {
+			return 0, err
+		}
+		if err := app.remove(key); err != nil {
+			return 0, err
+		}
+		if err := app.set(target, value, ct); err != nil {
+			if r, ok := asRejection(err); ok {
+				return 0, fmt.Errorf("key %q can't be moved to %q: %s", key, target, r.log)
+			}
+			return 0, err
+		}
+		if expiry != 0 {
+			if err := app.setExpiry(target, expiry); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(keys), nil
+}
+
+// MigratePrefix moves every key under oldPrefix to newPrefix, see
+// migrate.go, it can only be run between blocks
+// the prefixes are normalized like keys, they can't be empty, overlap or
+// reach into the app's own bookkeeping
+// like ReplaceAll it's for a chain that hasn't started, see replace.go
+func (app *KVStoreApplication) MigratePrefix(oldPrefix, newPrefix []byte) error {
+	if app.replica {
+		return ErrReplica
+	}
+	if app.inBlock() {
+		return ErrBlockInProgress
+	}
+	if err := app.checkRewrite(); err != nil {
+		return err
+	}
+	if app.appendOnly {
+		return errors.New("keys can't be moved in append only mode")
+	}
+	oldPrefix, newPrefix = app.normalizeKey(oldPrefix), app.normalizeKey(newPrefix)
+	if len(oldPrefix) == 0 || len(newPrefix) == 0 {
+		return errors.New("prefixes can't be empty")
+	}
+	if bytes.HasPrefix(oldPrefix, newPrefix) || bytes.HasPrefix(newPrefix, oldPrefix) {
+		return errors.New("the prefixes overlap")
+	}
+	for _, prefix := range [][]byte{oldPrefix, newPrefix} {
+		if isInternalKey(prefix) || bytes.HasPrefix(internalPrefix, prefix) {
+			return fmt.Errorf("prefix %q covers internal keys", prefix)
+		}
+	}
+	defer app.noteActivity()
+	// blocks left unflushed by commit batching go first
+	if err := app.flush(); err != nil {
+		return err
+	}
+
+	var total int
+	err := app.store.View(func(txn Txn) (err error) {
+		total, err = app.checkMigration(txn, oldPrefix, newPrefix)
+		return err
+	})
+	if err != nil || total == 0 {
+		return err
+	}
+
+	moved := 0
+	for moved < total {
+		if app.inBlock() {
+			return ErrBlockInProgress
+		}
+		var n int
+		err := app.commitOutsideBlock(false, func() (err error) {
+			n, err = app.migrateChunk(oldPrefix, newPrefix)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		moved += n
+		if n < migrateChunkSize {
+			break
+		}
+	}
+	app.logger.Info("migrated prefix", "from", string(oldPrefix), "to", string(newPrefix), "keys", moved,
+		"height", app.committed.Height, "app_hash", fmt.Sprintf("%X", app.committed.AppHash))
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestMigratePrefix(t *testing.T) {
+	store := NewMemStore()
+	app := NewKVStoreApplicationWithStore(store, WithMerkleAppHash(true))
+	// more keys than fit in one chunk
+	var txs []string
+	for i := 0; i < migrateChunkSize*2+500; i++ {
+		txs = append(txs, fmt.Sprintf("users/%04d=v%d", i, i))
+	}
+	deliverBlock(app, 1, append(txs, "users/tmp=x;ttl=5", "accounts/0001=taken")...)
+	if err := app.MigratePrefix([]byte("users/"), []byte("members/")); err != ErrChainStarted {
+		t.Fatalf("got %v, want ErrChainStarted", err)
+	}
+
+	app = NewKVStoreApplicationWithStore(store, WithMerkleAppHash(true), WithOfflineRewrites(true))
+	before := app.committed.AppHash
+	if err := app.MigratePrefix([]byte("users/"), []byte("accounts/")); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("a colliding migration got %v", err)
+	}
+	if err := app.MigratePrefix([]byte("users/"), []byte("users/x/")); err == nil {
+		t.Fatal("overlapping prefixes were accepted")
+	}
+	if !bytes.Equal(app.committed.AppHash, before) {
+		t.Fatal("a refused migration changed the app hash")
+	}
+
+	if err := app.MigratePrefix([]byte("users/"), []byte("members/")); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(app.committed.AppHash, before) || app.committed.KeyCount != int64(len(txs)+2) {
+		t.Errorf("migrated to %d keys", app.committed.KeyCount)
+	}
+	if _, exists, _ := app.get([]byte("users/0005")); exists {
+		t.Error("an old key is still there")
+	}
+	if value, _, _ := app.get([]byte("members/2499")); string(value) != "v2499" {
+		t.Errorf("members/2499 is %q", value)
+	}
+	var meta metaResponse
+	queryJSON(t, app, "meta", []byte("members/tmp"), &meta)
+	if meta.ExpiresAt != 6 {
+		t.Errorf("the expiry didn't move with the key: %v", meta.ExpiresAt)
+	}
+	res := app.Query(abcitypes.RequestQuery{Path: "keyproof", Data: []byte("members/0001")})
+	if res.Code != 0 || !VerifyProof(app.committed.AppHash, []byte("members/0001"), []byte("v1"), *res.ProofOps) {
+		t.Errorf("a moved key doesn't verify against the app hash: %s", res.Log)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// With the modification index (WithModIndex) the height every key was last
+// written at is recorded, along with an index by height, so the least and
+// most recently modified keys can be found without scanning the store
+//
+//	modifiedPrefix       | key                  -> height it was last written at
+//	modifiedHeightPrefix | height (8 bytes) key -> nothing, ordered by height
+//
+// removing a key removes its records, keys written before the index was
+// enabled aren't in it until they're written again
+
+var (
+	modifiedPrefix       = internalKey("mod/")
+	modifiedHeightPrefix = internalKey("modh/")
+)
+
+func modifiedKey(key []byte) []byte {
+	return append(append([]byte{}, modifiedPrefix...), key...)
+}
+
+func modifiedHeightKey(height int64, key []byte) []byte {
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], uint64(height))
+	return append(append(append([]byte{}, modifiedHeightPrefix...), h[:]...), key...)
+}
+
+// readModified returns the height the key was last written at as seen by
+// txn, found is false if it isn't in the index, a key written by the genesis
+// or outside a block before the first one is at height 0
+func readModified(txn Txn, key []byte) (height int64, found bool, err error) {
+	item, err := txn.Get(modifiedKey(key))
+	if err == ErrKeyNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	err = item.Value(func(val []byte) error {
+		height = int64(binary.BigEndian.Uint64(val))
+		return nil
+	})
+	return height, err == nil, err
+}
+
+// clearModified removes the key from the modification index
+func (app *KVStoreApplication) clearModified(key []byte) error {
+	if !app.modIndex {
+		return nil
+	}
+	height, found, err := readModified(app.currentBatch, key)
+	if err != nil || !found {
+		return err
+	}
+	if err := app.currentBatch.Delete(modifiedHeightKey(height, key)); err != nil {
+		return err
+	}
+	return app.currentBatch.Delete(modifiedKey(key))
+}
+
+// recordModified records the key as written in the current block
+func (app *KVStoreApplication) recordModified(key []byte) error {
+	if !app.modIndex {
+		return nil
+	}
+	if err := app.clearModified(key); err != nil {
+		return err
+	}
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], uint64(app.pending.Height))
+	if err := app.currentBatch.Set(modifiedKey(key), h[:]); err != nil {
+		return err
+	}
+	return app.currentBatch.Set(modifiedHeightKey(app.pending.Height, key), nil)
+}
+
+type modifiedKeyResponse struct {
+	Key    string `json:"key"`
+	Height int64  `json:"height"`
+}
+
+type extremesResponse struct {
+	// both are left out when no key is in the index
+	Oldest *modifiedKeyResponse `json:"oldest,omitempty"`
+	Newest *modifiedKeyResponse `json:"newest,omitempty"`
+}
+
+// queryExtremes returns the least and most recently modified keys, with the
+// height they were last written at, keys written at the same height are
+// ordered by key, it reads the two ends of the height index so it's cheap
+// only available with the modification index
+func (app *KVStoreApplication) queryExtremes(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if !app.modIndex {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "the modification index is disabled"
+		return
+	}
+
+	var eres extremesResponse
+	err := app.store.View(func(txn Txn) error {
+		eres.Oldest = firstModified(txn, false)
+		eres.Newest = firstModified(txn, true)
+		return nil
+	})
+	if err != nil {
+		halt("Query", err)
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, eres)
+	return
+}
+
+// firstModified returns the first entry of the height index, or the last
+// one in reverse, nil if it's empty
+func firstModified(txn Txn, reverse bool) *modifiedKeyResponse {
+	opts := DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = modifiedHeightPrefix
+	opts.Reverse = reverse
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	seek := modifiedHeightPrefix
+	if reverse {
+		seek = prefixEnd(modifiedHeightPrefix)
+	}
+	it.Seek(seek)
+	if !it.Valid() {
+		return nil
+	}
+	key := it.Item().Key()[len(modifiedHeightPrefix):]
+	return &modifiedKeyResponse{
+		Key:    string(key[8:]),
+		Height: int64(binary.BigEndian.Uint64(key[:8])),
+	}
+}
+
+// maxModifiedKeys bounds the keys a modified query can look up, only the
+// index is read, never a value, so it's higher than mget's
+const maxModifiedKeys = 1000
+
+type modifiedRequest struct {
+	Keys []string `json:"keys"`
+}
+
+type modifiedEntry struct {
+	Key string `json:"key"`
+	// Height is left out if the key isn't in the index, it doesn't exist
+	// or it hasn't been written since the index was enabled
+	Height *int64 `json:"height,omitempty"`
+	Exists bool   `json:"exists"`
+}
+
+type modifiedResponse struct {
+	// Height is the height every key was read at
+	Height int64           `json:"height"`
+	Keys   []modifiedEntry `json:"keys"`
+}
+
+// queryModified returns the height each key was last written at, e.g.
+// {"keys": ["a", "b"]}, in the order of the keys, so a client that synced at
+// some height only has to fetch the ones written after it, the heights are
+// all read as of the same block, like mget's values, and a key that's gone
+// has exists false, only available with the modification index
+func (app *KVStoreApplication) queryModified(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if !app.modIndex {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "the modification index is disabled"
+		return
+	}
+	var mreq modifiedRequest
+	if !parseRequest(req, &res, &mreq) {
+		return
+	}
+	if len(mreq.Keys) == 0 || len(mreq.Keys) > maxModifiedKeys {
+		res.Code = QUERY_INVALID
+		res.Log = fmt.Sprintf("modified takes between 1 and %d keys", maxModifiedKeys)
+		return
+	}
+
+	mres := modifiedResponse{Keys: make([]modifiedEntry, len(mreq.Keys))}
+	err := app.store.View(func(txn Txn) error {
+		s, err := readState(txn)
+		if err != nil {
+			return err
+		}
+		mres.Height = s.Height
+		for i, k := range mreq.Keys {
+			key := app.normalizeKey([]byte(k))
+			e := &mres.Keys[i]
+			e.Key = string(key)
+			height, found, err := readModified(txn, key)
+			if err != nil {
+				return err
+			}
+			if found {
+				e.Height = &height
+			}
+			// a key that's in the index exists, removing it removes its
+			// records
+			e.Exists = found
+			if !e.Exists && len(key) > 0 && !isInternalKey(key) {
+				_, err := txn.Get(key)
+				if err != nil && err != ErrKeyNotFound {
+					return err
+				}
+				e.Exists = err == nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		halt("Query", err)
+	}
+
+	res.Height = mres.Height
+	respondJSON(&res, mres)
+	return
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// queryJSON runs a query and decodes its response into v
+func queryJSON(t *testing.T, app *KVStoreApplication, path string, data []byte, v interface{}) {
+	t.Helper()
+	res := app.Query(abcitypes.RequestQuery{Path: path, Data: data})
+	if res.Code != 0 {
+		t.Fatalf("%s query got code %d: %s", path, res.Code, res.Log)
+	}
+	if err := json.Unmarshal(res.Value, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenesisKeyLeavesModIndex(t *testing.T) {
+	app := newTestApp(t, WithModIndex(true), WithTimeIndex(0))
+	app.InitChain(abcitypes.RequestInitChain{AppStateBytes: []byte(`[{"key": "g", "value": "1"}]`)})
+
+	var mres modifiedResponse
+	queryJSON(t, app, "modified", []byte(`{"keys": ["g"]}`), &mres)
+	if h := mres.Keys[0].Height; h == nil || *h != 0 {
+		t.Fatalf("the genesis key isn't at height 0: %v", h)
+	}
+
+	deliverBlock(app, 1, "g=2")
+	var eres extremesResponse
+	queryJSON(t, app, "extremes", nil, &eres)
+	if eres.Oldest == nil || eres.Oldest.Key != "g" || eres.Oldest.Height != 1 {
+		t.Errorf("oldest is %+v, want g at height 1", eres.Oldest)
+	}
+	app.store.View(func(txn Txn) error {
+		if _, err := txn.Get(modifiedHeightKey(0, []byte("g"))); err != ErrKeyNotFound {
+			t.Error("the height 0 entry is still in the index")
+		}
+		opts := DefaultIteratorOptions
+		opts.Prefix = writtenTimePrefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		n := 0
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+		}
+		if n != 1 {
+			t.Errorf("the time index has %d entries for g, want 1", n)
+		}
+		return nil
+	})
+}
+
+func TestGenesisKeyETag(t *testing.T) {
+	app := newTestApp(t, WithModIndex(true), WithGatewayETags(true, 0))
+	app.InitChain(abcitypes.RequestInitChain{AppStateBytes: []byte(`[{"key": "g", "value": "1"}]`)})
+	g := NewGateway(app)
+	get := func(etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/key?key=g", nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, r)
+		return w
+	}
+
+	etag := get("").Header().Get("ETag")
+	if w := get(etag); w.Code != 304 {
+		t.Errorf("an unchanged key got %d, want 304", w.Code)
+	}
+	// the same value at a new height
+	deliverBlock(app, 1, "g=2")
+	deliverBlock(app, 2, "g=1")
+	w := get(etag)
+	if w.Code != 200 || w.Header().Get("ETag") == etag {
+		t.Errorf("a rewritten key got %d with ETag %s", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestModifiedQuery(t *testing.T) {
+	app := newTestApp(t, WithModIndex(true))
+	deliverBlock(app, 1, "a=1", "b=1", "c=1")
+	deliverBlock(app, 2, "a=2")
+	deliverBlock(app, 3, "delprefix:b")
+
+	var mres modifiedResponse
+	queryJSON(t, app, "modified", []byte(`{"keys": ["c", "a", "b", "missing"]}`), &mres)
+	if mres.Height != 3 || len(mres.Keys) != 4 {
+		t.Fatalf("got %+v", mres)
+	}
+	// in the order asked for, with the heights the meta query reports
+	for i, want := range []struct {
+		key    string
+		height int64
+		exists bool
+	}{{"c", 1, true}, {"a", 2, true}, {"b", 0, false}, {"missing", 0, false}} {
+		e := mres.Keys[i]
+		if e.Key != want.key || e.Exists != want.exists || (e.Height != nil) != want.exists {
+			t.Errorf("entry %d is %+v, want %+v", i, e, want)
+			continue
+		}
+		if e.Height == nil {
+			continue
+		}
+		if *e.Height != want.height {
+			t.Errorf("%s is at height %d, want %d", e.Key, *e.Height, want.height)
+		}
+		if meta := keyMeta(t, app, e.Key); meta.ModifiedAt == nil || *meta.ModifiedAt != *e.Height {
+			t.Errorf("%s is at height %d, its meta says %v", e.Key, *e.Height, meta.ModifiedAt)
+		}
+	}
+
+	keys := make([]string, maxModifiedKeys+1)
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+	}
+	for _, data := range []string{`{"keys": []}`, fmt.Sprintf(`{"keys": ["%s"]}`, strings.Join(keys, `","`))} {
+		if res := app.Query(abcitypes.RequestQuery{Path: "modified", Data: []byte(data)}); res.Code != QUERY_INVALID {
+			t.Errorf("%.20s got code %d, want QUERY_INVALID", data, res.Code)
+		}
+	}
+	if res := newTestApp(t).Query(abcitypes.RequestQuery{Path: "modified", Data: []byte(`{"keys": ["a"]}`)}); res.Code != QUERY_NOT_ALLOWED {
+		t.Errorf("modified without the index got code %d", res.Code)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// With no-op rejection (WithRejectNoOps) a transaction is rejected with
+// NO_OP_WRITE if any of its writes sets a key to the value, and type, it
+// already has, it would take up space in a block and change nothing, unlike
+// the duplicate check, which only rejects a transaction whose writes all are,
+// '\x00ba=1\nb=2' with a already 1 is rejected too, the duplicate check goes
+// first, a transaction it rejects keeps its DUPLICATE_TX, which is every
+// 'key=value' transaction that's a no-op, so it's batches, and ops, that are
+// checked
+// a write with a ttl or delete_at isn't a no-op, it sets the key's expiry,
+// an op is checked through the writes it says it would make, see txOp, one
+// that doesn't say never is
+// it's checked in CheckTx and DeliverTx alike, so it's part of consensus,
+// every node has to have the same setting, a retry whose idempotency key was
+// already used goes through as before, see idempotency.go, that check comes
+// first, and a rejected no-op doesn't use up its key
+
+// checkNoOp rejects t if one of its writes changes nothing as seen by txn
+func (app *KVStoreApplication) checkNoOp(txn Txn, t transaction) error {
+	if !app.rejectNoOps {
+		return nil
+	}
+	writes := t.writes()
+	if t.op != nil {
+		if t.op.writes == nil {
+			return nil
+		}
+		var err error
+		if writes, err = t.op.writes(app, txn, t); err != nil {
+			return err
+		}
+	}
+	for _, w := range writes {
+		if w.expires() {
+			continue
+		}
+		value, ct, exists, err := lookup(txn, w.key)
+		if err != nil {
+			return err
+		}
+		if exists && ct == w.contentType && bytes.Equal(value, w.value) {
+			return reject(NO_OP_WRITE, fmt.Sprintf("%q is already set to that value, the write changes nothing", w.key))
+		}
+	}
+	return nil
+}
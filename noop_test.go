@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestRejectNoOps(t *testing.T) {
+	batch := func(lines ...string) string {
+		var b [][]byte
+		for _, l := range lines {
+			b = append(b, []byte(l))
+		}
+		return string(EncodeBatch(b...))
+	}
+	// the writes that change nothing, with a=1 and n=5
+	noOps := []string{batch("a=1", "c=3"), "mset:1:a,d", "getset:a:1", "swap:a:a", "incr:n:0"}
+	changes := []string{"a=2", "a=1;ttl=5", "cp:a:e", batch("a=2", "c=3")}
+
+	for _, on := range []bool{false, true} {
+		app := newTestApp(t, WithRejectNoOps(on), WithIdempotencyKeys(100))
+		deliverBlock(app, 1, "a=1", "b=2", "n=5;type=int")
+		check := func(tx string) uint32 {
+			return app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}).Code
+		}
+
+		want := uint32(VALID_TX)
+		if on {
+			want = NO_OP_WRITE
+		}
+		for _, tx := range noOps {
+			if code := check(tx); code != want {
+				t.Errorf("rejecting no-ops %v: %q got code %d, want %d", on, tx, code, want)
+			}
+		}
+		for _, tx := range changes {
+			if code := check(tx); code != VALID_TX {
+				t.Errorf("rejecting no-ops %v: %q got code %d", on, tx, code)
+			}
+		}
+		// an exact duplicate keeps its code
+		if code := check("a=1"); code != DUPLICATE_TX {
+			t.Errorf("rejecting no-ops %v: a duplicate got code %d", on, code)
+		}
+		if r := deliverBlock(app, 2, "getset:a:1")[0]; r.Code != want {
+			t.Errorf("rejecting no-ops %v: DeliverTx of a no-op got code %d", on, r.Code)
+		}
+
+		// a retry of an applied idempotency key goes through as before,
+		// though its writes are now no-ops
+		deliverBlock(app, 3, batch("x=1", "y=1;idempotency_key=k"))
+		if r := deliverBlock(app, 4, batch("x=1", "y=1;idempotency_key=k"))[0]; r.Code != VALID_TX {
+			t.Errorf("rejecting no-ops %v: a retry got code %d: %s", on, r.Code, r.Log)
+		}
+	}
+}
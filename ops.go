@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Besides 'key=value' a transaction can be an op of the format 'name:args'
+// with the arguments separated by ':' e.g. 'swap:a:b'
+//
+// An op never contains '=', every transaction without exactly one '=' used
+// to be invalid, so ops can't change the meaning of an existing transaction
+// it also means op arguments can't contain '=' or ':', keys that do can
+// only be written with 'key=value'
+// ops don't take options, ';name=value' on the end would turn them back
+// into a 'key=value' transaction
+
+// txOp is an operation a transaction can run
+type txOp struct {
+	name string
+	// args is the number of arguments the op takes, keys are the indexes
+	// of the ones that are keys, they get the same normalization and checks
+	// as the key of a 'key=value' transaction
+	args int
+	keys []int
+	// rest makes the last argument everything after the ones before it,
+	// ':' included
+	rest bool
+	// optional is the number of arguments at the end that can be left
+	// out, they're either all there or none of them are
+	optional int
+	// parse, if set, validates the arguments that aren't keys, it can
+	// only reject the format, as it's called without any state
+	parse func(args [][]byte) error
+	// check validates the op against the state visible to txn, in CheckTx
+	// that's the committed state, in DeliverTx it's the current batch
+	check func(app *KVStoreApplication, txn Txn, t transaction) error
+	// apply runs a checked op against the current batch, it must not
+	// write anything if it's going to reject the transaction
+	apply func(app *KVStoreApplication, t transaction) error
+	// removes is set if the op only ever removes keys, it's still
+	// accepted when the disk guard rejects writes
+	removes bool
+	// enabled, if set, says whether the app's options make the op
+	// available, one that isn't is rejected like a disabled op
+	enabled func(app *KVStoreApplication) bool
+	// returnsPrevious records the values the op overwrote in its
+	// receipt, see txindex.go
+	returnsPrevious bool
+	// batch, if set, makes the op short for the batch it returns, it's
+	// turned into the batch once it's parsed, and from then on limited,
+	// checked and applied like one, see batch.go
+	batch func(args [][]byte) []transaction
+	// writes, if set, returns the 'key=value' writes a checked op would
+	// make as seen by txn, for the no-op check, see noop.go
+	writes func(app *KVStoreApplication, txn Txn, t transaction) ([]transaction, error)
+}
+
+var txOps = map[string]*txOp{}
+
+// opEnabled returns whether op can be used on this node
+func (app *KVStoreApplication) opEnabled(op *txOp) bool {
+	return !app.disabledOps[op.name] && (op.enabled == nil || op.enabled(app))
+}
+
+func registerOp(op *txOp) {
+	txOps[op.name] = op
+}
+
+// UnknownOpPolicy decides what happens to a transaction that looks like an
+// op this node doesn't know, 'name:args' without an '=', e.g. one sent by a
+// client newer than the node
+type UnknownOpPolicy int
+
+const (
+	// UnknownOpReject rejects it with INVALID_FORMAT
+	UnknownOpReject UnknownOpPolicy = iota
+	// UnknownOpWrite writes the whole transaction as a key with an empty
+	// value, 'newop:a:b' is taken as 'newop:a:b=', so nothing the client
+	// sent is lost and the keys can be found with a prefix query later
+	// every node has to run with the same policy and know the same ops, a
+	// node that knows the op applies it instead, which forks the state,
+	// so it's for a client upgraded before the network, not for a
+	// network that's halfway through an upgrade
+	UnknownOpWrite
+)
+
+// isUnknownOp returns true if tx looks like an op but isn't a known one
+// the name has to be made of lowercase letters, digits and '_' like the
+// names of the known ops, so a key with a ':' in it isn't taken for an op
+// batches are never taken for one, their lines are checked on their own
+func isUnknownOp(tx []byte) bool {
+	if bytes.ContainsAny(tx, "=\n") {
+		return false
+	}
+	i := bytes.IndexByte(tx, ':')
+	if i <= 0 {
+		return false
+	}
+	for _, c := range tx[:i] {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+	_, known := txOps[string(tx[:i])]
+	return !known
+}
+
+// parseOp parses an op transaction, returns false if tx isn't one
+func parseOp(tx []byte, t *transaction) (bool, error) {
+	if bytes.Contains(tx, []byte("=")) {
+		return false, nil
+	}
+	i := bytes.IndexByte(tx, ':')
+	if i < 0 {
+		return false, nil
+	}
+	op, ok := txOps[string(tx[:i])]
+	if !ok {
+		return false, nil
+	}
+
+	var args [][]byte
+	if op.rest {
+		args = bytes.SplitN(tx[i+1:], []byte(":"), op.args)
+	} else {
+		args = bytes.Split(tx[i+1:], []byte(":"))
+	}
+	return true, setOp(op, args, t)
+}
+
+// setOp checks the arguments of op and sets them on t
+func setOp(op *txOp, args [][]byte, t *transaction) error {
+	if len(args) != op.args && (op.optional == 0 || len(args) != op.args-op.optional) {
+		if op.optional > 0 {
+			return reject(INVALID_FORMAT, fmt.Sprintf("%s takes %d or %d arguments", op.name, op.args-op.optional, op.args))
+		}
+		return reject(INVALID_FORMAT, fmt.Sprintf("%s takes %d arguments", op.name, op.args))
+	}
+	for _, i := range op.keys {
+		if len(args[i]) == 0 {
+			return reject(INVALID_FORMAT, op.name+" keys can't be empty")
+		}
+	}
+	if op.parse != nil {
+		if err := op.parse(args); err != nil {
+			return err
+		}
+	}
+	t.op, t.args = op, args
+	return nil
+}
+
+// lookup reads a key as seen by txn, the value is only valid inside txn
+// an empty key never exists, badger returns an error for it
+func lookup(txn Txn, key []byte) (value []byte, ct contentType, exists bool, err error) {
+	if len(key) == 0 {
+		return nil, 0, false, nil
+	}
+	item, err := txn.Get(key)
+	if err == ErrKeyNotFound {
+		return nil, 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	value, err = itemValue(item)
+	return value, itemType(item), err == nil, err
+}
+
+// swap:a:b exchanges the values, and their types, of two existing keys
+// it's rejected if either key doesn't exist, swapping a key with itself
+// is allowed and changes nothing
+func init() {
+	registerOp(&txOp{
+		name: "swap",
+		args: 2,
+		keys: []int{0, 1},
+		check: func(app *KVStoreApplication, txn Txn, t transaction) error {
+			for _, key := range t.args {
+				_, _, exists, err := lookup(txn, key)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					return reject(MISSING_KEY, fmt.Sprintf("can't swap %q, it doesn't exist", key))
+				}
+			}
+			if app.appendOnly {
+				return errOverwriteForbidden
+			}
+			return nil
+		},
+		apply: func(app *KVStoreApplication, t transaction) error {
+			a, b := t.args[0], t.args[1]
+			aValue, aType, _, err := lookup(app.currentBatch, a)
+			if err != nil {
+				return err
+			}
+			bValue, bType, _, err := lookup(app.currentBatch, b)
+			if err != nil {
+				return err
+			}
+			if err := app.set(a, bValue, bType); err != nil {
+				return err
+			}
+			return app.set(b, aValue, aType)
+		},
+		writes: func(app *KVStoreApplication, txn Txn, t transaction) ([]transaction, error) {
+			a, b := t.args[0], t.args[1]
+			aValue, aType, _, err := lookup(txn, a)
+			if err != nil {
+				return nil, err
+			}
+			bValue, bType, _, err := lookup(txn, b)
+			if err != nil {
+				return nil, err
+			}
+			return []transaction{{key: a, value: bValue, contentType: bType}, {key: b, value: aValue, contentType: aType}}, nil
+		},
+	})
+}
+
+// getset:key:value sets key to value and records the value it had in the
+// transaction's receipt, so a "tx" query for the transaction returns both,
+// a key that didn't exist has no previous value, the value is everything
+// after the key, ':' included, and is stored as bytes
+func init() {
+	registerOp(&txOp{
+		name:            "getset",
+		args:            2,
+		keys:            []int{0},
+		rest:            true,
+		returnsPrevious: true,
+		check: func(app *KVStoreApplication, txn Txn, t transaction) error {
+			_, _, exists, err := lookup(txn, t.args[0])
+			if err != nil {
+				return err
+			}
+			if exists && app.appendOnly {
+				return errOverwriteForbidden
+			}
+			return nil
+		},
+		apply: func(app *KVStoreApplication, t transaction) error {
+			return app.set(t.args[0], t.args[1], typeBytes)
+		},
+		writes: func(app *KVStoreApplication, txn Txn, t transaction) ([]transaction, error) {
+			return []transaction{{key: t.args[0], value: t.args[1]}}, nil
+		},
+	})
+}
+
+// cp:src:dst[:overwrite] copies the value, and type, of src to dst, it's
+// rejected with MISSING_KEY if src doesn't exist, and with KEY_EXISTS if
+// dst does, unless the last argument is 'overwrite', dst is written like
+// any other key, so it gets its own modified height and index entries, an
+// expiry on src isn't copied, dst doesn't expire
+func init() {
+	registerOp(&txOp{
+		name:     "cp",
+		args:     3,
+		optional: 1,
+		keys:     []int{0, 1},
+		parse: func(args [][]byte) error {
+			if len(args) == 3 && string(args[2]) != "overwrite" {
+				return reject(INVALID_FORMAT, "the last argument of cp can only be overwrite")
+			}
+			return nil
+		},
+		check: func(app *KVStoreApplication, txn Txn, t transaction) error {
+			src, dst := t.args[0], t.args[1]
+			_, _, exists, err := lookup(txn, src)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return reject(MISSING_KEY, fmt.Sprintf("can't copy %q, it doesn't exist", src))
+			}
+			_, _, exists, err = lookup(txn, dst)
+			if err != nil {
+				return err
+			}
+			if exists && len(t.args) < 3 {
+				return reject(KEY_EXISTS, fmt.Sprintf("can't copy to %q, it already exists", dst))
+			}
+			if exists && app.appendOnly {
+				return errOverwriteForbidden
+			}
+			return nil
+		},
+		apply: func(app *KVStoreApplication, t transaction) error {
+			value, ct, _, err := lookup(app.currentBatch, t.args[0])
+			if err != nil {
+				return err
+			}
+			return app.set(t.args[1], value, ct)
+		},
+		writes: func(app *KVStoreApplication, txn Txn, t transaction) ([]transaction, error) {
+			value, ct, _, err := lookup(txn, t.args[0])
+			return []transaction{{key: t.args[1], value: value, contentType: ct}}, err
+		},
+	})
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// typedValue reads a committed key along with its content type
+func typedValue(t *testing.T, app *KVStoreApplication, key string) (string, contentType) {
+	t.Helper()
+	var value []byte
+	var ct contentType
+	err := app.store.View(func(txn Txn) (err error) {
+		value, ct, _, err = lookup(txn, []byte(key))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(value), ct
+}
+
+func TestSwap(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "a=1", "b=2;type=int")
+
+	// the second swap sees the first, and swapping a key with itself is allowed
+	res := deliverBlock(app, 2, "swap:a:b", "c=3", "swap:b:c", "swap:a:a")
+	for i, r := range res {
+		if r.Code != VALID_TX {
+			t.Fatalf("tx %d got code %d: %s", i, r.Code, r.Log)
+		}
+	}
+	for key, want := range map[string]struct {
+		value string
+		ct    contentType
+	}{"a": {"2", typeInt}, "b": {"3", typeBytes}, "c": {"1", typeBytes}} {
+		if value, ct := typedValue(t, app, key); value != want.value || ct != want.ct {
+			t.Errorf("%s is %q of type %s, want %q of type %s", key, value, ct, want.value, want.ct)
+		}
+	}
+	if app.committed.KeyCount != 3 {
+		t.Errorf("swaps changed the key count to %d", app.committed.KeyCount)
+	}
+}
+
+func TestSwapMissingKey(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "a=1")
+	for _, tx := range []string{"swap:a:x", "swap:x:a", "swap:x:y"} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}); r.Code != MISSING_KEY {
+			t.Errorf("CheckTx %q got code %d, want MISSING_KEY", tx, r.Code)
+		}
+		if r := deliverBlock(app, app.committed.Height+1, tx)[0]; r.Code != MISSING_KEY {
+			t.Errorf("DeliverTx %q got code %d, want MISSING_KEY", tx, r.Code)
+		}
+	}
+	if value, _ := typedValue(t, app, "a"); value != "1" {
+		t.Errorf("a rejected swap changed a to %q", value)
+	}
+
+	for tx, want := range map[string]uint32{"swap:a": INVALID_FORMAT, "swap:a:\x00state": RESERVED_KEY} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}); r.Code != want {
+			t.Errorf("%q got code %d, want %d", tx, r.Code, want)
+		}
+	}
+	// with an '=' it's a plain write to the key "swap:a"
+	deliverBlock(app, app.committed.Height+1, "swap:a=b")
+	if value, _ := typedValue(t, app, "swap:a"); value != "b" {
+		t.Errorf("swap:a is %q", value)
+	}
+}
+
+func TestDisabledOps(t *testing.T) {
+	app := newTestApp(t, WithDisabledOps("swap", "expr"))
+	deliverBlock(app, 1, "a=1", "b=2")
+	for _, c := range []struct {
+		tx   string
+		code uint32
+	}{
+		{"swap:a:b", OP_DISABLED},
+		{"expr:a:+1", OP_DISABLED},
+		{"push:l:x", VALID_TX},
+		{"cp:a:c", VALID_TX},
+		{"c=1", VALID_TX},
+	} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(c.tx)}); r.Code != c.code {
+			t.Errorf("CheckTx %s got code %d, want %d: %s", c.tx, r.Code, c.code, r.Log)
+		}
+	}
+	res := deliverBlock(app, 2, "swap:a:b", "push:l:x")
+	if res[0].Code != OP_DISABLED || res[1].Code != VALID_TX {
+		t.Errorf("DeliverTx got codes %d and %d", res[0].Code, res[1].Code)
+	}
+	if value, _, _ := app.get([]byte("a")); string(value) != "1" {
+		t.Errorf("the disabled swap changed a to %q", value)
+	}
+
+	if msg := halts(func() { newTestApp(t, WithDisabledOps("nope")) }); msg == "" {
+		t.Error("disabling an unknown op didn't fail")
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	app := newTestApp(t, WithTxIndex(0))
+	deliverBlock(app, 1, "getset:a:1", "b=2")
+	// the value is everything after the key, ':' included
+	deliverBlock(app, 2, "getset:a:x:y", "getset:b:3")
+
+	previous := func(s string) *string { return &s }
+	for tx, want := range map[string]*string{
+		"getset:a:1":   nil,
+		"getset:a:x:y": previous("1"),
+		"getset:b:3":   previous("2"),
+	} {
+		r, ok := receipt(t, app, tx)
+		if !ok || r.Code != VALID_TX || len(r.Changes) != 1 {
+			t.Fatalf("receipt of %s is %+v", tx, r)
+		}
+		got := r.Changes[0].Previous
+		if (got == nil) != (want == nil) || (got != nil && *got != *want) {
+			t.Errorf("%s previous value is %v, want %v", tx, got, want)
+		}
+	}
+	if r, _ := receipt(t, app, "getset:a:x:y"); r.Changes[0].Key != "a" || r.Changes[0].Value != "x:y" {
+		t.Errorf("getset wrote %+v", r.Changes[0])
+	}
+	if value, _ := typedValue(t, app, "a"); value != "x:y" {
+		t.Errorf("a is %q", value)
+	}
+	// a plain write doesn't record what it overwrote
+	deliverBlock(app, 3, "b=4")
+	if r, _ := receipt(t, app, "b=4"); r.Changes[0].Previous != nil {
+		t.Errorf("plain write recorded previous value %q", *r.Changes[0].Previous)
+	}
+}
+
+func TestUnknownOps(t *testing.T) {
+	var hashes [][]byte
+	for _, policy := range []UnknownOpPolicy{UnknownOpReject, UnknownOpWrite, UnknownOpWrite} {
+		app := newTestApp(t, WithUnknownOps(policy))
+		want := uint32(INVALID_FORMAT)
+		if policy == UnknownOpWrite {
+			want = VALID_TX
+		}
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("newop:a:b")}); r.Code != want {
+			t.Errorf("policy %d: CheckTx got code %d, want %d", policy, r.Code, want)
+		}
+		// a name that couldn't be an op, and a malformed known op, are
+		// rejected whatever the policy
+		res := deliverBlock(app, 1, "newop:a:b", "New Op:a", "swap:a")
+		for i, want := range []uint32{want, INVALID_FORMAT, INVALID_FORMAT} {
+			if res[i].Code != want {
+				t.Errorf("policy %d: tx %d got code %d, want %d: %s", policy, i, res[i].Code, want, res[i].Log)
+			}
+		}
+
+		// the whole transaction is the key, with an empty value
+		if value, exists, _ := app.get([]byte("newop:a:b")); exists != (policy == UnknownOpWrite) || len(value) != 0 {
+			t.Errorf("policy %d: newop:a:b exists is %v, with value %q", policy, exists, value)
+		}
+		if policy == UnknownOpWrite {
+			// it's a write like any other, so sending it again is a duplicate
+			if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("newop:a:b")}); r.Code != DUPLICATE_TX {
+				t.Errorf("CheckTx again got code %d, want DUPLICATE_TX", r.Code)
+			}
+			hashes = append(hashes, app.committed.AppHash)
+		}
+	}
+	if !bytes.Equal(hashes[0], hashes[1]) {
+		t.Errorf("two nodes writing unknown ops got app hashes %X and %X", hashes[0], hashes[1])
+	}
+}
+
+func TestCp(t *testing.T) {
+	app := newTestApp(t, WithModIndex(true))
+	deliverBlock(app, 1, "a=5;type=int;ttl=10", "c=x")
+
+	res := deliverBlock(app, 2,
+		"cp:a:b", "cp:zz:b", "cp:a:b", "cp:c:b:overwrite", "cp:c:b:bad", "cp:a:e", "incr:e:1", "incr:b:1")
+	for i, want := range []uint32{VALID_TX, MISSING_KEY, KEY_EXISTS, VALID_TX, INVALID_FORMAT, VALID_TX, VALID_TX, INCOMPATIBLE_VALUE} {
+		if res[i].Code != want {
+			t.Errorf("tx %d got code %d, want %d: %s", i, res[i].Code, want, res[i].Log)
+		}
+	}
+	// the type is copied along with the value, so e can be incremented
+	// and b, overwritten with c's bytes, can't
+	for key, want := range map[string]struct {
+		value string
+		ct    contentType
+	}{"a": {"5", typeInt}, "b": {"x", typeBytes}, "e": {"6", typeInt}} {
+		if value, ct := typedValue(t, app, key); value != want.value || ct != want.ct {
+			t.Errorf("%s is %q of type %s, want %q of type %s", key, value, ct, want.value, want.ct)
+		}
+	}
+	if app.committed.KeyCount != 4 {
+		t.Errorf("got %d keys, want 4", app.committed.KeyCount)
+	}
+	// dst is written like any other key, with its own modified height, and
+	// doesn't get src's expiry
+	meta := keyMeta(t, app, "e")
+	if meta.ModifiedAt == nil || *meta.ModifiedAt != 2 || meta.ExpiresAt != 0 {
+		t.Errorf("e's meta is %+v, want modified at 2 without an expiry", meta)
+	}
+	if meta := keyMeta(t, app, "a"); meta.ModifiedAt == nil || *meta.ModifiedAt != 1 || meta.ExpiresAt != 11 {
+		t.Errorf("a's meta is %+v, want it as written at 1", meta)
+	}
+}
@@ -0,0 +1,782 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tendermint/tendermint/crypto"
+	"github.com/tendermint/tendermint/libs/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures optional behaviour of the KVStoreApplication
+// options are applied in order by NewKVStoreApplication, the defaults
+// always match the original behaviour of the application
+type Option func(app *KVStoreApplication)
+
+// WithLogger sets the logger used by the application
+// by default nothing is logged
+func WithLogger(logger log.Logger) Option {
+	return func(app *KVStoreApplication) {
+		app.logger = logger
+	}
+}
+
+// WithAdminQueries enables the privileged maintenance query paths
+// e.g. "flatten", these can be expensive so they are off by default
+// and should only be enabled on nodes that aren't publicly queryable
+func WithAdminQueries(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.adminQueries = enabled
+	}
+}
+
+// WithForceGenesis makes InitChain write the genesis entries even if the
+// store already has state, on top of it, rather than skip them, see genesis.go
+func WithForceGenesis(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.forceGenesis = enabled
+	}
+}
+
+// WithOfflineRewrites lets ReplaceAll, MigratePrefix and RestoreBackup
+// rewrite the state of a chain that has started, for an app run on the
+// store of a stopped node, the node can't rejoin the chain afterwards, it
+// has to be resynced, or start a new one from the rewritten state, see
+// replace.go
+func WithOfflineRewrites(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.offlineRewrites = enabled
+	}
+}
+
+// WithGenesisFiles lets the genesis app_state refer to a file of entries
+// rather than list them, relative paths are resolved against dir, they're
+// disabled by default, see genesisfile.go
+func WithGenesisFiles(dir string) Option {
+	return func(app *KVStoreApplication) {
+		app.genesisDir = dir
+	}
+}
+
+// WithInternalQuery enables the "internal" query, a dump of the app's own
+// keys for debugging, it's off by default, see internal.go
+func WithInternalQuery(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.internalQuery = enabled
+	}
+}
+
+// WithDiagnostics makes the gateway serve the state of the block being
+// processed and a goroutine dump under /debug, it's off by default, see
+// diagnostics.go
+func WithDiagnostics(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.diag = nil
+		if enabled {
+			app.diag = &diagnostics{}
+		}
+	}
+}
+
+// WithGatewayETags makes the gateway send an ETag with every /key response
+// and honor If-None-Match, maxAge is how long a client can use a response
+// before checking it again, 0 is every time, see gatewaycache.go
+func WithGatewayETags(enabled bool, maxAge time.Duration) Option {
+	return func(app *KVStoreApplication) {
+		app.gatewayETags = enabled
+		app.gatewayMaxAge = maxAge
+	}
+}
+
+// WithKeyNormalization sets how keys are normalized before being stored
+// or looked up, e.g. NormalizeFoldCase|NormalizeTrimSpace
+// this changes what ends up in the db, so it must be the same on every node
+func WithKeyNormalization(n KeyNormalization) Option {
+	return func(app *KVStoreApplication) {
+		app.keyNormalization = n
+	}
+}
+
+// WithTrimValues removes leading and trailing whitespace from the values of
+// 'key=value' writes, along with NormalizeTrimSpace for keys 'key = value'
+// then sets key to value, op arguments are left as they are
+// it's off by default so values are stored byte for byte, the trimmed value
+// is what's stored and hashed, so turning it on changes the app hash of any
+// block with such a value and it must be the same on every node
+func WithTrimValues(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.trimValues = enabled
+	}
+}
+
+// WithMaxListLength caps the number of elements in a list value, see list.go
+func WithMaxListLength(n int64) Option {
+	return func(app *KVStoreApplication) {
+		app.maxListLength = n
+	}
+}
+
+// WithMetrics registers the app's metrics with reg, the HTTP gateway then
+// serves reg on /metrics, so anything else registered with it, like
+// BadgerMetrics, is served along with them
+func WithMetrics(reg *prometheus.Registry) Option {
+	return func(app *KVStoreApplication) {
+		app.metricsRegistry = reg
+	}
+}
+
+// WithPrefixMetrics adds metrics of the reads and writes of every namespace
+// to the ones registered by WithMetrics, which it needs, up to max
+// namespaces get a label of their own, 0 is defaultMaxMetricPrefixes
+func WithPrefixMetrics(max int) Option {
+	return func(app *KVStoreApplication) {
+		if max <= 0 {
+			max = defaultMaxMetricPrefixes
+		}
+		app.maxMetricPrefixes = max
+	}
+}
+
+// WithDisabledOps rejects transactions running any of the named ops, e.g.
+// "swap" or "expr", with OP_DISABLED, every op is enabled by default
+// it changes which transactions are valid, so it must be the same on every node
+func WithDisabledOps(names ...string) Option {
+	return func(app *KVStoreApplication) {
+		if app.disabledOps == nil {
+			app.disabledOps = map[string]bool{}
+		}
+		for _, name := range names {
+			app.disabledOps[name] = true
+		}
+	}
+}
+
+// WithProofCache keeps the merkle tree built by a range proof query for the
+// queries after it, until a block changes the store, see merkle.go
+func WithProofCache(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		if enabled {
+			app.proofCache = &proofCache{}
+		} else {
+			app.proofCache = nil
+		}
+	}
+}
+
+// WithMaxPrefixDelete sets how many keys a single delprefix transaction can
+// remove, 0 removes the limit, the default is defaultMaxPrefixDelete
+// every key removed is part of the block's batch, so a big enough delete
+// would make the block impossible to commit
+func WithMaxPrefixDelete(n int) Option {
+	return func(app *KVStoreApplication) {
+		app.maxPrefixDelete = n
+	}
+}
+
+// WithMaxBatchOps sets how many lines a batch transaction can have, 0
+// removes the limit, the default is defaultMaxBatchOps, see batch.go
+func WithMaxBatchOps(n int) Option {
+	return func(app *KVStoreApplication) {
+		app.maxBatchOps = n
+	}
+}
+
+// defaultMaxConcurrentQueries is high enough that only a read storm hits it
+const defaultMaxConcurrentQueries = 256
+
+// WithMaxConcurrentQueries limits the number of queries running at once,
+// queries over the limit get QUERY_BUSY, 0 removes the limit
+// the default is defaultMaxConcurrentQueries
+func WithMaxConcurrentQueries(n int) Option {
+	return func(app *KVStoreApplication) {
+		if n > 0 {
+			app.querySlots = make(chan struct{}, n)
+		} else {
+			app.querySlots = nil
+		}
+	}
+}
+
+// WithQueryTimeBudget sets how long the "search", "sizehist" and "sum"
+// queries scan before answering with partial results and a cursor, a request can ask
+// for less, 0, the default, is no limit, see querybudget.go
+func WithQueryTimeBudget(d time.Duration) Option {
+	return func(app *KVStoreApplication) {
+		app.queryBudget = d
+	}
+}
+
+// WithFreezeWindows rejects every transaction in a block whose time falls
+// in one of the windows with FROZEN, a window with an End that isn't after
+// its Start is left out, see freeze.go
+func WithFreezeWindows(windows ...FreezeWindow) Option {
+	return func(app *KVStoreApplication) {
+		app.freezeWindows = nil
+		for _, w := range windows {
+			if w.End.After(w.Start) {
+				app.freezeWindows = append(app.freezeWindows, w)
+			}
+		}
+	}
+}
+
+// WithMaxQueryResponseSize limits the bytes a query response can have, a
+// paginated query answers with a smaller page instead, any other gets
+// QUERY_TOO_LARGE, 0, the default, is no limit, see responsesize.go
+func WithMaxQueryResponseSize(size int) Option {
+	return func(app *KVStoreApplication) {
+		app.maxResponseSize = size
+	}
+}
+
+// WithMaxConcurrentCheckTx limits the number of CheckTx calls validating a
+// transaction at once, the ones over the limit wait their turn, 0, the
+// default, is no limit
+// tendermint's own clients only ever run one CheckTx at a time, it matters
+// for an app embedded with a client that runs them in parallel
+func WithMaxConcurrentCheckTx(n int) Option {
+	return func(app *KVStoreApplication) {
+		if n > 0 {
+			app.checkSlots = make(chan struct{}, n)
+		} else {
+			app.checkSlots = nil
+		}
+	}
+}
+
+// WithMaxWatchers limits the number of WatchKey calls waiting at once, the
+// ones over the limit get ErrTooManyWatchers, 0 removes the limit
+// the default is defaultMaxWatchers
+func WithMaxWatchers(n int) Option {
+	return func(app *KVStoreApplication) {
+		if n > 0 {
+			app.watchSlots = make(chan struct{}, n)
+		} else {
+			app.watchSlots = nil
+		}
+	}
+}
+
+// WithBlockWriteBudget rejects the writes in a block once it's written
+// bytes of keys and values, 0, the default, is no limit, every node has to
+// use the same budget, see blockbudget.go
+func WithBlockWriteBudget(bytes int64) Option {
+	return func(app *KVStoreApplication) {
+		app.blockBudget = bytes
+	}
+}
+
+// WithSkipDeliverDuplicateCheck stops DeliverTx from checking if a
+// 'key=value' transaction writes a pair that already exists, which saves it
+// a read per transaction, CheckTx still rejects duplicates
+// a duplicate that gets into a block anyway, e.g. one checked before an
+// earlier block wrote the same pair, or proposed by a node that didn't check
+// it, is then written again instead of rejected, which changes the block's
+// results and its app hash, so every node must use the same setting
+func WithSkipDeliverDuplicateCheck(skip bool) Option {
+	return func(app *KVStoreApplication) {
+		app.skipDeliverDuplicates = skip
+	}
+}
+
+// WithAtomicBlocks makes blocks all or nothing, if any transaction in a
+// block fails DeliverTx then Commit discards every write in the block
+// NOTE: this is not standard ABCI behaviour, normally the valid transactions
+// in a block are applied and the invalid ones are skipped, every node on the
+// network must agree on this setting or they will end up with different state
+func WithAtomicBlocks(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.atomicBlocks = enabled
+	}
+}
+
+// WithReadCache puts an LRU cache of the given number of entries in front
+// of key queries, entries are invalidated on Commit for every key written
+// in the block, a size of 0 (the default) disables the cache
+func WithReadCache(size int) Option {
+	return func(app *KVStoreApplication) {
+		if size > 0 {
+			app.cache = newReadCache(size)
+		} else {
+			app.cache = nil
+		}
+	}
+}
+
+// WithInvariant registers an invariant checked at every Commit before the
+// block is persisted, violations are logged unless WithInvariantHalt is set
+// invariants run on every block, so they should be cheap
+func WithInvariant(name string, inv Invariant) Option {
+	return func(app *KVStoreApplication) {
+		app.invariants = append(app.invariants, namedInvariant{name: name, check: inv})
+	}
+}
+
+// WithBlockPredicate discards any block whose changes the predicate rejects,
+// see BlockPredicate, the app hash then depends on the predicate, every node
+// of the chain needs the same one, it can't be used with commit batching,
+// discarding a block throws away the batch, and the unflushed blocks in it
+func WithBlockPredicate(p BlockPredicate) Option {
+	return func(app *KVStoreApplication) {
+		app.blockPredicate = p
+	}
+}
+
+// WithInvariantHalt makes an invariant violation halt the node
+// instead of just logging it
+func WithInvariantHalt(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.haltOnInvariant = enabled
+	}
+}
+
+// WithTransactionLog appends every applied transaction to w, see wal.go
+// w should be opened for appending, if it's a file it is synced on every
+// Commit, a failed write halts the node as the log would be incomplete
+func WithTransactionLog(w io.Writer) Option {
+	return func(app *KVStoreApplication) {
+		app.txLog = w
+	}
+}
+
+// WithDuplicateInfo makes rejections of an already present key=value pair
+// explain that the transaction is safe to drop and set the response Info
+// to INFO_ALREADY_PRESENT, the code stays DUPLICATE_TX either way
+func WithDuplicateInfo(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.markDuplicates = enabled
+	}
+}
+
+// WithCommitBatching keeps writes in memory across Commits and only flushes
+// them to badger every blocks blocks, or once interval has passed since the
+// last flush if interval isn't 0, see flush.go for what this risks
+// Flush must be called on shutdown, and it can't be used with atomic blocks
+// or a block predicate
+// NOTE: never enable this on a validator
+func WithCommitBatching(blocks int, interval time.Duration) Option {
+	return func(app *KVStoreApplication) {
+		if blocks < 1 {
+			blocks = 1
+		}
+		app.flushBlocks = blocks
+		app.flushInterval = interval
+	}
+}
+
+// WithSyncOnFlush syncs the db to disk at the end of every flush, for a db
+// opened without synced writes, so every flushed block is durable with a
+// single sync, see flush.go
+func WithSyncOnFlush(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.syncOnFlush = enabled
+	}
+}
+
+// WithBlockWatchdog logs an error if a block is still open timeout after
+// BeginBlock, and halts the node as well if haltNode is set
+// a timeout of 0 (the default) disables the watchdog
+func WithBlockWatchdog(timeout time.Duration, haltNode bool) Option {
+	return func(app *KVStoreApplication) {
+		app.watchdogTimeout = timeout
+		app.watchdogHalt = haltNode
+	}
+}
+
+// WithWriteVerification reads every nth key written back from the db after
+// it's flushed, every 1 checks all of them, and logs an error if it doesn't
+// hold what was written, halting the node as well if haltNode is set
+// every of 0 (the default) disables verification, see verify.go
+func WithWriteVerification(every int, haltNode bool) Option {
+	return func(app *KVStoreApplication) {
+		app.verifyEvery = every
+		app.verifyHalt = haltNode
+	}
+}
+
+// WithDiskGuard rejects transactions that write with DISK_FULL in CheckTx
+// while usage reports less than minFree bytes free, e.g.
+// WithDiskGuard(DirUsage(dbDir), 1<<30, 0), the free space is sampled at
+// most once per interval, 0 is defaultDiskGuardInterval, see diskguard.go
+func WithDiskGuard(usage DiskUsage, minFree uint64, interval time.Duration) Option {
+	return func(app *KVStoreApplication) {
+		if interval <= 0 {
+			interval = defaultDiskGuardInterval
+		}
+		app.diskGuard = &diskGuard{usage: usage, minFree: minFree, interval: interval}
+	}
+}
+
+// WithMerkleAppHash makes the app hash the merkle root of the store's
+// contents, which lets entries be proven against it, see merkle.go
+// it changes the app hash, so every node must use the same setting
+func WithMerkleAppHash(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.merkleAppHash = enabled
+	}
+}
+
+// WithReceiptsRoot commits the results of every block's transactions in the
+// app hash, so they can be proven with the "receiptproof" query, it changes
+// the app hash, so every node must use the same setting, see receipts.go
+func WithReceiptsRoot(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.receiptsRoot = enabled
+	}
+}
+
+// WithAppendOnly makes every key immutable once written, transactions that
+// would change or remove an existing key are rejected with IMMUTABLE_KEY
+// every operation that removes or rewrites keys must honour this
+func WithAppendOnly(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.appendOnly = enabled
+	}
+}
+
+// WithUTF8Keys rejects transactions whose keys aren't valid UTF-8 with
+// INVALID_KEY, for stores read by text based tooling (JSON, HTTP)
+// binary keys are allowed by default
+func WithUTF8Keys(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.utf8Keys = enabled
+	}
+}
+
+// WithMaxKeyDepth rejects transactions with keys of more than max segments
+// separated by separator with KEY_TOO_DEEP, e.g. with "/" and 3 'a/b/c' is
+// allowed but 'a/b/c/d' isn't, keys of any depth are allowed by default
+func WithMaxKeyDepth(separator string, max int) Option {
+	return func(app *KVStoreApplication) {
+		app.keyDepthSeparator = []byte(separator)
+		app.maxKeyDepth = max
+	}
+}
+
+// WithMaintenance runs value log GC and flattens in the background while
+// the app is idle, see MaintenanceConfig, Close stops it
+func WithMaintenance(cfg MaintenanceConfig) Option {
+	return func(app *KVStoreApplication) {
+		app.maintainer = newMaintainer(app, cfg)
+	}
+}
+
+// WithTxIndex records a receipt for every delivered transaction that can be
+// looked up by its hash with the "tx" query, receipts are kept for the last
+// retainBlocks blocks, 0 keeps them forever
+func WithTxIndex(retainBlocks int64) Option {
+	return WithTxIndexRetention(TxIndexRetention{Blocks: retainBlocks})
+}
+
+// WithTxIndexRetention is WithTxIndex with receipts also pruned by count or
+// block time, see TxIndexRetention
+func WithTxIndexRetention(r TxIndexRetention) Option {
+	return func(app *KVStoreApplication) {
+		app.txIndex = true
+		app.txIndexRetention = r
+	}
+}
+
+// WithShadowWriter mirrors every block to w after it's flushed, see shadow.go
+func WithShadowWriter(w ShadowWriter) Option {
+	return func(app *KVStoreApplication) {
+		app.shadow = w
+	}
+}
+
+// WithKeyLimit caps the total number of keys in the store, creating a key
+// past the limit is rejected with KEY_LIMIT, see limits.go
+func WithKeyLimit(max int64) Option {
+	return func(app *KVStoreApplication) {
+		app.keyLimits = append(app.keyLimits, keyLimit{max: max})
+	}
+}
+
+// WithPrefixKeyLimit caps the number of keys under prefix, it can be
+// given several times for different prefixes
+func WithPrefixKeyLimit(prefix string, max int64) Option {
+	return func(app *KVStoreApplication) {
+		app.keyLimits = append(app.keyLimits, keyLimit{prefix: []byte(prefix), max: max})
+	}
+}
+
+// WithChangeIndex records every block's changes so the "diff" query can
+// return what changed between two heights, the last retainBlocks blocks
+// are kept, 0 keeps them forever
+func WithChangeIndex(retainBlocks int64) Option {
+	return func(app *KVStoreApplication) {
+		app.changeIndex = true
+		app.changeIndexRetain = retainBlocks
+	}
+}
+
+// WithChangeIndexCompaction lets CompactChangeIndex replace the change index
+// records of heights more than after blocks old with a summary for every
+// span heights, diffs are then only exact to span heights that far back,
+// see changecompact.go
+func WithChangeIndexCompaction(after, span int64) Option {
+	return func(app *KVStoreApplication) {
+		app.changeCompactAfter = after
+		app.changeCompactSpan = span
+	}
+}
+
+// WithModIndex records the height every key was last written at, for the
+// "extremes" query and the meta query's modified_at, see modified.go
+func WithModIndex(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.modIndex = enabled
+	}
+}
+
+// WithKeyVersions records the version of every key, for the setver op and
+// the meta query's version, see versions.go
+func WithKeyVersions(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.keyVersions = enabled
+	}
+}
+
+// WithTimeIndex records the block time every key was last written at, for
+// the "since" query and the meta query's written_at, records older than
+// retain are pruned, 0 keeps them forever, see written.go
+func WithTimeIndex(retain time.Duration) Option {
+	return func(app *KVStoreApplication) {
+		app.timeIndex = true
+		app.timeIndexRetain = retain
+	}
+}
+
+// WithTimeBuckets enables the bucket op, which pushes onto a list per time
+// bucket of the given width, e.g. time.Hour, by block time, the width is
+// rounded down to a whole second, under a second disables them, see
+// timebucket.go
+func WithTimeBuckets(width time.Duration) Option {
+	return func(app *KVStoreApplication) {
+		app.bucketWidth = width.Truncate(time.Second)
+	}
+}
+
+// WithIdempotencyKeys lets transactions carry an idempotency_key option, a
+// transaction with a key that was already applied is delivered as a no-op,
+// keys are remembered for retainBlocks blocks, 0 keeps them forever, whether
+// a retry is a no-op or applied again depends on it, so every node has to
+// use the same retention, see idempotency.go
+func WithIdempotencyKeys(retainBlocks int64) Option {
+	return func(app *KVStoreApplication) {
+		app.idempotencyKeys = true
+		app.idempotencyRetain = retainBlocks
+	}
+}
+
+// WithRanking ranks every int key under prefix by its value, for the zadd op
+// and the "topn" query, see ranking.go
+func WithRanking(prefix string) Option {
+	return func(app *KVStoreApplication) {
+		app.ranking = true
+		app.rankingPrefix = []byte(prefix)
+	}
+}
+
+// WithLocalPrefixes leaves the keys under any of the prefixes out of the app
+// hash, every node has to use the same ones from the start, and the keys
+// under them still have to be the same on every node, see localkeys.go
+func WithLocalPrefixes(prefixes ...string) Option {
+	return func(app *KVStoreApplication) {
+		for _, p := range prefixes {
+			app.localPrefixes = append(app.localPrefixes, []byte(p))
+		}
+	}
+}
+
+// WithShutdownSnapshot makes Close write a backup of the db to path, for
+// OpenDBFromSnapshot to start from, see snapshot.go
+func WithShutdownSnapshot(path string) Option {
+	return func(app *KVStoreApplication) {
+		app.shutdownSnapshot = path
+	}
+}
+
+// WithDeadLetterLog keeps the last size transactions CheckTx rejected in
+// memory, for the "rejected" query, 0 or less disables it, see deadletter.go
+func WithDeadLetterLog(size int) Option {
+	return func(app *KVStoreApplication) {
+		app.deadLetters = nil
+		if size > 0 {
+			app.deadLetters = newDeadLetters(size)
+		}
+	}
+}
+
+// WithCheckTxDedupWindow makes CheckTx reject a transaction it let into the
+// mempool among the last size ones, until it's delivered, without checking
+// it again, 0 or less disables it, see seentxs.go
+func WithCheckTxDedupWindow(size int) Option {
+	return func(app *KVStoreApplication) {
+		app.seenTxs = nil
+		if size > 0 {
+			app.seenTxs = newSeenTxs(size)
+		}
+	}
+}
+
+// WithTracer traces BeginBlock to Commit, every DeliverTx and Commit with
+// tracer, nil, the default, is a no-op tracer, see tracing.go
+func WithTracer(tracer trace.Tracer) Option {
+	return func(app *KVStoreApplication) {
+		app.tracing = tracer != nil
+		if tracer == nil {
+			tracer = trace.NewNoopTracerProvider().Tracer(tracerName)
+		}
+		app.tracer = tracer
+	}
+}
+
+// WithRejectNoOps rejects transactions with a write that sets a key to the
+// value it already has with NO_OP_WRITE, see noop.go
+func WithRejectNoOps(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.rejectNoOps = enabled
+	}
+}
+
+// WithStartupCheck checks the committed state is consistent before the app
+// starts, and halts if it isn't, the default is StartupCheckOff, see
+// startupcheck.go
+func WithStartupCheck(c StartupCheck) Option {
+	return func(app *KVStoreApplication) {
+		app.startupCheck = c
+	}
+}
+
+// WithSlowOpThreshold logs every Commit, DeliverTx and Query that takes
+// longer than d, 0, the default, logs none, see slowlog.go
+func WithSlowOpThreshold(d time.Duration) Option {
+	return func(app *KVStoreApplication) {
+		app.slowOpThreshold = d
+	}
+}
+
+// WithReadStats counts the reads of the size most read keys, for the
+// "readstats" query, 0 or less disables it, see readstats.go
+func WithReadStats(size int) Option {
+	return func(app *KVStoreApplication) {
+		app.readStats = nil
+		if size > 0 {
+			app.readStats = newReadStats(size)
+		}
+	}
+}
+
+// WithEventSink hands every block's changes to sink, from a goroutine of its
+// own, blocks it fails to take are handled according to policy, see
+// eventsink.go
+func WithEventSink(sink EventSink, policy EventSinkPolicy) Option {
+	return func(app *KVStoreApplication) {
+		app.events = &eventSink{sink: sink, policy: policy}
+	}
+}
+
+// WithCompactionHints schedules a background flatten after any block that
+// deletes at least threshold keys, see compaction.go
+// the flatten runs in WithMaintenance's windows if it's given, otherwise the
+// app is checked for idleness every minute
+func WithCompactionHints(threshold int) Option {
+	return func(app *KVStoreApplication) {
+		app.compactionThreshold = threshold
+	}
+}
+
+// WithReplica makes the app a read only replica, serving queries from a db
+// opened with WithReadOnly, see replica.go
+// it disables any maintenance and compaction hints
+func WithReplica(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.replica = enabled
+	}
+}
+
+// WithInvalidTxPolicy sets what happens when a block contains a malformed
+// transaction, the default is InvalidTxCount
+func WithInvalidTxPolicy(p InvalidTxPolicy) Option {
+	return func(app *KVStoreApplication) {
+		app.invalidTxPolicy = p
+	}
+}
+
+// WithGasSchedule prices transactions with the schedule, e.g.
+// DefaultGasSchedule, every node has to use the same one, see gas.go
+func WithGasSchedule(g GasSchedule) Option {
+	return func(app *KVStoreApplication) {
+		app.gas = &g
+	}
+}
+
+// WithHeightCheck sets what BeginBlock does with a block that doesn't follow
+// the last committed one, the default is HeightCheckOff, see heightcheck.go
+func WithHeightCheck(p HeightCheckPolicy) Option {
+	return func(app *KVStoreApplication) {
+		app.heightCheck = p
+	}
+}
+
+// WithSubscriptionBuffer sets how many changes a subscriber can fall behind
+// by before it's dropped, see Subscribe
+func WithSubscriptionBuffer(size int) Option {
+	return func(app *KVStoreApplication) {
+		if size > 0 {
+			app.subscriptions.buffer = size
+		}
+	}
+}
+
+// WithMaxTxSize rejects transactions longer than size bytes with
+// TX_TOO_LARGE, before they're parsed or touch the db
+func WithMaxTxSize(size int) Option {
+	return func(app *KVStoreApplication) {
+		app.maxTxSize = size
+	}
+}
+
+// WithBatchDuplicates sets what happens to a batch transaction that sets
+// the same key more than once, the default is BatchLastWins
+func WithBatchDuplicates(p BatchDuplicatePolicy) Option {
+	return func(app *KVStoreApplication) {
+		app.batchDuplicates = p
+	}
+}
+
+// WithUnknownOps sets what happens to a transaction running an op this node
+// doesn't know, the default is UnknownOpReject
+func WithUnknownOps(p UnknownOpPolicy) Option {
+	return func(app *KVStoreApplication) {
+		app.unknownOps = p
+	}
+}
+
+// WithValueCRC stores every value written from now on with a CRC32, checked
+// on every read, see crc.go
+func WithValueCRC(enabled bool) Option {
+	return func(app *KVStoreApplication) {
+		app.valueCRC = enabled
+	}
+}
+
+// WithAppVersion sets the app version reported by Info, it's recorded with
+// every committed block so a restart with a different version is logged
+func WithAppVersion(version uint64) Option {
+	return func(app *KVStoreApplication) {
+		app.appVersion = version
+	}
+}
+
+// WithQuerySigning signs every query response with the key, normally the
+// node key, e.g. p2p.LoadNodeKey(cfg.NodeKeyFile()).PrivKey, a nil key
+// turns signing off, see signing.go
+func WithQuerySigning(key crypto.PrivKey) Option {
+	return func(app *KVStoreApplication) {
+		app.signingKey = key
+	}
+}
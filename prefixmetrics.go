@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Per prefix metrics (WithPrefixMetrics) break the reads and writes of keys
+// down by namespace, the first segment of a key up to and including "/",
+// like the "prefixes" query, so the load of one tenant can be told apart
+// from another's
+//
+// every label value is a series on the prometheus server, so the number of
+// namespaces that get their own is capped, the first ones seen get one,
+// any namespace after that, and any key that isn't in a namespace, is
+// counted as "other", which never clashes as a namespace ends in "/"
+//
+// writes are counted in DeliverTx per change, a removed key is a write of
+// 0 bytes, reads are the queries that look up a value by key, a key that
+// doesn't exist is a read of 0 bytes, a scan or a prefix query isn't counted
+
+const (
+	defaultMaxMetricPrefixes = 100
+	otherPrefix              = "other"
+)
+
+var prefixSeparator = []byte("/")
+
+type prefixMetrics struct {
+	max     int
+	mtx     sync.Mutex
+	tracked map[string]bool
+
+	writes     *prometheus.CounterVec
+	writeBytes *prometheus.CounterVec
+	reads      *prometheus.CounterVec
+	readBytes  *prometheus.CounterVec
+}
+
+func newPrefixMetrics(max int) *prefixMetrics {
+	counter := func(name, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kvstore",
+			Subsystem: "app",
+			Name:      name,
+			Help:      help,
+		}, []string{"prefix"})
+	}
+	return &prefixMetrics{
+		max:        max,
+		tracked:    map[string]bool{},
+		writes:     counter("prefix_writes_total", "Number of keys written or removed by delivered transactions, by namespace."),
+		writeBytes: counter("prefix_write_bytes_total", "Bytes of the values written by delivered transactions, by namespace."),
+		reads:      counter("prefix_reads_total", "Number of keys looked up by queries, by namespace."),
+		readBytes:  counter("prefix_read_bytes_total", "Bytes of the values returned by key lookups, by namespace."),
+	}
+}
+
+// label returns the label key is counted under, queries and DeliverTx can
+// run at the same time, so the tracked namespaces have their own lock
+func (p *prefixMetrics) label(key []byte) string {
+	i := bytes.Index(key, prefixSeparator)
+	if i < 0 {
+		return otherPrefix
+	}
+	ns := string(key[:i+len(prefixSeparator)])
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.tracked[ns] {
+		return ns
+	}
+	if len(p.tracked) < p.max {
+		p.tracked[ns] = true
+		return ns
+	}
+	return otherPrefix
+}
+
+func (m *appMetrics) keyWritten(key []byte, size int) {
+	if m != nil && m.prefixes != nil {
+		l := m.prefixes.label(key)
+		m.prefixes.writes.WithLabelValues(l).Inc()
+		m.prefixes.writeBytes.WithLabelValues(l).Add(float64(size))
+	}
+}
+
+func (m *appMetrics) keyRead(key []byte, size int) {
+	if m != nil && m.prefixes != nil {
+		l := m.prefixes.label(key)
+		m.prefixes.reads.WithLabelValues(l).Inc()
+		m.prefixes.readBytes.WithLabelValues(l).Add(float64(size))
+	}
+}
+
+func (p *prefixMetrics) Describe(ch chan<- *prometheus.Desc) {
+	p.writes.Describe(ch)
+	p.writeBytes.Describe(ch)
+	p.reads.Describe(ch)
+	p.readBytes.Describe(ch)
+}
+
+func (p *prefixMetrics) Collect(ch chan<- prometheus.Metric) {
+	p.writes.Collect(ch)
+	p.writeBytes.Collect(ch)
+	p.reads.Collect(ch)
+	p.readBytes.Collect(ch)
+}
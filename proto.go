@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Besides the text and hex schemes a transaction can be a protobuf message,
+// the Tx message of tx.proto, marked with 0x00 and the scheme byte
+//
+//	0x00 'p' Tx
+//
+// a Tx is one of a write, an op or a batch, each one means the same as its
+// text form, a write is 'key=value' with its options, an op is its name and
+// arguments, and a batch is its writes, one per line, and they're checked
+// the same way, with the same rejections, but keys, values and arguments
+// are bytes, so they can contain '=', ':' or newlines
+// an op is by name rather than one message per op, ops are registered at
+// runtime, see txOps, and each one parses its own arguments, so new ops don't
+// need a change to the schema
+// a field that isn't in tx.proto is rejected rather than skipped, as it
+// could change what the transaction means to a node that knows it
+// the Go types below are written by hand against tx.proto, ProtoTx.Encode
+// is the client side of it
+
+// ProtoTx is the Tx message, exactly one of Write, Op and Batch is set
+type ProtoTx struct {
+	Write *ProtoWrite
+	Op    *ProtoOp
+	Batch *ProtoBatch
+}
+
+// ProtoWrite is the Write message
+type ProtoWrite struct {
+	Key     []byte
+	Value   []byte
+	Options []ProtoOption
+}
+
+// ProtoOption is the Option message
+type ProtoOption struct {
+	Name  string
+	Value string
+}
+
+// ProtoOp is the Op message
+type ProtoOp struct {
+	Name string
+	Args [][]byte
+}
+
+// ProtoBatch is the Batch message
+type ProtoBatch struct {
+	Writes []ProtoWrite
+}
+
+// Encode returns the transaction in the protobuf scheme
+func (p ProtoTx) Encode() []byte {
+	tx := []byte{schemeMarker, schemeProto}
+	switch {
+	case p.Write != nil:
+		tx = protowire.AppendTag(tx, 1, protowire.BytesType)
+		tx = protowire.AppendBytes(tx, p.Write.encode())
+	case p.Op != nil:
+		tx = protowire.AppendTag(tx, 2, protowire.BytesType)
+		tx = protowire.AppendBytes(tx, p.Op.encode())
+	case p.Batch != nil:
+		tx = protowire.AppendTag(tx, 3, protowire.BytesType)
+		tx = protowire.AppendBytes(tx, p.Batch.encode())
+	}
+	return tx
+}
+
+func (w ProtoWrite) encode() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, w.Key)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendBytes(b, w.Value)
+	for _, o := range w.Options {
+		var ob []byte
+		ob = protowire.AppendTag(ob, 1, protowire.BytesType)
+		ob = protowire.AppendString(ob, o.Name)
+		ob = protowire.AppendTag(ob, 2, protowire.BytesType)
+		ob = protowire.AppendString(ob, o.Value)
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, ob)
+	}
+	return b
+}
+
+func (op ProtoOp) encode() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, op.Name)
+	for _, arg := range op.Args {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, arg)
+	}
+	return b
+}
+
+func (batch ProtoBatch) encode() []byte {
+	var b []byte
+	for _, w := range batch.Writes {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, w.encode())
+	}
+	return b
+}
+
+var errInvalidProto = reject(INVALID_FORMAT, "protobuf transaction isn't a valid Tx message")
+
+// decodeFields calls field with the number and contents of every field in
+// b, every field of tx.proto is length delimited, so anything else is
+// invalid
+func decodeFields(b []byte, field func(num protowire.Number, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 || typ != protowire.BytesType {
+			return errInvalidProto
+		}
+		b = b[n:]
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return errInvalidProto
+		}
+		b = b[n:]
+		if err := field(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unknownProtoField(message string, num protowire.Number) error {
+	return reject(INVALID_FORMAT, fmt.Sprintf("protobuf transaction has an unknown %s field %d", message, num))
+}
+
+func decodeProtoTx(b []byte) (p ProtoTx, err error) {
+	kinds := 0
+	err = decodeFields(b, func(num protowire.Number, v []byte) error {
+		kinds++
+		switch num {
+		case 1:
+			w, err := decodeProtoWrite(v)
+			p.Write = &w
+			return err
+		case 2:
+			op, err := decodeProtoOp(v)
+			p.Op = &op
+			return err
+		case 3:
+			batch, err := decodeProtoBatch(v)
+			p.Batch = &batch
+			return err
+		}
+		return unknownProtoField("Tx", num)
+	})
+	if err == nil && kinds != 1 {
+		err = reject(INVALID_FORMAT, "protobuf transaction must be exactly one of a write, an op or a batch")
+	}
+	return p, err
+}
+
+func decodeProtoWrite(b []byte) (w ProtoWrite, err error) {
+	err = decodeFields(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1:
+			w.Key = v
+		case 2:
+			w.Value = v
+		case 3:
+			var o ProtoOption
+			err := decodeFields(v, func(num protowire.Number, v []byte) error {
+				switch num {
+				case 1:
+					o.Name = string(v)
+				case 2:
+					o.Value = string(v)
+				default:
+					return unknownProtoField("Option", num)
+				}
+				return nil
+			})
+			w.Options = append(w.Options, o)
+			return err
+		default:
+			return unknownProtoField("Write", num)
+		}
+		return nil
+	})
+	return w, err
+}
+
+func decodeProtoOp(b []byte) (op ProtoOp, err error) {
+	err = decodeFields(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1:
+			op.Name = string(v)
+		case 2:
+			op.Args = append(op.Args, v)
+		default:
+			return unknownProtoField("Op", num)
+		}
+		return nil
+	})
+	return op, err
+}
+
+func decodeProtoBatch(b []byte) (batch ProtoBatch, err error) {
+	err = decodeFields(b, func(num protowire.Number, v []byte) error {
+		if num != 1 {
+			return unknownProtoField("Batch", num)
+		}
+		w, err := decodeProtoWrite(v)
+		batch.Writes = append(batch.Writes, w)
+		return err
+	})
+	return batch, err
+}
+
+// parseProto parses a protobuf scheme transaction, returns false if tx
+// isn't one
+func parseProto(tx []byte, t *transaction) (bool, error) {
+	if len(tx) < 2 || tx[0] != schemeMarker || tx[1] != schemeProto {
+		return false, nil
+	}
+	p, err := decodeProtoTx(tx[2:])
+	if err != nil {
+		return true, err
+	}
+	switch {
+	case p.Write != nil:
+		return true, setProtoWrite(*p.Write, t)
+	case p.Op != nil:
+		op, ok := txOps[p.Op.Name]
+		if !ok {
+			return true, reject(INVALID_FORMAT, fmt.Sprintf("unknown op %q", p.Op.Name))
+		}
+		return true, setOp(op, p.Op.Args, t)
+	}
+	if len(p.Batch.Writes) == 0 {
+		return true, reject(INVALID_FORMAT, "protobuf batch must have at least one write")
+	}
+	for i, pw := range p.Batch.Writes {
+		var w transaction
+		if err := setProtoWrite(pw, &w); err != nil {
+			if r, ok := asRejection(err); ok {
+				return true, reject(r.code, fmt.Sprintf("line %d: %s", i+1, r.log))
+			}
+			return true, err
+		}
+		t.batch = append(t.batch, w)
+	}
+	return true, setBatchOptions(t)
+}
+
+// setProtoWrite sets the key, value and options of a write on t
+func setProtoWrite(w ProtoWrite, t *transaction) error {
+	if len(w.Key) == 0 {
+		return reject(INVALID_FORMAT, "protobuf transaction key can't be empty")
+	}
+	seen := make(map[string]bool, len(w.Options))
+	for _, o := range w.Options {
+		apply, ok := txOptions[o.Name]
+		if !ok {
+			return reject(INVALID_FORMAT, "unknown transaction option "+o.Name)
+		}
+		if seen[o.Name] {
+			return reject(INVALID_FORMAT, "duplicate transaction option "+o.Name)
+		}
+		seen[o.Name] = true
+		if err := apply(t, o.Value); err != nil {
+			return err
+		}
+	}
+	t.key, t.value = w.Key, w.Value
+	if t.value == nil {
+		t.value = []byte{}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestProtoTxParses(t *testing.T) {
+	// each kind parses to the same transaction as its text form
+	for _, c := range []struct {
+		proto ProtoTx
+		text  string
+	}{
+		{ProtoTx{Write: &ProtoWrite{Key: []byte("a"), Value: []byte("1"), Options: []ProtoOption{{"ttl", "5"}, {"memo", "m"}}}}, "a=1;ttl=5;memo=m"},
+		{ProtoTx{Write: &ProtoWrite{Key: []byte("a")}}, "a="},
+		{ProtoTx{Op: &ProtoOp{Name: "cp", Args: [][]byte{[]byte("a"), []byte("b")}}}, "cp:a:b"},
+		{ProtoTx{Op: &ProtoOp{Name: "setif", Args: [][]byte{[]byte("a"), []byte("1"), []byte("2"), []byte("3")}}}, "setif:a:1:2:3"},
+		{ProtoTx{Batch: &ProtoBatch{Writes: []ProtoWrite{{Key: []byte("k1"), Value: []byte("1")}, {Key: []byte("k2"), Value: []byte("2")}}}}, string(EncodeBatch([]byte("k1=1"), []byte("k2=2")))},
+	} {
+		got, err := parseTx(c.proto.Encode())
+		if err != nil {
+			t.Errorf("%s: %v", c.text, err)
+			continue
+		}
+		want, err := parseTx([]byte(c.text))
+		if err != nil {
+			t.Fatalf("%s: %v", c.text, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("the protobuf form of %q parsed as %+v, want %+v", c.text, got, want)
+		}
+	}
+
+	// and they decode to what was encoded
+	p := ProtoTx{Write: &ProtoWrite{Key: []byte("a=b"), Value: []byte("x:y\nz"), Options: []ProtoOption{{"memo", "m"}}}}
+	decoded, err := decodeProtoTx(p.Encode()[2:])
+	if err != nil || !reflect.DeepEqual(decoded, p) {
+		t.Errorf("decoded %+v as %+v: %v", p, decoded, err)
+	}
+	// what the text form can't hold
+	parsed, err := parseTx(p.Encode())
+	if err != nil || string(parsed.key) != "a=b" || string(parsed.value) != "x:y\nz" || parsed.memo != "m" {
+		t.Errorf("got %q=%q with memo %q: %v", parsed.key, parsed.value, parsed.memo, err)
+	}
+}
+
+func TestProtoTxThroughTheApp(t *testing.T) {
+	proto, text := newTestApp(t), newTestApp(t)
+	deliverBlock(proto, 1, "a=1")
+	deliverBlock(text, 1, "a=1")
+	res := deliverBlock(proto, 2,
+		string(ProtoTx{Write: &ProtoWrite{Key: []byte("b"), Value: []byte("2")}}.Encode()),
+		string(ProtoTx{Op: &ProtoOp{Name: "cp", Args: [][]byte{[]byte("a"), []byte("c")}}}.Encode()),
+		string(ProtoTx{Batch: &ProtoBatch{Writes: []ProtoWrite{{Key: []byte("d"), Value: []byte("4")}, {Key: []byte("e"), Value: []byte("5")}}}}.Encode()),
+	)
+	for i, r := range res {
+		if r.Code != VALID_TX {
+			t.Errorf("tx %d got code %d: %s", i, r.Code, r.Log)
+		}
+	}
+	deliverBlock(text, 2, "b=2", "cp:a:c", string(EncodeBatch([]byte("d=4"), []byte("e=5"))))
+	if !bytes.Equal(proto.committed.AppHash, text.committed.AppHash) {
+		t.Errorf("the protobuf txs got app hash %X, the text ones %X", proto.committed.AppHash, text.committed.AppHash)
+	}
+
+	for _, c := range []struct {
+		tx   []byte
+		code uint32
+	}{
+		{ProtoTx{}.Encode(), INVALID_FORMAT},
+		{ProtoTx{Write: &ProtoWrite{}}.Encode(), INVALID_FORMAT},
+		{ProtoTx{Write: &ProtoWrite{Key: []byte("a"), Options: []ProtoOption{{"nope", "1"}}}}.Encode(), INVALID_FORMAT},
+		{ProtoTx{Op: &ProtoOp{Name: "nope"}}.Encode(), INVALID_FORMAT},
+		{ProtoTx{Op: &ProtoOp{Name: "cp", Args: [][]byte{[]byte("a")}}}.Encode(), INVALID_FORMAT},
+		{ProtoTx{Batch: &ProtoBatch{}}.Encode(), INVALID_FORMAT},
+		// not a message, and a field tx.proto doesn't have
+		{[]byte{schemeMarker, schemeProto, 0x08, 1}, INVALID_FORMAT},
+		{[]byte{schemeMarker, schemeProto, 0x22, 0}, INVALID_FORMAT},
+		{ProtoTx{Write: &ProtoWrite{Key: []byte{0, 1}, Value: []byte("x")}}.Encode(), RESERVED_KEY},
+	} {
+		if r := proto.CheckTx(abcitypes.RequestCheckTx{Tx: c.tx}); r.Code != c.code {
+			t.Errorf("%q got code %d, want %d: %s", c.tx, r.Code, c.code, r.Log)
+		}
+	}
+}
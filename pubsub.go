@@ -0,0 +1,127 @@
+package main
+
+import (
+	"sync"
+)
+
+// Subscribers get every change as it's committed, instead of polling
+// changes are published once their block has been flushed to the db, so a
+// subscriber that queries a key it was told about sees the new value
+// publishing never blocks Commit, every subscription has a buffer and a
+// subscriber that lets it fill up is dropped, its channel is closed, as it
+// has missed changes and has to resync anyway
+
+const defaultSubscriptionBuffer = 1024
+
+// Change is a committed change to a user key
+type Change struct {
+	Height int64
+	Key    []byte
+	// Deleted is set if the key was removed, Value and Type are then empty
+	Deleted bool
+	Value   []byte
+	Type    string
+}
+
+// subscriptions is the set of subscribers, Subscribe can be called from
+// any goroutine while publish runs from Commit
+type subscriptions struct {
+	mtx    sync.Mutex
+	next   int
+	subs   map[int]subscriber
+	buffer int
+}
+
+// subscriber is a subscription's channel and the changes it wants, all of
+// them if filter is nil
+type subscriber struct {
+	ch     chan Change
+	filter func(Change) bool
+}
+
+// Subscribe returns a channel receiving every committed change, in order,
+// and a function that cancels the subscription, either way the channel is
+// closed once the subscription ends
+func (app *KVStoreApplication) Subscribe() (<-chan Change, func()) {
+	return app.subscribe(nil)
+}
+
+// subscribe is Subscribe with only the changes filter returns true for
+// counting towards the buffer
+func (app *KVStoreApplication) subscribe(filter func(Change) bool) (<-chan Change, func()) {
+	s := app.subscriptions
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.subs == nil {
+		s.subs = make(map[int]subscriber)
+	}
+	id, ch := s.next, make(chan Change, s.buffer)
+	s.next++
+	s.subs[id] = subscriber{ch: ch, filter: filter}
+
+	cancel := func() {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		if sub, ok := s.subs[id]; ok {
+			delete(s.subs, id)
+			close(sub.ch)
+		}
+	}
+	return ch, cancel
+}
+
+// active returns true if anyone is subscribed
+func (s *subscriptions) active() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.subs) > 0
+}
+
+// queuePublish queues the block being committed for the subscribers
+func (app *KVStoreApplication) queuePublish() {
+	if !app.subscriptions.active() {
+		return
+	}
+	for _, c := range app.changes {
+		app.publishPending = append(app.publishPending, publicChange(app.pending.Height, c))
+	}
+}
+
+// publicChange returns c as a Change made at height
+func publicChange(height int64, c change) Change {
+	change := Change{Height: height, Key: c.key, Deleted: c.deleted}
+	if !c.deleted {
+		change.Value, change.Type = c.value, c.contentType.String()
+	}
+	return change
+}
+
+// publish sends the flushed changes to every subscriber
+func (app *KVStoreApplication) publish() {
+	changes := app.publishPending
+	app.publishPending = nil
+	if len(changes) == 0 {
+		return
+	}
+
+	s := app.subscriptions
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for id, sub := range s.subs {
+		for _, c := range changes {
+			if sub.filter != nil && !sub.filter(c) {
+				continue
+			}
+			select {
+			case sub.ch <- c:
+				continue
+			default:
+			}
+			app.logger.Info("dropping slow subscriber", "height", c.Height)
+			delete(s.subs, id)
+			close(sub.ch)
+			break
+		}
+	}
+}
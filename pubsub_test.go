@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// received drains the changes already sent to ch
+func received(ch <-chan Change) []Change {
+	var changes []Change
+	for {
+		select {
+		case c, ok := <-ch:
+			if !ok {
+				return changes
+			}
+			changes = append(changes, c)
+		default:
+			return changes
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	app := newTestApp(t)
+	ch, cancel := app.Subscribe()
+	deliverBlock(app, 1, "a=1;ttl=1", "b=1;type=int", "bad")
+	deliverBlock(app, 2)
+
+	want := []Change{
+		{Height: 1, Key: []byte("a"), Value: []byte("1"), Type: "bytes"},
+		{Height: 1, Key: []byte("b"), Value: []byte("1"), Type: "int"},
+		{Height: 2, Key: []byte("a"), Deleted: true},
+	}
+	if got := received(ch); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	cancel()
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Error("the channel is still open after cancelling")
+	}
+	// publishing with the subscription gone mustn't send on the closed channel
+	deliverBlock(app, 3, "c=1")
+}
+
+func TestSubscribeWaitsForTheFlush(t *testing.T) {
+	app := newTestApp(t, WithCommitBatching(10, 0))
+	ch, cancel := app.Subscribe()
+	defer cancel()
+	deliverBlock(app, 1, "a=1")
+	if got := received(ch); len(got) != 0 {
+		t.Errorf("got %+v before the block was flushed", got)
+	}
+	if err := app.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := received(ch); len(got) != 1 || string(got[0].Key) != "a" {
+		t.Errorf("got %+v after the flush", got)
+	}
+}
+
+func TestSlowSubscriberDropped(t *testing.T) {
+	app := newTestApp(t, WithSubscriptionBuffer(2))
+	slow, _ := app.Subscribe()
+	fast, cancel := app.Subscribe()
+	defer cancel()
+
+	deliverBlock(app, 1, "a=1")
+	if got := received(fast); len(got) != 1 {
+		t.Fatalf("got %+v", got)
+	}
+	deliverBlock(app, 2, "b=1", "c=1")
+	if got := received(fast); len(got) != 2 {
+		t.Errorf("a subscriber keeping up got %+v", got)
+	}
+
+	// the slow one only fit the first two changes and was then closed
+	got := received(slow)
+	if len(got) != 2 {
+		t.Errorf("the slow subscriber got %+v", got)
+	}
+	if _, ok := <-slow; ok {
+		t.Error("the slow subscriber wasn't dropped")
+	}
+	if !app.subscriptions.active() {
+		t.Error("the subscriber keeping up was dropped too")
+	}
+}
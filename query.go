@@ -0,0 +1,981 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// Query paths that return JSON always marshal structs rather than maps
+// so the field order, and so the response bytes, are deterministic
+
+// respondJSON marshals v into the response value
+func respondJSON(res *abcitypes.ResponseQuery, v interface{}) {
+	val, err := json.Marshal(v)
+	if err != nil {
+		res.Code = QUERY_FAILED
+		res.Log = err.Error()
+		return
+	}
+	res.Value = val
+}
+
+// parseRequest unmarshals the JSON query data into v
+// returns false, with the response already filled in, if the data is invalid
+func parseRequest(req abcitypes.RequestQuery, res *abcitypes.ResponseQuery, v interface{}) bool {
+	if err := json.Unmarshal(req.Data, v); err != nil {
+		res.Code = QUERY_INVALID
+		res.Log = "invalid query data: " + err.Error()
+		return false
+	}
+	return true
+}
+
+// clampLimit returns limit bounded to [1, max], 0 means use the default
+func clampLimit(limit, def, max int) int {
+	if limit <= 0 {
+		return def
+	}
+	if limit > max {
+		return max
+	}
+	return limit
+}
+
+type statsResponse struct {
+	Keys       int64  `json:"keys"`
+	ValueBytes int64  `json:"value_bytes"`
+	Height     int64  `json:"height"`
+	AppHash    string `json:"app_hash"`
+	LSMSize    int64  `json:"lsm_size"`
+	VlogSize   int64  `json:"vlog_size"`
+	// MalformedTxs is the number of malformed transactions delivered
+	// since the node started, see InvalidTxPolicy
+	MalformedTxs int64 `json:"malformed_txs"`
+}
+
+// queryStats returns store wide statistics as of the last Commit
+// the key count and value bytes come from counters maintained on every
+// write, so this is cheap no matter how big the store is
+func (app *KVStoreApplication) queryStats(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	// only badger has an LSM tree and a value log
+	var lsm, vlog int64
+	if app.db != nil {
+		lsm, vlog = app.db.Size()
+	}
+	res.Height = app.committed.Height
+	respondJSON(&res, statsResponse{
+		Keys:         app.committed.KeyCount,
+		ValueBytes:   app.committed.ValueBytes,
+		Height:       app.committed.Height,
+		AppHash:      hex.EncodeToString(app.committed.AppHash),
+		LSMSize:      lsm,
+		VlogSize:     vlog,
+		MalformedTxs: app.malformedTxs,
+	})
+	return
+}
+
+type emptyResponse struct {
+	Empty bool `json:"empty"`
+}
+
+// queryEmpty returns whether the store has no user keys at all, it's a
+// single keys only seek past the app's own bookkeeping, so unlike the key
+// count in stats it doesn't depend on the counters being right
+func (app *KVStoreApplication) queryEmpty(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	empty := true
+	err := app.store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		it.Seek(prefixEnd(internalPrefix))
+		empty = !it.Valid()
+		return nil
+	})
+	if err != nil {
+		halt("Query", err)
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, emptyResponse{Empty: empty})
+	return
+}
+
+const (
+	defaultCompleteLimit = 10
+	maxCompleteLimit     = 100
+)
+
+type completeRequest struct {
+	Prefix string `json:"prefix"`
+	Limit  int    `json:"limit"`
+}
+
+// queryComplete returns the first keys under a prefix in sorted order
+// e.g. {"prefix": "us", "limit": 5}
+// only keys are read, values are never loaded, so it's cheap enough for
+// typeahead, the limit is capped at maxCompleteLimit to keep it that way
+func (app *KVStoreApplication) queryComplete(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var creq completeRequest
+	if !parseRequest(req, &res, &creq) {
+		return
+	}
+	limit := clampLimit(creq.Limit, defaultCompleteLimit, maxCompleteLimit)
+	prefix := app.normalizeKey([]byte(creq.Prefix))
+
+	keys := []string{}
+	err := app.store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid() && len(keys) < limit; it.Next() {
+			key := it.Item().Key()
+			if isInternalKey(key) {
+				continue
+			}
+			keys = append(keys, string(key))
+		}
+		return nil
+	})
+	if err != nil {
+		halt("Query", err)
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, keys)
+	return
+}
+
+const (
+	defaultPrefixesLimit = 100
+	maxPrefixesLimit     = 1000
+)
+
+type prefixesRequest struct {
+	Separator string `json:"separator"`
+	Counts    bool   `json:"counts"`
+	Limit     int    `json:"limit"`
+}
+
+type prefixCount struct {
+	Prefix string `json:"prefix"`
+	Count  int64  `json:"count,omitempty"`
+}
+
+// queryPrefixes lists the distinct top level namespaces in the store
+// e.g. {"separator": "/", "counts": true}, separator defaults to "/"
+// a namespace is the first segment of a key up to and including the
+// separator, keys without the separator aren't in any namespace
+// without counts the iterator seeks straight past each namespace it finds,
+// with counts every key in the namespace has to be visited (keys only)
+func (app *KVStoreApplication) queryPrefixes(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	preq := prefixesRequest{Separator: "/"}
+	if len(req.Data) > 0 && !parseRequest(req, &res, &preq) {
+		return
+	}
+	if preq.Separator == "" {
+		res.Code = QUERY_INVALID
+		res.Log = "separator can't be empty"
+		return
+	}
+	limit := clampLimit(preq.Limit, defaultPrefixesLimit, maxPrefixesLimit)
+	sep := []byte(preq.Separator)
+
+	prefixes := []prefixCount{}
+	err := app.store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		it.Seek(prefixEnd(internalPrefix))
+		for it.Valid() && len(prefixes) < limit {
+			key := it.Item().Key()
+			i := bytes.Index(key, sep)
+			if i < 0 {
+				it.Next()
+				continue
+			}
+			prefix := append([]byte{}, key[:i+len(sep)]...)
+
+			pc := prefixCount{Prefix: string(prefix)}
+			if preq.Counts {
+				for ; it.ValidForPrefix(prefix); it.Next() {
+					pc.Count++
+				}
+			} else if end := prefixEnd(prefix); end != nil {
+				it.Seek(end)
+			} else {
+				break
+			}
+			prefixes = append(prefixes, pc)
+		}
+		return nil
+	})
+	if err != nil {
+		halt("Query", err)
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, prefixes)
+	return
+}
+
+const (
+	defaultPrefixLimit = 100
+	maxPrefixLimit     = 1000
+)
+
+type prefixRequest struct {
+	Prefix string `json:"prefix"`
+	// Type optionally only returns values tagged with that content type
+	Type  string `json:"type"`
+	Limit int    `json:"limit"`
+}
+
+type kvPair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Type  string `json:"type"`
+}
+
+// queryPrefix returns the key/value pairs under a prefix in key order
+// e.g. {"prefix": "counters/", "type": "int", "limit": 10}
+// the type filter is checked against the entry's tag while iterating,
+// so values of other types are never loaded
+func (app *KVStoreApplication) queryPrefix(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var preq prefixRequest
+	if !parseRequest(req, &res, &preq) {
+		return
+	}
+	limit := clampLimit(preq.Limit, defaultPrefixLimit, maxPrefixLimit)
+	prefix := app.normalizeKey([]byte(preq.Prefix))
+
+	filter := false
+	var want contentType
+	if preq.Type != "" {
+		ct, ok := parseContentType(preq.Type)
+		if !ok {
+			res.Code = QUERY_INVALID
+			res.Log = "unknown value type " + preq.Type
+			return
+		}
+		filter, want = true, ct
+	}
+
+	pairs := []kvPair{}
+	err := app.store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.Prefix = prefix
+		// values are only fetched for entries that pass the filter
+		opts.PrefetchValues = !filter
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid() && len(pairs) < limit; it.Next() {
+			item := it.Item()
+			ct := itemType(item)
+			if isInternalKey(item.Key()) || (filter && ct != want) {
+				continue
+			}
+			value, err := itemValue(item)
+			if err != nil {
+				return err
+			}
+			pairs = append(pairs, kvPair{Key: string(item.Key()), Value: string(value), Type: ct.String()})
+		}
+		return nil
+	})
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, pairs)
+	return
+}
+
+type checksumRequest struct {
+	Prefix string `json:"prefix"`
+	// Start and End optionally narrow the range to [Start, End)
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+type checksumResponse struct {
+	Checksum string `json:"checksum"`
+	Keys     int64  `json:"keys"`
+}
+
+// queryChecksum hashes every entry in a key range, in key order
+// e.g. {"prefix": "users/"} or {"start": "a", "end": "m"}
+// checksum = sha256(len(key) key len(value) value type | ...), the same
+// encoding as the app hash, so two nodes with the same entries in the range
+// always get the same checksum, whatever order they were written in
+// every value in the range is read, so this is expensive on big ranges
+func (app *KVStoreApplication) queryChecksum(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var creq checksumRequest
+	if !parseRequest(req, &res, &creq) {
+		return
+	}
+	prefix := app.normalizeKey([]byte(creq.Prefix))
+	start := app.normalizeKey([]byte(creq.Start))
+	end := app.normalizeKey([]byte(creq.End))
+
+	var sum []byte
+	var keys int64
+	err := app.store.View(func(txn Txn) (err error) {
+		sum, keys, err = checksumRange(txn, prefix, start, end)
+		return err
+	})
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, checksumResponse{Checksum: hex.EncodeToString(sum), Keys: keys})
+	return
+}
+
+// checksumRange hashes the user entries under prefix in [start, end) visible
+// to txn, see queryChecksum, an empty end is no end
+func checksumRange(txn Txn, prefix, start, end []byte) ([]byte, int64, error) {
+	h := sha256.New()
+	var keys int64
+	opts := DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	seek := prefix
+	if bytes.Compare(start, seek) > 0 {
+		seek = start
+	}
+	for it.Seek(seek); it.Valid(); it.Next() {
+		item := it.Item()
+		if len(end) > 0 && bytes.Compare(item.Key(), end) >= 0 {
+			break
+		}
+		if isInternalKey(item.Key()) {
+			continue
+		}
+		err := item.Value(func(val []byte) error {
+			val, err := decodeValue(item.Key(), item.UserMeta(), val)
+			if err != nil {
+				return err
+			}
+			hashEntry(h, item.Key(), val, itemType(item))
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		keys++
+	}
+	return h.Sum(nil), keys, nil
+}
+
+type stateHashRequest struct {
+	// Force recomputes the hash from the store, which reads every entry
+	Force bool `json:"force"`
+}
+
+type stateHashResponse struct {
+	Height  int64  `json:"height"`
+	AppHash string `json:"app_hash"`
+	// Mode is "chained" or "merkle", see computeAppHash
+	Mode string `json:"mode"`
+	// Recomputed is only set when forced, in merkle mode it's the merkle
+	// root of the store, which should be the app hash, in chained mode the
+	// app hash commits to the history rather than the contents, so it's
+	// the store's checksum instead, for comparing with other nodes
+	Recomputed string `json:"recomputed,omitempty"`
+	// Matches is whether a recomputed merkle root is the app hash
+	Matches *bool `json:"matches,omitempty"`
+}
+
+// queryStateHash returns the app hash of the last Commit, it's cheap, it's
+// kept in memory, {"force": true} recomputes it from the store instead,
+// which reads every entry, as expensive as a checksum of the whole store
+// with commit batching a forced hash is of the last flushed block, the
+// height says which
+func (app *KVStoreApplication) queryStateHash(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var sreq stateHashRequest
+	if len(req.Data) > 0 && !parseRequest(req, &res, &sreq) {
+		return
+	}
+	sres := stateHashResponse{Height: app.committed.Height, AppHash: hex.EncodeToString(app.committed.AppHash), Mode: "chained"}
+	if app.merkleAppHash {
+		sres.Mode = "merkle"
+	}
+
+	if sreq.Force {
+		err := app.store.View(func(txn Txn) error {
+			s, err := readState(txn)
+			if err != nil {
+				return err
+			}
+			sres.Height, sres.AppHash = s.Height, hex.EncodeToString(s.AppHash)
+			var sum []byte
+			if app.merkleAppHash {
+				sum, err = merkleRoot(txn, app.localPrefixes)
+				matches := bytes.Equal(sum, s.stateHash())
+				sres.Matches = &matches
+			} else {
+				sum, _, err = checksumRange(txn, nil, nil, nil)
+			}
+			sres.Recomputed = hex.EncodeToString(sum)
+			return err
+		})
+		if err != nil {
+			app.queryReadFailed(&res, err)
+			return
+		}
+	}
+
+	res.Height = sres.Height
+	respondJSON(&res, sres)
+	return
+}
+
+type getDefaultRequest struct {
+	Key     string `json:"key"`
+	Default string `json:"default"`
+}
+
+type getDefaultResponse struct {
+	Value string `json:"value"`
+	// Found is false when Value is the default
+	Found bool `json:"found"`
+}
+
+// queryGetDefault looks up a key, returning the caller's default if it
+// doesn't exist, e.g. {"key": "config/timeout", "default": "30"}
+func (app *KVStoreApplication) queryGetDefault(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var greq getDefaultRequest
+	if !parseRequest(req, &res, &greq) {
+		return
+	}
+	key := app.normalizeKey([]byte(greq.Key))
+	res.Key = key
+
+	value, exists, err := app.get(key)
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+	gres := getDefaultResponse{Value: greq.Default, Found: exists}
+	if exists {
+		gres.Value = string(value)
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, gres)
+	return
+}
+
+type getRequest struct {
+	Key string `json:"key"`
+	// MaxSize fails the query if the value is bigger, Head returns only
+	// the first Head bytes of the value, 0 means no limit for either
+	MaxSize int `json:"max_size"`
+	Head    int `json:"head"`
+}
+
+type getResponse struct {
+	Value string `json:"value,omitempty"`
+	// Size is the size of the whole value, even when it isn't returned
+	Size      int  `json:"size"`
+	Found     bool `json:"found"`
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// queryGet looks up a key while letting the caller bound how much of the
+// value comes back, e.g. {"key": "blob", "max_size": 1024} fails with
+// QUERY_TOO_LARGE, reporting the size, if the value is over 1KB
+// and {"key": "blob", "head": 64} returns the first 64 bytes
+func (app *KVStoreApplication) queryGet(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var greq getRequest
+	if !parseRequest(req, &res, &greq) {
+		return
+	}
+	if greq.MaxSize < 0 || greq.Head < 0 || (greq.MaxSize > 0 && greq.Head > 0) {
+		res.Code = QUERY_INVALID
+		res.Log = "max_size and head must be positive and can't be combined"
+		return
+	}
+	key := app.normalizeKey([]byte(greq.Key))
+	res.Key = key
+
+	value, exists, err := app.get(key)
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+	gres := getResponse{Value: string(value), Size: len(value), Found: exists}
+	switch {
+	case greq.MaxSize > 0 && len(value) > greq.MaxSize:
+		res.Code = QUERY_TOO_LARGE
+		res.Log = fmt.Sprintf("value is %d bytes, over the max size of %d", len(value), greq.MaxSize)
+		gres.Value = ""
+	case greq.Head > 0 && len(value) > greq.Head:
+		gres.Value, gres.Truncated = string(value[:greq.Head]), true
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, gres)
+	return
+}
+
+// maxMultiGetKeys bounds the keys an mget query can read
+const maxMultiGetKeys = 100
+
+type multiGetRequest struct {
+	Keys []string `json:"keys"`
+}
+
+type multiGetEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	Found bool   `json:"found"`
+}
+
+type multiGetResponse struct {
+	// Height is the height every key was read at
+	Height int64           `json:"height"`
+	Values []multiGetEntry `json:"values"`
+}
+
+// queryMultiGet reads several keys as of the same block, e.g.
+// {"keys": ["from", "to"]}, a block committed while the keys are being read
+// can't make some of them old and some new, they're all read in one badger
+// transaction, along with the state, so the height is the one the values
+// are from even if the db is behind the last Commit, see WithCommitBatching
+// the values are returned in the order of the keys
+func (app *KVStoreApplication) queryMultiGet(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var mreq multiGetRequest
+	if !parseRequest(req, &res, &mreq) {
+		return
+	}
+	if len(mreq.Keys) == 0 || len(mreq.Keys) > maxMultiGetKeys {
+		res.Code = QUERY_INVALID
+		res.Log = fmt.Sprintf("mget takes between 1 and %d keys", maxMultiGetKeys)
+		return
+	}
+
+	mres := multiGetResponse{Values: make([]multiGetEntry, len(mreq.Keys))}
+	err := app.store.View(func(txn Txn) error {
+		s, err := readState(txn)
+		if err != nil {
+			return err
+		}
+		mres.Height = s.Height
+		for i, k := range mreq.Keys {
+			key := app.normalizeKey([]byte(k))
+			mres.Values[i].Key = string(key)
+			value, _, exists, err := lookup(txn, key)
+			if err != nil {
+				return err
+			}
+			mres.Values[i].Value, mres.Values[i].Found = string(value), exists
+			app.metrics.keyRead(key, len(value))
+		}
+		return nil
+	})
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+
+	res.Height = mres.Height
+	respondJSON(&res, mres)
+	return
+}
+
+type metaResponse struct {
+	Found bool   `json:"found"`
+	Type  string `json:"type,omitempty"`
+	Size  int    `json:"size"`
+	// ExpiresAt is the height the key is removed at, see expiry.go
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	// ModifiedAt is the height the key was last written at, see modified.go
+	ModifiedAt *int64 `json:"modified_at,omitempty"`
+	// WrittenAt is the block time the key was last written at, see written.go
+	WrittenAt *time.Time `json:"written_at,omitempty"`
+	// Version is the key's version, see versions.go
+	Version uint64 `json:"version,omitempty"`
+}
+
+// queryMeta returns what's known about the key in the query data without
+// its value, its type, size, when it expires and when it was last written
+func (app *KVStoreApplication) queryMeta(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	key := app.normalizeKey(req.Data)
+	res.Key = key
+
+	var mres metaResponse
+	err := app.store.View(func(txn Txn) error {
+		value, ct, exists, err := lookup(txn, key)
+		if err != nil || !exists {
+			return err
+		}
+		mres = metaResponse{Found: true, Type: ct.String(), Size: len(value)}
+		if mres.ExpiresAt, err = readExpiry(txn, key); err != nil {
+			return err
+		}
+		modified, found, err := readModified(txn, key)
+		if err != nil {
+			return err
+		}
+		if found {
+			mres.ModifiedAt = &modified
+		}
+		if app.keyVersions {
+			if mres.Version, err = readVersion(txn, key); err != nil {
+				return err
+			}
+		}
+		written, found, err := readWritten(txn, key)
+		if found {
+			t := time.Unix(0, written).UTC()
+			mres.WrittenAt = &t
+		}
+		return err
+	})
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, mres)
+	return
+}
+
+type verifyRequest struct {
+	Key string `json:"key"`
+	// Hash is the hex encoded sha256 of the expected value
+	Hash string `json:"hash"`
+}
+
+type verifyResponse struct {
+	Found bool `json:"found"`
+	Match bool `json:"match"`
+}
+
+// queryVerify checks the stored value against the sha256 hash of the value
+// the caller expects, without sending the value back
+// e.g. {"key": "blob", "hash": "2cf24dba..."}, the hash is always sha256
+func (app *KVStoreApplication) queryVerify(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var vreq verifyRequest
+	if !parseRequest(req, &res, &vreq) {
+		return
+	}
+	expected, err := hex.DecodeString(vreq.Hash)
+	if err != nil || len(expected) != sha256.Size {
+		res.Code = QUERY_INVALID
+		res.Log = "hash must be a hex encoded sha256 hash"
+		return
+	}
+	key := app.normalizeKey([]byte(vreq.Key))
+	res.Key = key
+
+	value, exists, err := app.get(key)
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+	sum := sha256.Sum256(value)
+
+	res.Height = app.committed.Height
+	respondJSON(&res, verifyResponse{Found: exists, Match: exists && bytes.Equal(sum[:], expected)})
+	return
+}
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+	defaultSearchScan  = 10000
+	maxSearchScan      = 100000
+)
+
+type searchRequest struct {
+	Prefix    string `json:"prefix"`
+	Substring string `json:"substring"`
+	Limit     int    `json:"limit"`
+	// MaxScan is how many keys are looked at before giving up
+	MaxScan int `json:"max_scan"`
+	// Start continues a previous search from that key
+	Start string `json:"start"`
+	// Budget is the longest the search can run, see querybudget.go
+	Budget string `json:"budget"`
+}
+
+type searchResponse struct {
+	Keys    []string `json:"keys"`
+	Scanned int      `json:"scanned"`
+	// Truncated is set if the search stopped early, at max_scan, limit
+	// or the time budget
+	Truncated bool `json:"truncated,omitempty"`
+	// Next is where to continue from if the search stopped early,
+	// empty once the whole prefix has been searched
+	Next string `json:"next,omitempty"`
+}
+
+// querySearch returns the keys under a prefix whose value contains a
+// substring, e.g. {"prefix": "users/", "substring": "alice"}
+// this is an expensive, best effort debugging tool, it reads every value
+// under the prefix, so it stops after max_scan keys (or limit matches, or
+// the time budget) and returns where it stopped, pass that as start to
+// carry on
+func (app *KVStoreApplication) querySearch(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var sreq searchRequest
+	if !parseRequest(req, &res, &sreq) {
+		return
+	}
+	if sreq.Substring == "" {
+		res.Code = QUERY_INVALID
+		res.Log = "substring can't be empty"
+		return
+	}
+	limit := clampLimit(sreq.Limit, defaultSearchLimit, maxSearchLimit)
+	maxScan := clampLimit(sreq.MaxScan, defaultSearchScan, maxSearchScan)
+	deadline, ok := app.queryDeadline(sreq.Budget, &res)
+	if !ok {
+		return
+	}
+	prefix := app.normalizeKey([]byte(sreq.Prefix))
+	substring := []byte(sreq.Substring)
+	start := prefix
+	if sreq.Start != "" {
+		start = app.normalizeKey([]byte(sreq.Start))
+	}
+
+	sres := searchResponse{Keys: []string{}}
+	err := app.store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(start); it.Valid(); it.Next() {
+			item := it.Item()
+			if isInternalKey(item.Key()) {
+				continue
+			}
+			if sres.Scanned == maxScan || len(sres.Keys) == limit || (sres.Scanned > 0 && deadline.passed()) {
+				sres.Truncated = true
+				sres.Next = string(item.Key())
+				return nil
+			}
+			sres.Scanned++
+			err := item.Value(func(val []byte) error {
+				val, err := decodeValue(item.Key(), item.UserMeta(), val)
+				if err != nil {
+					return err
+				}
+				if bytes.Contains(val, substring) {
+					sres.Keys = append(sres.Keys, string(item.Key()))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, sres)
+	return
+}
+
+const (
+	defaultScanLimit = 100
+	maxScanLimit     = 1000
+)
+
+type scanRequest struct {
+	// Start is the key to start from, the next_key of the previous
+	// page, empty for the first page
+	Start string `json:"start"`
+	Limit int    `json:"limit"`
+}
+
+type scanResponse struct {
+	Pairs []kvPair `json:"pairs"`
+	// NextKey is the start of the next page, empty on the last page
+	NextKey string `json:"next_key,omitempty"`
+}
+
+// queryScan pages through every user entry in key order
+// e.g. {"limit": 100} then {"start": <next_key>, "limit": 100} until there is
+// no next_key, pages are read from whatever is committed at the time, so
+// a key written between two pages shows up only if it sorts after the cursor
+func (app *KVStoreApplication) queryScan(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var sreq scanRequest
+	if !parseRequest(req, &res, &sreq) {
+		return
+	}
+	limit := clampLimit(sreq.Limit, defaultScanLimit, maxScanLimit)
+	start := prefixEnd(internalPrefix)
+	if sreq.Start != "" {
+		if key := app.normalizeKey([]byte(sreq.Start)); bytes.Compare(key, start) > 0 {
+			start = key
+		}
+	}
+
+	sres := scanResponse{Pairs: []kvPair{}}
+	err := app.store.View(func(txn Txn) error {
+		it := txn.NewIterator(DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(start); it.Valid(); it.Next() {
+			item := it.Item()
+			if len(sres.Pairs) == limit {
+				sres.NextKey = string(item.Key())
+				return nil
+			}
+			value, err := itemValue(item)
+			if err != nil {
+				return err
+			}
+			sres.Pairs = append(sres.Pairs, kvPair{Key: string(item.Key()), Value: string(value), Type: itemType(item).String()})
+		}
+		return nil
+	})
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, sres)
+	return
+}
+
+const (
+	defaultSizeHistScan = 100000
+	maxSizeHistScan     = 1000000
+)
+
+// sizeBuckets are the lower bounds of the value size buckets, in bytes,
+// every bucket is 4 times as wide as the one before it
+var sizeBuckets = []int64{0, 16, 64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+type sizeHistRequest struct {
+	Prefix  string `json:"prefix"`
+	MaxScan int    `json:"max_scan"`
+	// Start continues a previous histogram from that key
+	Start string `json:"start"`
+	// Budget is the longest the scan can run, see querybudget.go
+	Budget string `json:"budget"`
+}
+
+type sizeBucket struct {
+	Min int64 `json:"min"`
+	// Max is exclusive, it's left out for the last bucket
+	Max   int64 `json:"max,omitempty"`
+	Count int64 `json:"count"`
+}
+
+type sizeHistResponse struct {
+	Buckets []sizeBucket `json:"buckets"`
+	Scanned int64        `json:"scanned"`
+	// Truncated is set if the scan stopped at max_scan or the time
+	// budget, the histogram only covers the keys before Next in key order
+	Truncated bool `json:"truncated,omitempty"`
+	// Next is where to continue from if the scan was truncated, the
+	// counts of the histograms from there on add up to the whole prefix
+	Next string `json:"next,omitempty"`
+}
+
+// querySizeHist returns how many values, optionally only under a prefix,
+// fall into each size bucket, e.g. {"prefix": "users/"}
+// only keys are read, the sizes come from badger's entry metadata, but it
+// still visits every key so it stops after max_scan of them, or the time
+// budget, on a large store that makes the histogram an estimate from the
+// first keys, unless it's carried on from next
+func (app *KVStoreApplication) querySizeHist(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var hreq sizeHistRequest
+	if len(req.Data) > 0 && !parseRequest(req, &res, &hreq) {
+		return
+	}
+	maxScan := int64(clampLimit(hreq.MaxScan, defaultSizeHistScan, maxSizeHistScan))
+	deadline, ok := app.queryDeadline(hreq.Budget, &res)
+	if !ok {
+		return
+	}
+	prefix := app.normalizeKey([]byte(hreq.Prefix))
+	start := prefix
+	if len(prefix) == 0 {
+		start = prefixEnd(internalPrefix)
+	}
+	if hreq.Start != "" {
+		if key := app.normalizeKey([]byte(hreq.Start)); bytes.Compare(key, start) > 0 {
+			start = key
+		}
+	}
+
+	hres := sizeHistResponse{Buckets: make([]sizeBucket, len(sizeBuckets))}
+	for i, min := range sizeBuckets {
+		hres.Buckets[i].Min = min
+		if i+1 < len(sizeBuckets) {
+			hres.Buckets[i].Max = sizeBuckets[i+1]
+		}
+	}
+	err := app.store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(start); it.Valid(); it.Next() {
+			item := it.Item()
+			if isInternalKey(item.Key()) {
+				continue
+			}
+			if hres.Scanned == maxScan || (hres.Scanned > 0 && deadline.passed()) {
+				hres.Truncated = true
+				hres.Next = string(item.Key())
+				return nil
+			}
+			hres.Scanned++
+			size := item.ValueSize()
+			if item.UserMeta()&crcFlag != 0 {
+				size -= crcSize
+			}
+			i := len(sizeBuckets) - 1
+			for size < sizeBuckets[i] {
+				i--
+			}
+			hres.Buckets[i].Count++
+		}
+		return nil
+	})
+	if err != nil {
+		halt("Query", err)
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, hres)
+	return
+}
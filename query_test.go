@@ -0,0 +1,443 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+func TestStats(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "a=1", "b=22", "a=333")
+	var stats statsResponse
+	queryJSON(t, app, "stats", nil, &stats)
+	want := statsResponse{Keys: 2, ValueBytes: 5, Height: 1, AppHash: hex.EncodeToString(app.committed.AppHash)}
+	if stats != want {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+
+	deliverBlock(app, 2, "delprefix:b")
+	restarted := NewKVStoreApplicationWithStore(app.store)
+	queryJSON(t, restarted, "stats", nil, &stats)
+	want = statsResponse{Keys: 1, ValueBytes: 3, Height: 2, AppHash: hex.EncodeToString(app.committed.AppHash)}
+	if stats != want {
+		t.Errorf("after a restart got %+v, want %+v", stats, want)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "user3=1", "user1=1", "user2=1", "admin=1", "u=1")
+
+	var keys []string
+	queryJSON(t, app, "complete", []byte(`{"prefix": "user", "limit": 2}`), &keys)
+	if !reflect.DeepEqual(keys, []string{"user1", "user2"}) {
+		t.Errorf("got %q", keys)
+	}
+	queryJSON(t, app, "complete", []byte(`{"prefix": "us"}`), &keys)
+	if !reflect.DeepEqual(keys, []string{"user1", "user2", "user3"}) {
+		t.Errorf("got %q", keys)
+	}
+	queryJSON(t, app, "complete", []byte(`{"prefix": "x"}`), &keys)
+	if keys == nil || len(keys) != 0 {
+		t.Errorf("got %q, want an empty list", keys)
+	}
+}
+
+func TestCompleteLimit(t *testing.T) {
+	app := newTestApp(t)
+	var txs []string
+	for i := 0; i < maxCompleteLimit+10; i++ {
+		txs = append(txs, fmt.Sprintf("k%03d=1", i))
+	}
+	deliverBlock(app, 1, txs...)
+
+	var keys []string
+	queryJSON(t, app, "complete", []byte(`{}`), &keys)
+	if len(keys) != defaultCompleteLimit || keys[0] != "k000" {
+		t.Errorf("the default limit got %d keys starting at %q", len(keys), keys[0])
+	}
+	queryJSON(t, app, "complete", []byte(`{"limit": 100000}`), &keys)
+	if len(keys) != maxCompleteLimit {
+		t.Errorf("a large limit got %d keys, want the max of %d", len(keys), maxCompleteLimit)
+	}
+	if !sort.StringsAreSorted(keys) {
+		t.Error("the keys aren't sorted")
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "a/1=1", "a/2=1", "a-b=1", "b/1=1", "c=1", "d/x/y=1", "d:z=1")
+
+	var prefixes []prefixCount
+	queryJSON(t, app, "prefixes", nil, &prefixes)
+	want := []prefixCount{{Prefix: "a/"}, {Prefix: "b/"}, {Prefix: "d/"}}
+	if !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("got %+v, want %+v", prefixes, want)
+	}
+
+	queryJSON(t, app, "prefixes", []byte(`{"counts": true}`), &prefixes)
+	want = []prefixCount{{"a/", 2}, {"b/", 1}, {"d/", 1}}
+	if !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("with counts got %+v, want %+v", prefixes, want)
+	}
+
+	queryJSON(t, app, "prefixes", []byte(`{"separator": ":", "counts": true}`), &prefixes)
+	if want := []prefixCount{{"d:", 1}}; !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("a custom separator got %+v", prefixes)
+	}
+
+	queryJSON(t, app, "prefixes", []byte(`{"limit": 2}`), &prefixes)
+	if len(prefixes) != 2 {
+		t.Errorf("the limit got %+v", prefixes)
+	}
+
+	res := app.Query(abcitypes.RequestQuery{Path: "prefixes", Data: []byte(`{"separator": ""}`)})
+	if res.Code != QUERY_INVALID {
+		t.Errorf("an empty separator got code %d", res.Code)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	checksum := func(app *KVStoreApplication, data string) checksumResponse {
+		var res checksumResponse
+		queryJSON(t, app, "checksum", []byte(data), &res)
+		return res
+	}
+	// the same entries written in a different order, over different blocks
+	app := newTestApp(t)
+	deliverBlock(app, 1, "u/a=1", "u/b=2", "u/c=3", "v/a=4")
+	other := newTestApp(t)
+	deliverBlock(other, 1, "v/a=4", "u/c=3")
+	deliverBlock(other, 2, "u/b=x", "u/a=1")
+	deliverBlock(other, 3, "u/b=2")
+
+	for _, data := range []string{`{"prefix": "u/"}`, `{"start": "u/b", "end": "v/"}`, `{}`} {
+		a, b := checksum(app, data), checksum(other, data)
+		if a != b {
+			t.Errorf("%s: identical ranges got %+v and %+v", data, a, b)
+		}
+	}
+	if res := checksum(app, `{"prefix": "u/"}`); res.Keys != 3 {
+		t.Errorf("the prefix covered %d keys, want 3", res.Keys)
+	}
+	if res := checksum(app, `{"start": "u/b", "end": "v/"}`); res.Keys != 2 {
+		t.Errorf("the range covered %d keys, want 2", res.Keys)
+	}
+
+	before := checksum(other, `{"prefix": "u/"}`)
+	deliverBlock(other, 4, "u/c=4")
+	if after := checksum(other, `{"prefix": "u/"}`); after.Checksum == before.Checksum {
+		t.Error("a single edit didn't change the checksum")
+	}
+	// the edit is outside of these
+	if checksum(app, `{"prefix": "v/"}`) != checksum(other, `{"prefix": "v/"}`) {
+		t.Error("an edit changed the checksum of another prefix")
+	}
+	if checksum(app, `{"prefix": "u/", "end": "u/c"}`) != checksum(other, `{"prefix": "u/", "end": "u/c"}`) {
+		t.Error("an edit changed the checksum of a range before it")
+	}
+}
+
+func TestGetDefault(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "a=1", "empty=")
+	for data, want := range map[string]getDefaultResponse{
+		`{"key": "a", "default": "x"}`:     {Value: "1", Found: true},
+		`{"key": "b", "default": "x"}`:     {Value: "x", Found: false},
+		`{"key": "b"}`:                     {Value: "", Found: false},
+		`{"key": "empty", "default": "x"}`: {Value: "", Found: true},
+	} {
+		var got getDefaultResponse
+		queryJSON(t, app, "getdefault", []byte(data), &got)
+		if got != want {
+			t.Errorf("%s got %+v, want %+v", data, got, want)
+		}
+	}
+}
+
+func TestGetMaxSize(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "a=hello")
+	get := func(data string) (abcitypes.ResponseQuery, getResponse) {
+		res := app.Query(abcitypes.RequestQuery{Path: "get", Data: []byte(data)})
+		var gres getResponse
+		if err := json.Unmarshal(res.Value, &gres); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		return res, gres
+	}
+
+	for data, want := range map[string]getResponse{
+		`{"key": "a"}`:                {Value: "hello", Size: 5, Found: true},
+		`{"key": "a", "max_size": 5}`: {Value: "hello", Size: 5, Found: true},
+		`{"key": "a", "head": 2}`:     {Value: "he", Size: 5, Found: true, Truncated: true},
+		`{"key": "a", "head": 9}`:     {Value: "hello", Size: 5, Found: true},
+		`{"key": "b", "max_size": 1}`: {},
+	} {
+		if res, got := get(data); res.Code != 0 || got != want {
+			t.Errorf("%s got code %d and %+v, want %+v", data, res.Code, got, want)
+		}
+	}
+
+	res, got := get(`{"key": "a", "max_size": 4}`)
+	if res.Code != QUERY_TOO_LARGE || got != (getResponse{Size: 5, Found: true}) {
+		t.Errorf("a value over the max got code %d and %+v", res.Code, got)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "a=hello")
+	hash := func(value string) string {
+		sum := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(sum[:])
+	}
+	for _, c := range []struct {
+		key, hash string
+		want      verifyResponse
+	}{
+		{"a", hash("hello"), verifyResponse{Found: true, Match: true}},
+		{"a", hash("hellO"), verifyResponse{Found: true, Match: false}},
+		{"b", hash("hello"), verifyResponse{Found: false, Match: false}},
+	} {
+		var got verifyResponse
+		queryJSON(t, app, "verify", []byte(fmt.Sprintf(`{"key": %q, "hash": %q}`, c.key, c.hash)), &got)
+		if got != c.want {
+			t.Errorf("%s against %s got %+v, want %+v", c.key, c.hash, got, c.want)
+		}
+	}
+	for _, bad := range []string{"zz", hash("hello")[:10]} {
+		res := app.Query(abcitypes.RequestQuery{Path: "verify", Data: []byte(fmt.Sprintf(`{"key": "a", "hash": %q}`, bad))})
+		if res.Code != QUERY_INVALID {
+			t.Errorf("hash %q got code %d", bad, res.Code)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	app := newTestApp(t)
+	txs := []string{"v/1=name1"}
+	for i := 0; i < 10; i++ {
+		txs = append(txs, fmt.Sprintf("u/%d=name%d", i, i%3))
+	}
+	deliverBlock(app, 1, txs...)
+
+	for _, c := range []struct {
+		data string
+		want searchResponse
+	}{
+		{`{"prefix": "u/", "substring": "name1"}`, searchResponse{Keys: []string{"u/1", "u/4", "u/7"}, Scanned: 10}},
+		{`{"prefix": "u/", "substring": "nope"}`, searchResponse{Keys: []string{}, Scanned: 10}},
+		{`{"prefix": "u/", "substring": "name1", "limit": 2}`, searchResponse{Keys: []string{"u/1", "u/4"}, Scanned: 5, Truncated: true, Next: "u/5"}},
+		{`{"prefix": "u/", "substring": "name1", "max_scan": 3}`, searchResponse{Keys: []string{"u/1"}, Scanned: 3, Truncated: true, Next: "u/3"}},
+		{`{"prefix": "u/", "substring": "name1", "max_scan": 3, "start": "u/3"}`, searchResponse{Keys: []string{"u/4"}, Scanned: 3, Truncated: true, Next: "u/6"}},
+	} {
+		var got searchResponse
+		queryJSON(t, app, "search", []byte(c.data), &got)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s got %+v, want %+v", c.data, got, c.want)
+		}
+	}
+
+	res := app.Query(abcitypes.RequestQuery{Path: "search", Data: []byte(`{"prefix": "u/"}`)})
+	if res.Code != QUERY_INVALID {
+		t.Errorf("an empty substring got code %d", res.Code)
+	}
+}
+
+func TestScan(t *testing.T) {
+	// the mod index writes internal keys next to the user ones
+	app := newTestApp(t, WithModIndex(true))
+	want := map[string]kvPair{}
+	var txs []string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("%c/%d", 'a'+i%4, i)
+		want[key] = kvPair{Key: key, Value: fmt.Sprint(i), Type: "int"}
+		txs = append(txs, fmt.Sprintf("%s=%d;type=int", key, i))
+	}
+	deliverBlock(app, 1, txs...)
+
+	got := map[string]kvPair{}
+	var last, next string
+	pages := 0
+	for {
+		var sres scanResponse
+		queryJSON(t, app, "scan", []byte(fmt.Sprintf(`{"start": %q, "limit": 3}`, next)), &sres)
+		pages++
+		if len(sres.Pairs) > 3 {
+			t.Fatalf("a page of 3 has %d pairs", len(sres.Pairs))
+		}
+		for _, p := range sres.Pairs {
+			if p.Key <= last {
+				t.Fatalf("%s comes after %s", p.Key, last)
+			}
+			last = p.Key
+			got[p.Key] = p
+		}
+		if sres.NextKey == "" {
+			break
+		}
+		next = sres.NextKey
+	}
+	if pages != 7 {
+		t.Errorf("20 keys took %d pages of 3", pages)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("the scan returned %v, want %v", got, want)
+	}
+}
+
+func TestSizeHist(t *testing.T) {
+	// the CRC isn't counted in a value's size
+	app := newTestApp(t, WithValueCRC(true))
+	deliverBlock(app, 1, "a/x=", "a/y="+strings.Repeat("v", 15), "a/z="+strings.Repeat("v", 16),
+		"a/w="+strings.Repeat("v", 5000), "b="+strings.Repeat("v", 2<<20))
+
+	// counts maps the min of every non empty bucket to its count
+	counts := func(data string) (map[int64]int64, sizeHistResponse) {
+		var res sizeHistResponse
+		queryJSON(t, app, "sizehist", []byte(data), &res)
+		var total int64
+		m := map[int64]int64{}
+		for _, b := range res.Buckets {
+			if b.Count > 0 {
+				m[b.Min] = b.Count
+			}
+			total += b.Count
+		}
+		if total != res.Scanned {
+			t.Fatalf("%s: the buckets add up to %d of %d scanned", data, total, res.Scanned)
+		}
+		return m, res
+	}
+
+	for data, want := range map[string]map[int64]int64{
+		`{}`:                 {0: 2, 16: 1, 4096: 1, 1 << 20: 1},
+		`{"prefix": "a/"}`:   {0: 2, 16: 1, 4096: 1},
+		`{"prefix": "none"}`: {},
+	} {
+		if got, _ := counts(data); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s got buckets %v, want %v", data, got, want)
+		}
+	}
+
+	// a truncated scan carries on from next, the halves add up
+	first, res := counts(`{"max_scan": 2}`)
+	if !res.Truncated || res.Next != "a/y" || !reflect.DeepEqual(first, map[int64]int64{0: 1, 4096: 1}) {
+		t.Fatalf("the first half got %v and %+v", first, res)
+	}
+	rest, res := counts(fmt.Sprintf(`{"start": %q}`, res.Next))
+	if res.Truncated || !reflect.DeepEqual(rest, map[int64]int64{0: 1, 16: 1, 1 << 20: 1}) {
+		t.Fatalf("the second half got %v and %+v", rest, res)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	app := newTestApp(t, WithTxIndex(0), WithModIndex(true))
+	empty := func() bool {
+		t.Helper()
+		var res emptyResponse
+		queryJSON(t, app, "empty", nil, &res)
+		return res.Empty
+	}
+	if !empty() {
+		t.Fatal("a fresh store isn't empty")
+	}
+	// the block leaves the state record and a receipt behind
+	deliverBlock(app, 1, "\x00a=1")
+	if !empty() {
+		t.Fatal("the app's own keys count as user keys")
+	}
+	deliverBlock(app, 2, "a=1")
+	if empty() {
+		t.Fatal("the store is empty after a write")
+	}
+	deliverBlock(app, 3, "delprefix:a")
+	if !empty() {
+		t.Fatal("the store isn't empty after its only key was deleted")
+	}
+}
+
+func TestMultiGet(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "a=1", "b=2")
+	var res multiGetResponse
+	queryJSON(t, app, "mget", []byte(`{"keys": ["b", "zz", "a"]}`), &res)
+	want := multiGetResponse{Height: 1, Values: []multiGetEntry{{"b", "2", true}, {"zz", "", false}, {"a", "1", true}}}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("got %+v, want %+v", res, want)
+	}
+}
+
+func TestMultiGetIsNotTorn(t *testing.T) {
+	app := NewKVStoreApplication(testDB(t))
+	// every block writes both keys with its own height
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for h := int64(1); h <= 200; h++ {
+			deliverBlock(app, h, fmt.Sprintf("a=%d", h), fmt.Sprintf("b=%d", h))
+		}
+	}()
+
+	for reads := 0; ; reads++ {
+		select {
+		case <-done:
+			if reads == 0 {
+				t.Fatal("no reads ran alongside the blocks")
+			}
+			return
+		default:
+		}
+		var res multiGetResponse
+		queryJSON(t, app, "mget", []byte(`{"keys": ["a", "b"]}`), &res)
+		a, b := res.Values[0], res.Values[1]
+		if a.Found != b.Found || a.Value != b.Value {
+			t.Fatalf("a torn read at height %d: %+v and %+v", res.Height, a, b)
+		}
+		if res.Height > 0 && a.Value != fmt.Sprint(res.Height) {
+			t.Fatalf("values %s are from another height than %d", a.Value, res.Height)
+		}
+	}
+}
+
+func TestStateHash(t *testing.T) {
+	for _, merkle := range []bool{false, true} {
+		app := NewKVStoreApplication(testDB(t), WithMerkleAppHash(merkle))
+		deliverBlock(app, 1, "a=1")
+		app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 2}})
+		app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("b=2")})
+		app.EndBlock(abcitypes.RequestEndBlock{Height: 2})
+		commit := app.Commit()
+
+		var sres stateHashResponse
+		queryJSON(t, app, "statehash", nil, &sres)
+		if sres.Height != 2 || sres.AppHash != hex.EncodeToString(commit.Data) || sres.Recomputed != "" || sres.Matches != nil {
+			t.Errorf("merkle %v: statehash is %+v, want the app hash %X of the last Commit", merkle, sres, commit.Data)
+		}
+
+		queryJSON(t, app, "statehash", []byte(`{"force":true}`), &sres)
+		if merkle {
+			if sres.Mode != "merkle" || sres.Recomputed != sres.AppHash || sres.Matches == nil || !*sres.Matches {
+				t.Errorf("forced merkle statehash is %+v", sres)
+			}
+			continue
+		}
+		// in chained mode it's the checksum of the whole store
+		var cres checksumResponse
+		queryJSON(t, app, "checksum", []byte(`{}`), &cres)
+		if sres.Mode != "chained" || sres.Recomputed != cres.Checksum || sres.Matches != nil {
+			t.Errorf("forced chained statehash is %+v, want the checksum %s", sres, cres.Checksum)
+		}
+	}
+}
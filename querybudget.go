@@ -0,0 +1,49 @@
+package main
+
+import (
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// The expensive scans, "search", "sizehist" and "sum", stop once they've
+// run for the query time budget (WithQueryTimeBudget) and answer with what
+// they found so far, "truncated": true and a cursor to carry on from, so a
+// heavy query can't hold a read, and a query slot, for long
+// a request can ask for a shorter budget, e.g. {"budget": "50ms"}, but not a
+// longer one, without a node budget any budget can be asked for, and there's
+// none by default
+// the budget is wall-clock time on the node answering, so the same query can
+// be cut at a different key on another node, or on a retry, the cursor
+// always resumes right after the last key covered, and a scan always covers
+// at least one key before it gives up, so it makes progress
+
+// scanDeadline is when a query's scan has to stop, zero for never
+type scanDeadline time.Time
+
+// queryDeadline returns the deadline for a query asking for the given
+// budget, empty for the node's, false if it isn't a valid duration
+func (app *KVStoreApplication) queryDeadline(budget string, res *abcitypes.ResponseQuery) (scanDeadline, bool) {
+	d := app.queryBudget
+	if budget != "" {
+		requested, err := time.ParseDuration(budget)
+		if err != nil || requested <= 0 {
+			res.Code = QUERY_INVALID
+			res.Log = "budget must be a positive duration, e.g. 50ms"
+			return scanDeadline{}, false
+		}
+		if d == 0 || requested < d {
+			d = requested
+		}
+	}
+	if d == 0 {
+		return scanDeadline{}, true
+	}
+	return scanDeadline(time.Now().Add(d)), true
+}
+
+// passed returns true once the deadline has passed
+func (d scanDeadline) passed() bool {
+	t := time.Time(d)
+	return !t.IsZero() && time.Now().After(t)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestQueryBudget(t *testing.T) {
+	// a budget so small every scan stops after its first key or so
+	app := newTestApp(t, WithQueryTimeBudget(1))
+	var txs []string
+	var wantSum int64
+	wantKeys := 0
+	for i := 0; i < 500; i++ {
+		txs = append(txs, fmt.Sprintf("k%05d=%d", i, i))
+		wantSum += int64(i)
+		if strings.Contains(fmt.Sprint(i), "12") {
+			wantKeys++
+		}
+	}
+	deliverBlock(app, 1, txs...)
+
+	// each scan is cut short, and resuming from next covers every key once
+	var keys []string
+	pages := 0
+	for start := ""; ; pages++ {
+		var s searchResponse
+		queryJSON(t, app, "search", []byte(fmt.Sprintf(`{"substring": "12", "start": %q, "max_scan": 100000}`, start)), &s)
+		keys = append(keys, s.Keys...)
+		if s.Truncated != (s.Next != "") {
+			t.Fatalf("search truncated is %v with next %q", s.Truncated, s.Next)
+		}
+		if start = s.Next; start == "" {
+			break
+		}
+	}
+	if pages < 10 || len(keys) != wantKeys {
+		t.Errorf("search took %d pages to find %d keys, want %d", pages, len(keys), wantKeys)
+	}
+
+	var scanned int64
+	pages = 0
+	for start := ""; ; pages++ {
+		var h sizeHistResponse
+		queryJSON(t, app, "sizehist", []byte(fmt.Sprintf(`{"start": %q}`, start)), &h)
+		scanned += h.Scanned
+		if start = h.Next; start == "" {
+			break
+		}
+		if !h.Truncated {
+			t.Fatalf("sizehist has next %q but isn't truncated", h.Next)
+		}
+	}
+	if pages < 10 || scanned != 500 {
+		t.Errorf("sizehist took %d pages to scan %d keys", pages, scanned)
+	}
+
+	var sum, summed int64
+	pages = 0
+	for start := ""; ; pages++ {
+		var s sumResponse
+		queryJSON(t, app, "sum", []byte(fmt.Sprintf(`{"start": %q}`, start)), &s)
+		sum += s.Sum.Int64()
+		summed += s.Keys
+		if start = s.Next; start == "" {
+			break
+		}
+	}
+	if pages < 10 || sum != wantSum || summed != 500 {
+		t.Errorf("sum took %d pages to sum %d keys to %d, want %d", pages, summed, sum, wantSum)
+	}
+
+	// a request can only shorten the node's budget, without one it can
+	// ask for any
+	plain := newTestApp(t)
+	deliverBlock(plain, 1, txs...)
+	var h sizeHistResponse
+	queryJSON(t, plain, "sizehist", []byte(`{"budget": "1h"}`), &h)
+	if h.Truncated || h.Scanned != 500 {
+		t.Errorf("an hour's budget got %+v", h)
+	}
+	queryJSON(t, app, "sizehist", []byte(`{"budget": "1h"}`), &h)
+	if !h.Truncated {
+		t.Error("a request's budget overrode the node's")
+	}
+	for _, budget := range []string{"-1s", "0", "soon"} {
+		res := plain.Query(abcitypes.RequestQuery{Path: "search", Data: []byte(fmt.Sprintf(`{"substring": "x", "budget": %q}`, budget))})
+		if res.Code != QUERY_INVALID {
+			t.Errorf("a budget of %s got code %d, want QUERY_INVALID", budget, res.Code)
+		}
+	}
+}
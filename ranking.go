@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// With a ranking (WithRanking) every int key under the ranking's prefix is
+// a member scored by its value, e.g. with the prefix 'board/' the key
+// 'board/alice' with the value 10 is the member alice with a score of 10
+// the members are indexed by score, so the "topn" query can return the
+// highest scored ones without reading the whole namespace
+//
+//	rankScorePrefix | key                 -> score of the key
+//	rankPrefix      | score (8 bytes) key -> nothing, highest score first
+//
+// the index is kept up to date by set and remove, so a member can also be
+// written with 'key=value;type=int' or expr, writing a value of another
+// type, or removing the key, drops the member
+
+var (
+	rankPrefix      = internalKey("rank/")
+	rankScorePrefix = internalKey("ranks/")
+)
+
+func rankScoreKey(key []byte) []byte {
+	return append(append([]byte{}, rankScorePrefix...), key...)
+}
+
+// rankKey orders the entries by score, highest first, the sign bit is
+// flipped so negative scores sort below positive ones, then every bit is
+// inverted so a forward iteration goes from the highest down, members with
+// the same score are ordered by key
+func rankKey(score int64, key []byte) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], ^(uint64(score) ^ 1<<63))
+	return append(append(append([]byte{}, rankPrefix...), b[:]...), key...)
+}
+
+func rankScore(b []byte) int64 {
+	return int64(^binary.BigEndian.Uint64(b) ^ 1<<63)
+}
+
+// ranked returns whether key is in the ranking's namespace
+func (app *KVStoreApplication) ranked(key []byte) bool {
+	return app.ranking && bytes.HasPrefix(key, app.rankingPrefix)
+}
+
+// clearScore removes the key from the score index
+func (app *KVStoreApplication) clearScore(key []byte) error {
+	if !app.ranked(key) {
+		return nil
+	}
+	item, err := app.currentBatch.Get(rankScoreKey(key))
+	if err == ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var score int64
+	err = item.Value(func(val []byte) error {
+		score = int64(binary.BigEndian.Uint64(val))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := app.currentBatch.Delete(rankKey(score, key)); err != nil {
+		return err
+	}
+	return app.currentBatch.Delete(rankScoreKey(key))
+}
+
+// updateScore indexes the value written to key by its score, if it's a member
+func (app *KVStoreApplication) updateScore(key, value []byte, ct contentType) error {
+	if !app.ranked(key) {
+		return nil
+	}
+	if err := app.clearScore(key); err != nil {
+		return err
+	}
+	if ct != typeInt {
+		return nil
+	}
+	// the type was validated before the write
+	score, _ := strconv.ParseInt(string(value), 10, 64)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(score))
+	if err := app.currentBatch.Set(rankScoreKey(key), b[:]); err != nil {
+		return err
+	}
+	return app.currentBatch.Set(rankKey(score, key), nil)
+}
+
+// zadd:member:score sets the score of a member of the ranking, adding it if
+// it isn't one yet, e.g. 'zadd:alice:10', the member is written as an int
+// key under the ranking's prefix
+func init() {
+	registerOp(&txOp{
+		name: "zadd",
+		args: 2,
+		keys: []int{0},
+		parse: func(args [][]byte) error {
+			if _, err := strconv.ParseInt(string(args[1]), 10, 64); err != nil {
+				return reject(INVALID_FORMAT, "zadd score must be an integer")
+			}
+			return nil
+		},
+		enabled: func(app *KVStoreApplication) bool {
+			return app.ranking
+		},
+		check: func(app *KVStoreApplication, txn Txn, t transaction) error {
+			key := app.memberKey(t.args[0])
+			if err := app.checkKey(key); err != nil {
+				return err
+			}
+			_, _, exists, err := lookup(txn, key)
+			if err != nil {
+				return err
+			}
+			if exists && app.appendOnly {
+				return errOverwriteForbidden
+			}
+			return nil
+		},
+		apply: func(app *KVStoreApplication, t transaction) error {
+			return app.set(app.memberKey(t.args[0]), t.args[1], typeInt)
+		},
+	})
+}
+
+// memberKey returns the key a member of the ranking is stored under
+func (app *KVStoreApplication) memberKey(member []byte) []byte {
+	return append(append([]byte{}, app.rankingPrefix...), member...)
+}
+
+const (
+	defaultTopNLimit = 10
+	maxTopNLimit     = 1000
+)
+
+type topNRequest struct {
+	Limit int `json:"limit"`
+}
+
+type memberResponse struct {
+	Member string `json:"member"`
+	Score  int64  `json:"score"`
+}
+
+type topNResponse struct {
+	Members []memberResponse `json:"members"`
+}
+
+// queryTopN returns the highest scored members of the ranking, highest
+// first, e.g. {"limit": 3}, only available with a ranking
+func (app *KVStoreApplication) queryTopN(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if !app.ranking {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "there is no ranking on this network"
+		return
+	}
+	var treq topNRequest
+	if len(req.Data) > 0 && !parseRequest(req, &res, &treq) {
+		return
+	}
+	limit := clampLimit(treq.Limit, defaultTopNLimit, maxTopNLimit)
+
+	tres := topNResponse{Members: []memberResponse{}}
+	err := app.store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = rankPrefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(rankPrefix); it.Valid() && len(tres.Members) < limit; it.Next() {
+			entry := it.Item().Key()[len(rankPrefix):]
+			// an entry indexed under another ranking prefix isn't a
+			// member of this one
+			if len(entry) < 8 || !bytes.HasPrefix(entry[8:], app.rankingPrefix) {
+				continue
+			}
+			tres.Members = append(tres.Members, memberResponse{
+				Member: string(entry[8+len(app.rankingPrefix):]),
+				Score:  rankScore(entry[:8]),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		halt("Query", err)
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, tres)
+	return
+}
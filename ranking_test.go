@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTopNSkipsEntriesOfAnotherPrefix(t *testing.T) {
+	store := NewMemStore()
+	app := NewKVStoreApplicationWithStore(store, WithRanking("b/"))
+	deliverBlock(app, 1, "b/x=5;type=int")
+
+	app = NewKVStoreApplicationWithStore(store, WithRanking("b/longer/"))
+	deliverBlock(app, 2, "b/longer/y=3;type=int")
+	var tres topNResponse
+	queryJSON(t, app, "topn", []byte(`{"limit": 10}`), &tres)
+	if len(tres.Members) != 1 || tres.Members[0].Member != "y" || tres.Members[0].Score != 3 {
+		t.Errorf("got %+v, want only y", tres.Members)
+	}
+}
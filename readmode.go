@@ -0,0 +1,55 @@
+package main
+
+import (
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// Queries read in one of two modes
+//
+// Committed, every query path but "pending": a query only ever sees blocks
+// that have been committed and flushed to the db, a block that's still
+// being delivered is invisible to it, however far along it is, and so is
+// any block committed since the last flush with commit batching, the
+// writes only reach the db once the block is over, in one badger commit
+//
+// Read your writes, the "pending" path: a key lookup like the default path
+// that reads through the batch the open block writes to, so it sees the
+// writes of the transactions delivered so far in the block, and of the
+// blocks waiting for a flush, none of which are final, the block can still
+// be discarded, or the node crash before it's flushed, it's meant for
+// debugging and for a client following its own transactions through a
+// block, never for data that has to be committed
+// it reads the batch DeliverTx writes to, which is only safe because
+// tendermint never runs a Query at the same time as DeliverTx, so it must
+// only be queried through tendermint, never by calling Query directly from
+// another goroutine
+
+// queryPending looks up a key the way queryKey does, see read your writes
+// above, the height is the one of the block the value is from, the info is
+// "uncommitted" if that block is still open, "unflushed" if it's committed
+// but not flushed, and empty if there's nothing pending, the value is then
+// the committed one
+func (app *KVStoreApplication) queryPending(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if app.currentBatch == nil {
+		res = app.queryKey(req)
+		res.Height = app.committed.Height
+		return
+	}
+	res.Key = req.Data
+	res.Height, res.Info = app.committed.Height, "unflushed"
+	if app.blockOpen {
+		res.Height, res.Info = app.pending.Height, "uncommitted"
+	}
+	value, _, exists, err := lookup(app.currentBatch, app.normalizeKey(req.Data))
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+	if !exists {
+		res.Log = "does not exist"
+		return
+	}
+	res.Log = "exists"
+	res.Value = value
+	return
+}
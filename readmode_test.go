@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+func TestReadModes(t *testing.T) {
+	app := newTestApp(t, WithCommitBatching(2, 0))
+	deliverBlock(app, 1, "a=1", "b=1")
+
+	// block 1 is committed but not flushed, block 2 is open
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 2}})
+	app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("a=2")})
+	app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("c=2")})
+
+	// the committed mode sees neither
+	if res := app.Query(abcitypes.RequestQuery{Data: []byte("a")}); res.Value != nil {
+		t.Errorf("key query saw %q", res.Value)
+	}
+	var gres getResponse
+	queryJSON(t, app, "get", []byte(`{"key":"c"}`), &gres)
+	if gres.Found {
+		t.Errorf("get query saw c: %+v", gres)
+	}
+	var mres multiGetResponse
+	queryJSON(t, app, "mget", []byte(`{"keys":["a","b","c"]}`), &mres)
+	for _, e := range mres.Values {
+		if e.Found {
+			t.Errorf("mget query saw %+v", e)
+		}
+	}
+
+	// read your writes sees both, with the height of the block the value
+	// is from
+	for _, c := range []struct {
+		key, value, info string
+		height           int64
+	}{
+		{"a", "2", "uncommitted", 2},
+		{"b", "1", "uncommitted", 2},
+		{"c", "2", "uncommitted", 2},
+	} {
+		res := app.Query(abcitypes.RequestQuery{Path: "pending", Data: []byte(c.key)})
+		if string(res.Value) != c.value || res.Info != c.info || res.Height != c.height {
+			t.Errorf("pending %s got %q %q at %d, want %q %q at %d", c.key, res.Value, res.Info, res.Height, c.value, c.info, c.height)
+		}
+	}
+	app.EndBlock(abcitypes.RequestEndBlock{Height: 2})
+
+	// committing block 2 flushes both, so there's nothing pending and the
+	// two modes agree
+	app.Commit()
+	if res := app.Query(abcitypes.RequestQuery{Data: []byte("a")}); string(res.Value) != "2" {
+		t.Errorf("key query got %q after the flush", res.Value)
+	}
+	if res := app.Query(abcitypes.RequestQuery{Path: "pending", Data: []byte("a")}); string(res.Value) != "2" || res.Info != "" || res.Height != 2 {
+		t.Errorf("pending got %q %q at %d after the flush", res.Value, res.Info, res.Height)
+	}
+
+	// a committed block waiting for the flush is "unflushed"
+	deliverBlock(app, 3, "a=3")
+	if res := app.Query(abcitypes.RequestQuery{Path: "pending", Data: []byte("a")}); string(res.Value) != "3" || res.Info != "unflushed" || res.Height != 3 {
+		t.Errorf("pending got %q %q at %d, want the unflushed block 3", res.Value, res.Info, res.Height)
+	}
+	if res := app.Query(abcitypes.RequestQuery{Data: []byte("a")}); string(res.Value) != "2" {
+		t.Errorf("key query got %q before the flush", res.Value)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// Read stats (WithReadStats) count the reads of each key, so the hot keys
+// can be found, e.g. to size the read cache, see cache.go, or to decide
+// where to split the store, the "readstats" query lists them, most read
+// first, e.g. {"limit": 20}
+// reads are the queries that look up a value by key, like the per prefix
+// metrics, see prefixmetrics.go, a key that doesn't exist counts too
+//
+// only a bounded number of keys is tracked, the space saving algorithm
+// decides which, once the table is full a key read for the first time
+// takes the place of the least read one, starting from its count, so a key
+// read often enough always ends up in the table, its count can be over by
+// up to the count it started from, reported as its error
+// the counts are kept in memory on this node only, they start over when
+// it restarts, they're never part of the state
+
+const (
+	defaultReadStatsLimit = 100
+	maxReadStatsLimit     = 1000
+)
+
+type readCount struct {
+	reads int64
+	// over is how much reads can be over the key's actual reads
+	over int64
+}
+
+type readStats struct {
+	mtx    sync.Mutex
+	size   int
+	counts map[string]*readCount
+	total  int64
+	since  time.Time
+}
+
+func newReadStats(size int) *readStats {
+	return &readStats{size: size, counts: make(map[string]*readCount, size), since: time.Now()}
+}
+
+// record counts a read of key
+func (s *readStats) record(key []byte) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.total++
+	if c, ok := s.counts[string(key)]; ok {
+		c.reads++
+		return
+	}
+	if len(s.counts) < s.size {
+		s.counts[string(key)] = &readCount{reads: 1}
+		return
+	}
+	var least string
+	var min *readCount
+	for k, c := range s.counts {
+		if min == nil || c.reads < min.reads {
+			least, min = k, c
+		}
+	}
+	delete(s.counts, least)
+	s.counts[string(key)] = &readCount{reads: min.reads + 1, over: min.reads}
+}
+
+type readStatsRequest struct {
+	Limit int `json:"limit"`
+}
+
+type keyReads struct {
+	Key   string `json:"key"`
+	Reads int64  `json:"reads"`
+	// Error is how much Reads can be over the key's actual reads
+	Error int64 `json:"error,omitempty"`
+}
+
+type readStatsResponse struct {
+	// Since is when the node started counting
+	Since time.Time `json:"since"`
+	// Reads is the number of reads counted, of every key
+	Reads int64 `json:"reads"`
+	// Tracked is the number of keys in the table
+	Tracked int        `json:"tracked"`
+	Keys    []keyReads `json:"keys"`
+}
+
+// queryReadStats returns the most read keys, see readstats.go
+func (app *KVStoreApplication) queryReadStats(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if app.readStats == nil {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "read stats are disabled"
+		return
+	}
+	var rreq readStatsRequest
+	if len(req.Data) > 0 && !parseRequest(req, &res, &rreq) {
+		return
+	}
+	limit := clampLimit(rreq.Limit, defaultReadStatsLimit, maxReadStatsLimit)
+
+	s := app.readStats
+	s.mtx.Lock()
+	rres := readStatsResponse{Since: s.since.UTC(), Reads: s.total, Tracked: len(s.counts), Keys: make([]keyReads, 0, len(s.counts))}
+	for k, c := range s.counts {
+		rres.Keys = append(rres.Keys, keyReads{Key: k, Reads: c.reads, Error: c.over})
+	}
+	s.mtx.Unlock()
+
+	sort.Slice(rres.Keys, func(i, j int) bool {
+		a, b := rres.Keys[i], rres.Keys[j]
+		if a.Reads != b.Reads {
+			return a.Reads > b.Reads
+		}
+		return a.Key < b.Key
+	})
+	if len(rres.Keys) > limit {
+		rres.Keys = rres.Keys[:limit]
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, rres)
+	return
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestReadStats(t *testing.T) {
+	app := newTestApp(t, WithReadStats(3))
+	deliverBlock(app, 1, "hot=1", "warm=2")
+	read := func(n int, req abcitypes.RequestQuery) {
+		for i := 0; i < n; i++ {
+			app.Query(req)
+		}
+	}
+	read(5, abcitypes.RequestQuery{Data: []byte("hot")})
+	read(3, abcitypes.RequestQuery{Path: "get", Data: []byte(`{"key": "warm"}`)})
+	// a key that doesn't exist counts too
+	read(1, abcitypes.RequestQuery{Data: []byte("missing")})
+	read(1, abcitypes.RequestQuery{Data: []byte("cold")})
+
+	var stats readStatsResponse
+	queryJSON(t, app, "readstats", []byte(`{"limit": 3}`), &stats)
+	if stats.Reads != 10 || stats.Tracked != 3 {
+		t.Errorf("got %d reads of %d tracked keys, want 10 of 3", stats.Reads, stats.Tracked)
+	}
+	want := []keyReads{{Key: "hot", Reads: 5}, {Key: "warm", Reads: 3}}
+	if len(stats.Keys) != 3 || stats.Keys[0] != want[0] || stats.Keys[1] != want[1] {
+		t.Errorf("the most read keys are %+v, want %+v first", stats.Keys, want)
+	}
+	// cold took the place of missing, starting from its count
+	if last := stats.Keys[2]; last.Key != "cold" || last.Reads != 2 || last.Error != 1 {
+		t.Errorf("the third key is %+v, want cold at 2 with an error of 1", last)
+	}
+
+	// and the reads count as they go
+	read(1, abcitypes.RequestQuery{Data: []byte("warm")})
+	queryJSON(t, app, "readstats", []byte(`{"limit": 1}`), &stats)
+	if stats.Reads != 11 || len(stats.Keys) != 1 || stats.Keys[0].Key != "hot" {
+		t.Errorf("after another read got %+v", stats)
+	}
+
+	if res := newTestApp(t).Query(abcitypes.RequestQuery{Path: "readstats"}); res.Code != QUERY_NOT_ALLOWED {
+		t.Errorf("readstats without WithReadStats got code %d", res.Code)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/merkle"
+	tmcrypto "github.com/tendermint/tendermint/proto/tendermint/crypto"
+)
+
+// With the receipts root (WithReceiptsRoot) every block's DeliverTx results
+// are committed to in the app hash, so a client can prove the outcome of a
+// transaction, a receipt is a result as DeliverTx returned it, the leaves of
+// the block's receipts tree are, in delivery order
+//
+//	sha256(tx) | code (4 bytes)
+//
+// hashed as tendermint's crypto/merkle does, and the app hash becomes the
+// root of a tree of two leaves, the state hash, what the app hash would be
+// without receipts, and the receipts root
+//
+//	app hash = merkle([state hash, receipts root])
+//
+// so the key and range proofs of merkle mode are against the state hash, the
+// proofs come with a PROOF_OP_STATE op tying it to the app hash, VerifyProof
+// checks it, the state hash and the receipts of every block are kept
+//
+//	receiptsPrefix | height -> receiptsBlock as JSON
+//
+// the "receiptproof" query proves a transaction's receipt, e.g.
+// {"height": 10, "hash": "<hex>"}, VerifyReceiptProof checks it against the
+// app hash of the block, the one in the header of the next block
+// every node has to agree on the setting, it changes the app hash, and like
+// the rest of the store the receipts of a block are only readable once it's
+// flushed
+
+// PROOF_OP_STATE is the proof op type tying the state hash to the app hash
+const PROOF_OP_STATE = "kvstore:state"
+
+var receiptsPrefix = internalKey("rcpt/")
+
+func receiptsKey(height int64) []byte {
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], uint64(height))
+	return append(append([]byte{}, receiptsPrefix...), h[:]...)
+}
+
+// receiptsBlock is the record of a block's receipts
+type receiptsBlock struct {
+	StateHash []byte   `json:"state_hash"`
+	Leaves    [][]byte `json:"leaves"`
+}
+
+// StateProof ties a state hash to the app hash it's part of
+type StateProof struct {
+	StateHash    []byte `json:"state_hash"`
+	ReceiptsRoot []byte `json:"receipts_root"`
+}
+
+// Verify checks the proof against the app hash
+func (p StateProof) Verify(appHash []byte) error {
+	if !bytes.Equal(receiptsAppHash(p.StateHash, p.ReceiptsRoot), appHash) {
+		return errors.New("state hash and receipts root don't make up the app hash")
+	}
+	return nil
+}
+
+// ReceiptProof proves the receipt of a transaction in a block
+type ReceiptProof struct {
+	Height int64  `json:"height"`
+	TxHash []byte `json:"tx_hash"`
+	Code   uint32 `json:"code"`
+	// Proof is the receipt's inclusion proof in the receipts root, its
+	// index is the transaction's position in the block
+	Proof merkle.Proof `json:"proof"`
+	StateProof
+}
+
+// VerifyReceiptProof checks the receipt proof against the app hash of the
+// block, returns nil only if the transaction was delivered in it with the
+// proof's code
+func VerifyReceiptProof(appHash []byte, p ReceiptProof) error {
+	if err := p.StateProof.Verify(appHash); err != nil {
+		return err
+	}
+	return p.Proof.Verify(p.ReceiptsRoot, receiptLeaf(p.TxHash, p.Code))
+}
+
+func receiptLeaf(txHash []byte, code uint32) []byte {
+	var c [4]byte
+	binary.BigEndian.PutUint32(c[:], code)
+	return append(append([]byte{}, txHash...), c[:]...)
+}
+
+// receiptsAppHash returns the app hash made of the state hash and the
+// receipts root
+func receiptsAppHash(stateHash, receiptsRoot []byte) []byte {
+	return merkle.HashFromByteSlices([][]byte{stateHash, receiptsRoot})
+}
+
+// addReceipt records the result of a transaction delivered in the current
+// block
+func (app *KVStoreApplication) addReceipt(tx []byte, code uint32) {
+	if !app.receiptsRoot {
+		return
+	}
+	hash := sha256.Sum256(tx)
+	app.receipts = append(app.receipts, receiptLeaf(hash[:], code))
+}
+
+// readReceipts returns the receipts of the block at height as seen by txn,
+// false if there are none
+func readReceipts(txn Txn, height int64) (block receiptsBlock, found bool, err error) {
+	item, err := txn.Get(receiptsKey(height))
+	if err == ErrKeyNotFound {
+		return block, false, nil
+	}
+	if err != nil {
+		return block, false, err
+	}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &block)
+	})
+	return block, err == nil, err
+}
+
+// saveReceipts sets the app hash from the state hash and the block's
+// receipts, and records them
+func (app *KVStoreApplication) saveReceipts(stateHash []byte) error {
+	block := receiptsBlock{StateHash: stateHash, Leaves: app.receipts}
+	app.receipts = nil
+	// writes outside a block, see replace.go, are committed at the
+	// height of the last block, which keeps its receipts
+	if !app.blockOpen {
+		old, _, err := readReceipts(app.currentBatch, app.pending.Height)
+		if err != nil {
+			return err
+		}
+		block.Leaves = old.Leaves
+	}
+	root := merkle.HashFromByteSlices(block.Leaves)
+	app.pending.StateHash, app.pending.ReceiptsRoot = stateHash, root
+	app.pending.AppHash = receiptsAppHash(stateHash, root)
+	val, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	return app.currentBatch.Set(receiptsKey(app.pending.Height), val)
+}
+
+// withStateProof adds the PROOF_OP_STATE op of the committed state to the
+// proof ops of a proof against the state hash, if there's a receipts root
+func (app *KVStoreApplication) withStateProof(ops []tmcrypto.ProofOp) []tmcrypto.ProofOp {
+	if !app.receiptsRoot {
+		return ops
+	}
+	data, _ := json.Marshal(StateProof{StateHash: app.committed.StateHash, ReceiptsRoot: app.committed.ReceiptsRoot})
+	return append(ops, tmcrypto.ProofOp{Type: PROOF_OP_STATE, Data: data})
+}
+
+type receiptProofRequest struct {
+	Height int64  `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// queryReceiptProof returns the ReceiptProof of a transaction delivered in
+// a block, see receipts.go
+func (app *KVStoreApplication) queryReceiptProof(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if !app.receiptsRoot {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "the receipts root is disabled"
+		return
+	}
+	var rreq receiptProofRequest
+	if !parseRequest(req, &res, &rreq) {
+		return
+	}
+	hash, err := hex.DecodeString(strings.TrimPrefix(rreq.Hash, "0x"))
+	if err != nil || len(hash) != sha256.Size {
+		res.Code = QUERY_INVALID
+		res.Log = "hash must be a hex encoded sha256 transaction hash"
+		return
+	}
+
+	var block receiptsBlock
+	var found bool
+	err = app.store.View(func(txn Txn) (err error) {
+		block, found, err = readReceipts(txn, rreq.Height)
+		return err
+	})
+	if err != nil {
+		halt("Query", err)
+	}
+	if !found {
+		res.Code = QUERY_INVALID
+		res.Log = "there are no receipts for that height"
+		if app.unflushedBlocks > 0 {
+			res.Log += ", it may not be flushed yet"
+		}
+		return
+	}
+
+	// a transaction delivered twice in the block has the receipt of
+	// its last delivery
+	index := -1
+	for i, leaf := range block.Leaves {
+		if bytes.HasPrefix(leaf, hash) {
+			index = i
+		}
+	}
+	if index < 0 {
+		res.Log = "does not exist"
+		return
+	}
+	root, proofs := merkle.ProofsFromByteSlices(block.Leaves)
+	proof := ReceiptProof{
+		Height:     rreq.Height,
+		TxHash:     hash,
+		Code:       binary.BigEndian.Uint32(block.Leaves[index][sha256.Size:]),
+		Proof:      *proofs[index],
+		StateProof: StateProof{StateHash: block.StateHash, ReceiptsRoot: root},
+	}
+
+	res.Log = "exists"
+	res.Height = rreq.Height
+	respondJSON(&res, proof)
+	return
+}
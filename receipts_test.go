@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestReceiptProof(t *testing.T) {
+	for _, merkle := range []bool{false, true} {
+		store := NewMemStore()
+		app := NewKVStoreApplicationWithStore(store, WithReceiptsRoot(true), WithMerkleAppHash(merkle))
+		txs := []string{"a=1", "bad", "b=2"}
+		res := deliverBlock(app, 1, txs...)
+		appHash := app.committed.AppHash
+
+		for i, tx := range txs {
+			hash := sha256.Sum256([]byte(tx))
+			var p ReceiptProof
+			queryJSON(t, app, "receiptproof", []byte(fmt.Sprintf(`{"height": 1, "hash": "%x"}`, hash)), &p)
+			if p.Code != res[i].Code || p.Proof.Index != int64(i) {
+				t.Errorf("merkle %v: the receipt of %q has code %d at %d", merkle, tx, p.Code, p.Proof.Index)
+			}
+			if err := VerifyReceiptProof(appHash, p); err != nil {
+				t.Errorf("merkle %v: the receipt of %q doesn't verify: %v", merkle, tx, err)
+			}
+			p.Code ^= 1
+			if VerifyReceiptProof(appHash, p) == nil {
+				t.Errorf("merkle %v: a receipt with the wrong code verified", merkle)
+			}
+		}
+		hash := sha256.Sum256([]byte("c=3"))
+		if r := app.Query(abcitypes.RequestQuery{Path: "receiptproof", Data: []byte(fmt.Sprintf(`{"height": 1, "hash": "%x"}`, hash))}); r.Log != "does not exist" {
+			t.Errorf("merkle %v: a tx that isn't in the block got a receipt", merkle)
+		}
+
+		deliverBlock(app, 2)
+		restarted := NewKVStoreApplicationWithStore(store, WithReceiptsRoot(true), WithMerkleAppHash(merkle))
+		if !bytes.Equal(restarted.Info(abcitypes.RequestInfo{}).LastBlockAppHash, app.committed.AppHash) {
+			t.Errorf("merkle %v: the app hash changed over a restart", merkle)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Repair finds the app's own records that have gone out of sync with the
+// keys they're about and fixes them, it's for a node whose store was left
+// inconsistent, e.g. by a crash in the middle of a flush on a store that
+// doesn't commit atomically, or by a bug, it's run offline, between blocks
+// with nothing else writing to the store
+//
+//   - an index entry for a key that doesn't exist, or one that doesn't have
+//     the type the index is for, is removed, along with its ordered entry,
+//     the indexes are the modification and time indexes, expiries,
+//     versions, the ranking and list lengths
+//   - an ordered entry, e.g. of modifiedHeightPrefix, without the keyed
+//     entry it's the order of is removed
+//   - a list length that isn't the length of its list is rewritten
+//   - the key count, the value bytes and the key limit counts are recounted
+//
+// the entries and counters aren't part of the app hash, so a repair doesn't
+// change it, and a node that's repaired stays in consensus with the rest,
+// every fix is logged, the fixes are written repairChunkSize at a time
+// the store has no chunked values or other records made of several entries,
+// so there's nothing else for a pass to find, a missing index entry, e.g.
+// for a key written before the index was enabled, is left missing
+
+// repairChunkSize is the number of fixes written per batch
+const repairChunkSize = 1000
+
+// RepairReport is what a Repair fixed
+type RepairReport struct {
+	// Entries is the number of index entries removed
+	Entries int `json:"entries"`
+	// ListLengths is the number of list lengths rewritten
+	ListLengths int `json:"list_lengths"`
+	// Counters is set if the key count, the value bytes or a key limit
+	// count was wrong
+	Counters bool `json:"counters"`
+}
+
+// repairIndex is an index of user keys, keyed entries are prefix | key ->
+// 8 bytes, an ordered entry, if the index has them, is made of the value of
+// the keyed entry and the key
+type repairIndex struct {
+	name   string
+	prefix []byte
+	// ordered is the prefix of the ordered entries, nil if there aren't
+	// any, an ordered entry is ordered | 8 bytes | key
+	ordered    []byte
+	orderedKey func(value, key []byte) []byte
+	// valid, if set, says whether a key of that type belongs in the index
+	valid func(ct contentType) bool
+}
+
+var repairIndexes = []repairIndex{
+	{name: "modified", prefix: modifiedPrefix, ordered: modifiedHeightPrefix, orderedKey: func(value, key []byte) []byte {
+		return modifiedHeightKey(int64(binary.BigEndian.Uint64(value)), key)
+	}},
+	{name: "written", prefix: writtenPrefix, ordered: writtenTimePrefix, orderedKey: func(value, key []byte) []byte {
+		return writtenTimeKey(int64(binary.BigEndian.Uint64(value)), key)
+	}},
+	{name: "expiry", prefix: expiryPrefix, ordered: expiryHeightPrefix, orderedKey: func(value, key []byte) []byte {
+		return expiryHeightKey(int64(binary.BigEndian.Uint64(value)), key)
+	}},
+	{name: "ranking", prefix: rankScorePrefix, ordered: rankPrefix, orderedKey: func(value, key []byte) []byte {
+		return rankKey(int64(binary.BigEndian.Uint64(value)), key)
+	}, valid: func(ct contentType) bool { return ct == typeInt }},
+	{name: "version", prefix: versionPrefix},
+	{name: "list length", prefix: listLenPrefix, valid: func(ct contentType) bool { return ct == typeList }},
+}
+
+// repairFix is a write of a repair, a nil value removes the key
+type repairFix struct {
+	key   []byte
+	value []byte
+}
+
+// repairScan collects the fixes a repair makes as seen by txn
+type repairScan struct {
+	app     *KVStoreApplication
+	txn     Txn
+	fixes   []repairFix
+	removed map[string]bool
+	report  RepairReport
+}
+
+// remove removes an entry of the index, once
+func (r *repairScan) remove(idx repairIndex, entry, key []byte) {
+	if r.removed[string(entry)] {
+		return
+	}
+	r.removed[string(entry)] = true
+	r.fixes = append(r.fixes, repairFix{key: append([]byte{}, entry...)})
+	r.report.Entries++
+	r.app.logger.Info("repair removed a dangling index entry", "index", idx.name, "key", string(key))
+}
+
+// scanKeyed checks the keyed entries of idx against the keys
+func (r *repairScan) scanKeyed(idx repairIndex) error {
+	opts := DefaultIteratorOptions
+	opts.Prefix = idx.prefix
+	it := r.txn.NewIterator(opts)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		entry := it.Item().Key()
+		key := entry[len(idx.prefix):]
+		_, ct, exists, err := lookup(r.txn, key)
+		if err != nil {
+			return err
+		}
+		if exists && (idx.valid == nil || idx.valid(ct)) {
+			continue
+		}
+		r.remove(idx, entry, key)
+		if idx.ordered == nil {
+			continue
+		}
+		value, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if len(value) == 8 {
+			r.remove(idx, idx.orderedKey(value, key), key)
+		}
+	}
+	return nil
+}
+
+// scanOrdered checks the ordered entries of idx against its keyed entries
+func (r *repairScan) scanOrdered(idx repairIndex) error {
+	opts := DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = idx.ordered
+	it := r.txn.NewIterator(opts)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		entry := it.Item().Key()
+		if len(entry) <= len(idx.ordered)+8 {
+			r.remove(idx, entry, nil)
+			continue
+		}
+		key := entry[len(idx.ordered)+8:]
+		item, err := r.txn.Get(append(append([]byte{}, idx.prefix...), key...))
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		if err == nil {
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if len(value) == 8 && bytes.Equal(idx.orderedKey(value, key), entry) {
+				continue
+			}
+		}
+		r.remove(idx, entry, key)
+	}
+	return nil
+}
+
+// scanKeys recounts the user keys into s and checks the list lengths
+func (r *repairScan) scanKeys(s *state) error {
+	s.KeyCount, s.ValueBytes = 0, 0
+	for i := range s.PrefixKeys {
+		s.PrefixKeys[i].Keys = 0
+	}
+	it := r.txn.NewIterator(DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek(prefixEnd(internalPrefix)); it.Valid(); it.Next() {
+		item := it.Item()
+		key := item.Key()
+		value, err := itemValue(item)
+		if err != nil {
+			return err
+		}
+		s.KeyCount++
+		s.ValueBytes += int64(len(value))
+		s.countKey(key, 1)
+		if itemType(item) != typeList {
+			continue
+		}
+		list, err := decodeList(value)
+		if err != nil {
+			return err
+		}
+		n, err := readListLen(r.txn, key)
+		if err != nil {
+			return err
+		}
+		if _, err := r.txn.Get(listLenKey(key)); err == nil && n == int64(len(list)) {
+			continue
+		}
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(len(list)))
+		r.fixes = append(r.fixes, repairFix{key: listLenKey(key), value: b[:]})
+		r.report.ListLengths++
+		r.app.logger.Info("repair rewrote a list length", "key", string(key), "length", len(list), "was", n)
+	}
+	return nil
+}
+
+// Repair fixes the app's records that are out of sync with the keys, see
+// repair.go, it can only be run between blocks
+func (app *KVStoreApplication) Repair() (RepairReport, error) {
+	if app.replica {
+		return RepairReport{}, ErrReplica
+	}
+	if app.inBlock() {
+		return RepairReport{}, ErrBlockInProgress
+	}
+	defer app.noteActivity()
+	// blocks left unflushed by commit batching go first
+	if err := app.flush(); err != nil {
+		return RepairReport{}, err
+	}
+
+	s := app.committed.clone()
+	var r *repairScan
+	err := app.store.View(func(txn Txn) error {
+		r = &repairScan{app: app, txn: txn, removed: map[string]bool{}}
+		for _, idx := range repairIndexes {
+			if err := r.scanKeyed(idx); err != nil {
+				return err
+			}
+			if idx.ordered == nil {
+				continue
+			}
+			if err := r.scanOrdered(idx); err != nil {
+				return err
+			}
+		}
+		return r.scanKeys(&s)
+	})
+	if err != nil {
+		return RepairReport{}, err
+	}
+
+	c := app.committed
+	if s.KeyCount != c.KeyCount || s.ValueBytes != c.ValueBytes {
+		r.report.Counters = true
+		app.logger.Info("repair recounted the keys", "keys", s.KeyCount, "was", c.KeyCount,
+			"value_bytes", s.ValueBytes, "was_value_bytes", c.ValueBytes)
+	}
+	for i, p := range s.PrefixKeys {
+		if p.Keys != c.PrefixKeys[i].Keys {
+			r.report.Counters = true
+			app.logger.Info("repair recounted a key limit", "prefix", string(p.Prefix), "keys", p.Keys, "was", c.PrefixKeys[i].Keys)
+		}
+	}
+
+	for len(r.fixes) > 0 {
+		if app.inBlock() {
+			return r.report, ErrBlockInProgress
+		}
+		n := len(r.fixes)
+		if n > repairChunkSize {
+			n = repairChunkSize
+		}
+		err := app.store.Update(func(txn Txn) error {
+			for _, fix := range r.fixes[:n] {
+				var err error
+				if fix.value == nil {
+					err = txn.Delete(fix.key)
+				} else {
+					err = txn.Set(fix.key, fix.value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return r.report, fmt.Errorf("writing the fixes: %w", err)
+		}
+		r.fixes = r.fixes[n:]
+	}
+	if r.report.Counters {
+		if err := app.store.Update(s.save); err != nil {
+			return r.report, fmt.Errorf("saving the counters: %w", err)
+		}
+		app.committed = s
+		app.metrics.committed(app.committed)
+	}
+	app.logger.Info("repaired the store", "entries", r.report.Entries, "list_lengths", r.report.ListLengths,
+		"counters", r.report.Counters, "height", app.committed.Height)
+	return r.report, nil
+}
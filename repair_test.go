@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	app := newTestApp(t, WithModIndex(true), WithKeyVersions(true), WithPrefixKeyLimit("p/", 10))
+	deliverBlock(app, 1, "a=1", "p/x=abc", `l=["a","b"];type=list`, "e=1;ttl=100")
+	want := app.committed
+
+	// index entries of a missing key, an ordered entry without its keyed
+	// entry, list lengths of a key that isn't a list and of one that is,
+	// and counters that drifted
+	err := app.store.Update(func(txn Txn) error {
+		var h [8]byte
+		binary.BigEndian.PutUint64(h[:], 7)
+		for _, key := range [][]byte{modifiedKey([]byte("ghost")), versionKey([]byte("ghost")), listLenKey([]byte("a")), listLenKey([]byte("l"))} {
+			if err := txn.Set(key, h[:]); err != nil {
+				return err
+			}
+		}
+		for _, key := range [][]byte{modifiedHeightKey(7, []byte("ghost")), modifiedHeightKey(3, []byte("a"))} {
+			if err := txn.Set(key, nil); err != nil {
+				return err
+			}
+		}
+		s := app.committed.clone()
+		s.KeyCount += 5
+		s.ValueBytes -= 2
+		s.PrefixKeys[0].Keys = 4
+		return s.save(txn)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app = NewKVStoreApplicationWithStore(app.store, WithModIndex(true), WithKeyVersions(true), WithPrefixKeyLimit("p/", 10))
+
+	report, err := app.Repair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (RepairReport{Entries: 5, ListLengths: 1, Counters: true}) {
+		t.Errorf("got %+v", report)
+	}
+	s, err := loadState(app.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.KeyCount != want.KeyCount || s.ValueBytes != want.ValueBytes || s.PrefixKeys[0].Keys != 1 {
+		t.Errorf("recounted %d keys and %d value bytes, want %d and %d", s.KeyCount, s.ValueBytes, want.KeyCount, want.ValueBytes)
+	}
+	if !bytes.Equal(s.AppHash, want.AppHash) {
+		t.Error("the repair changed the app hash")
+	}
+	app.store.View(func(txn Txn) error {
+		for _, key := range [][]byte{modifiedKey([]byte("ghost")), modifiedHeightKey(7, []byte("ghost")),
+			modifiedHeightKey(3, []byte("a")), versionKey([]byte("ghost")), listLenKey([]byte("a"))} {
+			if _, err := txn.Get(key); err != ErrKeyNotFound {
+				t.Errorf("%q is still there", key)
+			}
+		}
+		if _, err := txn.Get(modifiedHeightKey(1, []byte("a"))); err != nil {
+			t.Error("the repair removed an entry that was right")
+		}
+		if n, _ := readListLen(txn, []byte("l")); n != 2 {
+			t.Errorf("the list length is %d", n)
+		}
+		return nil
+	})
+
+	if report, err := app.Repair(); err != nil || report != (RepairReport{}) {
+		t.Errorf("a second repair got %+v, %v", report, err)
+	}
+	if res := deliverBlock(app, 2, "p/y=1"); res[0].Code != VALID_TX {
+		t.Errorf("a block after the repair got code %d", res[0].Code)
+	}
+}
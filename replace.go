@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ReplaceAll and the genesis state write to the store outside of a block,
+// the writes are committed at the current height, with the app hash updated
+// as if they were a block of their own, so every node has to make the same
+// writes at the same height, or before the chain starts, to stay in consensus
+//
+// once the chain has started tendermint has the app hash of the height too,
+// after a rewrite the app reports a different one for the same height, and
+// the handshake of the next start fails, the node halts, so ReplaceAll,
+// MigratePrefix and RestoreBackup return ErrChainStarted past height 0,
+// unless WithOfflineRewrites is set, for an operator rewriting the store of
+// a stopped node that then resyncs it, or starts a new chain from it
+
+// ErrChainStarted is returned by the operations that rewrite the state
+// outside of a block once the chain has started, see replace.go
+var ErrChainStarted = errors.New("the chain has started, rewriting its state outside of a block needs WithOfflineRewrites")
+
+// checkRewrite returns ErrChainStarted if the state can't be rewritten
+// outside of a block, see replace.go
+func (app *KVStoreApplication) checkRewrite() error {
+	if app.committed.Height > 0 && !app.offlineRewrites {
+		return ErrChainStarted
+	}
+	return nil
+}
+
+// commitOutsideBlock runs write, which makes its changes through set and
+// remove, and commits them at the current height along with the new state
+// nothing is written if write fails, the change index only gets a record
+// if indexChanges is set, as the height's record would be overwritten
+func (app *KVStoreApplication) commitOutsideBlock(indexChanges bool, write func() error) error {
+	app.currentBatch = app.store.NewBatch()
+	app.pending = app.committed.clone()
+	app.pending.AppVersion = app.appVersion
+	app.changes = nil
+	err := write()
+	if err == nil {
+		err = app.computeAppHash()
+	}
+	if err == nil && indexChanges {
+		err = app.indexChanges()
+	} else if err == nil {
+		err = app.markUnindexed()
+	}
+	if err == nil {
+		err = app.pending.save(app.currentBatch)
+	}
+	if err == nil {
+		err = app.saveFormatVersion(app.currentBatch)
+	}
+	if err != nil {
+		app.currentBatch.Discard()
+		app.currentBatch = nil
+		app.changes = nil
+		return err
+	}
+
+	app.committed = app.pending
+	app.metrics.committed(app.committed)
+	app.queueShadow()
+	app.queuePublish()
+	app.queueEvents()
+	app.unflushed = append(app.unflushed, app.changes...)
+	app.changes = nil
+	return app.flush()
+}
+
+// setChecked checks a write the way validateTx checks the writes of a
+// transaction, set makes the checks that depend on the state, then writes it
+// typeName is the name of the value's content type, empty for bytes
+func (app *KVStoreApplication) setChecked(key, value []byte, typeName string) error {
+	key = app.normalizeKey(key)
+	value = app.trimValue(value)
+	if err := app.checkKey(key); err != nil {
+		return err
+	}
+	ct := typeBytes
+	if typeName != "" {
+		var ok bool
+		if ct, ok = parseContentType(typeName); !ok {
+			return reject(INVALID_FORMAT, "unknown value type "+typeName)
+		}
+	}
+	if err := ct.validate(value); err != nil {
+		return err
+	}
+	if ct == typeList || ct == typeSet {
+		list, _ := decodeList(value)
+		if err := app.checkListLength(int64(len(list))); err != nil {
+			return err
+		}
+	}
+	return app.set(key, value, ct)
+}
+
+// ReplaceAll replaces every user key in the store with kvs, in a single
+// badger transaction, so either all of it happens or none of it does, the
+// app's own bookkeeping is kept, it's meant for migrating from another
+// system, and can only be run between blocks
+// the entries are checked like the writes of a transaction, Type is the
+// name of a content type, empty for bytes, a key listed twice gets the last
+// value, the whole replacement has to fit within badger's transaction size
+// limit, otherwise badger.ErrTxnTooBig is returned and nothing changes
+// the change index doesn't record the replacement, a consumer of it has to
+// resync after one
+// it's for a chain that hasn't started, past height 0 the node has to be
+// stopped and resynced after it, see replace.go and WithOfflineRewrites
+func (app *KVStoreApplication) ReplaceAll(kvs []KV) error {
+	if app.replica {
+		return ErrReplica
+	}
+	if app.inBlock() {
+		return ErrBlockInProgress
+	}
+	if err := app.checkRewrite(); err != nil {
+		return err
+	}
+	defer app.noteActivity()
+	// blocks left unflushed by commit batching go first
+	if err := app.flush(); err != nil {
+		return err
+	}
+
+	err := app.commitOutsideBlock(false, func() error {
+		return app.replaceUserKeys(kvs)
+	})
+	if err != nil {
+		return err
+	}
+	app.logger.Info("replaced the store's contents", "height", app.committed.Height, "keys", app.committed.KeyCount,
+		"app_hash", fmt.Sprintf("%X", app.committed.AppHash))
+	return nil
+}
+
+// replaceUserKeys removes every user key through the current batch and
+// writes kvs in their place, see ReplaceAll
+func (app *KVStoreApplication) replaceUserKeys(kvs []KV) error {
+	var keys [][]byte
+	opts := DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := app.currentBatch.NewIterator(opts)
+	for it.Seek(prefixEnd(internalPrefix)); it.Valid(); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+	for _, key := range keys {
+		if err := app.remove(key); err != nil {
+			return err
+		}
+	}
+	for i, kv := range kvs {
+		if err := app.setChecked(kv.Key, kv.Value, kv.Type); err != nil {
+			if r, ok := asRejection(err); ok {
+				return fmt.Errorf("invalid entry %d: %s", i, r.log)
+			}
+			return err
+		}
+	}
+	return nil
+}
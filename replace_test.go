@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// genesisApp returns an app whose chain hasn't started, with keys from the
+// genesis state
+func genesisApp(t *testing.T, store Store, appState string, opts ...Option) *KVStoreApplication {
+	t.Helper()
+	app := NewKVStoreApplicationWithStore(store, opts...)
+	app.InitChain(abcitypes.RequestInitChain{AppStateBytes: []byte(appState)})
+	return app
+}
+
+func TestReplaceAll(t *testing.T) {
+	for _, merkle := range []bool{false, true} {
+		store := NewMemStore()
+		app := genesisApp(t, store, `[{"key": "a", "value": "1"}, {"key": "b", "value": "2"}, {"key": "l", "value": "[\"x\"]", "type": "list"}]`,
+			WithMerkleAppHash(merkle), WithModIndex(true), WithTimeIndex(0))
+		before := app.committed.AppHash
+		err := app.ReplaceAll([]KV{{Key: []byte("x"), Value: []byte("1"), Type: "int"}, {Key: []byte("\x00bad"), Value: []byte("1")}})
+		if err == nil {
+			t.Fatal("an invalid entry was accepted")
+		}
+		if !bytes.Equal(app.committed.AppHash, before) || app.committed.KeyCount != 3 {
+			t.Fatal("a failed replacement changed the state")
+		}
+
+		err = app.ReplaceAll([]KV{{Key: []byte("x"), Value: []byte("1"), Type: "int"}, {Key: []byte("a"), Value: []byte("q")}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(app.committed.AppHash, before) || app.committed.KeyCount != 2 || app.committed.Height != 0 {
+			t.Errorf("merkle %v: replaced at height %d with %d keys", merkle, app.committed.Height, app.committed.KeyCount)
+		}
+
+		// the replacement is what a restart sees
+		app = NewKVStoreApplicationWithStore(store, WithMerkleAppHash(merkle), WithModIndex(true), WithTimeIndex(0),
+			WithStartupCheck(StartupCheckFull))
+		if info := app.Info(abcitypes.RequestInfo{}); info.LastBlockHeight != 0 || !bytes.Equal(info.LastBlockAppHash, app.committed.AppHash) {
+			t.Errorf("merkle %v: restarted at height %d", merkle, info.LastBlockHeight)
+		}
+		for key, want := range map[string]string{"a": "q", "x": "1", "b": "", "l": ""} {
+			if value, _, _ := app.get([]byte(key)); string(value) != want {
+				t.Errorf("merkle %v: %s is %q, want %q", merkle, key, value, want)
+			}
+		}
+		if res := deliverBlock(app, 1, "b=3"); res[0].Code != VALID_TX {
+			t.Errorf("merkle %v: the first block after the replacement got code %d", merkle, res[0].Code)
+		}
+	}
+}
+
+func TestReplaceAllAfterTheChainStarted(t *testing.T) {
+	store := NewMemStore()
+	app := NewKVStoreApplicationWithStore(store)
+	deliverBlock(app, 1, "a=1")
+	before := app.committed.AppHash
+	if err := app.ReplaceAll([]KV{{Key: []byte("b"), Value: []byte("2")}}); err != ErrChainStarted {
+		t.Fatalf("got %v, want ErrChainStarted", err)
+	}
+	if !bytes.Equal(app.committed.AppHash, before) {
+		t.Fatal("a refused replacement changed the app hash")
+	}
+
+	// an operator rewriting the store of a stopped node, tendermint still
+	// has the app hash the node committed at height 1
+	app = NewKVStoreApplicationWithStore(store, WithOfflineRewrites(true))
+	if err := app.ReplaceAll([]KV{{Key: []byte("b"), Value: []byte("2")}}); err != nil {
+		t.Fatal(err)
+	}
+	info := NewKVStoreApplicationWithStore(store).Info(abcitypes.RequestInfo{})
+	if info.LastBlockHeight != 1 || bytes.Equal(info.LastBlockAppHash, before) {
+		t.Errorf("restarted at height %d with app hash %X", info.LastBlockHeight, info.LastBlockAppHash)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+)
+
+// A replica (WithReplica) serves queries from a copy of a node's db and never
+// writes to it, it doesn't take part in consensus, so extra replicas add read
+// capacity without touching the network
+//
+// the copy is opened read only (OpenDB with WithReadOnly), badger refuses to
+// open a directory read only while a node has it open for writing, so a
+// replica always runs on its own copy, taken from a stopped node or with
+// badger's backup and restore, to catch up the replica is restarted on a
+// newer copy, Info reports the copy's height so clients can tell how far
+// behind it is
+//
+// CheckTx rejects every transaction with READ_ONLY, BeginBlock, DeliverTx and
+// Commit halt the node, as a replica being sent blocks means it was wired up
+// to consensus by mistake, and maintenance operations return ErrReplica
+
+// ErrReplica is returned by operations that would write to a replica's db
+var ErrReplica = errors.New("the app is a read only replica")
+
+// errReplicaTx rejects transactions sent to a replica
+var errReplicaTx = reject(READ_ONLY, "the app is a read only replica, transactions have to be sent to a full node")
+
+// refuseOnReplica halts the node if it's a replica
+func (app *KVStoreApplication) refuseOnReplica(method string) {
+	if app.replica {
+		halt(method, ErrReplica)
+	}
+}
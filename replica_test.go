@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// replica opens a read only copy of a db with a=1 committed at height 1
+func replica(t *testing.T, opts ...Option) *KVStoreApplication {
+	t.Helper()
+	dir := t.TempDir()
+	quiet := func(o *badger.Options) { *o = o.WithLogger(nil) }
+	db, err := OpenDB(dir, quiet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliverBlock(NewKVStoreApplication(db), 1, "a=1")
+	db.Close()
+
+	rdb, err := OpenDB(dir, WithReadOnly(), quiet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rdb.Close() })
+	return NewKVStoreApplication(rdb, append(opts, WithReplica(true))...)
+}
+
+func TestReplica(t *testing.T) {
+	app := replica(t, WithCompactionHints(1))
+
+	if h := app.Info(abcitypes.RequestInfo{}).LastBlockHeight; h != 1 {
+		t.Errorf("Info reported height %d, want the copy's 1", h)
+	}
+	if res := app.Query(abcitypes.RequestQuery{Data: []byte("a")}); res.Code != 0 || string(res.Value) != "1" {
+		t.Errorf("a got code %d and %q", res.Code, res.Value)
+	}
+
+	if res := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("b=1")}); res.Code != READ_ONLY {
+		t.Errorf("CheckTx got code %d, want READ_ONLY", res.Code)
+	}
+	if _, err := app.ValidateBatch([][]byte{[]byte("b=1")}); err != ErrReplica {
+		t.Errorf("ValidateBatch got %v, want ErrReplica", err)
+	}
+	if err := app.Flatten(1); err != ErrReplica {
+		t.Errorf("Flatten got %v, want ErrReplica", err)
+	}
+	if app.maintainer != nil {
+		t.Error("the replica runs maintenance")
+	}
+
+	for method, f := range map[string]func(){
+		"BeginBlock": func() { app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 2}}) },
+		"DeliverTx":  func() { app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("b=1")}) },
+		"Commit":     func() { app.Commit() },
+	} {
+		if msg := halts(f); !strings.Contains(msg, method) || !strings.Contains(msg, ErrReplica.Error()) {
+			t.Errorf("%s on a replica got %q", method, msg)
+		}
+	}
+}
+
+func TestReplicaRejectsWrites(t *testing.T) {
+	app := replica(t)
+	// every kind of transaction, valid or not, is told to go to a full node
+	for _, tx := range []string{"b=1", "a=1", "incr:n:1", string(EncodeBatch([]byte("b=1"), []byte("c=2"))), "bad"} {
+		res := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)})
+		if res.Code != READ_ONLY || !strings.Contains(res.Log, "read only replica") {
+			t.Errorf("CheckTx %q got code %d: %s, want READ_ONLY", tx, res.Code, res.Log)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// The response size limit (WithMaxQueryResponseSize) bounds how many bytes a
+// query answers with, the value, key and proof of the response, so a client
+// asking for a big page or a huge value by accident doesn't get, or make the
+// node build and send, a response of that size
+// a paginated query, one whose response has a cursor, is run again with a
+// smaller limit until its page fits, so a client gets fewer entries and a
+// cursor to carry on from, rather than an error, e.g. a scan with a limit of
+// 1000 over values of 10KB comes back with a page of 62 pairs under a 1MB
+// limit, a page of a single entry that doesn't fit fails like any other
+// query over the limit, with QUERY_TOO_LARGE, reporting the size, the limit
+// is checked before a response is signed
+
+// pagedQueries are the queries that are run again with a smaller limit when
+// their response is too large, with the limit they use when the request
+// doesn't have one
+var pagedQueries = map[string]int{
+	"scan":     defaultScanLimit,
+	"internal": defaultInternalLimit,
+	"search":   defaultSearchLimit,
+	"since":    defaultSinceLimit,
+	"buckets":  defaultBucketsLimit,
+	"expiring": defaultExpiringLimit,
+}
+
+// responseSize returns the size of a query response as far as the limit is
+// concerned
+func responseSize(res abcitypes.ResponseQuery) int {
+	n := len(res.Key) + len(res.Value)
+	if res.ProofOps != nil {
+		for _, op := range res.ProofOps.Ops {
+			n += len(op.Type) + len(op.Key) + len(op.Data)
+		}
+	}
+	return n
+}
+
+// requestWithLimit returns the JSON request data with its limit set
+func requestWithLimit(data []byte, limit int) ([]byte, error) {
+	fields := make(map[string]json.RawMessage)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+	}
+	// encoding/json matches field names case insensitively
+	for name := range fields {
+		if strings.EqualFold(name, "limit") {
+			delete(fields, name)
+		}
+	}
+	fields["limit"] = json.RawMessage(strconv.Itoa(limit))
+	return json.Marshal(fields)
+}
+
+// limitResponse answers the query within the response size limit
+func (app *KVStoreApplication) limitResponse(req abcitypes.RequestQuery) abcitypes.ResponseQuery {
+	res := app.query(req)
+	if app.maxResponseSize <= 0 || res.Code != 0 || responseSize(res) <= app.maxResponseSize {
+		return res
+	}
+
+	if limit, ok := pagedQueries[req.Path]; ok {
+		var lreq struct {
+			Limit int `json:"limit"`
+		}
+		if json.Unmarshal(req.Data, &lreq) == nil && lreq.Limit > 0 {
+			limit = lreq.Limit
+		}
+		for limit > 1 {
+			limit /= 2
+			data, err := requestWithLimit(req.Data, limit)
+			if err != nil {
+				break
+			}
+			paged := req
+			paged.Data = data
+			if res = app.query(paged); res.Code != 0 || responseSize(res) <= app.maxResponseSize {
+				return res
+			}
+		}
+	}
+
+	size := responseSize(res)
+	res.Code = QUERY_TOO_LARGE
+	res.Log = fmt.Sprintf("response is %d bytes, over the limit of %d", size, app.maxResponseSize)
+	res.Value, res.ProofOps = nil, nil
+	return res
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestMaxQueryResponseSize(t *testing.T) {
+	app := newTestApp(t, WithMaxQueryResponseSize(2000))
+	var txs []string
+	for i := 0; i < 50; i++ {
+		txs = append(txs, fmt.Sprintf("k%02d=%s", i, strings.Repeat("x", 100)))
+	}
+	// the key and value of edge come to the limit exactly
+	txs = append(txs, "huge="+strings.Repeat("y", 2000), "edge="+strings.Repeat("y", 1996))
+	deliverBlock(app, 1, txs...)
+
+	// a single value errors over the limit
+	if res := app.Query(abcitypes.RequestQuery{Data: []byte("edge")}); res.Code != 0 || len(res.Value) != 1996 {
+		t.Errorf("a response at the limit got code %d: %s", res.Code, res.Log)
+	}
+	if res := app.Query(abcitypes.RequestQuery{Data: []byte("huge")}); res.Code != QUERY_TOO_LARGE || res.Value != nil {
+		t.Errorf("a response over the limit got code %d: %s", res.Code, res.Log)
+	}
+	if res := app.Query(abcitypes.RequestQuery{Path: "prefix", Data: []byte(`{"prefix": "k"}`)}); res.Code != QUERY_TOO_LARGE {
+		t.Errorf("a prefix query over the limit got code %d", res.Code)
+	}
+
+	// a page under the limit is left as asked for
+	var sres scanResponse
+	queryJSON(t, app, "scan", []byte(`{"start": "k", "limit": 10}`), &sres)
+	if len(sres.Pairs) != 10 {
+		t.Errorf("a page of 10 under the limit got %d pairs", len(sres.Pairs))
+	}
+	// one over it is cut down, and resuming gets every key
+	seen, pages := 0, 0
+	for next := "k"; next != "" && next < "l"; pages++ {
+		res := app.Query(abcitypes.RequestQuery{Path: "scan", Data: []byte(fmt.Sprintf(`{"start": %q, "limit": 40}`, next))})
+		if res.Code != 0 || len(res.Value) > 2000 {
+			t.Fatalf("page %d got code %d and %d bytes: %s", pages, res.Code, len(res.Value), res.Log)
+		}
+		sres = scanResponse{}
+		if err := json.Unmarshal(res.Value, &sres); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range sres.Pairs {
+			if strings.HasPrefix(p.Key, "k") {
+				seen++
+			}
+		}
+		next = sres.NextKey
+	}
+	if seen != 50 || pages < 3 {
+		t.Errorf("got %d of the k keys in %d pages", seen, pages)
+	}
+	// a page of a single entry that doesn't fit fails
+	if res := app.Query(abcitypes.RequestQuery{Path: "scan", Data: []byte(`{"start": "huge"}`)}); res.Code != QUERY_TOO_LARGE {
+		t.Errorf("a page starting at huge got code %d", res.Code)
+	}
+
+	// no limit by default
+	plain := newTestApp(t)
+	deliverBlock(plain, 1, txs...)
+	if res := plain.Query(abcitypes.RequestQuery{Data: []byte("huge")}); res.Code != 0 {
+		t.Errorf("without a limit got code %d", res.Code)
+	}
+}
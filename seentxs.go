@@ -0,0 +1,90 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+)
+
+// With a dedup window (WithCheckTxDedupWindow) CheckTx remembers the hashes
+// of the last transactions it let into the mempool, a client resubmitting
+// the exact same transaction, e.g. in a retry loop, is rejected with
+// DUPLICATE_TX straight away, without parsing it or reading the store
+// a transaction is forgotten once it's delivered in a block, so it can be
+// sent again once it's been applied, or when it fails a recheck, so one
+// that's dropped from the mempool that way can be sent again too, one the
+// mempool drops without a recheck, e.g. when it's full, stays in the window
+// until newer transactions push it out, the window is the number of hashes
+// kept, the least recently seen one goes first
+// it's only consulted for new transactions, never on a recheck, and it's
+// local to the node, it only ever rejects in CheckTx
+
+// seenTxs is the dedup window, an LRU set of transaction hashes
+type seenTxs struct {
+	mtx     sync.Mutex
+	size    int
+	order   *list.List
+	entries map[[sha256.Size]byte]*list.Element
+}
+
+func newSeenTxs(size int) *seenTxs {
+	return &seenTxs{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[[sha256.Size]byte]*list.Element, size),
+	}
+}
+
+// seen returns true if tx is in the window, moving it to the front
+func (s *seenTxs) seen(tx []byte) bool {
+	if s == nil {
+		return false
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	el, ok := s.entries[sha256.Sum256(tx)]
+	if ok {
+		s.order.MoveToFront(el)
+	}
+	return ok
+}
+
+// add puts tx in the window, evicting the least recently seen transaction
+// if it's full
+func (s *seenTxs) add(tx []byte) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	hash := sha256.Sum256(tx)
+	if el, ok := s.entries[hash]; ok {
+		s.order.MoveToFront(el)
+		return
+	}
+	s.entries[hash] = s.order.PushFront(hash)
+	if s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.([sha256.Size]byte))
+	}
+}
+
+// forget removes tx from the window
+func (s *seenTxs) forget(tx []byte) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	hash := sha256.Sum256(tx)
+	if el, ok := s.entries[hash]; ok {
+		s.order.Remove(el)
+		delete(s.entries, hash)
+	}
+}
+
+var errSeenTx = reject(DUPLICATE_TX, "the same transaction was just submitted and is waiting in the mempool")
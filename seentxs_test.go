@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// viewCounter counts the reads made of a store
+type viewCounter struct {
+	Store
+	views int
+}
+
+func (s *viewCounter) View(fn func(txn Txn) error) error {
+	s.views++
+	return s.Store.View(fn)
+}
+
+func TestCheckTxDedupWindow(t *testing.T) {
+	store := &viewCounter{Store: NewMemStore()}
+	app := NewKVStoreApplicationWithStore(store, WithCheckTxDedupWindow(2))
+	check := func(tx string, typ abcitypes.CheckTxType) abcitypes.ResponseCheckTx {
+		return app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx), Type: typ})
+	}
+
+	if r := check("a=1", abcitypes.CheckTxType_New); r.Code != VALID_TX {
+		t.Fatalf("the first submission got code %d: %s", r.Code, r.Log)
+	}
+	// the resubmission is rejected without reading the store
+	views := store.views
+	if views == 0 {
+		t.Fatal("CheckTx didn't read the store")
+	}
+	if r := check("a=1", abcitypes.CheckTxType_New); r.Code != DUPLICATE_TX || r.Log != errSeenTx.log {
+		t.Errorf("the resubmission got code %d: %s", r.Code, r.Log)
+	}
+	if store.views != views {
+		t.Errorf("the resubmission read the store %d times", store.views-views)
+	}
+	// a recheck isn't deduplicated
+	if r := check("a=1", abcitypes.CheckTxType_Recheck); r.Code != VALID_TX {
+		t.Errorf("the recheck got code %d: %s", r.Code, r.Log)
+	}
+
+	// the window holds 2, newer txs push a=1 out
+	check("b=1", abcitypes.CheckTxType_New)
+	check("c=1", abcitypes.CheckTxType_New)
+	if r := check("a=1", abcitypes.CheckTxType_New); r.Code != VALID_TX {
+		t.Errorf("a=1 out of the window got code %d: %s", r.Code, r.Log)
+	}
+
+	// a delivered tx is forgotten, it's then rejected by the store check
+	deliverBlock(app, 1, "c=1")
+	if r := check("c=1", abcitypes.CheckTxType_New); r.Code != DUPLICATE_TX || r.Log == errSeenTx.log {
+		t.Errorf("the delivered tx got code %d: %s", r.Code, r.Log)
+	}
+
+	// without a window every submission is checked
+	plain := newTestApp(t)
+	for i := 0; i < 2; i++ {
+		if r := plain.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("a=1")}); r.Code != VALID_TX {
+			t.Errorf("submission %d without a window got code %d", i, r.Code)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// A set is a value of type set, a JSON array of distinct strings in sorted
+// order, e.g. 'tags=["blue","red"];type=set', the order makes the stored
+// value, and so the app hash, depend only on the members, not on the order
+// they were added in, a set value that isn't sorted or has a member twice is
+// rejected with INVALID_VALUE, like any value that isn't of its type
+//
+// 'sadd:key:member' adds a member, creating the set if the key doesn't
+// exist, and 'srem:key:member' removes one, the member is everything after
+// the key, ':' included, it has to be non-empty UTF-8 text, adding a member
+// that's already there, or removing one that isn't, changes nothing and is
+// rejected with DUPLICATE_TX and MISSING_KEY, the set is kept once its last
+// member is removed, as an empty set
+// a set is bounded by WithMaxListLength like a list
+//
+// the "sismember" query checks a member is in a set, e.g.
+// {"key": "tags", "member": "red"}
+
+// encodeSet encodes sorted distinct members as a set value
+func encodeSet(members []string) ([]byte, error) {
+	if members == nil {
+		members = []string{}
+	}
+	return json.Marshal(members)
+}
+
+// validSet returns true if the members are sorted and distinct
+func validSet(members []string) bool {
+	for i := 1; i < len(members); i++ {
+		if members[i-1] >= members[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseMember checks the member argument of a set op
+func parseMember(args [][]byte) error {
+	if len(args[1]) == 0 || !utf8.Valid(args[1]) {
+		return reject(INVALID_VALUE, "set members must be non-empty UTF-8 text")
+	}
+	return nil
+}
+
+// readSet returns the members of the set at key as seen by txn, and whether
+// the key exists, a key that isn't a set is rejected with
+// INCOMPATIBLE_VALUE
+func readSet(txn Txn, key []byte) ([]string, bool, error) {
+	value, ct, exists, err := lookup(txn, key)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	if ct != typeSet {
+		return nil, true, reject(INCOMPATIBLE_VALUE, fmt.Sprintf("%q isn't a set", key))
+	}
+	members, err := decodeList(value)
+	return members, true, err
+}
+
+// memberIndex returns where member is, or would go, in the set, and
+// whether it's in it
+func memberIndex(members []string, member string) (int, bool) {
+	i := sort.SearchStrings(members, member)
+	return i, i < len(members) && members[i] == member
+}
+
+// checkSAdd checks a member can be added to the set at key as seen by txn
+func (app *KVStoreApplication) checkSAdd(txn Txn, key, member []byte) error {
+	members, exists, err := readSet(txn, key)
+	if err != nil {
+		return err
+	}
+	if _, ok := memberIndex(members, string(member)); ok {
+		return reject(DUPLICATE_TX, fmt.Sprintf("%q is already in the set %q", member, key))
+	}
+	if exists && app.appendOnly {
+		return errOverwriteForbidden
+	}
+	return app.checkListLength(int64(len(members)) + 1)
+}
+
+// checkSRem checks a member can be removed from the set at key as seen by
+// txn
+func (app *KVStoreApplication) checkSRem(txn Txn, key, member []byte) error {
+	members, exists, err := readSet(txn, key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return reject(MISSING_KEY, fmt.Sprintf("key %q doesn't exist", key))
+	}
+	if _, ok := memberIndex(members, string(member)); !ok {
+		return reject(MISSING_KEY, fmt.Sprintf("%q isn't in the set %q", member, key))
+	}
+	if app.appendOnly {
+		return errOverwriteForbidden
+	}
+	return nil
+}
+
+// updateSet adds member to, or removes it from, the set at key in the
+// current batch
+func (app *KVStoreApplication) updateSet(key, member []byte, add bool) error {
+	members, _, err := readSet(app.currentBatch, key)
+	if err != nil {
+		return err
+	}
+	i, ok := memberIndex(members, string(member))
+	switch {
+	case add && !ok:
+		members = append(members, "")
+		copy(members[i+1:], members[i:])
+		members[i] = string(member)
+	case !add && ok:
+		members = append(members[:i], members[i+1:]...)
+	}
+	value, err := encodeSet(members)
+	if err != nil {
+		return err
+	}
+	return app.set(key, value, typeSet)
+}
+
+func init() {
+	registerOp(&txOp{
+		name:  "sadd",
+		args:  2,
+		keys:  []int{0},
+		rest:  true,
+		parse: parseMember,
+		check: func(app *KVStoreApplication, txn Txn, t transaction) error {
+			return app.checkSAdd(txn, t.args[0], t.args[1])
+		},
+		apply: func(app *KVStoreApplication, t transaction) error {
+			return app.updateSet(t.args[0], t.args[1], true)
+		},
+	})
+	registerOp(&txOp{
+		name:  "srem",
+		args:  2,
+		keys:  []int{0},
+		rest:  true,
+		parse: parseMember,
+		check: func(app *KVStoreApplication, txn Txn, t transaction) error {
+			return app.checkSRem(txn, t.args[0], t.args[1])
+		},
+		apply: func(app *KVStoreApplication, t transaction) error {
+			return app.updateSet(t.args[0], t.args[1], false)
+		},
+	})
+}
+
+type isMemberRequest struct {
+	Key    string `json:"key"`
+	Member string `json:"member"`
+}
+
+type isMemberResponse struct {
+	Height int64 `json:"height"`
+	// Exists is set if the set exists, Member if the member is in it
+	Exists bool `json:"exists"`
+	Member bool `json:"member"`
+	Size   int  `json:"size"`
+}
+
+// queryIsMember checks a member is in a set, see set.go
+func (app *KVStoreApplication) queryIsMember(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var mreq isMemberRequest
+	if !parseRequest(req, &res, &mreq) {
+		return
+	}
+	key := app.normalizeKey([]byte(mreq.Key))
+	res.Key = key
+
+	mres := isMemberResponse{Height: app.committed.Height}
+	err := app.store.View(func(txn Txn) error {
+		members, exists, err := readSet(txn, key)
+		if err != nil || !exists {
+			return err
+		}
+		mres.Exists, mres.Size = true, len(members)
+		_, mres.Member = memberIndex(members, mreq.Member)
+		return nil
+	})
+	if r, ok := asRejection(err); ok {
+		res.Code = QUERY_INVALID
+		res.Log = r.log
+		return
+	}
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, mres)
+	return
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestSets(t *testing.T) {
+	app := newTestApp(t, WithMaxListLength(3))
+	members := func() string {
+		t.Helper()
+		value, ct := typedValue(t, app, "tags")
+		if ct != typeSet {
+			t.Fatalf("tags is a %s", ct)
+		}
+		return value
+	}
+
+	// a member already in the set is a no-op, the set stays sorted
+	res := deliverBlock(app, 1, "sadd:tags:red", "sadd:tags:blue", "sadd:tags:red", "sadd:tags:a:b", "sadd:tags:zz")
+	for i, want := range []uint32{VALID_TX, VALID_TX, DUPLICATE_TX, VALID_TX, LIST_TOO_LONG} {
+		if res[i].Code != want {
+			t.Errorf("sadd %d got code %d, want %d: %s", i, res[i].Code, want, res[i].Log)
+		}
+	}
+	if got := members(); got != `["a:b","blue","red"]` {
+		t.Errorf("tags is %s", got)
+	}
+	for tx, want := range map[string]uint32{
+		"sadd:tags:blue":       DUPLICATE_TX,
+		"sadd:tags:":           INVALID_VALUE,
+		"sadd:tags:\xff":       INVALID_VALUE,
+		`s=["b","a"];type=set`: INVALID_VALUE,
+		`s=["a","a"];type=set`: INVALID_VALUE,
+		`s=["a","b"];type=set`: VALID_TX,
+	} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}); r.Code != want {
+			t.Errorf("%q got code %d, want %d: %s", tx, r.Code, want, r.Log)
+		}
+	}
+
+	// removing
+	res = deliverBlock(app, 2, "srem:tags:blue", "srem:tags:blue", "srem:nope:x", "push:l:x", "sadd:l:x")
+	for i, want := range []uint32{VALID_TX, MISSING_KEY, MISSING_KEY, VALID_TX, INCOMPATIBLE_VALUE} {
+		if res[i].Code != want {
+			t.Errorf("block 2 tx %d got code %d, want %d: %s", i, res[i].Code, want, res[i].Log)
+		}
+	}
+	if got := members(); got != `["a:b","red"]` {
+		t.Errorf("tags is %s after removing blue", got)
+	}
+
+	for _, c := range []struct {
+		member string
+		want   isMemberResponse
+	}{
+		{"red", isMemberResponse{Height: 2, Exists: true, Member: true, Size: 2}},
+		{"blue", isMemberResponse{Height: 2, Exists: true, Size: 2}},
+	} {
+		var got isMemberResponse
+		queryJSON(t, app, "sismember", []byte(fmt.Sprintf(`{"key": "tags", "member": %q}`, c.member)), &got)
+		if got != c.want {
+			t.Errorf("sismember %s got %+v, want %+v", c.member, got, c.want)
+		}
+	}
+	if res := app.Query(abcitypes.RequestQuery{Path: "sismember", Data: []byte(`{"key": "l", "member": "x"}`)}); res.Code != QUERY_INVALID {
+		t.Errorf("sismember on a list got code %d", res.Code)
+	}
+
+	// the last member out leaves an empty set
+	deliverBlock(app, 3, "srem:tags:red", "srem:tags:a:b")
+	if got := members(); got != `[]` {
+		t.Errorf("tags is %s after removing every member", got)
+	}
+}
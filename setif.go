@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// setif:key:value:condkey:condval sets key to value only if condkey holds
+// exactly condval, e.g. 'setif:balance/alice:10:active/alice:yes'
+// a condition key that doesn't exist is rejected with MISSING_KEY, one with
+// a different value with CONDITION_FAILED, so clients can tell them apart
+// the condition can be on the key itself, 'setif:a:2:a:1' is a compare and
+// set, the value is written as bytes
+
+// checkSetIf checks the op's condition against the state visible to txn
+func checkSetIf(app *KVStoreApplication, txn Txn, t transaction) error {
+	key, condKey, condValue := t.args[0], t.args[2], t.args[3]
+	value, _, exists, err := lookup(txn, condKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return reject(MISSING_KEY, fmt.Sprintf("the condition key %q doesn't exist", condKey))
+	}
+	if !bytes.Equal(value, condValue) {
+		return reject(CONDITION_FAILED, fmt.Sprintf("the condition key %q doesn't hold the expected value", condKey))
+	}
+	if app.appendOnly {
+		_, _, exists, err := lookup(txn, key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errOverwriteForbidden
+		}
+	}
+	return nil
+}
+
+func init() {
+	registerOp(&txOp{
+		name:  "setif",
+		args:  4,
+		keys:  []int{0, 2},
+		check: checkSetIf,
+		apply: func(app *KVStoreApplication, t transaction) error {
+			return app.set(t.args[0], t.args[1], typeBytes)
+		},
+		writes: func(app *KVStoreApplication, txn Txn, t transaction) ([]transaction, error) {
+			return []transaction{{key: t.args[0], value: t.args[1]}}, nil
+		},
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestSetIf(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "active=yes", "closed=no", "bal=5")
+
+	for _, c := range []struct {
+		tx   string
+		code uint32
+	}{
+		{"setif:bal:10:active:yes", VALID_TX},
+		{"setif:bal:20:closed:yes", CONDITION_FAILED},
+		{"setif:bal:30:missing:yes", MISSING_KEY},
+		{"setif:bal:11:bal:5", VALID_TX},
+		{"setif::1:active:yes", INVALID_FORMAT},
+		{"setif:bal:1:active", INVALID_FORMAT},
+	} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(c.tx)}); r.Code != c.code {
+			t.Errorf("CheckTx %s got code %d, want %d: %s", c.tx, r.Code, c.code, r.Log)
+		}
+	}
+
+	// the block sees its own earlier writes, the compare and set on bal
+	// applies after the first write to it
+	res := deliverBlock(app, 2, "setif:bal:10:active:yes", "setif:bal:20:closed:yes", "setif:bal:30:missing:yes",
+		"setif:bal:11:bal:10", "setif:bal:12:bal:10", "missing=yes", "setif:flag:on:missing:yes")
+	for i, want := range []uint32{VALID_TX, CONDITION_FAILED, MISSING_KEY, VALID_TX, CONDITION_FAILED, VALID_TX, VALID_TX} {
+		if res[i].Code != want {
+			t.Errorf("DeliverTx %d got code %d, want %d: %s", i, res[i].Code, want, res[i].Log)
+		}
+	}
+	for key, want := range map[string]string{"bal": "11", "flag": "on"} {
+		if value, _, _ := app.get([]byte(key)); string(value) != want {
+			t.Errorf("%s is %q, want %q", key, value, want)
+		}
+	}
+}
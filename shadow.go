@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/dgraph-io/badger"
+)
+
+// A shadow writer gets a copy of every block once it has been flushed to
+// the primary db, e.g. to migrate to a new db while the node keeps running
+// or to keep a read replica up to date
+// it's best effort, an error is logged and the block is not retried, so the
+// shadow can fall behind the primary but never gets ahead of it
+// the writer is called synchronously from Commit, a slow one slows the node
+
+// ShadowWrite is a single write to a user key
+type ShadowWrite struct {
+	Key []byte
+	// Delete is set if the key was removed, Value is then empty
+	Delete bool
+	Value  []byte
+	// UserMeta is the badger user meta the value is stored with, the
+	// value's type, see contenttype.go
+	UserMeta byte
+}
+
+// ShadowWriter receives every committed block's writes in order
+type ShadowWriter interface {
+	WriteBlock(height int64, writes []ShadowWrite) error
+}
+
+// shadowBlock is a block waiting for the primary to be flushed
+type shadowBlock struct {
+	height int64
+	writes []ShadowWrite
+}
+
+// queueShadow queues the block being committed for the shadow writer
+func (app *KVStoreApplication) queueShadow() {
+	if app.shadow == nil {
+		return
+	}
+	writes := make([]ShadowWrite, len(app.changes))
+	for i, c := range app.changes {
+		writes[i] = ShadowWrite{Key: c.key, Delete: c.deleted, Value: c.value, UserMeta: byte(c.contentType)}
+	}
+	app.shadowPending = append(app.shadowPending, shadowBlock{height: app.pending.Height, writes: writes})
+}
+
+// writeShadow hands the flushed blocks to the shadow writer
+func (app *KVStoreApplication) writeShadow() {
+	for _, b := range app.shadowPending {
+		if err := app.shadow.WriteBlock(b.height, b.writes); err != nil {
+			app.logger.Error("shadow write failed", "height", b.height, "err", err)
+		}
+	}
+	app.shadowPending = nil
+}
+
+// BadgerShadow is a ShadowWriter that mirrors the writes to another badger db
+type BadgerShadow struct {
+	DB *badger.DB
+}
+
+func (s BadgerShadow) WriteBlock(height int64, writes []ShadowWrite) error {
+	wb := s.DB.NewWriteBatch()
+	defer wb.Cancel()
+	for _, w := range writes {
+		if w.Delete {
+			if err := wb.Delete(w.Key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := wb.SetEntry(badger.NewEntry(w.Key, w.Value).WithMeta(w.UserMeta)); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
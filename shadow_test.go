@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// fakeShadow records the blocks it's given, failing them if err is set
+type fakeShadow struct {
+	heights []int64
+	writes  []ShadowWrite
+	err     error
+}
+
+func (f *fakeShadow) WriteBlock(height int64, writes []ShadowWrite) error {
+	f.heights = append(f.heights, height)
+	f.writes = append(f.writes, writes...)
+	return f.err
+}
+
+func TestShadowWriter(t *testing.T) {
+	shadow := &fakeShadow{}
+	app := newTestApp(t, WithShadowWriter(shadow))
+	deliverBlock(app, 1, "a=1", "b=2;type=int", "bad")
+	deliverBlock(app, 2, "delprefix:a", "b=3;type=int")
+
+	want := []ShadowWrite{
+		{Key: []byte("a"), Value: []byte("1"), UserMeta: byte(typeBytes)},
+		{Key: []byte("b"), Value: []byte("2"), UserMeta: byte(typeInt)},
+		{Key: []byte("a"), Delete: true},
+		{Key: []byte("b"), Value: []byte("3"), UserMeta: byte(typeInt)},
+	}
+	if !reflect.DeepEqual(shadow.heights, []int64{1, 2}) {
+		t.Errorf("got blocks %v", shadow.heights)
+	}
+	if len(shadow.writes) != len(want) {
+		t.Fatalf("got writes %+v", shadow.writes)
+	}
+	for i, w := range want {
+		got := shadow.writes[i]
+		if string(got.Key) != string(w.Key) || got.Delete != w.Delete || string(got.Value) != string(w.Value) || got.UserMeta != w.UserMeta {
+			t.Errorf("write %d is %+v, want %+v", i, got, w)
+		}
+	}
+
+	// a failing shadow is only logged
+	shadow.err = errors.New("shadow is down")
+	deliverBlock(app, 3, "c=1")
+	if app.committed.Height != 3 {
+		t.Error("a shadow failure stopped the block")
+	}
+}
+
+func TestShadowWaitsForTheFlush(t *testing.T) {
+	shadow := &fakeShadow{}
+	app := newTestApp(t, WithShadowWriter(shadow), WithCommitBatching(2, 0))
+	for h := int64(1); h <= 3; h++ {
+		deliverBlock(app, h, fmt.Sprintf("k%d=v", h))
+	}
+	if !reflect.DeepEqual(shadow.heights, []int64{1, 2}) {
+		t.Errorf("got blocks %v before the flush, want the flushed ones", shadow.heights)
+	}
+	if err := app.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(shadow.heights, []int64{1, 2, 3}) {
+		t.Errorf("got blocks %v after the flush", shadow.heights)
+	}
+}
+
+func TestBadgerShadow(t *testing.T) {
+	primary := NewKVStoreApplication(testDB(t), WithShadowWriter(BadgerShadow{DB: testDB(t)}))
+	shadowDB := primary.shadow.(BadgerShadow).DB
+	deliverBlock(primary, 1, "a=1", "b=2;type=int", "c=3")
+	deliverBlock(primary, 2, "delprefix:c", "a=4")
+
+	// the shadow has the user keys with their types
+	shadow := NewBadgerStore(shadowDB)
+	for key, want := range map[string]string{"a": "4", "b": "2", "c": ""} {
+		var value string
+		var ct contentType
+		err := shadow.View(func(txn Txn) error {
+			v, vt, _, err := lookup(txn, []byte(key))
+			value, ct = string(v), vt
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != want || (key == "b" && ct != typeInt) {
+			t.Errorf("the shadow has %s=%q of type %s", key, value, ct)
+		}
+	}
+}
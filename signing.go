@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto"
+	tmcrypto "github.com/tendermint/tendermint/proto/tendermint/crypto"
+)
+
+// With query signing (WithQuerySigning) the node signs every query response
+// with a key of its own, normally its tendermint node key, so a client that
+// trusts the node can tell the response came from it unchanged, e.g. through
+// a proxy or the gateway
+// it only says what this node answered, unlike a proof against the merkle
+// app hash it says nothing about whether the answer matches consensus, a
+// node that's faulty or lying signs its answers just as well
+//
+// The signature is the last proof op of the response
+//
+//	{"type": "kvstore:sig", "key": <the public key>, "data": <the signature>}
+//
+// signed over the sha256 of, in order, "kvstore:sig:v1", the request's path
+// and data, and the response's code, height, key, value, log, info and every
+// proof op before the signature, by type, key and data
+// each field is its 8 byte big endian length followed by its bytes, the code
+// and height are 8 byte big endian numbers, and the proof ops are preceded by
+// their number, the request is part of it so an answer can't be passed off
+// as the answer to another query
+// responses rejected with QUERY_BUSY aren't signed, tendermint's own fields
+// (index and codespace) aren't covered
+
+// PROOF_OP_SIGNATURE is the proof op type of a query response signature
+const PROOF_OP_SIGNATURE = "kvstore:sig"
+
+const querySignatureDomain = "kvstore:sig:v1"
+
+func writeSigned(h hash.Hash, b []byte) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(b)))
+	h.Write(n[:])
+	h.Write(b)
+}
+
+func writeSignedInt(h hash.Hash, i uint64) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], i)
+	h.Write(n[:])
+}
+
+// querySignBytes returns the digest signed for the response, ops are the
+// proof ops before the signature
+func querySignBytes(req abcitypes.RequestQuery, res abcitypes.ResponseQuery, ops []tmcrypto.ProofOp) []byte {
+	h := sha256.New()
+	writeSigned(h, []byte(querySignatureDomain))
+	writeSigned(h, []byte(req.Path))
+	writeSigned(h, req.Data)
+	writeSignedInt(h, uint64(res.Code))
+	writeSignedInt(h, uint64(res.Height))
+	writeSigned(h, res.Key)
+	writeSigned(h, res.Value)
+	writeSigned(h, []byte(res.Log))
+	writeSigned(h, []byte(res.Info))
+	writeSignedInt(h, uint64(len(ops)))
+	for _, op := range ops {
+		writeSigned(h, []byte(op.Type))
+		writeSigned(h, op.Key)
+		writeSigned(h, op.Data)
+	}
+	return h.Sum(nil)
+}
+
+// signQuery adds the signature to a response, see signing.go
+func (app *KVStoreApplication) signQuery(req abcitypes.RequestQuery, res *abcitypes.ResponseQuery) {
+	var ops []tmcrypto.ProofOp
+	if res.ProofOps != nil {
+		ops = res.ProofOps.Ops
+	}
+	sig, err := app.signingKey.Sign(querySignBytes(req, *res, ops))
+	if err != nil {
+		// the key can't sign, the node is misconfigured, better no
+		// answer than one the client can't check
+		halt("Query", err)
+	}
+	op := tmcrypto.ProofOp{Type: PROOF_OP_SIGNATURE, Key: app.signingKey.PubKey().Bytes(), Data: sig}
+	res.ProofOps = &tmcrypto.ProofOps{Ops: append(ops[:len(ops):len(ops)], op)}
+}
+
+// VerifyQueryResponse checks the response to req was signed by pub
+// returns nil only if the response carries a signature by that key over the
+// request and every part of the response it covers, see signing.go
+func VerifyQueryResponse(pub crypto.PubKey, req abcitypes.RequestQuery, res abcitypes.ResponseQuery) error {
+	if res.ProofOps == nil || len(res.ProofOps.Ops) == 0 {
+		return errors.New("response isn't signed")
+	}
+	ops := res.ProofOps.Ops
+	sig := ops[len(ops)-1]
+	if sig.Type != PROOF_OP_SIGNATURE {
+		return errors.New("response isn't signed")
+	}
+	if !bytes.Equal(sig.Key, pub.Bytes()) {
+		return errors.New("response is signed by a different key")
+	}
+	if !pub.VerifySignature(querySignBytes(req, res, ops[:len(ops)-1]), sig.Data) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
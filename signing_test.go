@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+	tmcrypto "github.com/tendermint/tendermint/proto/tendermint/crypto"
+)
+
+func TestQuerySigning(t *testing.T) {
+	key := ed25519.GenPrivKey()
+	pub := key.PubKey()
+	app := newTestApp(t, WithQuerySigning(key), WithMerkleAppHash(true))
+	deliverBlock(app, 1, "a=1")
+
+	for _, req := range []abcitypes.RequestQuery{{Data: []byte("a")}, {Path: "keyproof", Data: []byte("a")}, {Path: "config"}, {Data: []byte("missing")}} {
+		res := app.Query(req)
+		if err := VerifyQueryResponse(pub, req, res); err != nil {
+			t.Fatalf("%s %q: %v", req.Path, req.Data, err)
+		}
+
+		tampered := map[string]abcitypes.ResponseQuery{}
+		r := res
+		r.Value = []byte("2")
+		tampered["value"] = r
+		r = res
+		r.Height++
+		tampered["height"] = r
+		r = res
+		r.Log = "exists, trust me"
+		tampered["log"] = r
+		if len(res.ProofOps.Ops) > 1 {
+			// a proof op before the signature
+			r = res
+			ops := append([]tmcrypto.ProofOp{}, res.ProofOps.Ops...)
+			ops[0].Data = append(append([]byte{}, ops[0].Data...), ' ')
+			r.ProofOps = &tmcrypto.ProofOps{Ops: ops}
+			tampered["proof op"] = r
+		}
+		r = res
+		r.ProofOps = nil
+		tampered["signature"] = r
+		for what, bad := range tampered {
+			if VerifyQueryResponse(pub, req, bad) == nil {
+				t.Errorf("%s %q: response with a changed %s verified", req.Path, req.Data, what)
+			}
+		}
+
+		// the signature is over the request too, and by the node's key
+		other := req
+		other.Data = []byte("b")
+		if VerifyQueryResponse(pub, other, res) == nil {
+			t.Errorf("%s %q: response verified for another request", req.Path, req.Data)
+		}
+		if VerifyQueryResponse(ed25519.GenPrivKey().PubKey(), req, res) == nil {
+			t.Errorf("%s %q: response verified with another key", req.Path, req.Data)
+		}
+	}
+
+	req := abcitypes.RequestQuery{Data: []byte("a")}
+	if err := VerifyQueryResponse(pub, req, newTestApp(t).Query(req)); err == nil {
+		t.Error("response without query signing verified")
+	}
+}
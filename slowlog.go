@@ -0,0 +1,29 @@
+package main
+
+import (
+	"time"
+)
+
+// The slow operation log (WithSlowOpThreshold) logs every Commit, DeliverTx
+// and Query that runs for longer than the threshold, with what it was
+// working on, so latency outliers show up without logging every call
+//
+//	I[...] slow operation  method=Commit took=1.2s height=1042 writes=30512
+//
+// tendermint's logger has no warning level, the entries are logged at info
+// with the "slow operation" message to search for, the time is the node's
+// own clock, it's only ever logged, never part of the state
+
+// logSlow logs method if it's been running since start for longer than the
+// threshold, it's deferred at the start of the method, keyvals are what the
+// method was working on
+func (app *KVStoreApplication) logSlow(method string, start time.Time, keyvals ...interface{}) {
+	if app.slowOpThreshold <= 0 {
+		return
+	}
+	took := time.Since(start)
+	if took < app.slowOpThreshold {
+		return
+	}
+	app.logger.Info("slow operation", append([]interface{}{"method", method, "took", took}, keyvals...)...)
+}
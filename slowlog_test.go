@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/log"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// slowStore is a store whose reads and commits take delay
+type slowStore struct {
+	Store
+	delay time.Duration
+}
+
+func (s slowStore) View(fn func(txn Txn) error) error {
+	time.Sleep(s.delay)
+	return s.Store.View(fn)
+}
+
+func (s slowStore) NewBatch() Txn {
+	return slowTxn{s.Store.NewBatch(), s.delay}
+}
+
+type slowTxn struct {
+	Txn
+	delay time.Duration
+}
+
+func (t slowTxn) Get(key []byte) (Item, error) {
+	time.Sleep(t.delay)
+	return t.Txn.Get(key)
+}
+
+func (t slowTxn) Commit() error {
+	time.Sleep(t.delay)
+	return t.Txn.Commit()
+}
+
+func TestSlowOpLog(t *testing.T) {
+	slowOps := func(threshold time.Duration) []string {
+		var logs bytes.Buffer
+		app := NewKVStoreApplicationWithStore(slowStore{NewMemStore(), 20 * time.Millisecond},
+			WithLogger(log.NewTMLogger(&logs)), WithSlowOpThreshold(threshold))
+		app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 1}})
+		app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("a=1")})
+		app.EndBlock(abcitypes.RequestEndBlock{Height: 1})
+		app.Commit()
+		app.Query(abcitypes.RequestQuery{Data: []byte("a")})
+		var lines []string
+		for _, l := range strings.Split(logs.String(), "\n") {
+			if strings.Contains(l, "slow operation") {
+				lines = append(lines, l)
+			}
+		}
+		return lines
+	}
+
+	lines := slowOps(10 * time.Millisecond)
+	for _, want := range []string{"method=DeliverTx", "method=Commit", "method=Query"} {
+		found := false
+		for _, l := range lines {
+			found = found || strings.Contains(l, want)
+		}
+		if !found {
+			t.Errorf("no slow operation logged with %s in %q", want, lines)
+		}
+	}
+	if lines := slowOps(time.Hour); len(lines) != 0 {
+		t.Errorf("logged %q under an hour's threshold", lines)
+	}
+	if lines := slowOps(0); len(lines) != 0 {
+		t.Errorf("logged %q without a threshold", lines)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"github.com/dgraph-io/badger"
+)
+
+// With a shutdown snapshot (WithShutdownSnapshot) Close writes a badger
+// backup of the whole db to a file, the app's own bookkeeping included, so
+// a node whose db directory is lost or wiped can be started again from it
+// with OpenDBFromSnapshot, and only has to catch up on the blocks since,
+// rather than replaying the whole chain
+//
+// the snapshot is of the db, with commit batching blocks that haven't been
+// flushed yet aren't in it, the state in it is just as far behind, so the
+// node replays them from tendermint like it does after a crash
+
+// loadMaxPendingWrites bounds the writes badger keeps in memory while
+// loading a snapshot, it's badger's own recommended value
+const loadMaxPendingWrites = 256
+
+// writeSnapshot writes a backup of the db to path, it's written next to path
+// first and renamed once complete, so a crash halfway through never leaves
+// a partial snapshot behind in place of the last good one
+func writeSnapshot(db *badger.DB, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	_, err = db.Backup(w, 0)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// snapshotOnClose writes the shutdown snapshot, if there is one, it's called
+// from Close, a block in flight would leave the snapshot with a block that
+// was never committed, so it's skipped then
+func (app *KVStoreApplication) snapshotOnClose() {
+	if app.shutdownSnapshot == "" {
+		return
+	}
+	if app.inBlock() {
+		app.logger.Error("not writing the shutdown snapshot, a block is in flight", "height", app.pending.Height)
+		return
+	}
+	if err := writeSnapshot(app.db, app.shutdownSnapshot); err != nil {
+		app.logger.Error("writing the shutdown snapshot failed", "path", app.shutdownSnapshot, "err", err)
+		return
+	}
+	app.logger.Info("wrote the shutdown snapshot", "path", app.shutdownSnapshot)
+}
+
+// OpenDBFromSnapshot is OpenDB, except that if dir is empty, or doesn't exist
+// yet, and there is a snapshot at snapshot, the db is loaded from it, see
+// WithShutdownSnapshot, a db that already has data is opened as it is, so
+// it's safe to always start a node with this, a load that fails leaves
+// what it wrote in dir, which has to be emptied before trying again
+func OpenDBFromSnapshot(dir, snapshot string, opts ...DBOption) (*badger.DB, error) {
+	empty, err := isEmptyDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	db, err := OpenDB(dir, opts...)
+	if err != nil || !empty {
+		return db, err
+	}
+
+	f, err := os.Open(snapshot)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	defer f.Close()
+	if err := db.Load(bufio.NewReader(f), loadMaxPendingWrites); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// isEmptyDir returns whether dir has no entries, a missing dir is empty
+func isEmptyDir(dir string) (bool, error) {
+	d, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer d.Close()
+	_, err = d.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// quietDB silences badger's logger
+func quietDB(o *badger.Options) { *o = o.WithLogger(nil) }
+
+func TestShutdownSnapshot(t *testing.T) {
+	snapshot := filepath.Join(t.TempDir(), "snapshot")
+	dir := t.TempDir()
+	db, err := OpenDB(dir, quietDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := NewKVStoreApplication(db, WithShutdownSnapshot(snapshot))
+	deliverBlock(app, 1, "a=1", "b=2;type=int")
+
+	// closing mid block doesn't write one
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 2}})
+	app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("c=3")})
+	app.Close()
+	if _, err := os.Stat(snapshot); !os.IsNotExist(err) {
+		t.Fatalf("a snapshot was written in the middle of a block: %v", err)
+	}
+	app.EndBlock(abcitypes.RequestEndBlock{Height: 2})
+	app.Commit()
+	app.Close()
+	db.Close()
+
+	// a fresh dir is loaded from the snapshot
+	restored, err := OpenDBFromSnapshot(filepath.Join(t.TempDir(), "new"), snapshot, quietDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	app = NewKVStoreApplication(restored)
+	if app.committed.Height != 2 || app.committed.KeyCount != 3 {
+		t.Fatalf("the restored db is at height %d with %d keys", app.committed.Height, app.committed.KeyCount)
+	}
+	for key, want := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		if value, _, _ := app.get([]byte(key)); string(value) != want {
+			t.Errorf("%s is %q in the restored db, want %q", key, value, want)
+		}
+	}
+
+	// a dir with data is opened as it is
+	db, err = OpenDB(dir, quietDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliverBlock(NewKVStoreApplication(db), 3, "d=4")
+	db.Close()
+	db, err = OpenDBFromSnapshot(dir, snapshot, quietDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if app := NewKVStoreApplication(db); app.committed.Height != 3 {
+		t.Errorf("the existing db was replaced, it's at height %d", app.committed.Height)
+	}
+}
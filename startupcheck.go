@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/tendermint/tendermint/crypto/merkle"
+)
+
+// The startup check (WithStartupCheck) makes sure the state a node restarts
+// with, after a crash say, hangs together before it takes part in consensus
+// again, a node that fails it refuses to start, rather than go on to commit
+// app hashes the rest of the network doesn't agree with
+//
+// the quick check only reads the records of the last block
+//   - the app hash is set if there's a height
+//   - with the receipts root, the state hash and the receipts root make up
+//     the app hash, and match the block's receipts, see receipts.go
+//   - in chained mode, with the change index, the last block's changes
+//     hashed onto the state hash before it give its state hash again
+//
+// the full check also reads every entry
+//   - every value decodes, with the value CRC its checksum matches
+//   - the key count, the value bytes and the key limit counts are right
+//   - in merkle mode the merkle root of the store is the state hash
+//
+// a quick check is cheap enough for every start, a full check reads the
+// whole store, like a forced "statehash" query
+
+// StartupCheck is how thoroughly the state is checked on startup
+type StartupCheck int
+
+const (
+	// StartupCheckOff doesn't check anything, the default
+	StartupCheckOff StartupCheck = iota
+	// StartupCheckQuick checks the records of the last block
+	StartupCheckQuick
+	// StartupCheckFull checks the records of the last block and every
+	// entry in the store
+	StartupCheckFull
+)
+
+func (c StartupCheck) String() string {
+	switch c {
+	case StartupCheckQuick:
+		return "quick"
+	case StartupCheckFull:
+		return "full"
+	}
+	return "off"
+}
+
+// checkStartup checks the committed state s is consistent with the store,
+// see startupcheck.go
+func (app *KVStoreApplication) checkStartup(s state) error {
+	if app.startupCheck == StartupCheckOff || s.Height == 0 {
+		return nil
+	}
+	return app.store.View(func(txn Txn) error {
+		if err := app.checkLastBlock(txn, s); err != nil {
+			return err
+		}
+		if app.startupCheck == StartupCheckFull {
+			return app.checkEntries(txn, s)
+		}
+		return nil
+	})
+}
+
+// checkLastBlock makes the quick checks, see startupcheck.go
+func (app *KVStoreApplication) checkLastBlock(txn Txn, s state) error {
+	if len(s.AppHash) == 0 {
+		return fmt.Errorf("the state at height %d has no app hash", s.Height)
+	}
+	if s.StateHash != nil {
+		if err := (StateProof{StateHash: s.StateHash, ReceiptsRoot: s.ReceiptsRoot}).Verify(s.AppHash); err != nil {
+			return fmt.Errorf("the state at height %d is inconsistent: %w", s.Height, err)
+		}
+		block, found, err := readReceipts(txn, s.Height)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("there are no receipts for height %d", s.Height)
+		}
+		if !bytes.Equal(block.StateHash, s.StateHash) || !bytes.Equal(merkle.HashFromByteSlices(block.Leaves), s.ReceiptsRoot) {
+			return fmt.Errorf("the receipts of height %d don't match its app hash", s.Height)
+		}
+	}
+
+	// the change index record is only of the height's own block if
+	// nothing was written outside of it, see backup.go
+	if app.merkleAppHash || !app.changeIndex || s.PrevStateHash == nil {
+		return nil
+	}
+	unindexed, ok, err := readHeight(txn, unindexedKey)
+	if err != nil || (ok && unindexed >= s.Height) {
+		return err
+	}
+	indexed, err := readChanges(txn, s.Height)
+	if err == ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var changes []change
+	for _, c := range indexed {
+		if !app.isLocal(c.Key) {
+			changes = append(changes, change{key: c.Key, value: c.Value, contentType: contentType(c.Type), deleted: c.Deleted})
+		}
+	}
+	if !bytes.Equal(nextAppHash(s.PrevStateHash, changes), s.stateHash()) {
+		return fmt.Errorf("the changes of height %d don't hash to its app hash", s.Height)
+	}
+	return nil
+}
+
+// checkEntries makes the full checks, see startupcheck.go
+func (app *KVStoreApplication) checkEntries(txn Txn, s state) error {
+	var keys, valueBytes int64
+	prefixKeys := make([]int64, len(s.PrefixKeys))
+	opts := DefaultIteratorOptions
+	it := txn.NewIterator(opts)
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		if isInternalKey(item.Key()) {
+			continue
+		}
+		value, err := itemValue(item)
+		if err != nil {
+			it.Close()
+			return err
+		}
+		keys++
+		valueBytes += int64(len(value))
+		for i, c := range s.PrefixKeys {
+			if bytes.HasPrefix(item.Key(), c.Prefix) {
+				prefixKeys[i]++
+			}
+		}
+	}
+	it.Close()
+
+	if keys != s.KeyCount || valueBytes != s.ValueBytes {
+		return fmt.Errorf("the store has %d keys and %d value bytes, the state at height %d says %d and %d",
+			keys, valueBytes, s.Height, s.KeyCount, s.ValueBytes)
+	}
+	for i, c := range s.PrefixKeys {
+		if prefixKeys[i] != c.Keys {
+			return fmt.Errorf("prefix %q has %d keys, the state at height %d says %d", c.Prefix, prefixKeys[i], s.Height, c.Keys)
+		}
+	}
+	if app.merkleAppHash {
+		root, err := merkleRoot(txn, app.localPrefixes)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(root, s.stateHash()) {
+			return fmt.Errorf("the merkle root of the store isn't the state hash of height %d", s.Height)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var startupCheckModes = []struct {
+	name   string
+	merkle bool
+	// hashed is set if the quick check can tell the app hash is wrong,
+	// from the change index or the receipts
+	hashed bool
+	opts   []Option
+}{
+	{name: "chained"},
+	{name: "chained with the change index", hashed: true, opts: []Option{WithChangeIndex(0)}},
+	{name: "chained with receipts", hashed: true, opts: []Option{WithChangeIndex(0), WithReceiptsRoot(true)}},
+	{name: "merkle", merkle: true, opts: []Option{WithMerkleAppHash(true)}},
+	{name: "merkle with receipts", merkle: true, hashed: true, opts: []Option{WithMerkleAppHash(true), WithReceiptsRoot(true)}},
+}
+
+// restart runs a new app on store with the startup check and returns what
+// it halted with, "" if it started
+func restart(store Store, check StartupCheck, opts ...Option) string {
+	return halts(func() { NewKVStoreApplicationWithStore(store, append(opts, WithStartupCheck(check))...) })
+}
+
+// tamper rewrites the committed state record behind the app's back
+func tamper(t *testing.T, store Store, f func(s *state)) {
+	t.Helper()
+	s, err := loadState(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f(&s)
+	if err := store.Update(s.save); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// committedStore returns a store two blocks in
+func committedStore(opts ...Option) Store {
+	store := NewMemStore()
+	app := NewKVStoreApplicationWithStore(store, opts...)
+	deliverBlock(app, 1, "a=1", "b=2")
+	deliverBlock(app, 2, "a=3")
+	return store
+}
+
+func TestStartupCheckPasses(t *testing.T) {
+	for _, mode := range startupCheckModes {
+		store := committedStore(mode.opts...)
+		for _, check := range []StartupCheck{StartupCheckQuick, StartupCheckFull} {
+			if msg := restart(store, check, mode.opts...); msg != "" {
+				t.Errorf("%s, %s check: %s", mode.name, check, msg)
+			}
+		}
+	}
+}
+
+func TestStartupCheckFindsCorruptValues(t *testing.T) {
+	for _, mode := range startupCheckModes {
+		store := committedStore(mode.opts...)
+		// a value of the same length, only the merkle root can tell
+		store.Update(func(txn Txn) error { return txn.Set([]byte("b"), []byte("3")) })
+		msg := restart(store, StartupCheckFull, mode.opts...)
+		if mode.merkle != strings.Contains(msg, "merkle root") {
+			t.Errorf("%s: a changed value got %q", mode.name, msg)
+		}
+
+		store.Update(func(txn Txn) error { return txn.Set([]byte("b"), []byte("22")) })
+		if msg := restart(store, StartupCheckFull, mode.opts...); !strings.Contains(msg, "value bytes") {
+			t.Errorf("%s: a resized value got %q", mode.name, msg)
+		}
+		if msg := restart(store, StartupCheckQuick, mode.opts...); msg != "" {
+			t.Errorf("%s: the quick check read the values: %s", mode.name, msg)
+		}
+	}
+}
+
+func TestStartupCheckFindsCorruptState(t *testing.T) {
+	for _, mode := range startupCheckModes {
+		store := committedStore(mode.opts...)
+		tamper(t, store, func(s *state) { s.KeyCount++ })
+		if msg := restart(store, StartupCheckFull, mode.opts...); !strings.Contains(msg, "the store has 2 keys") {
+			t.Errorf("%s: a wrong key count got %q", mode.name, msg)
+		}
+
+		store = committedStore(mode.opts...)
+		tamper(t, store, func(s *state) {
+			s.AppHash = append([]byte{}, s.AppHash...)
+			s.AppHash[0] ^= 1
+			if s.StateHash != nil {
+				s.StateHash = append([]byte{}, s.StateHash...)
+				s.StateHash[0] ^= 1
+			}
+		})
+		if msg := restart(store, StartupCheckQuick, mode.opts...); mode.hashed == (msg == "") {
+			t.Errorf("%s: a wrong app hash got %q", mode.name, msg)
+		}
+		if mode.merkle && restart(store, StartupCheckFull, mode.opts...) == "" {
+			t.Errorf("%s: the full check missed a wrong app hash", mode.name)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"hash"
+)
+
+// All the application's own bookkeeping lives in the same db as the user
+// data, under keys starting with internalPrefix, user transactions are not
+// allowed to touch those keys
+var internalPrefix = []byte{0x00}
+
+var stateKey = internalKey("state")
+
+func internalKey(name string) []byte {
+	return append(append([]byte{}, internalPrefix...), name...)
+}
+
+func isInternalKey(key []byte) bool {
+	return bytes.HasPrefix(key, internalPrefix)
+}
+
+// state is everything the application needs to remember between blocks
+// it is persisted in the same badger transaction as the block it describes
+// so it can never be out of sync with the data
+type state struct {
+	Height     int64  `json:"height"`
+	AppHash    []byte `json:"app_hash"`
+	KeyCount   int64  `json:"key_count"`
+	ValueBytes int64  `json:"value_bytes"`
+	// AppVersion is the app version the block was committed with
+	AppVersion uint64 `json:"app_version,omitempty"`
+	// PrefixKeys counts the keys under every prefix with a key limit
+	PrefixKeys []limitCount `json:"prefix_keys,omitempty"`
+	// StateHash and ReceiptsRoot make up the app hash with the receipts
+	// root, see receipts.go
+	StateHash    []byte `json:"state_hash,omitempty"`
+	ReceiptsRoot []byte `json:"receipts_root,omitempty"`
+	// PrevStateHash is the state hash the block's changes were chained
+	// onto, unset in merkle mode, see startupcheck.go
+	PrevStateHash []byte `json:"prev_state_hash,omitempty"`
+	// TxReceipts counts the transaction index's entries, it's recounted
+	// on startup, only when there's a receipt limit, see txindex.go
+	TxReceipts int64 `json:"-"`
+}
+
+// clone returns a copy of the state that doesn't share anything with s
+// the pending state has to start as a clone of the committed one
+func (s state) clone() state {
+	s.PrefixKeys = append([]limitCount(nil), s.PrefixKeys...)
+	return s
+}
+
+// loadState reads the last committed state, a fresh db has the zero state
+func loadState(store Store) (s state, err error) {
+	err = store.View(func(txn Txn) error {
+		s, err = readState(txn)
+		return err
+	})
+	return
+}
+
+// readState reads the state as seen by txn, which is the state of the last
+// block flushed before txn started
+func readState(txn Txn) (s state, err error) {
+	item, err := txn.Get(stateKey)
+	if err == ErrKeyNotFound {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &s)
+	})
+	return
+}
+
+// save adds the state to the given batch
+func (s state) save(txn Txn) error {
+	val, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return txn.Set(stateKey, val)
+}
+
+// change is a single write applied in the current block
+// a removed key is a change with deleted set and no value
+// existed is whether the key existed before the change, previous is the
+// value it had, only kept with the tx index, for receipts, see txindex.go
+// version is the key's version after the change, only with key versions
+type change struct {
+	key         []byte
+	value       []byte
+	contentType contentType
+	deleted     bool
+	existed     bool
+	previous    []byte
+	version     uint64
+}
+
+// tombstone is the type a removed key is hashed with, it's never the
+// type of a stored value
+const tombstone contentType = 0xff
+
+// nextAppHash chains the previous app hash with every change in the block
+// hash = sha256(previous hash | len(key) key len(value) value type | ...)
+// the changes are hashed in the order they were delivered, which is the same
+// on every node, so nodes with the same history always agree on the hash
+// a block without changes keeps the previous hash
+func nextAppHash(prev []byte, changes []change) []byte {
+	if len(changes) == 0 {
+		return prev
+	}
+	h := sha256.New()
+	h.Write(prev)
+	for _, c := range changes {
+		ct := c.contentType
+		if c.deleted {
+			ct = tombstone
+		}
+		hashEntry(h, c.key, c.value, ct)
+	}
+	return h.Sum(nil)
+}
+
+// hashEntry writes an entry to h, see encodeEntry
+func hashEntry(h hash.Hash, key, value []byte, ct contentType) {
+	h.Write(encodeEntry(key, value, ct))
+}
+
+// encodeEntry encodes an entry as len(key) key len(value) value type
+// the lengths make the encoding unambiguous, so different sequences of
+// entries can't produce the same bytes
+func encodeEntry(key, value []byte, ct contentType) []byte {
+	buf := make([]byte, 0, len(key)+len(value)+2*binary.MaxVarintLen64+1)
+	buf = appendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = appendUvarint(buf, uint64(len(value)))
+	buf = append(buf, value...)
+	return append(buf, byte(ct))
+}
+
+func appendUvarint(buf []byte, n uint64) []byte {
+	var lenBuf [binary.MaxVarintLen64]byte
+	return append(buf, lenBuf[:binary.PutUvarint(lenBuf[:], n)]...)
+}
+
+// computeAppHash sets the pending app hash for the current block
+// either chaining the block's changes onto the previous hash (the default)
+// or, in merkle mode, as the merkle root of the whole store (see merkle.go)
+func (app *KVStoreApplication) computeAppHash() error {
+	var hash []byte
+	if !app.merkleAppHash {
+		app.pending.PrevStateHash = app.pending.stateHash()
+		hash = nextAppHash(app.pending.PrevStateHash, app.hashedChanges())
+	} else {
+		app.pending.PrevStateHash = nil
+		root, err := merkleRoot(app.currentBatch, app.localPrefixes)
+		if err != nil {
+			return err
+		}
+		hash = root
+	}
+	if app.receiptsRoot {
+		return app.saveReceipts(hash)
+	}
+	app.pending.AppHash, app.pending.StateHash, app.pending.ReceiptsRoot = hash, nil, nil
+	return nil
+}
+
+// stateHash returns the hash of the store's state, the app hash unless it
+// also commits to receipts, see receipts.go
+func (s state) stateHash() []byte {
+	if s.StateHash != nil {
+		return s.StateHash
+	}
+	return s.AppHash
+}
+
+// errOverwriteForbidden rejects changing an existing key in append only mode
+var errOverwriteForbidden = reject(IMMUTABLE_KEY, "the store is append only, existing keys can't be changed or removed")
+
+// set writes a key to the current batch and keeps the block's state
+// (counters, changes for the app hash) up to date
+// every operation that writes a user key must go through here, which is
+// also where the append only mode is enforced against writes earlier in
+// the same block that validation against committed state can't see
+func (app *KVStoreApplication) set(key, value []byte, ct contentType) error {
+	// Reading through the batch means writes earlier in this
+	// block are taken into account
+	// item.ValueSize can't be used as it reports 0 for values that
+	// are still pending in the batch
+	var previous int64
+	var was contentType
+	var old []byte
+	item, err := app.currentBatch.Get(key)
+	exists := err == nil
+	if err != nil && err != ErrKeyNotFound {
+		return err
+	}
+	if exists && app.appendOnly {
+		return errOverwriteForbidden
+	}
+	if exists {
+		was = itemType(item)
+		err = item.Value(func(val []byte) error {
+			val, err := decodeValue(key, item.UserMeta(), val)
+			previous = int64(len(val))
+			if app.txIndex {
+				old = append([]byte{}, val...)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	} else if err := app.checkKeyLimits(key, app.pending); err != nil {
+		return err
+	}
+
+	if err := app.currentBatch.SetEntry(app.entry(key, value, ct)); err != nil {
+		return err
+	}
+	if err := app.clearExpiry(key); err != nil {
+		return err
+	}
+	if err := app.recordModified(key); err != nil {
+		return err
+	}
+	if err := app.recordWritten(key); err != nil {
+		return err
+	}
+	version, err := app.bumpVersion(key, exists)
+	if err != nil {
+		return err
+	}
+	if err := app.updateScore(key, value, ct); err != nil {
+		return err
+	}
+	if err := app.updateListLen(key, value, ct, was, exists); err != nil {
+		return err
+	}
+
+	if !exists {
+		app.pending.KeyCount++
+		app.pending.countKey(key, 1)
+	}
+	app.pending.ValueBytes += int64(len(value)) - previous
+	app.changes = append(app.changes, change{key: key, value: value, contentType: ct, existed: exists, previous: old, version: version})
+	return nil
+}
+
+// remove deletes a key from the current batch, the counterpart of set
+// every operation that removes a user key must go through here
+// it's a no-op if the key doesn't exist
+func (app *KVStoreApplication) remove(key []byte) error {
+	value, ct, exists, err := lookup(app.currentBatch, key)
+	if err != nil || !exists {
+		return err
+	}
+	if app.appendOnly {
+		return errOverwriteForbidden
+	}
+
+	if err := app.currentBatch.Delete(key); err != nil {
+		return err
+	}
+	if err := app.clearExpiry(key); err != nil {
+		return err
+	}
+	if err := app.clearModified(key); err != nil {
+		return err
+	}
+	if err := app.clearWritten(key); err != nil {
+		return err
+	}
+	if err := app.clearVersion(key); err != nil {
+		return err
+	}
+	if err := app.clearScore(key); err != nil {
+		return err
+	}
+	if ct == typeList {
+		if err := app.currentBatch.Delete(listLenKey(key)); err != nil {
+			return err
+		}
+	}
+
+	app.pending.KeyCount--
+	app.pending.countKey(key, -1)
+	app.pending.ValueBytes -= int64(len(value))
+	app.changes = append(app.changes, change{key: key, deleted: true, existed: true, previous: value})
+	return nil
+}
+
+// get reads a key as of the last Commit, going through the read cache
+// if there is one, the returned value is safe to keep after the call
+// an empty key never exists, badger returns an error for it
+func (app *KVStoreApplication) get(key []byte) (value []byte, exists bool, err error) {
+	if len(key) == 0 {
+		return nil, false, nil
+	}
+	defer func() {
+		if err == nil {
+			app.metrics.keyRead(key, len(value))
+			app.readStats.record(key)
+		}
+	}()
+	if app.cache != nil {
+		if value, exists, ok := app.cache.get(key); ok {
+			return value, exists, nil
+		}
+	}
+
+	err = app.store.View(func(txn Txn) error {
+		item, err := txn.Get(key)
+		if err == ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		exists = true
+		// the value passed to item.Value is only valid inside the
+		// transaction so it has to be copied out
+		value, err = itemValue(item)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	if app.cache != nil {
+		app.cache.add(key, value, exists)
+	}
+	return value, exists, nil
+}
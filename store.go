@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/dgraph-io/badger"
+)
+
+// The application keeps its data in a Store, badger by default, see
+// NewKVStoreApplication, any other with NewKVStoreApplicationWithStore, e.g.
+// the in memory MemStore, the interface is the part of badger's the app uses
+//
+//   - View runs a read only Txn that sees a snapshot of the store, it's safe
+//     to run any number of them while a batch is being written
+//   - NewBatch starts a read write Txn, its reads and iterators see its own
+//     writes, nothing of it is visible to any other Txn until it's committed,
+//     and a commit is all or nothing
+//   - an iterator goes over the keys in byte order, or in reverse, and only
+//     over those with its prefix
+//   - every entry has a byte of metadata next to its value
+//
+// a batch is committed once per flush, see flush.go, Commit has to make it
+// durable, or as durable as the store goes, badger's is up to its options
+// what's left of badger, the value log GC, compaction, its size, sync and
+// snapshots, is only there with a badger store, the options that need it
+// panic with any other
+
+// ErrKeyNotFound is returned by Txn.Get for a key that isn't in the store
+var ErrKeyNotFound = badger.ErrKeyNotFound
+
+// ErrNotBadger is returned by operations only a badger store has
+var ErrNotBadger = errors.New("the store isn't badger")
+
+// Store is the storage the application keeps its data in
+type Store interface {
+	// View runs fn in a read only Txn
+	View(fn func(txn Txn) error) error
+	// Update runs fn in a batch, committed if fn returns nil
+	Update(fn func(txn Txn) error) error
+	// NewBatch starts a read write Txn
+	NewBatch() Txn
+}
+
+// Txn is a transaction on a Store
+type Txn interface {
+	// Get returns the item of key, ErrKeyNotFound if there isn't one
+	Get(key []byte) (Item, error)
+	Set(key, value []byte) error
+	SetEntry(e *Entry) error
+	Delete(key []byte) error
+	// NewIterator returns an iterator that has to be closed before the
+	// Txn is committed or discarded
+	NewIterator(opts IteratorOptions) Iterator
+	Commit() error
+	// Discard drops the Txn's writes, it can be called after Commit
+	Discard()
+}
+
+// Item is an entry read from a Store, the slices it returns are only
+// valid until the iterator moves on or the Txn ends, but for the copies
+type Item interface {
+	Key() []byte
+	KeyCopy(dst []byte) []byte
+	Value(fn func(val []byte) error) error
+	ValueCopy(dst []byte) ([]byte, error)
+	// ValueSize is the size of a committed value, badger reports 0 for a
+	// batch's own writes
+	ValueSize() int64
+	UserMeta() byte
+}
+
+// Iterator iterates over the items of a Txn
+type Iterator interface {
+	Rewind()
+	// Seek moves to the first key at or after key, at or before it in
+	// reverse, an empty key is the iterator's prefix
+	Seek(key []byte)
+	Valid() bool
+	ValidForPrefix(prefix []byte) bool
+	Next()
+	Item() Item
+	Close()
+}
+
+// IteratorOptions are the options of an Iterator
+type IteratorOptions struct {
+	// PrefetchValues is a hint that the values are read too
+	PrefetchValues bool
+	Reverse        bool
+	// Prefix limits the iterator to the keys with it
+	Prefix []byte
+}
+
+// DefaultIteratorOptions iterates forward over every key, with the values
+var DefaultIteratorOptions = IteratorOptions{PrefetchValues: true}
+
+// Entry is a value to set with its metadata
+type Entry struct {
+	Key      []byte
+	Value    []byte
+	UserMeta byte
+}
+
+// badgerStore is the Store of a badger db
+type badgerStore struct {
+	db *badger.DB
+}
+
+// NewBadgerStore returns the Store of a badger db
+func NewBadgerStore(db *badger.DB) Store {
+	return badgerStore{db: db}
+}
+
+func (s badgerStore) View(fn func(txn Txn) error) error {
+	return s.db.View(func(txn *badger.Txn) error { return fn(badgerTxn{txn}) })
+}
+
+func (s badgerStore) Update(fn func(txn Txn) error) error {
+	return s.db.Update(func(txn *badger.Txn) error { return fn(badgerTxn{txn}) })
+}
+
+func (s badgerStore) NewBatch() Txn {
+	return badgerTxn{s.db.NewTransaction(true)}
+}
+
+type badgerTxn struct {
+	txn *badger.Txn
+}
+
+func (t badgerTxn) Get(key []byte) (Item, error) {
+	item, err := t.txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (t badgerTxn) Set(key, value []byte) error {
+	return t.txn.Set(key, value)
+}
+
+func (t badgerTxn) SetEntry(e *Entry) error {
+	return t.txn.SetEntry(badger.NewEntry(e.Key, e.Value).WithMeta(e.UserMeta))
+}
+
+func (t badgerTxn) Delete(key []byte) error {
+	return t.txn.Delete(key)
+}
+
+func (t badgerTxn) NewIterator(opts IteratorOptions) Iterator {
+	bopts := badger.DefaultIteratorOptions
+	bopts.PrefetchValues, bopts.Reverse, bopts.Prefix = opts.PrefetchValues, opts.Reverse, opts.Prefix
+	return badgerIterator{t.txn.NewIterator(bopts)}
+}
+
+func (t badgerTxn) Commit() error {
+	return t.txn.Commit()
+}
+
+func (t badgerTxn) Discard() {
+	t.txn.Discard()
+}
+
+type badgerIterator struct {
+	*badger.Iterator
+}
+
+func (it badgerIterator) Item() Item {
+	return it.Iterator.Item()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// storeTrace runs the same reads and writes on store and describes what it
+// saw, two stores that behave alike give the same trace
+func storeTrace(t *testing.T, store Store) string {
+	var trace strings.Builder
+	batch := store.NewBatch()
+	get := func(txn Txn, key string) {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			fmt.Fprintf(&trace, "get %s: %v\n", key, err)
+			return
+		}
+		value, _ := item.ValueCopy(nil)
+		fmt.Fprintf(&trace, "get %s: %q meta %d", key, value, item.UserMeta())
+		// badger has no size for a batch's own writes
+		if txn != batch {
+			fmt.Fprintf(&trace, " size %d", item.ValueSize())
+		}
+		trace.WriteString("\n")
+	}
+	iterate := func(txn Txn, opts IteratorOptions, seek string) {
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		fmt.Fprintf(&trace, "iterate %q reverse %v from %q:", opts.Prefix, opts.Reverse, seek)
+		for it.Seek([]byte(seek)); it.Valid(); it.Next() {
+			fmt.Fprintf(&trace, " %s", it.Item().KeyCopy(nil))
+		}
+		trace.WriteString("\n")
+	}
+	view := func(f func(txn Txn)) {
+		if err := store.View(func(txn Txn) error { f(txn); return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, key := range []string{"p/b", "p/a", "p/c", "q", "o"} {
+		if err := batch.Set([]byte(key), []byte("v"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch.SetEntry(&Entry{Key: []byte("p/d"), Value: []byte("meta"), UserMeta: 7})
+	batch.Delete([]byte("p/c"))
+	// the batch sees its own writes, nothing else does until it's committed
+	get(batch, "p/c")
+	get(batch, "p/d")
+	iterate(batch, IteratorOptions{Prefix: []byte("p/")}, "")
+	view(func(txn Txn) { get(txn, "p/a") })
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	batch.Discard()
+
+	view(func(txn Txn) {
+		for _, key := range []string{"p/a", "p/c", "p/d", "o"} {
+			get(txn, key)
+		}
+		iterate(txn, DefaultIteratorOptions, "")
+		iterate(txn, IteratorOptions{Prefix: []byte("p/")}, "p/b")
+		iterate(txn, IteratorOptions{Prefix: []byte("p/"), Reverse: true}, "")
+		iterate(txn, IteratorOptions{Prefix: []byte("p/"), Reverse: true}, "p/c")
+		iterate(txn, IteratorOptions{Reverse: true}, "p/")
+	})
+
+	// a discarded batch leaves nothing behind, a failed update neither
+	batch = store.NewBatch()
+	batch.Set([]byte("p/x"), []byte("x"))
+	batch.Delete([]byte("p/a"))
+	batch.Discard()
+	store.Update(func(txn Txn) error {
+		txn.Set([]byte("p/y"), []byte("y"))
+		return fmt.Errorf("failed")
+	})
+	store.Update(func(txn Txn) error { return txn.Set([]byte("p/z"), []byte("z")) })
+	view(func(txn Txn) { iterate(txn, IteratorOptions{Prefix: []byte("p/")}, "") })
+	return trace.String()
+}
+
+func TestStoresAgree(t *testing.T) {
+	mem, badger := storeTrace(t, NewMemStore()), storeTrace(t, NewBadgerStore(testDB(t)))
+	if mem != badger {
+		t.Errorf("the memory store saw\n%s\nbadger saw\n%s", mem, badger)
+	}
+
+	// and the app gets the same state and app hash on either
+	var hashes [][]byte
+	for _, app := range []*KVStoreApplication{newTestApp(t), NewKVStoreApplication(testDB(t))} {
+		deliverBlock(app, 1, "a=1", "b=2;type=int", "c=3")
+		deliverBlock(app, 2, "delprefix:c", "incr:b:5", "cp:a:d")
+		hashes = append(hashes, app.committed.AppHash)
+	}
+	if !bytes.Equal(hashes[0], hashes[1]) {
+		t.Errorf("got app hashes %X on the memory store and %X on badger", hashes[0], hashes[1])
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// The "sum" query adds up the ints under a prefix, e.g. the counters of a
+// dashboard, so a client doesn't have to fetch every one of them to sum them
+//
+//	{"prefix": "hits/", "skip": true}
+//
+// a value is an int if it parses as a base 10 int64, tagged or not, so
+// counters set as plain bytes count too, a value that isn't one fails the
+// query, unless skip is set, then it's left out and counted in skipped
+// the sum is exact, it can go past the int64 range
+// like sizehist it stops after max_scan keys, or the time budget, see
+// querybudget.go, and says where to carry on from, the sums from there on
+// add up to the sum of the whole prefix
+
+const (
+	defaultSumScan = 100000
+	maxSumScan     = 1000000
+)
+
+type sumRequest struct {
+	Prefix  string `json:"prefix"`
+	Skip    bool   `json:"skip"`
+	MaxScan int    `json:"max_scan"`
+	// Start continues a previous sum from that key
+	Start string `json:"start"`
+	// Budget is the longest the scan can run, see querybudget.go
+	Budget string `json:"budget"`
+}
+
+type sumResponse struct {
+	Height int64    `json:"height"`
+	Sum    *big.Int `json:"sum"`
+	// Keys is the number of ints summed, Skipped the number of values left
+	// out as they weren't ints
+	Keys    int64 `json:"keys"`
+	Skipped int64 `json:"skipped"`
+	Scanned int64 `json:"scanned"`
+	// Truncated is set if the scan stopped at max_scan or the time
+	// budget, the sum only covers the keys before Next in key order
+	Truncated bool   `json:"truncated,omitempty"`
+	Next      string `json:"next,omitempty"`
+}
+
+// querySum returns the sum of the ints under a prefix, see sum.go
+func (app *KVStoreApplication) querySum(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	var sreq sumRequest
+	if len(req.Data) > 0 && !parseRequest(req, &res, &sreq) {
+		return
+	}
+	maxScan := int64(clampLimit(sreq.MaxScan, defaultSumScan, maxSumScan))
+	deadline, ok := app.queryDeadline(sreq.Budget, &res)
+	if !ok {
+		return
+	}
+	prefix := app.normalizeKey([]byte(sreq.Prefix))
+	start := prefix
+	if len(prefix) == 0 {
+		start = prefixEnd(internalPrefix)
+	}
+	if sreq.Start != "" {
+		if key := app.normalizeKey([]byte(sreq.Start)); bytes.Compare(key, start) > 0 {
+			start = key
+		}
+	}
+
+	sres := sumResponse{Height: app.committed.Height, Sum: new(big.Int)}
+	var notInt []byte
+	err := app.store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		var n big.Int
+		for it.Seek(start); it.Valid(); it.Next() {
+			item := it.Item()
+			if isInternalKey(item.Key()) {
+				continue
+			}
+			if sres.Scanned == maxScan || (sres.Scanned > 0 && deadline.passed()) {
+				sres.Truncated = true
+				sres.Next = string(item.Key())
+				return nil
+			}
+			sres.Scanned++
+			var v int64
+			var isInt bool
+			err := item.Value(func(val []byte) error {
+				val, err := decodeValue(item.Key(), item.UserMeta(), val)
+				if err != nil {
+					return err
+				}
+				v, err = strconv.ParseInt(string(val), 10, 64)
+				isInt = err == nil
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if !isInt {
+				if !sreq.Skip {
+					notInt = item.KeyCopy(nil)
+					return nil
+				}
+				sres.Skipped++
+				continue
+			}
+			sres.Sum.Add(sres.Sum, n.SetInt64(v))
+			sres.Keys++
+		}
+		return nil
+	})
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+	if notInt != nil {
+		res.Code = QUERY_INVALID
+		res.Log = fmt.Sprintf("the value of %q isn't an int, set skip to leave it out", notInt)
+		return
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, sres)
+	return
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestSum(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 1, "c/a=5;type=int", "c/b=-2", "c/c=9223372036854775807", "c/d=9223372036854775807", "c/e=abc", "d=100")
+
+	// strict, the value that isn't an int fails the query
+	res := app.Query(abcitypes.RequestQuery{Path: "sum", Data: []byte(`{"prefix": "c/"}`)})
+	if res.Code != QUERY_INVALID || !strings.Contains(res.Log, `"c/e"`) {
+		t.Errorf("a strict sum over a string got code %d: %s", res.Code, res.Log)
+	}
+
+	// skipped, and the sum goes past the int64 range
+	want, _ := new(big.Int).SetString("18446744073709551617", 10)
+	var s sumResponse
+	queryJSON(t, app, "sum", []byte(`{"prefix": "c/", "skip": true}`), &s)
+	if s.Sum.Cmp(want) != 0 || s.Keys != 4 || s.Skipped != 1 || s.Scanned != 5 || s.Truncated {
+		t.Errorf("skipping got %+v, want a sum of %s", s, want)
+	}
+	queryJSON(t, app, "sum", []byte(`{"prefix": "d"}`), &s)
+	if s.Sum.Int64() != 100 || s.Keys != 1 {
+		t.Errorf("the sum of d got %+v", s)
+	}
+
+	// a scan cut short carries on from next, the parts add up
+	var first, rest sumResponse
+	queryJSON(t, app, "sum", []byte(`{"prefix": "c/", "skip": true, "max_scan": 2}`), &first)
+	if !first.Truncated || first.Next != "c/c" || first.Sum.Int64() != 3 {
+		t.Fatalf("the first part got %+v", first)
+	}
+	queryJSON(t, app, "sum", []byte(`{"prefix": "c/", "skip": true, "start": "c/c"}`), &rest)
+	if total := new(big.Int).Add(first.Sum, rest.Sum); rest.Truncated || total.Cmp(want) != 0 {
+		t.Errorf("the parts add up to %s, want %s", total, want)
+	}
+}
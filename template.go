@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+)
+
+// A write with ';template=true' has placeholders in its value filled in from
+// the block it's delivered in, e.g. 'key=created-at-{height};template=true'
+// sets key to 'created-at-10' in block 10
+//
+//	{height} the height of the block
+//	{time}   the block's header time, RFC 3339 in UTC with nanoseconds
+//	{unix}   the block's header time in unix seconds
+//
+// '{{' is a literal '{', any other '{' has to start one of the placeholders,
+// a value with an unknown or unterminated one is rejected with INVALID_VALUE,
+// a '}' outside a placeholder is kept as is
+// the values only ever come from the block header, never the node's clock,
+// so every node stores the same bytes, CheckTx fills them in with the next
+// height and the last block's time, which is enough to check the value, the
+// value stored is only decided once the transaction is delivered
+// the value is filled in before anything else looks at it, so its type and
+// the duplicate check are about the value that's stored, a filled in value
+// that isn't valid for its type is rejected with TEMPLATE_INVALID rather than
+// INVALID_VALUE, whether it's valid can depend on the block, so it can pass
+// CheckTx and fail in DeliverTx without the proposer being at fault
+
+var templatePlaceholders = map[string]func(app *KVStoreApplication) string{
+	"height": func(app *KVStoreApplication) string {
+		return strconv.FormatInt(app.txHeight(), 10)
+	},
+	"time": func(app *KVStoreApplication) string {
+		return app.blockTime.UTC().Format(time.RFC3339Nano)
+	},
+	"unix": func(app *KVStoreApplication) string {
+		return strconv.FormatInt(app.blockTime.Unix(), 10)
+	},
+}
+
+// interpolate fills in the placeholders of a template value
+func (app *KVStoreApplication) interpolate(value []byte) ([]byte, error) {
+	var out []byte
+	for {
+		i := bytes.IndexByte(value, '{')
+		if i < 0 {
+			return append(out, value...), nil
+		}
+		out = append(out, value[:i]...)
+		value = value[i+1:]
+		if len(value) > 0 && value[0] == '{' {
+			out = append(out, '{')
+			value = value[1:]
+			continue
+		}
+		end := bytes.IndexByte(value, '}')
+		if end < 0 {
+			return nil, reject(INVALID_VALUE, "unterminated template placeholder")
+		}
+		fill, ok := templatePlaceholders[string(value[:end])]
+		if !ok {
+			return nil, reject(INVALID_VALUE, "unknown template placeholder {"+string(value[:end])+"}")
+		}
+		out = append(out, fill(app)...)
+		value = value[end+1:]
+	}
+}
+
+// interpolateWrites fills in the values of the transaction's template writes
+func (app *KVStoreApplication) interpolateWrites(t *transaction) (err error) {
+	if t.batch == nil {
+		if t.template {
+			t.value, err = app.interpolate(t.value)
+		}
+		return
+	}
+	for i := range t.batch {
+		if !t.batch[i].template {
+			continue
+		}
+		if t.batch[i].value, err = app.interpolate(t.batch[i].value); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestTemplateValueInvalidAfterInterpolation(t *testing.T) {
+	app := newTestApp(t, WithInvalidTxPolicy(InvalidTxHalt))
+	tx := "k=x{height};type=int;template=true"
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}); r.Code != TEMPLATE_INVALID {
+		t.Errorf("CheckTx got code %d, want TEMPLATE_INVALID", r.Code)
+	}
+	// it isn't malformed, so it doesn't halt the node
+	res := deliverBlock(app, 1, tx, "n={height};type=int;template=true")
+	if res[0].Code != TEMPLATE_INVALID || res[1].Code != VALID_TX {
+		t.Errorf("got codes %d and %d", res[0].Code, res[1].Code)
+	}
+	if app.malformedTxs != 0 {
+		t.Errorf("%d transactions counted as malformed", app.malformedTxs)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// With time buckets (WithTimeBuckets) a series of values can be split into
+// lists by time, 'bucket:series:element' pushes element onto the list of the
+// bucket the block's time falls in, e.g. with hourly buckets
+//
+//	bucket:cpu:0.93  ->  push:cpu/2024-05-01-130000:0.93
+//
+// the bucket key is the series, '/' and the start of the bucket in UTC, as
+// YYYY-MM-DD-hhmmss, so the buckets of a series sort by time, and the key
+// has no ':' and doesn't change under key normalization, a bucket starts
+// at a multiple of the width since the zero time, on the hour for hourly
+// buckets, at midnight UTC for daily ones, the time is the block header's,
+// never the node's clock, so every node writes the same key, CheckTx checks
+// against the bucket of the last block's time, the bucket is only decided
+// once the transaction is delivered, like a template's {time}
+// a bucket is a list like any other, with its limits, the width is part of
+// consensus, every node has to have the same one
+//
+// the "buckets" query lists the buckets of a series, oldest first, e.g.
+// {"series": "cpu", "from": "2024-05-01T00:00:00Z", "to": "...", "limit": 24}
+// and the "bucket" query reads the bucket a time falls in, e.g.
+// {"series": "cpu", "time": "2024-05-01T13:20:00Z"}
+
+// bucketTimeFormat is the format of a bucket's start in its key, it's fixed
+// width, so it sorts by time
+const bucketTimeFormat = "2006-01-02-150405"
+
+const (
+	defaultBucketsLimit = 100
+	maxBucketsLimit     = 1000
+)
+
+// bucketStart returns the start of the bucket t falls in
+func (app *KVStoreApplication) bucketStart(t time.Time) time.Time {
+	return t.UTC().Truncate(app.bucketWidth)
+}
+
+// bucketKey returns the key of the bucket of series starting at start
+func bucketKey(series []byte, start time.Time) []byte {
+	key := append(append([]byte{}, series...), '/')
+	return append(key, start.Format(bucketTimeFormat)...)
+}
+
+// blockBucketKey returns the key of the bucket of series for the block's
+// time
+func (app *KVStoreApplication) blockBucketKey(series []byte) []byte {
+	return bucketKey(series, app.bucketStart(app.blockTime))
+}
+
+func init() {
+	registerOp(&txOp{
+		name: "bucket",
+		args: 2,
+		keys: []int{0},
+		rest: true,
+		enabled: func(app *KVStoreApplication) bool {
+			return app.bucketWidth > 0
+		},
+		check: func(app *KVStoreApplication, txn Txn, t transaction) error {
+			key := app.blockBucketKey(t.args[0])
+			if err := app.checkKey(key); err != nil {
+				return err
+			}
+			return app.checkPush(txn, key)
+		},
+		apply: func(app *KVStoreApplication, t transaction) error {
+			return app.push(app.blockBucketKey(t.args[0]), t.args[1])
+		},
+	})
+}
+
+type bucketsRequest struct {
+	Series string `json:"series"`
+	// From and To bound the buckets listed by their start, both inclusive,
+	// either can be left out
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Limit int    `json:"limit"`
+}
+
+type bucketEntry struct {
+	Key   string    `json:"key"`
+	Start time.Time `json:"start"`
+	// Elements is the length of the bucket's list
+	Elements int64 `json:"elements"`
+}
+
+type bucketsResponse struct {
+	Height  int64         `json:"height"`
+	Width   string        `json:"width"`
+	Buckets []bucketEntry `json:"buckets"`
+	// Next is set if there are more buckets, where to carry on from
+	Next string `json:"next,omitempty"`
+}
+
+type bucketRequest struct {
+	Series string `json:"series"`
+	Time   string `json:"time"`
+}
+
+type bucketResponse struct {
+	Height   int64     `json:"height"`
+	Key      string    `json:"key"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Exists   bool      `json:"exists"`
+	Elements []string  `json:"elements"`
+}
+
+// parseBucketTime parses a time in a buckets request, zero if it's empty
+func parseBucketTime(name, value string, res *abcitypes.ResponseQuery) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		res.Code = QUERY_INVALID
+		res.Log = name + " must be an RFC 3339 time"
+		return t, false
+	}
+	return t, true
+}
+
+// bucketsEnabled sets the response for a node without time buckets
+func (app *KVStoreApplication) bucketsEnabled(res *abcitypes.ResponseQuery) bool {
+	if app.bucketWidth == 0 {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "time buckets are disabled"
+		return false
+	}
+	return true
+}
+
+// queryBuckets lists the buckets of a series, see timebucket.go
+func (app *KVStoreApplication) queryBuckets(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if !app.bucketsEnabled(&res) {
+		return
+	}
+	var breq bucketsRequest
+	if !parseRequest(req, &res, &breq) {
+		return
+	}
+	from, ok := parseBucketTime("from", breq.From, &res)
+	if !ok {
+		return
+	}
+	to, ok := parseBucketTime("to", breq.To, &res)
+	if !ok {
+		return
+	}
+	limit := clampLimit(breq.Limit, defaultBucketsLimit, maxBucketsLimit)
+	series := app.normalizeKey([]byte(breq.Series))
+	if len(series) == 0 {
+		res.Code = QUERY_INVALID
+		res.Log = "series can't be empty"
+		return
+	}
+	prefix := append(append([]byte{}, series...), '/')
+	seek := prefix
+	if !from.IsZero() {
+		// a bucket that starts before from can't be listed, but the
+		// one it falls in can, from is rounded up to the next start
+		start := app.bucketStart(from)
+		if start.Before(from) {
+			start = start.Add(app.bucketWidth)
+		}
+		seek = bucketKey(series, start)
+	}
+
+	bres := bucketsResponse{Height: app.committed.Height, Width: app.bucketWidth.String(), Buckets: []bucketEntry{}}
+	err := app.store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(seek); it.Valid(); it.Next() {
+			item := it.Item()
+			// anything else under the series that isn't a bucket is
+			// left out
+			start, err := time.Parse(bucketTimeFormat, string(item.Key()[len(prefix):]))
+			if err != nil || itemType(item) != typeList {
+				continue
+			}
+			if !to.IsZero() && start.After(to) {
+				return nil
+			}
+			if len(bres.Buckets) == limit {
+				bres.Next = start.Format(time.RFC3339)
+				return nil
+			}
+			n, err := readListLen(txn, item.Key())
+			if err != nil {
+				return err
+			}
+			bres.Buckets = append(bres.Buckets, bucketEntry{Key: string(item.Key()), Start: start, Elements: n})
+		}
+		return nil
+	})
+	if err != nil {
+		halt("Query", err)
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, bres)
+	return
+}
+
+// queryBucket returns the elements of the bucket a time falls in, see
+// timebucket.go
+func (app *KVStoreApplication) queryBucket(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if !app.bucketsEnabled(&res) {
+		return
+	}
+	var breq bucketRequest
+	if !parseRequest(req, &res, &breq) {
+		return
+	}
+	if breq.Time == "" {
+		res.Code = QUERY_INVALID
+		res.Log = "time can't be empty"
+		return
+	}
+	t, ok := parseBucketTime("time", breq.Time, &res)
+	if !ok {
+		return
+	}
+	series := app.normalizeKey([]byte(breq.Series))
+	if len(series) == 0 {
+		res.Code = QUERY_INVALID
+		res.Log = "series can't be empty"
+		return
+	}
+	start := app.bucketStart(t)
+	key := bucketKey(series, start)
+
+	bres := bucketResponse{Height: app.committed.Height, Key: string(key), Start: start, End: start.Add(app.bucketWidth), Elements: []string{}}
+	err := app.store.View(func(txn Txn) error {
+		value, ct, exists, err := lookup(txn, key)
+		if err != nil || !exists {
+			return err
+		}
+		if ct != typeList {
+			return reject(INCOMPATIBLE_VALUE, fmt.Sprintf("%q isn't a list", key))
+		}
+		bres.Exists = true
+		bres.Elements, err = decodeList(value)
+		return err
+	})
+	if r, ok := asRejection(err); ok {
+		res.Code = QUERY_INVALID
+		res.Log = r.log
+		return
+	}
+	if err != nil {
+		app.queryReadFailed(&res, err)
+		return
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, bres)
+	return
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+func TestTimeBuckets(t *testing.T) {
+	app := newTestApp(t, WithTimeBuckets(time.Hour))
+	base := time.Date(2024, 5, 1, 12, 59, 59, 0, time.UTC)
+	for i, d := range []time.Duration{0, time.Second, 30 * time.Minute, 2 * time.Hour} {
+		app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: int64(i + 1), Time: base.Add(d)}})
+		if r := app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte(fmt.Sprintf("bucket:cpu:v%d", i))}); r.Code != VALID_TX {
+			t.Fatalf("block %d got code %d: %s", i+1, r.Code, r.Log)
+		}
+		app.EndBlock(abcitypes.RequestEndBlock{Height: int64(i + 1)})
+		app.Commit()
+	}
+
+	// the second before the hour, the hour and half past, two hours on
+	keys := func(res bucketsResponse) (keys []string) {
+		for _, b := range res.Buckets {
+			keys = append(keys, fmt.Sprintf("%s %d", b.Key, b.Elements))
+		}
+		return keys
+	}
+	for _, c := range []struct {
+		data string
+		want []string
+	}{
+		{`{"series": "cpu"}`, []string{"cpu/2024-05-01-120000 1", "cpu/2024-05-01-130000 2", "cpu/2024-05-01-140000 1"}},
+		{`{"series": "cpu", "from": "2024-05-01T13:00:00Z"}`, []string{"cpu/2024-05-01-130000 2", "cpu/2024-05-01-140000 1"}},
+		{`{"series": "cpu", "from": "2024-05-01T12:30:00Z", "to": "2024-05-01T13:00:00Z"}`, []string{"cpu/2024-05-01-130000 2"}},
+		{`{"series": "mem"}`, nil},
+	} {
+		var res bucketsResponse
+		queryJSON(t, app, "buckets", []byte(c.data), &res)
+		if got := keys(res); !reflect.DeepEqual(got, c.want) || res.Height != 4 {
+			t.Errorf("%s got %v at height %d, want %v", c.data, got, res.Height, c.want)
+		}
+	}
+	var page bucketsResponse
+	queryJSON(t, app, "buckets", []byte(`{"series": "cpu", "limit": 1}`), &page)
+	if len(page.Buckets) != 1 || page.Next != "2024-05-01T13:00:00Z" {
+		t.Errorf("a page of 1 got %+v", page)
+	}
+
+	// a bucket is read by any time in it, in any zone
+	for _, at := range []string{"2024-05-01T13:45:00Z", "2024-05-01T14:45:00+01:00"} {
+		var b bucketResponse
+		queryJSON(t, app, "bucket", []byte(fmt.Sprintf(`{"series": "cpu", "time": %q}`, at)), &b)
+		if b.Key != "cpu/2024-05-01-130000" || !b.Exists || !reflect.DeepEqual(b.Elements, []string{"v1", "v2"}) {
+			t.Errorf("the bucket at %s is %+v", at, b)
+		}
+	}
+	var empty bucketResponse
+	queryJSON(t, app, "bucket", []byte(`{"series": "cpu", "time": "2024-05-01T10:00:00Z"}`), &empty)
+	if empty.Exists || len(empty.Elements) != 0 {
+		t.Errorf("a bucket nothing was written to is %+v", empty)
+	}
+	if res := app.Query(abcitypes.RequestQuery{Path: "bucket", Data: []byte(`{"series": "cpu"}`)}); res.Code != QUERY_INVALID {
+		t.Errorf("a bucket query without a time got code %d", res.Code)
+	}
+
+	if r := newTestApp(t).CheckTx(abcitypes.RequestCheckTx{Tx: []byte("bucket:cpu:x")}); r.Code != OP_DISABLED {
+		t.Errorf("bucket without time buckets got code %d", r.Code)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// With a tracer (WithTracer) the write path is traced with OpenTelemetry,
+// every block is a span from BeginBlock to the end of its Commit, and each
+// DeliverTx and the Commit are spans under it
+//
+//	kvstore.block      height
+//	kvstore.DeliverTx  height, tx_size, op, batch_ops, code
+//	kvstore.Commit     height, writes, flushed
+//
+// ABCI calls come without a context, so the block span is a root span, the
+// height ties it to tendermint's own traces and logs, the attributes are
+// sizes and counts, never a key or a value, a rejected transaction's span
+// has an error status, the log isn't recorded as it can quote its keys
+// the default tracer is a no-op one, the provider behind a tracer decides
+// what's sampled and where it's exported to
+
+// tracerName is the instrumentation name of the default no-op tracer
+const tracerName = "github.com/iammadab/kvstore"
+
+// startBlockSpan starts the span of the block at height
+func (app *KVStoreApplication) startBlockSpan(height int64) {
+	app.blockCtx, app.blockSpan = app.tracer.Start(context.Background(), "kvstore.block",
+		trace.WithAttributes(attribute.Int64("height", height)))
+}
+
+// endBlockSpan ends the span of the block, once it's committed
+func (app *KVStoreApplication) endBlockSpan() {
+	if app.blockSpan != nil {
+		app.blockSpan.End()
+	}
+	app.blockCtx, app.blockSpan = nil, nil
+}
+
+// startSpan starts a span under the block's
+func (app *KVStoreApplication) startSpan(name string, attrs ...attribute.KeyValue) trace.Span {
+	ctx := app.blockCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := app.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// traceTx records what kind of transaction t is on its DeliverTx span
+func traceTx(span trace.Span, t transaction) {
+	if !span.IsRecording() {
+		return
+	}
+	if t.op != nil {
+		span.SetAttributes(attribute.String("op", t.op.name))
+	}
+	if t.batch != nil {
+		span.SetAttributes(attribute.Int("batch_ops", len(t.batch)))
+	}
+}
+
+// endTxSpan ends a DeliverTx span with the transaction's code
+func endTxSpan(span trace.Span, code uint32) {
+	span.SetAttributes(attribute.Int64("code", int64(code)))
+	if code != VALID_TX {
+		span.SetStatus(codes.Error, "rejected")
+	}
+	span.End()
+}
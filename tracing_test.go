@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordedSpan is a span kept by spanRecorder
+type recordedSpan struct {
+	trace.Span
+	name   string
+	parent string
+	attrs  map[string]string
+	status codes.Code
+	ended  bool
+}
+
+func (s *recordedSpan) IsRecording() bool { return true }
+
+func (s *recordedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, a := range kv {
+		s.attrs[string(a.Key)] = a.Value.Emit()
+	}
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, _ string) { s.status = code }
+
+func (s *recordedSpan) End(...trace.SpanEndOption) { s.ended = true }
+
+// spanRecorder is a tracer that keeps every span in memory, in the order
+// they're started
+type spanRecorder struct {
+	spans []*recordedSpan
+}
+
+type recordedSpanKey struct{}
+
+func (r *spanRecorder) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	s := &recordedSpan{Span: trace.SpanFromContext(context.Background()), name: name, attrs: map[string]string{}}
+	cfg := trace.NewSpanStartConfig(opts...)
+	s.SetAttributes(cfg.Attributes()...)
+	if parent, ok := ctx.Value(recordedSpanKey{}).(*recordedSpan); ok {
+		s.parent = parent.name
+	}
+	r.spans = append(r.spans, s)
+	return context.WithValue(ctx, recordedSpanKey{}, s), s
+}
+
+func TestTracing(t *testing.T) {
+	rec := &spanRecorder{}
+	app := newTestApp(t, WithTracer(rec))
+	deliverBlock(app, 1, "secret=hunter2", "incr:count:1", "bad")
+
+	var got []string
+	for _, s := range rec.spans {
+		if !s.ended {
+			t.Errorf("%s was never ended", s.name)
+		}
+		for k, v := range s.attrs {
+			if strings.Contains(v, "secret") || strings.Contains(v, "hunter2") {
+				t.Errorf("%s records %s=%s", s.name, k, v)
+			}
+		}
+		got = append(got, fmt.Sprintf("%s<%s %v", s.name, s.parent, s.status == codes.Error))
+	}
+	want := []string{
+		"kvstore.block< false",
+		"kvstore.DeliverTx<kvstore.block false",
+		"kvstore.DeliverTx<kvstore.block false",
+		"kvstore.DeliverTx<kvstore.block true",
+		"kvstore.Commit<kvstore.block false",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got spans\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for i, want := range []map[string]string{
+		{"height": "1"},
+		{"height": "1", "tx_size": "14", "code": "0"},
+		{"height": "1", "tx_size": "12", "op": "incr", "code": "0"},
+		{"height": "1", "tx_size": "3", "code": fmt.Sprint(INVALID_FORMAT)},
+		{"height": "1", "writes": "2", "flushed": "true"},
+	} {
+		for k, v := range want {
+			if got := rec.spans[i].attrs[k]; got != v {
+				t.Errorf("%s has %s=%q, want %q", rec.spans[i].name, k, got, v)
+			}
+		}
+	}
+
+	// a batch records its size
+	rec.spans = nil
+	deliverBlock(app, 2, string(EncodeBatch([]byte("a=1"), []byte("b=2"))))
+	if ops := rec.spans[1].attrs["batch_ops"]; ops != "2" {
+		t.Errorf("a batch of 2 has batch_ops=%q", ops)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// A transaction is of the format 'key=value', optionally followed by
+// options of the format ';name=value' e.g. 'key=value;valid_until=100'
+//
+// Only option names the application knows about are treated as options,
+// anything else stays part of the value, so 'key=a;b' still sets key to 'a;b'
+// and an option is only split off if what's left is still a whole
+// transaction, so 'key;type=a' still sets 'key;type' to 'a'
+// a transaction with more than one '=' used to always be invalid, so no
+// transaction that was valid before options existed changes meaning
+
+// transaction is a parsed transaction
+type transaction struct {
+	key   []byte
+	value []byte
+
+	// validUntil is the last height the transaction can be applied at
+	// 0 means the transaction doesn't expire
+	validUntil int64
+	// contentType is what kind of data the value holds, see contenttype.go
+	contentType contentType
+	// ttl is the number of blocks until the key expires, see expiry.go
+	// 0 means the key doesn't expire
+	ttl int64
+	// deleteAt is the height the key is removed at, the delete_at option,
+	// an explicit expiry rather than a relative one, see expiry.go
+	deleteAt int64
+	// memo is free form text stored with the transaction's receipt, it
+	// isn't part of the state or the app hash, see txindex.go
+	memo string
+	// idempotencyKey is set by the idempotency_key option, a transaction
+	// with a key that was already used changes nothing, see idempotency.go
+	idempotencyKey string
+	// template is set by the template option, the value has placeholders
+	// filled in when it's written, see template.go
+	template bool
+
+	// op is set for op transactions, which have args instead of a
+	// key and value, see ops.go
+	op   *txOp
+	args [][]byte
+
+	// batch is set for batch transactions, one transaction per line,
+	// see batch.go
+	batch []transaction
+	// guards are the conditions of a guarded batch, see guard.go
+	guards []guard
+}
+
+// keys returns every key the transaction touches
+func (t transaction) keys() [][]byte {
+	if t.op != nil {
+		keys := make([][]byte, len(t.op.keys))
+		for i, arg := range t.op.keys {
+			keys[i] = t.args[arg]
+		}
+		return keys
+	}
+	var keys [][]byte
+	for _, w := range t.writes() {
+		keys = append(keys, w.key)
+	}
+	for _, g := range t.guards {
+		keys = append(keys, g.key)
+	}
+	return keys
+}
+
+// writes returns the 'key=value' writes the transaction makes, the lines
+// of a batch, nothing for an op
+func (t transaction) writes() []transaction {
+	switch {
+	case t.op != nil:
+		return nil
+	case t.batch != nil:
+		return t.batch
+	}
+	return []transaction{t}
+}
+
+// parseTx splits a transaction of the format 'key=value'
+// into its key and value, along with any options
+// or a transaction in the hex scheme, see encoding.go, or the protobuf
+// scheme, see proto.go
+func parseTx(tx []byte) (t transaction, err error) {
+	if ok, err := parseEncoded(tx, &t); ok {
+		return t, err
+	}
+	if ok, err := parseProto(tx, &t); ok {
+		return t, err
+	}
+	if ok, err := parseOp(tx, &t); ok {
+		return t, err
+	}
+	if ok, err := parseBatch(tx, &t); ok {
+		return t, err
+	}
+
+	body, err := parseOptions(tx, &t)
+	if err != nil {
+		return t, err
+	}
+
+	parts := bytes.Split(body, []byte("="))
+	if len(parts) != 2 {
+		return t, reject(INVALID_FORMAT, "transaction must be of the format key=value")
+	}
+	t.key, t.value = parts[0], parts[1]
+	return t, nil
+}
+
+const maxTTL = 1 << 48
+
+// maxMemoSize bounds the memo option, e.g. ';memo=order-1234'
+const maxMemoSize = 256
+
+// txOptions are the options a transaction can carry
+// each one parses its value into the transaction
+var txOptions = map[string]func(t *transaction, value string) error{
+	"valid_until": func(t *transaction, value string) error {
+		height, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || height < 1 {
+			return reject(INVALID_FORMAT, "valid_until must be a positive height")
+		}
+		t.validUntil = height
+		return nil
+	},
+	"ttl": func(t *transaction, value string) error {
+		blocks, err := strconv.ParseInt(value, 10, 64)
+		// the bound keeps height+ttl from overflowing
+		if err != nil || blocks < 1 || blocks > maxTTL {
+			return reject(INVALID_FORMAT, "ttl must be a positive number of blocks")
+		}
+		t.ttl = blocks
+		return nil
+	},
+	"delete_at": func(t *transaction, value string) error {
+		height, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || height < 1 {
+			return reject(INVALID_FORMAT, "delete_at must be a positive height")
+		}
+		t.deleteAt = height
+		return nil
+	},
+	"memo": func(t *transaction, value string) error {
+		if len(value) > maxMemoSize {
+			return reject(INVALID_FORMAT, "memo can't be longer than "+strconv.Itoa(maxMemoSize)+" bytes")
+		}
+		t.memo = value
+		return nil
+	},
+	"idempotency_key": func(t *transaction, value string) error {
+		if value == "" || len(value) > maxIdempotencyKeySize {
+			return reject(INVALID_FORMAT, "idempotency_key must be between 1 and "+strconv.Itoa(maxIdempotencyKeySize)+" bytes")
+		}
+		t.idempotencyKey = value
+		return nil
+	},
+	"template": func(t *transaction, value string) error {
+		template, err := strconv.ParseBool(value)
+		if err != nil {
+			return reject(INVALID_FORMAT, "template must be true or false")
+		}
+		t.template = template
+		return nil
+	},
+	"type": func(t *transaction, value string) error {
+		ct, ok := parseContentType(value)
+		if !ok {
+			return reject(INVALID_FORMAT, "unknown value type "+value)
+		}
+		t.contentType = ct
+		return nil
+	},
+}
+
+// expires returns true if the write gives the key an expiry
+func (t transaction) expires() bool {
+	return t.ttl != 0 || t.deleteAt != 0
+}
+
+// parseOptions strips the known options off the end of the transaction
+// and sets them on t, returns what's left of the transaction
+func parseOptions(tx []byte, t *transaction) ([]byte, error) {
+	seen := make(map[string]bool)
+	for {
+		i := bytes.LastIndexByte(tx, ';')
+		if i < 0 {
+			return tx, nil
+		}
+		parts := bytes.SplitN(tx[i+1:], []byte("="), 2)
+		if len(parts) != 2 {
+			return tx, nil
+		}
+		name := string(parts[0])
+		apply, ok := txOptions[name]
+		if !ok || !bytes.Contains(tx[:i], []byte("=")) {
+			return tx, nil
+		}
+		if seen[name] {
+			return nil, reject(INVALID_FORMAT, "duplicate transaction option "+name)
+		}
+		seen[name] = true
+		if err := apply(t, string(parts[1])); err != nil {
+			return nil, err
+		}
+		tx = tx[:i]
+	}
+}
+
+// parse is parseTx with the application's key normalization and value
+// trimming applied
+// CheckTx and DeliverTx must both use this so they agree on the key
+func (app *KVStoreApplication) parse(tx []byte) (transaction, error) {
+	t, err := parseTx(tx)
+	if err != nil && app.unknownOps == UnknownOpWrite && isUnknownOp(tx) {
+		t, err = transaction{key: tx, value: []byte{}}, nil
+	}
+	if err != nil {
+		return t, err
+	}
+	// a disabled op is left as the op, for validateTx to reject
+	if t.op != nil && t.op.batch != nil && app.opEnabled(t.op) {
+		t.batch, t.op, t.args = t.op.batch(t.args), nil, nil
+	}
+	if t.op != nil {
+		for _, i := range t.op.keys {
+			t.args[i] = app.normalizeKey(t.args[i])
+		}
+		return t, nil
+	}
+	if t.batch != nil {
+		if app.maxBatchOps > 0 && len(t.batch) > app.maxBatchOps {
+			return t, reject(BATCH_TOO_LARGE, fmt.Sprintf("batch has %d lines, over the limit of %d", len(t.batch), app.maxBatchOps))
+		}
+		for i := range t.batch {
+			t.batch[i].key = app.normalizeKey(t.batch[i].key)
+			t.batch[i].value = app.trimValue(t.batch[i].value)
+		}
+		for i := range t.guards {
+			t.guards[i].key = app.normalizeKey(t.guards[i].key)
+			t.guards[i].value = app.trimValue(t.guards[i].value)
+		}
+		// duplicates can only be found once the keys are normalized
+		t.batch, err = app.dedupeBatch(t.batch)
+		return t, err
+	}
+	t.key = app.normalizeKey(t.key)
+	t.value = app.trimValue(t.value)
+	return t, nil
+}
+
+// trimValue removes the whitespace around a value if value trimming is on
+func (app *KVStoreApplication) trimValue(value []byte) []byte {
+	if app.trimValues {
+		return bytes.TrimSpace(value)
+	}
+	return value
+}
+
+// txHeight is the height a transaction being validated would be applied at
+// in DeliverTx that's the current block, in CheckTx it's the next block
+func (app *KVStoreApplication) txHeight() int64 {
+	if app.inBlock() {
+		return app.pending.Height
+	}
+	return app.committed.Height + 1
+}
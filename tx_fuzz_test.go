@@ -0,0 +1,84 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// the seeds cover every transaction format and op, the corpus in
+// testdata/fuzz/FuzzParseTx has the empty keys that once halted the node and
+// other inputs near the edges of the parsers
+var fuzzSeeds = [][]byte{
+	[]byte("a=1"),
+	[]byte("=1"),
+	[]byte(" =1"),
+	[]byte("a=1;ttl=5;type=int"),
+	[]byte("a=1;delete_at=10;memo=hi;idempotency_key=x"),
+	[]byte("a=x{height}{{;template=true"),
+	[]byte("k=line1\nline2;ttl=5"),
+	[]byte("l=[\"a\"];type=list"),
+	EncodeTx([]byte("k=\n"), []byte("v;ttl=1")),
+	EncodeBatch([]byte("a=1"), []byte("b=2;type=int"), EncodeTx([]byte("c"), []byte("3"))),
+	EncodeBatch([]byte("lock=!="), []byte("a==1"), []byte("a=2")),
+	EncodeBatch(),
+	ProtoTx{Write: &ProtoWrite{Key: []byte("a"), Value: []byte("1"), Options: []ProtoOption{{Name: "ttl", Value: "3"}}}}.Encode(),
+	ProtoTx{Op: &ProtoOp{Name: "swap", Args: [][]byte{[]byte("a"), []byte("b")}}}.Encode(),
+	ProtoTx{Batch: &ProtoBatch{Writes: []ProtoWrite{{Key: []byte("a")}, {Key: []byte("b"), Value: []byte("2")}}}}.Encode(),
+	[]byte("swap:a:b"),
+	[]byte("getset:a:x:y"),
+	[]byte("cp:a:c:overwrite"),
+	[]byte("setif:a:2:a:1"),
+	[]byte("incr:n:5"),
+	[]byte("mset:v:a,b,,c"),
+	[]byte("delprefix:a"),
+	[]byte("reap:a"),
+	[]byte("expr:n:n+1"),
+	[]byte("push:l:x"),
+	[]byte("sadd:s:x"),
+	[]byte("srem:s:x"),
+	[]byte("zadd:alice:10"),
+	[]byte("bucket:b:x"),
+	[]byte("setver:a:1:v"),
+	[]byte("init:a:v"),
+	[]byte("newop:a:b"),
+	[]byte("\x00"),
+	[]byte("\x00x"),
+	[]byte("\x00p\xff"),
+	[]byte("\x00b\n\n"),
+}
+
+// FuzzParseTx checks no transaction makes the parser, CheckTx or DeliverTx
+// panic, and that every rejection has a transaction code
+func FuzzParseTx(f *testing.F) {
+	for _, tx := range fuzzSeeds {
+		f.Add(tx)
+	}
+	app := NewKVStoreApplicationWithStore(NewMemStore(),
+		WithKeyNormalization(NormalizeTrimSpace), WithIdempotencyKeys(0), WithKeyVersions(true),
+		WithModIndex(true), WithRanking("board/"), WithTimeBuckets(0), WithUnknownOps(UnknownOpWrite))
+	deliverBlock(app, 1, "a=1", "n=1;type=int", `l=["x"];type=list`, `s=["x"];type=set`)
+
+	f.Fuzz(func(t *testing.T, tx []byte) {
+		if _, err := parseTx(tx); err != nil {
+			if _, ok := asRejection(err); !ok {
+				t.Fatalf("%q: parseTx returned an error that isn't a rejection: %v", tx, err)
+			}
+		}
+		res := app.CheckTx(abcitypes.RequestCheckTx{Tx: tx})
+		if res.Code > TEMPLATE_INVALID {
+			t.Fatalf("%q: CheckTx returned unknown code %d", tx, res.Code)
+		}
+		// ValidateBatch runs DeliverTx and throws the block away
+		codes, err := app.ValidateBatch([][]byte{tx})
+		if err != nil {
+			t.Fatalf("%q: %v", tx, err)
+		}
+		if codes[0] > TEMPLATE_INVALID {
+			t.Fatalf("%q: DeliverTx returned unknown code %d", tx, codes[0])
+		}
+	})
+}
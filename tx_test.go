@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestValidUntil(t *testing.T) {
+	app := newTestApp(t)
+	deliverBlock(app, 4)
+	res := deliverBlock(app, 5, "a=1;valid_until=4", "b=1;valid_until=5", "c=1;valid_until=x")
+	if res[0].Code != TX_EXPIRED {
+		t.Errorf("a tx past its deadline got code %d", res[0].Code)
+	}
+	if res[1].Code != VALID_TX {
+		t.Errorf("a tx at its deadline got code %d: %s", res[1].Code, res[1].Log)
+	}
+	if res[2].Code != INVALID_FORMAT {
+		t.Errorf("a bad deadline got code %d", res[2].Code)
+	}
+	if _, exists, _ := app.get([]byte("a")); exists {
+		t.Error("the expired tx was applied")
+	}
+
+	// CheckTx judges against the next block
+	if res := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("d=1;valid_until=5")}); res.Code != TX_EXPIRED {
+		t.Errorf("CheckTx got code %d for a tx that can't make the next block", res.Code)
+	}
+	if res := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("d=1;valid_until=6")}); res.Code != VALID_TX {
+		t.Errorf("CheckTx got code %d: %s", res.Code, res.Log)
+	}
+}
+
+func TestTrimValues(t *testing.T) {
+	// by default the bytes around = are kept
+	app := newTestApp(t)
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("n = 5 ;type=int")}); r.Code != INVALID_VALUE {
+		t.Errorf("CheckTx of a padded int got code %d", r.Code)
+	}
+	res := deliverBlock(app, 1, "key = value ", "n = 5 ;type=int")
+	if res[0].Code != VALID_TX || res[1].Code != INVALID_VALUE {
+		t.Fatalf("got codes %d and %d", res[0].Code, res[1].Code)
+	}
+	if value, _, _ := app.get([]byte("key ")); string(value) != " value " {
+		t.Errorf("the untrimmed value is %q", value)
+	}
+
+	// trimmed the same way in CheckTx and DeliverTx, and stored the same as
+	// if it had been sent without the spaces
+	app = newTestApp(t, WithTrimValues(true), WithKeyNormalization(NormalizeTrimSpace))
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("n = 5 ;type=int")}); r.Code != VALID_TX {
+		t.Errorf("CheckTx of a padded int got code %d: %s", r.Code, r.Log)
+	}
+	res = deliverBlock(app, 1, "key = value ", "n = 5 ;type=int")
+	if res[0].Code != VALID_TX || res[1].Code != VALID_TX {
+		t.Fatalf("got codes %d and %d", res[0].Code, res[1].Code)
+	}
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(" key=value")}); r.Code != DUPLICATE_TX {
+		t.Errorf("CheckTx of the same write padded differently got code %d", r.Code)
+	}
+	plain := newTestApp(t)
+	deliverBlock(plain, 1, "key=value", "n=5;type=int")
+	if !bytes.Equal(app.committed.AppHash, plain.committed.AppHash) {
+		t.Error("the trimmed block has a different app hash from the unpadded one")
+	}
+}
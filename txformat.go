@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sort"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// The "txformat" query tells clients which transactions this node accepts,
+// so they can check a feature is there before relying on it instead of
+// finding out from a rejection, the response is a stable contract, fields
+// are only ever added
+//
+//	{"version": 2, "encodings": ["text", "hex", "batch", "guarded_batch", "protobuf"], "ops": [...],
+//	 "options": [...], "types": [...]}
+//
+// only what's enabled on this node is listed, e.g. an op disabled with
+// WithDisabledOps, or zadd without a ranking, is left out
+
+// txFormatVersion is the version of the transaction format, it changes only
+// if a transaction could be read differently than before, new encodings,
+// ops, options and types show up in the lists without a new version
+// version 2 marks batches with a scheme byte, see batch.go
+const txFormatVersion = 2
+
+type txFormatResponse struct {
+	Version   int      `json:"version"`
+	Encodings []string `json:"encodings"`
+	Ops       []string `json:"ops"`
+	Options   []string `json:"options"`
+	Types     []string `json:"types"`
+	// MaxTxSize is the limit on a transaction's size, 0 is no limit
+	MaxTxSize int `json:"max_tx_size"`
+	// MaxBatchOps is the limit on the lines of a batch, 0 is no limit
+	MaxBatchOps int `json:"max_batch_ops"`
+}
+
+// txOptionEnabled returns whether the transaction option can be used on
+// this node, see validateTx
+func (app *KVStoreApplication) txOptionEnabled(name string) bool {
+	return name != "idempotency_key" || app.idempotencyKeys
+}
+
+// queryTxFormat returns the transaction format this node accepts
+func (app *KVStoreApplication) queryTxFormat(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	fres := txFormatResponse{
+		Version:     txFormatVersion,
+		Encodings:   []string{"text", "hex", "batch", "guarded_batch", "protobuf"},
+		Ops:         []string{},
+		Options:     []string{},
+		MaxTxSize:   app.maxTxSize,
+		MaxBatchOps: app.maxBatchOps,
+	}
+	for name, op := range txOps {
+		if app.opEnabled(op) {
+			fres.Ops = append(fres.Ops, name)
+		}
+	}
+	sort.Strings(fres.Ops)
+	for name := range txOptions {
+		if app.txOptionEnabled(name) {
+			fres.Options = append(fres.Options, name)
+		}
+	}
+	sort.Strings(fres.Options)
+	for ct := typeBytes; ct <= typeSet; ct++ {
+		fres.Types = append(fres.Types, ct.String())
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, fres)
+	return
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestTxFormat(t *testing.T) {
+	contains := func(list []string, s string) bool {
+		for _, v := range list {
+			if v == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	app := newTestApp(t, WithDisabledOps("swap"))
+	var fres txFormatResponse
+	queryJSON(t, app, "txformat", nil, &fres)
+	if fres.Version != txFormatVersion {
+		t.Errorf("version is %d, want %d", fres.Version, txFormatVersion)
+	}
+	for name, op := range txOps {
+		if contains(fres.Ops, name) != app.opEnabled(op) {
+			t.Errorf("op %s advertised is %v, enabled is %v", name, contains(fres.Ops, name), app.opEnabled(op))
+		}
+	}
+	// what's left out is what the node rejects
+	for _, c := range []struct {
+		tx     string
+		code   uint32
+		listed bool
+		list   []string
+		name   string
+	}{
+		{"swap:a:b", OP_DISABLED, false, fres.Ops, "swap"},
+		{"zadd:alice:1", OP_DISABLED, false, fres.Ops, "zadd"},
+		{"incr:n:1", VALID_TX, true, fres.Ops, "incr"},
+		{"a=1;idempotency_key=x", INVALID_FORMAT, false, fres.Options, "idempotency_key"},
+		{"a=1;ttl=5", VALID_TX, true, fres.Options, "ttl"},
+	} {
+		if got := contains(c.list, c.name); got != c.listed {
+			t.Errorf("%s listed is %v, want %v", c.name, got, c.listed)
+		}
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(c.tx)}); r.Code != c.code {
+			t.Errorf("CheckTx %s got code %d, want %d: %s", c.tx, r.Code, c.code, r.Log)
+		}
+	}
+
+	app = newTestApp(t, WithRanking("r/"), WithIdempotencyKeys(0))
+	deliverBlock(app, 1, "a=1", "b=2")
+	queryJSON(t, app, "txformat", nil, &fres)
+	if !contains(fres.Ops, "swap") || !contains(fres.Ops, "zadd") {
+		t.Errorf("ops %v are missing swap or zadd", fres.Ops)
+	}
+	if !contains(fres.Options, "idempotency_key") {
+		t.Errorf("options %v are missing idempotency_key", fres.Options)
+	}
+	for _, tx := range []string{"swap:a:b", "zadd:alice:1", "c=1;idempotency_key=x"} {
+		if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(tx)}); r.Code != VALID_TX {
+			t.Errorf("CheckTx %s got code %d: %s", tx, r.Code, r.Log)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// The transaction index records a receipt for every delivered transaction,
+// keyed by its hash (sha256 of the transaction bytes, the same hash
+// tendermint uses), so clients can find out what a transaction did
+//
+//	txIndexPrefix  | hash                 -> receipt as JSON
+//	txHeightPrefix | height (8 bytes) hash -> block time, for pruning
+//
+// the index is internal data, it's written in the same batch as the block
+// but isn't part of the app hash, in either mode, so its retention can be
+// changed without affecting consensus, pruning is still only based on the
+// blocks, never on the node's own clock, so every node keeps the same receipts
+// a transaction delivered more than once keeps the receipt of its latest
+// accepted delivery, later rejections (e.g. as a duplicate) don't replace it
+// a block discarded by atomic blocks leaves no receipts
+
+var (
+	txIndexPrefix  = internalKey("tx/")
+	txHeightPrefix = internalKey("txh/")
+)
+
+// Receipt is what a delivered transaction did
+type Receipt struct {
+	Height int64  `json:"height"`
+	Code   uint32 `json:"code"`
+	Log    string `json:"log,omitempty"`
+	// Memo is the transaction's memo option
+	Memo string `json:"memo,omitempty"`
+	// Changes are the writes the transaction made, in order
+	Changes []receiptChange `json:"changes"`
+}
+
+// receiptChange is a write in a receipt, Previous is the value the key had
+// before it, only recorded for ops that return it, e.g. getset, and left
+// out if the key didn't exist, Version is the version the write made, only
+// with key versions, see versions.go
+type receiptChange struct {
+	kvPair
+	Previous *string `json:"previous,omitempty"`
+	Version  uint64  `json:"version,omitempty"`
+}
+
+// TxIndexRetention bounds the transaction index, see WithTxIndexRetention
+// a receipt is pruned as soon as any of the limits is passed, 0 disables
+// a limit
+type TxIndexRetention struct {
+	// Blocks keeps the receipts of the last Blocks blocks
+	Blocks int64
+	// Receipts keeps the last Receipts receipts, a transaction delivered
+	// more than once counts once for each block it was delivered in
+	Receipts int64
+	// Age keeps the receipts of blocks with a header time less than Age
+	// before the current block's
+	Age time.Duration
+}
+
+func txIndexKey(hash []byte) []byte {
+	return append(append([]byte{}, txIndexPrefix...), hash...)
+}
+
+func txHeightKey(height int64, hash []byte) []byte {
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], uint64(height))
+	key := append(append([]byte{}, txHeightPrefix...), h[:]...)
+	return append(key, hash...)
+}
+
+// indexTx records the receipt of a transaction delivered in the current block
+// parsed is the transaction as far as it was parsed
+func (app *KVStoreApplication) indexTx(tx []byte, parsed transaction, code uint32, log string, changes []change) error {
+	if !app.txIndex {
+		return nil
+	}
+	hash := sha256.Sum256(tx)
+	key := txIndexKey(hash[:])
+
+	if code != VALID_TX {
+		item, err := app.currentBatch.Get(key)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		if err == nil {
+			var prev Receipt
+			err = item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &prev)
+			})
+			if err != nil {
+				return err
+			}
+			if prev.Code == VALID_TX {
+				return nil
+			}
+		}
+	}
+
+	receipt := Receipt{Height: app.pending.Height, Code: code, Log: log, Memo: parsed.memo, Changes: []receiptChange{}}
+	withPrevious := parsed.op != nil && parsed.op.returnsPrevious
+	for _, c := range changes {
+		rc := receiptChange{kvPair: kvPair{Key: string(c.key), Value: string(c.value), Type: c.contentType.String()}, Version: c.version}
+		if withPrevious && c.existed {
+			previous := string(c.previous)
+			rc.Previous = &previous
+		}
+		receipt.Changes = append(receipt.Changes, rc)
+	}
+	val, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	if err := app.currentBatch.Set(key, val); err != nil {
+		return err
+	}
+
+	heightKey := txHeightKey(receipt.Height, hash[:])
+	// a transaction rejected and then accepted in the same block
+	// already has its entry
+	_, err = app.currentBatch.Get(heightKey)
+	if err != nil && err != ErrKeyNotFound {
+		return err
+	}
+	if err == ErrKeyNotFound {
+		app.pending.TxReceipts++
+	}
+	var t [8]byte
+	binary.BigEndian.PutUint64(t[:], uint64(app.blockTime.UnixNano()))
+	return app.currentBatch.Set(heightKey, t[:])
+}
+
+// countTxReceipts counts the entries in the transaction index's height
+// index, they are only counted when a receipt limit is set
+func countTxReceipts(store Store) (n int64, err error) {
+	err = store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = txHeightPrefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+		}
+		return nil
+	})
+	return
+}
+
+// pruneTxIndex drops the receipts that have fallen out of the retention
+// limits, it runs at the end of every block, the oldest receipts go first
+func (app *KVStoreApplication) pruneTxIndex() error {
+	r := app.txIndexRetention
+	if !app.txIndex || (r.Blocks <= 0 && r.Receipts <= 0 && r.Age <= 0) {
+		return nil
+	}
+	// each limit only ever prunes the oldest entries, so the scan can
+	// stop at the first entry all of them keep
+	cutoff := int64(0)
+	if r.Blocks > 0 {
+		cutoff = app.pending.Height - r.Blocks
+	}
+	excess := int64(0)
+	if r.Receipts > 0 {
+		excess = app.pending.TxReceipts - r.Receipts
+	}
+	var oldest int64
+	if r.Age > 0 {
+		oldest = app.blockTime.Add(-r.Age).UnixNano()
+	}
+
+	var stale [][]byte
+	opts := DefaultIteratorOptions
+	opts.PrefetchValues = r.Age > 0
+	opts.Prefix = txHeightPrefix
+	it := app.currentBatch.NewIterator(opts)
+	for it.Seek(txHeightPrefix); it.Valid(); it.Next() {
+		item := it.Item()
+		key := item.KeyCopy(nil)
+		prune := int64(len(stale)) < excess ||
+			int64(binary.BigEndian.Uint64(key[len(txHeightPrefix):])) <= cutoff
+		if !prune && r.Age > 0 {
+			err := item.Value(func(val []byte) error {
+				// entries from before block times were recorded
+				// have none and count as the oldest
+				prune = len(val) != 8 || int64(binary.BigEndian.Uint64(val)) < oldest
+				return nil
+			})
+			if err != nil {
+				it.Close()
+				return err
+			}
+		}
+		if !prune {
+			break
+		}
+		stale = append(stale, key)
+	}
+	it.Close()
+
+	for _, key := range stale {
+		height := int64(binary.BigEndian.Uint64(key[len(txHeightPrefix):]))
+		indexKey := txIndexKey(key[len(txHeightPrefix)+8:])
+		// the receipt might have been replaced by a later delivery
+		item, err := app.currentBatch.Get(indexKey)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		if err == nil {
+			var r Receipt
+			if err := item.Value(func(val []byte) error { return json.Unmarshal(val, &r) }); err != nil {
+				return err
+			}
+			if r.Height == height {
+				if err := app.currentBatch.Delete(indexKey); err != nil {
+					return err
+				}
+			}
+		}
+		if err := app.currentBatch.Delete(key); err != nil {
+			return err
+		}
+		app.pending.TxReceipts--
+	}
+	return nil
+}
+
+// queryTx returns the receipt of the transaction with the hex encoded hash
+// in the query data
+func (app *KVStoreApplication) queryTx(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if !app.txIndex {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "the transaction index is disabled"
+		return
+	}
+	hash, err := hex.DecodeString(strings.TrimPrefix(string(req.Data), "0x"))
+	if err != nil || len(hash) != sha256.Size {
+		res.Code = QUERY_INVALID
+		res.Log = "query data must be a hex encoded sha256 transaction hash"
+		return
+	}
+
+	res.Key = hash
+	res.Height = app.committed.Height
+	err = app.store.View(func(txn Txn) error {
+		item, err := txn.Get(txIndexKey(hash))
+		if err == ErrKeyNotFound {
+			res.Log = "does not exist"
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		res.Log = "exists"
+		res.Value, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		halt("Query", err)
+	}
+	return
+}
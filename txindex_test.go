@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// txHash is the hex hash a "tx" query looks a transaction up by
+func txHash(tx string) []byte {
+	hash := sha256.Sum256([]byte(tx))
+	return []byte(hex.EncodeToString(hash[:]))
+}
+
+// receipt queries the receipt of tx, ok is false if there is none
+func receipt(t *testing.T, app *KVStoreApplication, tx string) (r Receipt, ok bool) {
+	t.Helper()
+	res := app.Query(abcitypes.RequestQuery{Path: "tx", Data: txHash(tx)})
+	if res.Code != 0 {
+		t.Fatalf("tx query for %q got code %d: %s", tx, res.Code, res.Log)
+	}
+	if res.Log == "does not exist" {
+		return r, false
+	}
+	if err := json.Unmarshal(res.Value, &r); err != nil {
+		t.Fatal(err)
+	}
+	return r, true
+}
+
+func TestTxIndex(t *testing.T) {
+	app := newTestApp(t, WithTxIndex(2))
+	deliverBlock(app, 1, "k1=v", "bad")
+	deliverBlock(app, 2, "k2=v;type=int", "k1=v")
+
+	r, ok := receipt(t, app, "k1=v")
+	want := kvPair{Key: "k1", Value: "v", Type: "bytes"}
+	if !ok || r.Height != 1 || r.Code != VALID_TX || len(r.Changes) != 1 || r.Changes[0].kvPair != want {
+		t.Errorf("got %+v, a later duplicate shouldn't replace the receipt", r)
+	}
+	if r, ok := receipt(t, app, "bad"); !ok || r.Code != INVALID_FORMAT || r.Log == "" || len(r.Changes) != 0 {
+		t.Errorf("the rejected tx got %+v", r)
+	}
+	if r, ok := receipt(t, app, "k2=v;type=int"); !ok || r.Height != 2 || r.Code != INVALID_VALUE {
+		t.Errorf("the rejected int got %+v", r)
+	}
+	if _, ok := receipt(t, app, "k3=v"); ok {
+		t.Error("a tx that was never delivered has a receipt")
+	}
+
+	// only the last two blocks are kept
+	deliverBlock(app, 3, "k3=v")
+	if _, ok := receipt(t, app, "k1=v"); ok {
+		t.Error("the receipt of height 1 wasn't pruned")
+	}
+	if r, ok := receipt(t, app, "k3=v"); !ok || r.Height != 3 {
+		t.Errorf("got %+v", r)
+	}
+}
+
+func TestTxQueryInvalid(t *testing.T) {
+	app := newTestApp(t)
+	if res := app.Query(abcitypes.RequestQuery{Path: "tx", Data: txHash("a=1")}); res.Code != QUERY_NOT_ALLOWED {
+		t.Errorf("a disabled index got code %d", res.Code)
+	}
+	app = newTestApp(t, WithTxIndex(0))
+	for _, data := range []string{"", "xyz", "abcd"} {
+		if res := app.Query(abcitypes.RequestQuery{Path: "tx", Data: []byte(data)}); res.Code != QUERY_INVALID {
+			t.Errorf("%q got code %d", data, res.Code)
+		}
+	}
+}
+
+func TestTxIndexRetention(t *testing.T) {
+	store := NewMemStore()
+	app := NewKVStoreApplicationWithStore(store, WithTxIndexRetention(TxIndexRetention{Receipts: 3, Age: 10 * time.Second}))
+	plain := newTestApp(t)
+	start := time.Unix(1000, 0)
+	// the block runs in both apps, the index mustn't change the app hash
+	block := func(height int64, at time.Duration, txs ...string) {
+		for _, a := range []*KVStoreApplication{app, plain} {
+			a.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: height, Time: start.Add(at)}})
+			for _, tx := range txs {
+				a.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte(tx)})
+			}
+			a.EndBlock(abcitypes.RequestEndBlock{Height: height})
+			a.Commit()
+		}
+		if !bytes.Equal(app.committed.AppHash, plain.committed.AppHash) {
+			t.Fatalf("the tx index changed the app hash at height %d", height)
+		}
+	}
+	kept := func(want map[string]bool) {
+		t.Helper()
+		for tx, want := range want {
+			if _, ok := receipt(t, app, tx); ok != want {
+				t.Errorf("receipt of %q kept %v, want %v", tx, ok, want)
+			}
+		}
+	}
+
+	// over the count, the oldest receipt goes
+	block(1, 0, "a=1", "b=1")
+	block(2, time.Second, "c=1", "d=1")
+	kept(map[string]bool{"a=1": false, "b=1": true, "c=1": true, "d=1": true})
+	if app.committed.TxReceipts != 3 {
+		t.Fatalf("%d receipts are counted, want 3", app.committed.TxReceipts)
+	}
+
+	// too old, everything before the block goes
+	block(3, 20*time.Second, "e=1")
+	kept(map[string]bool{"b=1": false, "c=1": false, "d=1": false, "e=1": true})
+	if app.committed.TxReceipts != 1 {
+		t.Fatalf("%d receipts are counted, want 1", app.committed.TxReceipts)
+	}
+
+	// the count survives a restart
+	app = NewKVStoreApplicationWithStore(store, WithTxIndexRetention(TxIndexRetention{Receipts: 3}))
+	if app.committed.TxReceipts != 1 {
+		t.Fatalf("%d receipts are counted after a restart, want 1", app.committed.TxReceipts)
+	}
+}
+
+func TestMemo(t *testing.T) {
+	app := newTestApp(t, WithTxIndex(0))
+	plain := newTestApp(t)
+	txs := []string{"a=1;memo=order-1", "a=1;memo=again", "b=1;memo=x;ttl=5", "c=1;memo=" + strings.Repeat("m", maxMemoSize+1)}
+	res := deliverBlock(app, 1, txs...)
+	for i, want := range []uint32{VALID_TX, DUPLICATE_TX, VALID_TX, INVALID_FORMAT} {
+		if res[i].Code != want {
+			t.Errorf("%.20s got code %d, want %d: %s", txs[i], res[i].Code, want, res[i].Log)
+		}
+	}
+
+	for tx, memo := range map[string]string{txs[0]: "order-1", txs[1]: "again", txs[2]: "x"} {
+		if r, ok := receipt(t, app, tx); !ok || r.Memo != memo {
+			t.Errorf("the receipt of %s got %+v, want memo %q", tx, r, memo)
+		}
+	}
+	if value, _, _ := app.get([]byte("a")); string(value) != "1" {
+		t.Errorf("a is %q, the memo ended up in the value", value)
+	}
+
+	// the memo is only in the receipt, the state is the same as without it
+	deliverBlock(plain, 1, "a=1", "a=1", "b=1;ttl=5")
+	if !bytes.Equal(app.committed.AppHash, plain.committed.AppHash) {
+		t.Error("the memos changed the app hash")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+// ValidateBatch runs txs, in order, against the committed state as if they
+// were delivered in the next block, and returns the code each one would get
+// nothing is written, the block is simulated in a badger transaction that
+// is thrown away, so a transaction sees the writes of the ones before it
+// e.g. 'a=1' followed by 'swap:a:b' fails on b but not on a
+// each code is what DeliverTx would return, with atomic blocks enabled a
+// single rejection would also mean none of the writes are applied
+//
+// blocks left unflushed by commit batching are flushed first, so it sees
+// every committed block, as the next block's DeliverTx does
+// it can't be called while a block is being processed
+func (app *KVStoreApplication) ValidateBatch(txs [][]byte) (codes []uint32, err error) {
+	if app.replica {
+		return nil, ErrReplica
+	}
+	if app.inBlock() {
+		return nil, ErrBlockInProgress
+	}
+	if err := app.flush(); err != nil {
+		return nil, err
+	}
+
+	batch, pending, changes, blockBytes := app.currentBatch, app.pending, app.changes, app.blockBytes
+	app.currentBatch = app.store.NewBatch()
+	app.pending = app.committed.clone()
+	app.pending.Height = app.committed.Height + 1
+	app.changes = nil
+	app.blockBytes = 0
+	// txHeight has to see the simulated block as the current one
+	app.blockOpen = true
+	defer func() {
+		app.currentBatch.Discard()
+		app.currentBatch, app.pending, app.changes, app.blockBytes = batch, pending, changes, blockBytes
+		app.blockOpen = false
+	}()
+
+	codes = make([]uint32, len(txs))
+	for i, tx := range txs {
+		_, err := app.deliverTx(tx)
+		if _, ok := err.(*alreadyApplied); ok {
+			codes[i] = VALID_TX
+			continue
+		}
+		if r, ok := asRejection(err); ok {
+			codes[i] = r.code
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = VALID_TX
+	}
+	return codes, nil
+}
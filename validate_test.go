@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidateBatchSeesUnflushedBlocks(t *testing.T) {
+	app := newTestApp(t, WithCommitBatching(10, 0))
+	deliverBlock(app, 1, "a=1")
+	codes, err := app.ValidateBatch([][]byte{[]byte("a=1"), []byte("swap:a:b"), []byte("b=2"), []byte("swap:a:b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint32{DUPLICATE_TX, MISSING_KEY, VALID_TX, VALID_TX}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Errorf("tx %d got code %d, want %d", i, codes[i], want[i])
+		}
+	}
+	res := deliverBlock(app, 2, "a=1")
+	if res[0].Code != codes[0] {
+		t.Errorf("DeliverTx got code %d, ValidateBatch said %d", res[0].Code, codes[0])
+	}
+}
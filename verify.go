@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// With write verification (WithWriteVerification) every flush is followed
+// by reading back the keys it wrote, and comparing them with what the
+// blocks meant to write, so a write badger lost or mangled without
+// reporting an error is caught at the block that made it, not whenever the
+// key happens to be read next
+// it costs a read per verified key, so it can be limited to every nth one
+
+// writeMismatchError is a key that doesn't read back as it was written
+type writeMismatchError struct {
+	key    []byte
+	reason string
+}
+
+func (e *writeMismatchError) Error() string {
+	return fmt.Sprintf("key %q doesn't match what was written: %s", e.key, e.reason)
+}
+
+// verifyWrites reads back the keys of a flush's changes from the db
+// only the last change to a key counts, it's what the key should hold
+// a mismatch is logged, and returned if the node should halt on it
+func (app *KVStoreApplication) verifyWrites(changes []change) error {
+	if app.verifyEvery <= 0 {
+		return nil
+	}
+	last := make(map[string]int, len(changes))
+	for i, c := range changes {
+		last[string(c.key)] = i
+	}
+
+	var mismatch error
+	err := app.store.View(func(txn Txn) error {
+		for i, c := range changes {
+			if last[string(c.key)] != i {
+				continue
+			}
+			app.verifyCount++
+			if app.verifyCount%int64(app.verifyEvery) != 0 {
+				continue
+			}
+			value, ct, exists, err := lookup(txn, c.key)
+			if _, ok := err.(*corruptValueError); ok {
+				mismatch = &writeMismatchError{key: c.key, reason: err.Error()}
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			switch {
+			case c.deleted && exists:
+				mismatch = &writeMismatchError{key: c.key, reason: "it was removed but still exists"}
+			case !c.deleted && !exists:
+				mismatch = &writeMismatchError{key: c.key, reason: "it was written but doesn't exist"}
+			case !c.deleted && !bytes.Equal(value, c.value):
+				mismatch = &writeMismatchError{key: c.key, reason: "the value is different"}
+			case !c.deleted && ct != c.contentType:
+				mismatch = &writeMismatchError{key: c.key, reason: fmt.Sprintf("the type is %s instead of %s", ct, c.contentType)}
+			}
+			if mismatch != nil {
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if mismatch == nil {
+		return nil
+	}
+	app.logger.Error("WRITE VERIFICATION FAILED", "height", app.committed.Height, "err", mismatch)
+	if app.verifyHalt {
+		return mismatch
+	}
+	return nil
+}
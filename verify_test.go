@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+// lossyStore is a store whose blocks silently mangle the writes to one key,
+// and drop the deletes of another, without returning an error
+type lossyStore struct {
+	Store
+	mangle, keep string
+}
+
+func (s *lossyStore) NewBatch() Txn { return lossyTxn{s.Store.NewBatch(), s} }
+
+type lossyTxn struct {
+	Txn
+	s *lossyStore
+}
+
+func (t lossyTxn) SetEntry(e *Entry) error {
+	if string(e.Key) == t.s.mangle {
+		mangled := *e
+		mangled.Value = append([]byte("x"), e.Value...)
+		return t.Txn.SetEntry(&mangled)
+	}
+	return t.Txn.SetEntry(e)
+}
+
+func (t lossyTxn) Delete(key []byte) error {
+	if string(key) == t.s.keep {
+		return nil
+	}
+	return t.Txn.Delete(key)
+}
+
+func TestWriteVerification(t *testing.T) {
+	// every write reads back fine, whatever the options
+	for _, opts := range [][]Option{
+		{WithWriteVerification(1, true)},
+		{WithWriteVerification(1, true), WithAtomicBlocks(true), WithValueCRC(true)},
+		{WithWriteVerification(2, true), WithCommitBatching(3, 0)},
+	} {
+		app := newTestApp(t, opts...)
+		for h := int64(1); h < 6; h++ {
+			deliverBlock(app, h, "a=1;type=int", "a=2;type=int", "b=x;ttl=1", "a=bad;type=int", "delprefix:b")
+		}
+		app.Flush()
+	}
+
+	for _, c := range []struct {
+		name        string
+		store       *lossyStore
+		txs         []string
+		key, reason string
+	}{
+		{"mangled", &lossyStore{Store: NewMemStore(), mangle: "a"}, []string{"a=1"}, "a", "the value is different"},
+		{"lost delete", &lossyStore{Store: NewMemStore(), keep: "b"}, []string{"b=1", "delprefix:b"}, "b", "it was removed but still exists"},
+	} {
+		app := NewKVStoreApplicationWithStore(c.store, WithWriteVerification(1, true))
+		msg := halts(func() { deliverBlock(app, 1, c.txs...) })
+		if !strings.Contains(msg, c.reason) || !strings.Contains(msg, `"`+c.key+`"`) {
+			t.Errorf("%s: the block got %q", c.name, msg)
+		}
+	}
+
+	// without halting the mismatch is only logged
+	var logs bytes.Buffer
+	app := NewKVStoreApplicationWithStore(&lossyStore{Store: NewMemStore(), mangle: "a"},
+		WithWriteVerification(1, false), WithLogger(log.NewTMLogger(&logs)))
+	if msg := halts(func() { deliverBlock(app, 1, "a=1") }); msg != "" {
+		t.Fatalf("the mismatch halted the node: %s", msg)
+	}
+	if !strings.Contains(logs.String(), "WRITE VERIFICATION FAILED") {
+		t.Errorf("the mismatch wasn't logged:\n%s", logs.String())
+	}
+}
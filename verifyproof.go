@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	tmcrypto "github.com/tendermint/tendermint/proto/tendermint/crypto"
+)
+
+// The proof ops of a "keyproof" or "rangeproof" query response can be
+// checked with VerifyProof without the rest of the app, by a service that
+// only has the merkle app hash the node committed to, the format is stable
+//
+//	ProofOps{Ops: [{Type: "kvstore:key", Key: key, Data: json(KeyProof)}]}
+//	ProofOps{Ops: [{Type: "kvstore:range", Key: prefix, Data: json(RangeProof)}]}
+//
+// the data is the JSON of a KeyProof or a RangeProof, every leaf of the tree
+// is an entry encoded as uvarint(len(key)) key uvarint(len(value)) value
+// type, hashed as tendermint's crypto/merkle does, RFC 6962 style, and the
+// leaves are in key order, see merkle.go
+// with the receipts root the tree's root is the state hash, and a second op
+// ties it to the app hash, see receipts.go
+//
+//	{Type: "kvstore:state", Data: json(StateProof)}
+//
+// the key is the key as stored, after any key normalization on the node
+// a node with query signing adds the signature as a last op, see
+// signing.go, VerifyProof ignores it, it's checked with VerifyQueryResponse
+
+// VerifyProof returns true if the proof proves that key is set to value in
+// the tree with that root, or, with a nil value, that key doesn't exist
+// a key proof proves one key, a range proof any key under its prefix
+// the value is compared whatever its type, and an empty value has to be
+// passed as an empty slice rather than nil, mind that a decoded query
+// response can have a nil Value for one
+func VerifyProof(root, key, value []byte, proof tmcrypto.ProofOps) bool {
+	if n := len(proof.Ops); n > 0 && proof.Ops[n-1].Type == PROOF_OP_SIGNATURE {
+		proof.Ops = proof.Ops[:n-1]
+	}
+	switch len(proof.Ops) {
+	case 1:
+	case 2:
+		var s StateProof
+		if proof.Ops[1].Type != PROOF_OP_STATE || json.Unmarshal(proof.Ops[1].Data, &s) != nil || s.Verify(root) != nil {
+			return false
+		}
+		root = s.StateHash
+	default:
+		return false
+	}
+	op := proof.Ops[0]
+	switch op.Type {
+	case PROOF_OP_KEY:
+		var p KeyProof
+		if json.Unmarshal(op.Data, &p) != nil || !bytes.Equal(p.Key, key) || VerifyKeyProof(root, p) != nil {
+			return false
+		}
+		if value == nil {
+			return p.Entry == nil
+		}
+		return p.Entry != nil && bytes.Equal(p.Entry.Value, value)
+	case PROOF_OP_RANGE:
+		var p RangeProof
+		if json.Unmarshal(op.Data, &p) != nil || !bytes.HasPrefix(key, p.Prefix) || VerifyRangeProof(root, p) != nil {
+			return false
+		}
+		for _, e := range p.Entries {
+			if bytes.Equal(e.Key, key) {
+				return value != nil && bytes.Equal(e.Value, value)
+			}
+		}
+		return value == nil
+	}
+	return false
+}
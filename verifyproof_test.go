@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+)
+
+func TestVerifyProofFromSigningNode(t *testing.T) {
+	for _, receipts := range []bool{false, true} {
+		app := newTestApp(t, WithMerkleAppHash(true), WithReceiptsRoot(receipts), WithQuerySigning(ed25519.GenPrivKey()))
+		deliverBlock(app, 1, "a/1=x", "a/2=y")
+		root := app.committed.AppHash
+
+		res := app.Query(abcitypes.RequestQuery{Path: "keyproof", Data: []byte("a/1")})
+		if res.Code != 0 || res.ProofOps == nil {
+			t.Fatalf("keyproof got code %d: %s", res.Code, res.Log)
+		}
+		if last := res.ProofOps.Ops[len(res.ProofOps.Ops)-1]; last.Type != PROOF_OP_SIGNATURE {
+			t.Fatalf("the last op is %s, not the signature", last.Type)
+		}
+		if !VerifyProof(root, []byte("a/1"), []byte("x"), *res.ProofOps) {
+			t.Error("a signed key proof doesn't verify")
+		}
+		if VerifyProof(root, []byte("a/1"), []byte("z"), *res.ProofOps) {
+			t.Error("a signed key proof verifies the wrong value")
+		}
+
+		res = app.Query(abcitypes.RequestQuery{Path: "rangeproof", Data: []byte("a/")})
+		if res.Code != 0 || res.ProofOps == nil {
+			t.Fatalf("rangeproof got code %d: %s", res.Code, res.Log)
+		}
+		if !VerifyProof(root, []byte("a/2"), []byte("y"), *res.ProofOps) {
+			t.Error("a signed range proof doesn't verify")
+		}
+		if !VerifyProof(root, []byte("a/3"), nil, *res.ProofOps) {
+			t.Error("a signed range proof doesn't prove a/3 absent")
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// With key versions (WithKeyVersions) every key has a version, the number of
+// times it's been written since it was created, a key that doesn't exist is
+// at version 0, every write makes it one higher, removing the key drops its
+// version, so a key created again starts over at 1
+//
+//	versionPrefix | key -> version (8 bytes)
+//
+// a key written before versions were enabled has no record, it's taken to
+// be at version 1 until it's written again
+// with the tx index every change in a receipt has the version it made
+//
+// setver:key:version:value sets key to value only if it's at that version,
+// e.g. 'setver:doc:3:draft', otherwise it's rejected with VERSION_CONFLICT,
+// version 0 only creates the key, it's optimistic concurrency on the version
+// rather than on the whole value like setif, a client reads the version with
+// the meta query, writes based on it, and retries from the read on a
+// conflict, the value is everything after the version, ':' included, and is
+// stored as bytes
+
+var versionPrefix = internalKey("ver/")
+
+func versionKey(key []byte) []byte {
+	return append(append([]byte{}, versionPrefix...), key...)
+}
+
+// readVersion returns the version of the key as seen by txn
+func readVersion(txn Txn, key []byte) (uint64, error) {
+	item, err := txn.Get(versionKey(key))
+	if err == ErrKeyNotFound {
+		_, err := txn.Get(key)
+		if err == ErrKeyNotFound {
+			return 0, nil
+		}
+		return 1, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	var version uint64
+	err = item.Value(func(val []byte) error {
+		version = binary.BigEndian.Uint64(val)
+		return nil
+	})
+	return version, err
+}
+
+// bumpVersion moves the key to its next version in the current batch and
+// returns it, 0 without key versions, existed is whether the key existed
+// before the write
+func (app *KVStoreApplication) bumpVersion(key []byte, existed bool) (uint64, error) {
+	if !app.keyVersions {
+		return 0, nil
+	}
+	version := uint64(0)
+	if existed {
+		var err error
+		if version, err = readVersion(app.currentBatch, key); err != nil {
+			return 0, err
+		}
+	}
+	version++
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], version)
+	return version, app.currentBatch.Set(versionKey(key), v[:])
+}
+
+// clearVersion drops the version of a removed key
+func (app *KVStoreApplication) clearVersion(key []byte) error {
+	if !app.keyVersions {
+		return nil
+	}
+	return app.currentBatch.Delete(versionKey(key))
+}
+
+func init() {
+	registerOp(&txOp{
+		name: "setver",
+		args: 3,
+		keys: []int{0},
+		rest: true,
+		parse: func(args [][]byte) error {
+			if _, err := strconv.ParseUint(string(args[1]), 10, 64); err != nil {
+				return reject(INVALID_FORMAT, "the version must be a non-negative integer")
+			}
+			return nil
+		},
+		enabled: func(app *KVStoreApplication) bool {
+			return app.keyVersions
+		},
+		check: func(app *KVStoreApplication, txn Txn, t transaction) error {
+			expected, _ := strconv.ParseUint(string(t.args[1]), 10, 64)
+			version, err := readVersion(txn, t.args[0])
+			if err != nil {
+				return err
+			}
+			if version != expected {
+				return reject(VERSION_CONFLICT, fmt.Sprintf("key %q is at version %d, not %d", t.args[0], version, expected))
+			}
+			if version > 0 && app.appendOnly {
+				return errOverwriteForbidden
+			}
+			return nil
+		},
+		apply: func(app *KVStoreApplication, t transaction) error {
+			return app.set(t.args[0], t.args[2], typeBytes)
+		},
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestSetVer(t *testing.T) {
+	app := newTestApp(t, WithKeyVersions(true), WithTxIndex(0))
+
+	// a first write has to expect version 0, a stale version is rejected
+	res := deliverBlock(app, 1, "setver:doc:1:x", "setver:doc:0:a:b", "setver:doc:0:c", "setver:doc:1:d")
+	for i, want := range []uint32{VERSION_CONFLICT, VALID_TX, VERSION_CONFLICT, VALID_TX} {
+		if res[i].Code != want {
+			t.Errorf("tx %d got code %d, want %d: %s", i, res[i].Code, want, res[i].Log)
+		}
+	}
+	if meta := keyMeta(t, app, "doc"); meta.Version != 2 {
+		t.Errorf("doc is at version %d after two writes", meta.Version)
+	}
+	if value, _, _ := app.get([]byte("doc")); string(value) != "d" {
+		t.Errorf("doc is %q", value)
+	}
+	// the value can have ':' in it
+	if r, _ := receipt(t, app, "setver:doc:0:a:b"); len(r.Changes) != 1 || r.Changes[0].Value != "a:b" || r.Changes[0].Version != 1 {
+		t.Errorf("the first write's receipt is %+v", r)
+	}
+	if r, _ := receipt(t, app, "setver:doc:1:d"); len(r.Changes) != 1 || r.Changes[0].Version != 2 {
+		t.Errorf("the second write's receipt is %+v, want version 2", r)
+	}
+
+	// a plain write bumps the version too
+	deliverBlock(app, 2, "doc=plain")
+	if res := deliverBlock(app, 3, "setver:doc:2:s"); res[0].Code != VERSION_CONFLICT {
+		t.Errorf("a write at the version before a plain set got code %d", res[0].Code)
+	}
+
+	// removing the key starts it over
+	if res := deliverBlock(app, 4, "setver:doc:3:s", "delprefix:doc"); res[0].Code != VALID_TX || res[1].Code != VALID_TX {
+		t.Errorf("got codes %d and %d", res[0].Code, res[1].Code)
+	}
+	if res := deliverBlock(app, 5, "setver:doc:0:new"); res[0].Code != VALID_TX {
+		t.Errorf("recreating doc at version 0 got code %d", res[0].Code)
+	}
+	if meta := keyMeta(t, app, "doc"); meta.Version != 1 {
+		t.Errorf("the recreated doc is at version %d", meta.Version)
+	}
+
+	if r := app.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("setver:doc:x:s")}); r.Code != INVALID_FORMAT {
+		t.Errorf("a version that isn't a number got code %d", r.Code)
+	}
+	if r := newTestApp(t).CheckTx(abcitypes.RequestCheckTx{Tx: []byte("setver:doc:0:s")}); r.Code != OP_DISABLED {
+		t.Errorf("setver without key versions got code %d", r.Code)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// The transaction log is a plain text record of every applied transaction
+// independent of tendermint's own block store, one line per transaction
+//
+//	<height> tx <go quoted transaction>
+//	<height> commit
+//
+// Transactions are buffered for the whole block and written, followed by
+// the commit line, before the block is committed to the db
+// if the node crashes before the db commit, tendermint replays the block on
+// restart and the block gets written again, so a block can show up more than
+// once, and a crash mid write can leave a block without its commit line
+// ReplayLog deals with both by only applying blocks that have a commit line
+// and skipping any height it already applied
+
+// syncer is implemented by writers that can flush to stable storage e.g. *os.File
+type syncer interface {
+	Sync() error
+}
+
+// logTx buffers an applied transaction for the transaction log
+func (app *KVStoreApplication) logTx(tx []byte) {
+	if app.txLog != nil {
+		app.txLogPending = append(app.txLogPending, tx)
+	}
+}
+
+// flushTxLog writes the current block to the transaction log
+func (app *KVStoreApplication) flushTxLog() error {
+	if app.txLog == nil || len(app.txLogPending) == 0 {
+		return nil
+	}
+
+	w := bufio.NewWriter(app.txLog)
+	height := app.pending.Height
+	for _, tx := range app.txLogPending {
+		fmt.Fprintf(w, "%d tx %s\n", height, strconv.Quote(string(tx)))
+	}
+	fmt.Fprintf(w, "%d commit\n", height)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	app.txLogPending = nil
+
+	if s, ok := app.txLog.(syncer); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// ReplayLog rebuilds the store from a transaction log by delivering every
+// logged block again, it's meant to be run against an empty store
+// blocks at or below the store's current height are skipped
+func (app *KVStoreApplication) ReplayLog(path string) error {
+	if app.inBlock() {
+		return ErrBlockInProgress
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// replayed transactions shouldn't be logged again
+	txLog := app.txLog
+	app.txLog = nil
+	defer func() { app.txLog = txLog }()
+
+	var (
+		block  [][]byte
+		height int64
+	)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		parts := strings.SplitN(scanner.Text(), " ", 3)
+		if len(parts) < 2 {
+			return fmt.Errorf("transaction log line %d is malformed", line)
+		}
+		h, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("transaction log line %d has an invalid height: %v", line, err)
+		}
+		// a new height without a commit line for the last one means that
+		// block never finished being written
+		if h != height {
+			block, height = nil, h
+		}
+
+		switch {
+		case parts[1] == "tx" && len(parts) == 3:
+			tx, err := strconv.Unquote(parts[2])
+			if err != nil {
+				return fmt.Errorf("transaction log line %d has an invalid transaction: %v", line, err)
+			}
+			block = append(block, []byte(tx))
+		case parts[1] == "commit":
+			if height > app.committed.Height {
+				if err := app.replayBlock(height, block); err != nil {
+					return err
+				}
+			}
+			block = nil
+		default:
+			return fmt.Errorf("transaction log line %d is malformed", line)
+		}
+	}
+	return scanner.Err()
+}
+
+// replayBlock delivers a logged block through the normal ABCI path
+func (app *KVStoreApplication) replayBlock(height int64, txs [][]byte) error {
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: height}})
+	for _, tx := range txs {
+		if res := app.DeliverTx(abcitypes.RequestDeliverTx{Tx: tx}); res.Code != VALID_TX {
+			app.EndBlock(abcitypes.RequestEndBlock{Height: height})
+			app.Commit()
+			return fmt.Errorf("logged transaction at height %d was rejected on replay: %s", height, res.Log)
+		}
+	}
+	app.EndBlock(abcitypes.RequestEndBlock{Height: height})
+	app.Commit()
+	return nil
+}
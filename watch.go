@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// A watch (WatchKey, GET /watch on the gateway) waits for a key to change,
+// it returns straight away if the key was written after the height the
+// client has, otherwise it waits on a subscription that only gets the key's
+// changes, until one is flushed or the client gives up
+//
+// it isn't a query path, tendermint only ever runs one ABCI call at a time,
+// the local client even shares its lock between the consensus and query
+// connections, so a Query waiting for a Commit would hold up the Commit it
+// waits for, a watch runs on the caller's goroutine instead
+//
+// the written heights come from the modification index, which has to be
+// enabled, it has no record of removed keys, so a removal after the height
+// is only seen by a watch that's already waiting when it happens
+
+// defaultMaxWatchers bounds the watches waiting at once, each one is a
+// subscription that every flushed block's changes go through
+const defaultMaxWatchers = 1024
+
+var (
+	// ErrTooManyWatchers is returned by WatchKey when the limit set with
+	// WithMaxWatchers is reached
+	ErrTooManyWatchers = errors.New("too many watches in flight")
+	errWatchNoModIndex = errors.New("watching a key needs the modification index")
+)
+
+// WatchKey returns the key's value if it was written after since, if not it
+// waits for its next change, returns false if ctx is done first
+// the change is as of the last flushed block, like a query, and its height
+// is the height of the block that made it
+func (app *KVStoreApplication) WatchKey(ctx context.Context, key []byte, since int64) (Change, bool, error) {
+	if !app.modIndex {
+		return Change{}, false, errWatchNoModIndex
+	}
+	if app.watchSlots != nil {
+		select {
+		case app.watchSlots <- struct{}{}:
+			defer func() { <-app.watchSlots }()
+		default:
+			return Change{}, false, ErrTooManyWatchers
+		}
+	}
+	key = app.normalizeKey(key)
+
+	for {
+		// subscribed first, so a block flushed after the read below
+		// can't be missed
+		changes, cancel := app.subscribe(func(c Change) bool { return bytes.Equal(c.Key, key) })
+		c, changed, err := app.writtenSince(key, since)
+		if err != nil || changed {
+			cancel()
+			return c, changed, err
+		}
+		select {
+		case <-ctx.Done():
+			cancel()
+			return Change{}, false, nil
+		case c, ok := <-changes:
+			cancel()
+			if ok {
+				return c, true, nil
+			}
+			// dropped for falling behind, there's been a change then
+		}
+	}
+}
+
+// writtenSince returns the key as a change if it was written after since
+func (app *KVStoreApplication) writtenSince(key []byte, since int64) (c Change, changed bool, err error) {
+	err = app.store.View(func(txn Txn) error {
+		height, found, err := readModified(txn, key)
+		if err != nil || !found || height <= since {
+			return err
+		}
+		value, ct, exists, err := lookup(txn, key)
+		if err != nil || !exists {
+			return err
+		}
+		c = Change{Height: height, Key: key, Value: value, Type: ct.String()}
+		changed = true
+		return nil
+	})
+	return c, changed, err
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type watchResult struct {
+	c       Change
+	changed bool
+	err     error
+}
+
+// watch runs WatchKey on a goroutine of its own, returning once it's waiting
+func watch(ctx context.Context, t *testing.T, app *KVStoreApplication, key string, since int64) <-chan watchResult {
+	t.Helper()
+	done := make(chan watchResult, 1)
+	waiting := len(app.watchSlots)
+	go func() {
+		c, changed, err := app.WatchKey(ctx, []byte(key), since)
+		done <- watchResult{c, changed, err}
+	}()
+	for len(app.watchSlots) == waiting {
+		time.Sleep(time.Millisecond)
+	}
+	return done
+}
+
+func TestWatchKey(t *testing.T) {
+	app := newTestApp(t, WithModIndex(true), WithMaxWatchers(2))
+	deliverBlock(app, 1, "a=1")
+
+	// written after since, it returns straight away
+	c, changed, err := app.WatchKey(context.Background(), []byte("a"), 0)
+	if err != nil || !changed || c.Height != 1 || string(c.Value) != "1" {
+		t.Errorf("watch since 0 got %+v, %v, %v", c, changed, err)
+	}
+
+	// otherwise it waits, a block that doesn't touch the key doesn't
+	// release it, the one that does does
+	done := watch(context.Background(), t, app, "a", 1)
+	deliverBlock(app, 2, "b=1")
+	select {
+	case r := <-done:
+		t.Fatalf("watch was released by a block without the key: %+v", r)
+	case <-time.After(20 * time.Millisecond):
+	}
+	deliverBlock(app, 3, "a=2")
+	if r := <-done; r.err != nil || !r.changed || r.c.Height != 3 || string(r.c.Value) != "2" {
+		t.Errorf("watch got %+v", r)
+	}
+
+	// one that times out gets no change
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if c, changed, err := app.WatchKey(ctx, []byte("a"), 3); err != nil || changed {
+		t.Errorf("watch that timed out got %+v, %v, %v", c, changed, err)
+	}
+
+	// the watchers are bounded
+	ctx, cancel = context.WithCancel(context.Background())
+	first, second := watch(ctx, t, app, "z", 0), watch(ctx, t, app, "z", 0)
+	if _, _, err := app.WatchKey(ctx, []byte("z"), 0); err != ErrTooManyWatchers {
+		t.Errorf("a third watch got %v, want ErrTooManyWatchers", err)
+	}
+	cancel()
+	<-first
+	<-second
+
+	if _, _, err := newTestApp(t).WatchKey(context.Background(), []byte("a"), 0); err != errWatchNoModIndex {
+		t.Errorf("watch without the mod index got %v", err)
+	}
+}
+
+func TestGatewayWatch(t *testing.T) {
+	app := newTestApp(t, WithModIndex(true))
+	deliverBlock(app, 1, "a=1")
+	srv := httptest.NewServer(NewGateway(app))
+	defer srv.Close()
+
+	done := make(chan *http.Response)
+	go func() {
+		res, err := http.Get(srv.URL + "/watch?key=a&since=1&timeout=5s")
+		if err != nil {
+			t.Error(err)
+		}
+		done <- res
+	}()
+	for len(app.watchSlots) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	deliverBlock(app, 2, "a=2")
+	res := <-done
+	defer res.Body.Close()
+	var ev streamEvent
+	if err := json.NewDecoder(res.Body).Decode(&ev); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || ev.Height != 2 || ev.Key != "a" || ev.Value != "2" {
+		t.Errorf("watch got %d %+v", res.StatusCode, ev)
+	}
+
+	for url, want := range map[string]int{
+		"/watch?key=a&since=2&timeout=20ms": http.StatusNoContent,
+		"/watch?since=2":                    http.StatusBadRequest,
+		"/watch?key=a&timeout=1h":           http.StatusBadRequest,
+	} {
+		res, err := http.Get(srv.URL + url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Errorf("%s got %d, want %d", url, res.StatusCode, want)
+		}
+	}
+}
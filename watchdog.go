@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// The watchdog makes a block that hangs between BeginBlock and Commit
+// visible, e.g. a DeliverTx stuck on the db, once a block has been open
+// longer than the timeout it logs, or halts the node if configured to
+
+// startWatchdog arms the watchdog for the block that was just started
+func (app *KVStoreApplication) startWatchdog(height int64) {
+	app.stopWatchdog()
+	if app.watchdogTimeout <= 0 {
+		return
+	}
+	timeout, haltNode, logger := app.watchdogTimeout, app.watchdogHalt, app.logger
+	app.watchdog = time.AfterFunc(timeout, func() {
+		logger.Error("block has been open for too long", "height", height, "timeout", timeout)
+		if haltNode {
+			halt("watchdog", fmt.Errorf("block at height %d was not committed within %s", height, timeout))
+		}
+	})
+}
+
+// stopWatchdog disarms the watchdog once the block is committed
+func (app *KVStoreApplication) stopWatchdog() {
+	if app.watchdog != nil {
+		app.watchdog.Stop()
+		app.watchdog = nil
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/log"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// logLines sends every line logged to it on the channel, the watchdog logs
+// from its own goroutine
+type logLines chan string
+
+func (l logLines) Write(p []byte) (int, error) {
+	l <- string(p)
+	return len(p), nil
+}
+
+func TestBlockWatchdogFires(t *testing.T) {
+	lines := make(logLines, 16)
+	app := newTestApp(t, WithBlockWatchdog(10*time.Millisecond, false), WithLogger(log.NewTMLogger(lines)))
+	app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: 1}})
+	app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte("a=1")})
+
+	select {
+	case line := <-lines:
+		if !strings.Contains(line, "block has been open for too long") || !strings.Contains(line, "height=1") {
+			t.Errorf("the watchdog logged %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watchdog didn't fire for a block left open")
+	}
+
+	// the block can still finish after the watchdog fired
+	app.EndBlock(abcitypes.RequestEndBlock{Height: 1})
+	app.Commit()
+	if app.watchdog != nil {
+		t.Error("Commit left the watchdog armed")
+	}
+}
+
+func TestBlockWatchdogStopsOnCommit(t *testing.T) {
+	lines := make(logLines, 16)
+	app := newTestApp(t, WithBlockWatchdog(50*time.Millisecond, true), WithLogger(log.NewTMLogger(lines)))
+	for h := int64(1); h <= 3; h++ {
+		deliverBlock(app, h, "a=1")
+	}
+	// a watchdog that wasn't stopped would halt the test binary here
+	time.Sleep(100 * time.Millisecond)
+	for len(lines) > 0 {
+		if line := <-lines; strings.Contains(line, "open for too long") {
+			t.Errorf("the watchdog fired for committed blocks: %s", line)
+		}
+	}
+}
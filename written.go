@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// With the time index (WithTimeIndex) the block time every key was last
+// written at is recorded, along with an index by time, so the keys written
+// since some point in time can be listed without scanning the store
+//
+//	writtenPrefix     | key                -> block time it was last written at
+//	writtenTimePrefix | time (8 bytes) key -> nothing, ordered by time
+//
+// the time is the block header's, in unix nanoseconds, never the local
+// clock's, so every node builds the same index
+// removing a key removes its records, records older than the retention
+// are pruned at the end of every block, the key itself is kept
+
+var (
+	writtenPrefix     = internalKey("wt/")
+	writtenTimePrefix = internalKey("wth/")
+)
+
+func writtenKey(key []byte) []byte {
+	return append(append([]byte{}, writtenPrefix...), key...)
+}
+
+func writtenTimeKey(t int64, key []byte) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(t))
+	return append(append(append([]byte{}, writtenTimePrefix...), b[:]...), key...)
+}
+
+// readWritten returns the block time, in unix nanoseconds, the key was last
+// written at as seen by txn, found is false if it isn't in the index, the
+// time of a key written outside a block can be anything, the genesis time
+// or none
+func readWritten(txn Txn, key []byte) (t int64, found bool, err error) {
+	item, err := txn.Get(writtenKey(key))
+	if err == ErrKeyNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	err = item.Value(func(val []byte) error {
+		t = int64(binary.BigEndian.Uint64(val))
+		return nil
+	})
+	return t, err == nil, err
+}
+
+// clearWritten removes the key from the time index
+func (app *KVStoreApplication) clearWritten(key []byte) error {
+	if !app.timeIndex {
+		return nil
+	}
+	t, found, err := readWritten(app.currentBatch, key)
+	if err != nil || !found {
+		return err
+	}
+	if err := app.currentBatch.Delete(writtenTimeKey(t, key)); err != nil {
+		return err
+	}
+	return app.currentBatch.Delete(writtenKey(key))
+}
+
+// recordWritten records the key as written at the current block's time
+func (app *KVStoreApplication) recordWritten(key []byte) error {
+	if !app.timeIndex {
+		return nil
+	}
+	if err := app.clearWritten(key); err != nil {
+		return err
+	}
+	t := app.blockTime.UnixNano()
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(t))
+	if err := app.currentBatch.Set(writtenKey(key), b[:]); err != nil {
+		return err
+	}
+	return app.currentBatch.Set(writtenTimeKey(t, key), nil)
+}
+
+// pruneTimeIndex drops the records older than the retention, it runs at
+// the end of every block
+func (app *KVStoreApplication) pruneTimeIndex() error {
+	if !app.timeIndex || app.timeIndexRetain <= 0 {
+		return nil
+	}
+	oldest := app.blockTime.Add(-app.timeIndexRetain).UnixNano()
+	if oldest <= 0 {
+		return nil
+	}
+	cutoff := writtenTimeKey(oldest, nil)
+
+	var stale [][]byte
+	opts := DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = writtenTimePrefix
+	it := app.currentBatch.NewIterator(opts)
+	for it.Seek(writtenTimePrefix); it.Valid() && bytes.Compare(it.Item().Key(), cutoff) < 0; it.Next() {
+		stale = append(stale, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+
+	for _, key := range stale {
+		if err := app.currentBatch.Delete(key); err != nil {
+			return err
+		}
+		if err := app.currentBatch.Delete(writtenKey(key[len(writtenTimePrefix)+8:])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	defaultSinceLimit = 100
+	maxSinceLimit     = 1000
+)
+
+type sinceRequest struct {
+	Since time.Time `json:"since"`
+	Limit int       `json:"limit"`
+	// Start is the next of the previous page, empty for the first page
+	Start string `json:"start"`
+}
+
+type writtenKeyResponse struct {
+	Key  string    `json:"key"`
+	Time time.Time `json:"time"`
+}
+
+type sinceResponse struct {
+	Keys []writtenKeyResponse `json:"keys"`
+	// Next is set if there are more keys, pass it as start to get them
+	Next string `json:"next,omitempty"`
+}
+
+// querySince returns the keys last written at or after a block time, oldest
+// first, e.g. {"since": "2021-06-01T00:00:00Z"}, keys written in the same
+// block are ordered by key, a key written again since shows up only once,
+// at its last write, with a retention keys last written before it are
+// missing, whatever since is
+// only available with the time index
+func (app *KVStoreApplication) querySince(req abcitypes.RequestQuery) (res abcitypes.ResponseQuery) {
+	if !app.timeIndex {
+		res.Code = QUERY_NOT_ALLOWED
+		res.Log = "the time index is disabled"
+		return
+	}
+	var sreq sinceRequest
+	if !parseRequest(req, &res, &sreq) {
+		return
+	}
+	limit := clampLimit(sreq.Limit, defaultSinceLimit, maxSinceLimit)
+	start := writtenTimePrefix
+	if since := sreq.Since.UnixNano(); since > 0 && !sreq.Since.IsZero() {
+		start = writtenTimeKey(since, nil)
+	}
+	if sreq.Start != "" {
+		cursor, err := hex.DecodeString(sreq.Start)
+		if err != nil || len(cursor) < 8 {
+			res.Code = QUERY_INVALID
+			res.Log = "start isn't the next of a previous page"
+			return
+		}
+		if key := append(append([]byte{}, writtenTimePrefix...), cursor...); bytes.Compare(key, start) > 0 {
+			start = key
+		}
+	}
+
+	sres := sinceResponse{Keys: []writtenKeyResponse{}}
+	err := app.store.View(func(txn Txn) error {
+		opts := DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = writtenTimePrefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(start); it.Valid(); it.Next() {
+			entry := it.Item().Key()[len(writtenTimePrefix):]
+			if len(sres.Keys) == limit {
+				sres.Next = hex.EncodeToString(entry)
+				return nil
+			}
+			sres.Keys = append(sres.Keys, writtenKeyResponse{
+				Key:  string(entry[8:]),
+				Time: time.Unix(0, int64(binary.BigEndian.Uint64(entry[:8]))).UTC(),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		halt("Query", err)
+	}
+
+	res.Height = app.committed.Height
+	respondJSON(&res, sres)
+	return
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+func TestSince(t *testing.T) {
+	app := newTestApp(t, WithTimeIndex(3*time.Hour))
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	// block h runs at hour h, the index keeps the last three hours
+	for h := int64(1); h <= 6; h++ {
+		app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: h, Time: start.Add(time.Duration(h) * time.Hour)}})
+		txs := []string{fmt.Sprintf("k%d=v", h), fmt.Sprintf("j%d=v", h)}
+		if h == 5 {
+			txs = append(txs, "k4=w", "delprefix:j5")
+		}
+		for _, tx := range txs {
+			app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte(tx)})
+		}
+		app.EndBlock(abcitypes.RequestEndBlock{Height: h})
+		app.Commit()
+	}
+
+	// since returns the keys as key@hour, and the next page
+	since := func(data string) ([]string, string) {
+		t.Helper()
+		var res sinceResponse
+		queryJSON(t, app, "since", []byte(data), &res)
+		keys := []string{}
+		for _, k := range res.Keys {
+			keys = append(keys, fmt.Sprintf("%s@%d", k.Key, k.Time.Hour()))
+		}
+		return keys, res.Next
+	}
+
+	// k4 moves to its rewrite, j5 is gone, hours 1 and 2 are pruned
+	for data, want := range map[string][]string{
+		`{}`:                                {"j3@3", "k3@3", "j4@4", "k4@5", "k5@5", "j6@6", "k6@6"},
+		`{"since": "2021-06-01T05:00:00Z"}`: {"k4@5", "k5@5", "j6@6", "k6@6"},
+		`{"since": "2021-06-01T07:00:00Z"}`: {},
+	} {
+		if got, next := since(data); !reflect.DeepEqual(got, want) || next != "" {
+			t.Errorf("%s got %v and next %q, want %v", data, got, next, want)
+		}
+	}
+
+	// paged two at a time
+	var got []string
+	next := ""
+	for pages := 0; pages < 10; pages++ {
+		var keys []string
+		keys, next = since(fmt.Sprintf(`{"since": "2021-06-01T04:00:00Z", "limit": 2, "start": %q}`, next))
+		got = append(got, keys...)
+		if next == "" {
+			break
+		}
+	}
+	if want := []string{"j4@4", "k4@5", "k5@5", "j6@6", "k6@6"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the pages got %v, want %v", got, want)
+	}
+
+	if res := newTestApp(t).Query(abcitypes.RequestQuery{Path: "since", Data: []byte(`{}`)}); res.Code != QUERY_NOT_ALLOWED {
+		t.Errorf("since without the time index got code %d", res.Code)
+	}
+}
+
+func TestWrittenAt(t *testing.T) {
+	app := newTestApp(t, WithTimeIndex(0))
+	first := time.Date(2021, 6, 1, 12, 30, 0, 123, time.UTC)
+	second := first.Add(90 * time.Second)
+	for _, b := range []struct {
+		height int64
+		at     time.Time
+		txs    []string
+	}{
+		{1, first, []string{"a=1", "b=1"}},
+		{2, second, []string{"a=2", "incr:n:1"}},
+	} {
+		app.BeginBlock(abcitypes.RequestBeginBlock{Header: tmproto.Header{Height: b.height, Time: b.at}})
+		for _, tx := range b.txs {
+			app.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte(tx)})
+		}
+		app.EndBlock(abcitypes.RequestEndBlock{Height: b.height})
+		app.Commit()
+	}
+
+	for key, want := range map[string]time.Time{"a": second, "b": first, "n": second} {
+		if meta := keyMeta(t, app, key); meta.WrittenAt == nil || !meta.WrittenAt.Equal(want) {
+			t.Errorf("%s was written at %v, want the block time %v", key, meta.WrittenAt, want)
+		}
+	}
+	plain := newTestApp(t)
+	deliverBlock(plain, 1, "a=1")
+	if meta := keyMeta(t, plain, "a"); !meta.Found || meta.WrittenAt != nil {
+		t.Errorf("without the time index a key got written at %v", meta.WrittenAt)
+	}
+}